@@ -0,0 +1,603 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/admin"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/archive"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/budget"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/filter"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/policy"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/pricing"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/publisher"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/redact"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/report"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/stall"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/status"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/stream"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/watcher"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the sidecar configuration.
+type Config struct {
+	NATS     NATSConfig `yaml:"nats"`
+	WatchDir string     `yaml:"watch_dir"`
+	// WatchRoots replaces WatchDir with several roots, which may be globs
+	// such as /home/*/.claude/projects.
+	WatchRoots []WatchRoot `yaml:"watch_roots"`
+	// RescanInterval is how often glob roots are re-expanded to pick up
+	// new home directories.
+	RescanInterval time.Duration `yaml:"rescan_interval"`
+	// Filters select which projects and transcripts are followed; roots may
+	// override them.
+	Filters FilterConfig `yaml:"filters"`
+	// WatchMode is fsnotify, poll or auto; see watcher.Mode.
+	WatchMode         string            `yaml:"watch_mode"`
+	WatchPollInterval time.Duration     `yaml:"watch_poll_interval"`
+	IdleThreshold     time.Duration     `yaml:"idle_threshold"`
+	PollInterval      time.Duration     `yaml:"poll_interval"`
+	Registry          RegistryConfig    `yaml:"registry"`
+	Attribution       AttributionConfig `yaml:"attribution"`
+	Status            StatusConfig      `yaml:"status"`
+	Provision         ProvisionConfig   `yaml:"provision"`
+	Control           ControlConfig     `yaml:"control"`
+	Archive           ArchiveConfig     `yaml:"archive"`
+	Redaction         RedactionConfig   `yaml:"redaction"`
+	Events            EventsConfig      `yaml:"events"`
+	Policy            PolicyConfig      `yaml:"policy"`
+	Stall             StallConfig       `yaml:"stall"`
+	Budget            BudgetConfig      `yaml:"budget"`
+	History           HistoryConfig     `yaml:"history"`
+	Reports           ReportsConfig     `yaml:"reports"`
+	Admin             AdminConfig       `yaml:"admin"`
+	Stream            StreamConfig      `yaml:"stream"`
+	Reload            ReloadConfig      `yaml:"reload"`
+	// Pricing overrides built-in model prices, in US dollars per million
+	// tokens, keyed by model name or name prefix.
+	Pricing map[string]pricing.Price `yaml:"pricing"`
+}
+
+// WatchRoot is a transcript directory, or a glob of them, with an optional
+// owner label attached to its sessions' events. "{user}" in the label is
+// replaced by the account owning each matched directory. Include and
+// Exclude, when set, replace the global filter lists for this root; an empty
+// list clears them.
+type WatchRoot struct {
+	Path    string   `yaml:"path"`
+	Owner   string   `yaml:"owner"`
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+// FilterConfig lists include and exclude rules; see package filter for the
+// rule syntax.
+type FilterConfig struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+// roots returns the watch roots with "~" expanded and their filters
+// compiled, falling back to WatchDir.
+func (c Config) roots() ([]watcher.Root, error) {
+	if len(c.WatchRoots) == 0 {
+		f, err := filter.New(c.Filters.Include, c.Filters.Exclude)
+		if err != nil {
+			return nil, fmt.Errorf("filters: %w", err)
+		}
+		return []watcher.Root{{Path: expandHome(c.WatchDir), Filter: f}}, nil
+	}
+	roots := make([]watcher.Root, len(c.WatchRoots))
+	for i, r := range c.WatchRoots {
+		include, exclude := c.Filters.Include, c.Filters.Exclude
+		if r.Include != nil {
+			include = r.Include
+		}
+		if r.Exclude != nil {
+			exclude = r.Exclude
+		}
+		f, err := filter.New(include, exclude)
+		if err != nil {
+			return nil, fmt.Errorf("watch root %s: %w", r.Path, err)
+		}
+		roots[i] = watcher.Root{Path: expandHome(r.Path), Owner: r.Owner, Filter: f}
+	}
+	return roots, nil
+}
+
+// newWatcher creates the transcript watcher described by the config.
+func (c Config) newWatcher(tracker watcher.Toucher, logger *slog.Logger) (*watcher.Watcher, error) {
+	mode, err := watcher.ParseMode(c.WatchMode)
+	if err != nil {
+		return nil, err
+	}
+	roots, err := c.roots()
+	if err != nil {
+		return nil, err
+	}
+	w, err := watcher.New(roots, mode, tracker, logger)
+	if err != nil {
+		return nil, err
+	}
+	w.SetRescanInterval(c.RescanInterval)
+	w.SetPollInterval(c.WatchPollInterval)
+	return w, nil
+}
+
+// rootPaths returns the expanded paths of the watch roots.
+func (c Config) rootPaths() []string {
+	if len(c.WatchRoots) == 0 {
+		return []string{expandHome(c.WatchDir)}
+	}
+	paths := make([]string, len(c.WatchRoots))
+	for i, r := range c.WatchRoots {
+		paths[i] = expandHome(r.Path)
+	}
+	return paths
+}
+
+// validate checks the config the way startup would, so a reload can reject
+// an invalid file before anything changes.
+func (c Config) validate() error {
+	if c.IdleThreshold <= 0 || c.PollInterval <= 0 {
+		return errors.New("idle_threshold and poll_interval must be positive")
+	}
+	if _, err := watcher.ParseMode(c.WatchMode); err != nil {
+		return err
+	}
+	if _, err := c.roots(); err != nil {
+		return err
+	}
+	if _, err := c.Redaction.redactor(); err != nil {
+		return fmt.Errorf("redaction: %w", err)
+	}
+	switch c.Events.Commands {
+	case publisher.CommandsOff, publisher.CommandsInline, publisher.CommandsEvent:
+	default:
+		return fmt.Errorf("events.commands: unknown value %q", c.Events.Commands)
+	}
+	if _, err := pricing.New(c.Pricing); err != nil {
+		return fmt.Errorf("pricing: %w", err)
+	}
+	if c.Policy.Enabled {
+		if _, err := policy.New(c.Policy.rules(), nil, slog.New(slog.DiscardHandler)); err != nil {
+			return fmt.Errorf("policy: %w", err)
+		}
+	}
+	if c.Budget.Enabled {
+		if _, err := c.Budget.config(); err != nil {
+			return fmt.Errorf("budget: %w", err)
+		}
+	}
+	if c.Reports.Enabled {
+		if _, err := c.Reports.at(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StreamConfig controls live publishing of transcript messages to
+// swarm.cc.session.{id}.stream. Sizes are in bytes.
+type StreamConfig struct {
+	Enabled  bool `yaml:"enabled"`
+	MaxText  int  `yaml:"max_text"`
+	MaxInput int  `yaml:"max_input"`
+	MaxQueue int  `yaml:"max_queue"`
+}
+
+// ReloadConfig controls reloading the config file while running. SIGHUP
+// always reloads; Watch also reloads whenever the file changes.
+type ReloadConfig struct {
+	Watch bool `yaml:"watch"`
+}
+
+// AdminConfig controls the local HTTP admin API used by "cc-sidecar top".
+type AdminConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Listen  string `yaml:"listen"`
+	// Token is required as a bearer token on every request when set, and
+	// is needed to listen on a non-loopback address.
+	Token string `yaml:"token"`
+}
+
+// HistoryConfig controls the local SQLite database of completed sessions.
+type HistoryConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Path    string `yaml:"path"`
+}
+
+// ReportsConfig schedules daily and weekly usage reports built from the
+// session history.
+type ReportsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Periods lists daily and/or weekly.
+	Periods []string `yaml:"periods"`
+	// At is the local time of day, as HH:MM, reports are generated.
+	At string `yaml:"at"`
+	// Format is markdown or json.
+	Format string `yaml:"format"`
+	// Dir, if set, receives a file per report.
+	Dir string `yaml:"dir"`
+	// Publish sends reports on swarm.cc.report.{period}.
+	Publish bool `yaml:"publish"`
+	// Top is how many files and tools each section lists.
+	Top int `yaml:"top"`
+}
+
+// at validates the config and parses the time of day as an offset from
+// midnight.
+func (r ReportsConfig) at() (time.Duration, error) {
+	if r.Format != report.FormatMarkdown && r.Format != report.FormatJSON {
+		return 0, fmt.Errorf("reports.format: unknown format %q", r.Format)
+	}
+	t, err := time.Parse("15:04", r.At)
+	if err != nil {
+		return 0, fmt.Errorf("reports.at: %w", err)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// BudgetConfig caps spend per task and per owner.
+type BudgetConfig struct {
+	Enabled bool `yaml:"enabled"`
+	Limits  []struct {
+		Scope    string  `yaml:"scope"` // task or owner
+		ID       string  `yaml:"id"`
+		LimitUSD float64 `yaml:"limit_usd"`
+	} `yaml:"limits"`
+	// Thresholds are fractions of a limit that raise an alert.
+	Thresholds []float64 `yaml:"thresholds"`
+	// Bucket is a KV bucket of budgets set at runtime. Empty disables it.
+	Bucket string `yaml:"bucket"`
+	// Signal is sent to sessions spending against an exhausted budget:
+	// SIGINT, SIGTERM or empty for none.
+	Signal string `yaml:"signal"`
+}
+
+func (b BudgetConfig) config() (budget.Config, error) {
+	cfg := budget.Config{Thresholds: b.Thresholds}
+	for _, l := range b.Limits {
+		cfg.Limits = append(cfg.Limits, budget.Limit{Scope: l.Scope, ID: l.ID, LimitUSD: l.LimitUSD})
+	}
+	switch b.Signal {
+	case "":
+	case "SIGINT":
+		cfg.Signal = syscall.SIGINT
+	case "SIGTERM":
+		cfg.Signal = syscall.SIGTERM
+	default:
+		return budget.Config{}, fmt.Errorf("unsupported budget signal %q", b.Signal)
+	}
+	return cfg, cfg.Validate()
+}
+
+// StallConfig controls detection of stuck and looping sessions. A zero
+// threshold disables that detector.
+type StallConfig struct {
+	Enabled        bool          `yaml:"enabled"`
+	RepeatedCalls  int           `yaml:"repeated_calls"`
+	RepeatedErrors int           `yaml:"repeated_errors"`
+	NoProgress     time.Duration `yaml:"no_progress"`
+	MaxDuration    time.Duration `yaml:"max_duration"`
+	CheckInterval  time.Duration `yaml:"check_interval"`
+	// ForceComplete completes a session when a time-based detector fires,
+	// instead of leaving it tracked until the process exits.
+	ForceComplete bool `yaml:"force_complete"`
+}
+
+func (s StallConfig) config() stall.Config {
+	return stall.Config{
+		RepeatedCalls:  s.RepeatedCalls,
+		RepeatedErrors: s.RepeatedErrors,
+		NoProgress:     s.NoProgress,
+		MaxDuration:    s.MaxDuration,
+		CheckInterval:  s.CheckInterval,
+	}
+}
+
+// PolicyConfig lists the rules evaluated against every session.
+type PolicyConfig struct {
+	Enabled bool `yaml:"enabled"`
+	Rules   []struct {
+		Name     string `yaml:"name"`
+		Type     string `yaml:"type"` // file, command, outside_working_dir, tool_errors
+		Severity string `yaml:"severity"`
+		// Patterns are globs for file rules and regexps for command rules.
+		Patterns []string `yaml:"patterns"`
+		Max      int      `yaml:"max"`
+	} `yaml:"rules"`
+}
+
+func (p PolicyConfig) rules() []policy.Rule {
+	rules := make([]policy.Rule, 0, len(p.Rules))
+	for _, r := range p.Rules {
+		rules = append(rules, policy.Rule{Name: r.Name, Type: r.Type, Severity: r.Severity, Patterns: r.Patterns, Max: r.Max})
+	}
+	return rules
+}
+
+// EventsConfig selects optional sections of session events.
+type EventsConfig struct {
+	Summary bool `yaml:"summary"`
+	// Commands is off, inline or event.
+	Commands string `yaml:"commands"`
+}
+
+// RedactionConfig controls scrubbing of secrets and personal data from
+// everything the sidecar sends off the machine.
+type RedactionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Detectors lists built-in detectors by name. Omit to enable all.
+	Detectors []string `yaml:"detectors"`
+	Patterns  []struct {
+		Name  string `yaml:"name"`
+		Regex string `yaml:"regex"`
+	} `yaml:"patterns"`
+	DenyPaths  []string `yaml:"deny_paths"`
+	AllowPaths []string `yaml:"allow_paths"`
+}
+
+// redactor builds the configured redactor, or nil when disabled.
+func (r RedactionConfig) redactor() (*redact.Redactor, error) {
+	if !r.Enabled {
+		return nil, nil
+	}
+	cfg := redact.Config{
+		Detectors:  r.Detectors,
+		DenyPaths:  r.DenyPaths,
+		AllowPaths: r.AllowPaths,
+	}
+	for _, p := range r.Patterns {
+		cfg.Patterns = append(cfg.Patterns, redact.Pattern{Name: p.Name, Regex: p.Regex})
+	}
+	return redact.New(cfg)
+}
+
+// ArchiveConfig controls uploading finished transcripts to an object store.
+type ArchiveConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	Bucket      string        `yaml:"bucket"`
+	Compression string        `yaml:"compression"` // none, gzip or zstd
+	ChunkSize   int           `yaml:"chunk_size"`
+	PartSize    int           `yaml:"part_size"`
+	MaxAge      time.Duration `yaml:"max_age"`
+	Replicas    int           `yaml:"replicas"`
+}
+
+// ControlConfig controls the NATS request/reply control plane.
+type ControlConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Host is the subject token this sidecar answers on. Defaults to the
+	// hostname.
+	Host string `yaml:"host"`
+}
+
+// controlHost is the host the control plane answers for.
+func (c Config) controlHost() string {
+	if c.Control.Host != "" {
+		return c.Control.Host
+	}
+	host, _ := os.Hostname()
+	return host
+}
+
+// StatusConfig controls writing live session status to a KV bucket.
+type StatusConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Bucket  string        `yaml:"bucket"`
+	TTL     time.Duration `yaml:"ttl"`
+	// Throttle is the minimum interval between writes for a session whose
+	// state has not changed.
+	Throttle time.Duration `yaml:"throttle"`
+}
+
+// AttributionConfig selects and configures the strategies used to match a
+// session to its task. Resolvers are tried in order; the first match wins.
+type AttributionConfig struct {
+	// Resolvers lists strategies by name: registry, prompt, env, path.
+	Resolvers []string `yaml:"resolvers"`
+	// PromptPattern matches a task marker in the first user prompt.
+	PromptPattern string `yaml:"prompt_pattern"`
+	Env           struct {
+		TaskVar  string `yaml:"task_var"`
+		OwnerVar string `yaml:"owner_var"`
+	} `yaml:"env"`
+	PathRules []struct {
+		Source  string `yaml:"source"` // cwd or branch
+		Pattern string `yaml:"pattern"`
+	} `yaml:"path_rules"`
+}
+
+// RegistryConfig controls how sessions are matched to registry mappings that
+// the orchestrator writes late.
+type RegistryConfig struct {
+	// WaitForMapping is how long a completion waits for a missing mapping
+	// before publishing without one.
+	WaitForMapping time.Duration `yaml:"wait_for_mapping"`
+	// AttributionWindow is how long after publishing an unattributed session
+	// a newly arriving mapping triggers a cc.session.attributed event.
+	AttributionWindow time.Duration `yaml:"attribution_window"`
+}
+
+// ProvisionConfig controls the optional startup step that creates or updates
+// the JetStream stream and KV bucket the sidecar depends on.
+type ProvisionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// DryRun reports drift without creating or updating anything.
+	DryRun bool `yaml:"dry_run"`
+	Stream struct {
+		Name            string        `yaml:"name"`
+		Subjects        []string      `yaml:"subjects"`
+		Retention       string        `yaml:"retention"`
+		Storage         string        `yaml:"storage"`
+		MaxAge          time.Duration `yaml:"max_age"`
+		Replicas        int           `yaml:"replicas"`
+		DuplicateWindow time.Duration `yaml:"duplicate_window"`
+	} `yaml:"stream"`
+	Registry struct {
+		TTL      time.Duration `yaml:"ttl"`
+		History  uint8         `yaml:"history"`
+		Replicas int           `yaml:"replicas"`
+	} `yaml:"registry"`
+}
+
+// NATSConfig holds connection and authentication settings for NATS.
+type NATSConfig struct {
+	// URL is a single server URL. URLs, when set, takes precedence and lists
+	// several seed servers of a cluster.
+	URL          string   `yaml:"url"`
+	URLs         []string `yaml:"urls"`
+	Token        string   `yaml:"token"`
+	TokenFile    string   `yaml:"token_file"`
+	User         string   `yaml:"user"`
+	Password     string   `yaml:"password"`
+	NKeySeedFile string   `yaml:"nkey_seed_file"`
+	CredsFile    string   `yaml:"creds_file"`
+	TLS          struct {
+		CertFile string `yaml:"cert_file"`
+		KeyFile  string `yaml:"key_file"`
+		CAFile   string `yaml:"ca_file"`
+	} `yaml:"tls"`
+}
+
+// publisherOptions converts the NATS config into publisher options.
+func (n NATSConfig) publisherOptions() publisher.Options {
+	urls := n.URLs
+	if len(urls) == 0 && n.URL != "" {
+		urls = []string{n.URL}
+	}
+	return publisher.Options{
+		URLs:         urls,
+		Token:        n.Token,
+		TokenFile:    expandHome(n.TokenFile),
+		User:         n.User,
+		Password:     n.Password,
+		NKeySeedFile: expandHome(n.NKeySeedFile),
+		CredsFile:    expandHome(n.CredsFile),
+		TLS: publisher.TLSOptions{
+			CertFile: expandHome(n.TLS.CertFile),
+			KeyFile:  expandHome(n.TLS.KeyFile),
+			CAFile:   expandHome(n.TLS.CAFile),
+		},
+	}
+}
+
+// loadConfig reads the config at startup, falling back to the defaults
+// when the file cannot be read or parsed.
+func loadConfig(path string, logger *slog.Logger) Config {
+	cfg, err := readConfig(path)
+	if err != nil {
+		logger.Warn("could not load config file, using defaults", "path", path, "error", err)
+		cfg = defaultConfig()
+		cfg.applyEnv()
+	}
+	return cfg
+}
+
+// readConfig reads the config file over the defaults and applies env
+// overrides.
+func readConfig(path string) (Config, error) {
+	cfg := defaultConfig()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("read config: %w", err)
+		}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return Config{}, fmt.Errorf("parse config: %w", err)
+		}
+	}
+	cfg.applyEnv()
+	return cfg, nil
+}
+
+func defaultConfig() Config {
+	cfg := Config{
+		WatchDir:      "~/.claude/projects/",
+		IdleThreshold: 10 * time.Second,
+		PollInterval:  15 * time.Second,
+	}
+	cfg.NATS.URL = "nats://localhost:4222"
+	cfg.Registry.AttributionWindow = time.Hour
+	cfg.Attribution.Resolvers = []string{"registry"}
+	cfg.Status.Bucket = status.DefaultBucket
+	cfg.Status.TTL = 24 * time.Hour
+	cfg.Status.Throttle = 5 * time.Second
+	cfg.Redaction.Enabled = true
+	cfg.Stall.RepeatedCalls = 5
+	cfg.Stall.RepeatedErrors = 3
+	cfg.Stall.NoProgress = 30 * time.Minute
+	cfg.Stall.MaxDuration = 8 * time.Hour
+	cfg.Stall.CheckInterval = time.Minute
+	cfg.Events.Commands = publisher.CommandsOff
+	cfg.RescanInterval = watcher.DefaultRescanInterval
+	cfg.WatchMode = string(watcher.ModeAuto)
+	cfg.WatchPollInterval = watcher.DefaultPollInterval
+	cfg.Admin.Listen = admin.DefaultListen
+	cfg.Stream.MaxText = stream.DefaultMaxText
+	cfg.Stream.MaxInput = stream.DefaultMaxInput
+	cfg.Stream.MaxQueue = stream.DefaultMaxQueue
+	cfg.History.Enabled = true
+	cfg.History.Path = "~/.local/share/cc-sidecar/history.db"
+	cfg.Reports.Periods = []string{report.PeriodDaily}
+	cfg.Reports.At = "00:30"
+	cfg.Reports.Format = report.FormatMarkdown
+	cfg.Reports.Publish = true
+	cfg.Reports.Top = report.DefaultTop
+	cfg.Budget.Thresholds = budget.DefaultThresholds
+	cfg.Budget.Bucket = budget.DefaultBucket
+	cfg.Archive.Bucket = archive.DefaultBucket
+	cfg.Archive.Compression = archive.CompressionZstd
+	cfg.Archive.Replicas = 1
+	cfg.Provision.Stream.Name = "CC_SESSIONS"
+	cfg.Provision.Stream.Subjects = []string{"swarm.cc.session.>", "swarm.cc.report.>"}
+	cfg.Provision.Stream.Retention = "limits"
+	cfg.Provision.Stream.MaxAge = 30 * 24 * time.Hour
+	cfg.Provision.Stream.Replicas = 1
+	cfg.Provision.Stream.DuplicateWindow = 2 * time.Minute
+	cfg.Provision.Registry.TTL = 7 * 24 * time.Hour
+	cfg.Provision.Registry.History = 1
+	cfg.Provision.Registry.Replicas = 1
+	return cfg
+}
+
+// applyEnv applies env overrides, which take precedence over the file.
+func (c *Config) applyEnv() {
+	if v := os.Getenv("CC_SIDECAR_NATS_URL"); v != "" {
+		c.NATS.URL = v
+		c.NATS.URLs = nil
+	}
+	if v := os.Getenv("CC_SIDECAR_NATS_TOKEN"); v != "" {
+		c.NATS.Token = v
+	}
+	if v := os.Getenv("CC_SIDECAR_NATS_TOKEN_FILE"); v != "" {
+		c.NATS.TokenFile = v
+	}
+	if v := os.Getenv("CC_SIDECAR_NATS_CREDS_FILE"); v != "" {
+		c.NATS.CredsFile = v
+	}
+	if v := os.Getenv("CC_SIDECAR_NATS_NKEY_SEED_FILE"); v != "" {
+		c.NATS.NKeySeedFile = v
+	}
+	if v := os.Getenv("CC_SIDECAR_ADMIN_TOKEN"); v != "" {
+		c.Admin.Token = v
+	}
+	if v := os.Getenv("CC_SIDECAR_WATCH_DIR"); v != "" {
+		c.WatchDir = v
+	}
+	if v := os.Getenv("CC_SIDECAR_WATCH_MODE"); v != "" {
+		c.WatchMode = v
+	}
+	if v := os.Getenv("CC_SIDECAR_IDLE_THRESHOLD"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			c.IdleThreshold = d
+		}
+	}
+}
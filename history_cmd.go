@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/history"
+)
+
+// runHistory implements "cc-sidecar history", querying the local session
+// history database.
+func runHistory(args []string) int {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to config file")
+	dbPath := fs.String("db", "", "history database (default from config)")
+	project := fs.String("project", "", "project directory or working dir substring")
+	task := fs.String("task", "", "task ID")
+	owner := fs.String("owner", "", "owner UUID")
+	since := fs.String("since", "", "start time: 2006-01-02, RFC 3339, or a duration such as 7d or 12h")
+	until := fs.String("until", "", "end time, exclusive: 2006-01-02 or RFC 3339")
+	reason := fs.String("reason", "", "failure reason, e.g. "+history.ReasonNoAssistantResponse)
+	failed := fs.Bool("failed", false, "only failed sessions")
+	unpublished := fs.Bool("unpublished", false, "only sessions whose event was not published")
+	limit := fs.Int("limit", 50, "maximum sessions to list, 0 for all")
+	format := fs.String("format", history.FormatTable, "output format: table, json or csv")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	path := *dbPath
+	if path == "" {
+		path = loadConfig(*configPath, logger).History.Path
+	}
+
+	filter := history.Filter{
+		Project:       *project,
+		TaskID:        *task,
+		OwnerUUID:     *owner,
+		FailureReason: *reason,
+		Failed:        *failed,
+		Unpublished:   *unpublished,
+		Limit:         *limit,
+	}
+	now := time.Now()
+	var err error
+	if filter.Since, err = parseTime(*since, now); err != nil {
+		fmt.Fprintf(os.Stderr, "history: -since: %v\n", err)
+		return 2
+	}
+	if filter.Until, err = parseTime(*until, now); err != nil {
+		fmt.Fprintf(os.Stderr, "history: -until: %v\n", err)
+		return 2
+	}
+
+	store, err := history.Open(expandHome(path))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "history: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	recs, err := store.Query(context.Background(), filter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "history: %v\n", err)
+		return 1
+	}
+	if err := history.Write(os.Stdout, *format, recs); err != nil {
+		fmt.Fprintf(os.Stderr, "history: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// parseTime parses an absolute date or time, or a duration before now.
+// Durations accept a "d" suffix for days. Empty yields the zero time.
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q as a date, time or duration", s)
+}
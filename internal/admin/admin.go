@@ -0,0 +1,266 @@
+// Package admin serves the live session view over local HTTP for the top
+// dashboard and other tools on the same machine.
+//
+// Endpoints:
+//
+//	GET /v1/sessions                   all tracked sessions
+//	GET /v1/sessions/{id}              one session
+//	GET /v1/sessions/{id}/activity     recent parsed activity (?limit=N)
+//	GET /v1/events                     server-sent events: a "session" event
+//	                                   per session on connect, then one per
+//	                                   update
+//
+// Without a token the server only listens on loopback and only answers
+// requests addressed to a loopback host, so a web page cannot reach it
+// through DNS rebinding. With a token every request must carry it as a
+// bearer token, and any address may be used.
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/live"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/redact"
+)
+
+// DefaultListen is the default admin API address. It is loopback-only
+// because responses include prompts and tool output.
+const DefaultListen = "127.0.0.1:7777"
+
+const (
+	defaultActivityLimit = 100
+	maxActivityLimit     = 500
+	// keepaliveInterval is how often an idle event stream sends a comment
+	// to keep proxies and clients from timing out.
+	keepaliveInterval = 15 * time.Second
+)
+
+// Server is the admin HTTP server.
+type Server struct {
+	listen   string
+	token    string
+	monitor  *live.Monitor
+	redactor *redact.Redactor
+	logger   *slog.Logger
+
+	ln  net.Listener
+	srv *http.Server
+}
+
+// New creates an admin server for monitor on listen.
+func New(listen string, monitor *live.Monitor, logger *slog.Logger) *Server {
+	s := &Server{
+		listen:  listen,
+		monitor: monitor,
+		logger:  logger.With("component", "admin"),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/sessions", s.handleSessions)
+	mux.HandleFunc("GET /v1/sessions/{id}", s.handleSession)
+	mux.HandleFunc("GET /v1/sessions/{id}/activity", s.handleActivity)
+	mux.HandleFunc("GET /v1/events", s.handleEvents)
+	s.srv = &http.Server{Handler: s.guard(mux), ReadHeaderTimeout: 10 * time.Second}
+	return s
+}
+
+// SetToken requires every request to carry token as a bearer token, which
+// also allows listening on a non-loopback address. Must be called before
+// Start.
+func (s *Server) SetToken(token string) {
+	s.token = token
+}
+
+// SetRedactor scrubs every response before it is sent.
+func (s *Server) SetRedactor(r *redact.Redactor) {
+	s.redactor = r
+}
+
+// Start listens and serves in the background.
+func (s *Server) Start() error {
+	if s.token == "" && !loopback(s.listen) {
+		return fmt.Errorf("admin listen %s: a token is required to listen on a non-loopback address", s.listen)
+	}
+	ln, err := net.Listen("tcp", s.listen)
+	if err != nil {
+		return fmt.Errorf("admin listen: %w", err)
+	}
+	s.ln = ln
+	go func() {
+		if err := s.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("admin server stopped", "error", err)
+		}
+	}()
+	s.logger.Info("admin API listening", "addr", ln.Addr().String())
+	return nil
+}
+
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() string {
+	if s.ln == nil {
+		return s.listen
+	}
+	return s.ln.Addr().String()
+}
+
+// Stop shuts the server down, closing open event streams.
+func (s *Server) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = s.srv.Shutdown(ctx)
+}
+
+// guard rejects requests without the token or, when no token is set,
+// requests addressed to a host other than loopback.
+func (s *Server) guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				s.writeError(w, http.StatusUnauthorized, "missing or invalid token")
+				return
+			}
+		} else if !loopback(r.Host) {
+			s.writeError(w, http.StatusForbidden, "host not allowed")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// loopback reports whether addr, a host with an optional port, names the
+// loopback interface.
+func loopback(addr string) bool {
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, s.monitor.Sessions())
+}
+
+func (s *Server) handleSession(w http.ResponseWriter, r *http.Request) {
+	sess, ok := s.monitor.Session(r.PathValue("id"))
+	if !ok {
+		s.writeError(w, http.StatusNotFound, "session not tracked")
+		return
+	}
+	s.writeJSON(w, http.StatusOK, sess)
+}
+
+func (s *Server) handleActivity(w http.ResponseWriter, r *http.Request) {
+	limit := defaultActivityLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			s.writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		limit = min(n, maxActivityLimit)
+	}
+	acts, ok := s.monitor.Activity(r.PathValue("id"), limit)
+	if !ok {
+		s.writeError(w, http.StatusNotFound, "session not tracked")
+		return
+	}
+	s.writeJSON(w, http.StatusOK, acts)
+}
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.writeError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+	// Subscribe before the snapshot so no update falls in between.
+	updates, cancel := s.monitor.Subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for _, sess := range s.monitor.Sessions() {
+		if err := s.writeEvent(w, sess); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		select {
+		case sess, ok := <-updates:
+			if !ok {
+				return
+			}
+			if err := s.writeEvent(w, sess); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *Server) writeEvent(w http.ResponseWriter, sess live.Session) error {
+	data, err := s.marshal(sess)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: session\ndata: %s\n\n", data)
+	return err
+}
+
+// marshal encodes v as JSON, redacted if a redactor is set.
+func (s *Server) marshal(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if s.redactor == nil {
+		return data, nil
+	}
+	return s.redactor.JSON(data, redact.Report{})
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, status int, v any) {
+	data, err := s.marshal(v)
+	if err != nil {
+		s.logger.Error("failed to encode admin response", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(append(data, '\n'))
+}
+
+func (s *Server) writeError(w http.ResponseWriter, status int, msg string) {
+	s.writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,213 @@
+package admin
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/live"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/redact"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/session"
+)
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+}
+
+func startServer(t *testing.T, m *live.Monitor) *Server {
+	t.Helper()
+	s := New("127.0.0.1:0", m, testLogger())
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Stop)
+	return s
+}
+
+func getJSON(t *testing.T, url string, v any) int {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode
+}
+
+func TestServer_Sessions(t *testing.T) {
+	m := live.New(nil)
+	info := session.SessionInfo{SessionID: "s1", State: session.StateRunning, PID: 7}
+	m.Observe(info, []session.Activity{
+		{Kind: session.ActivityPrompt, Text: "hello"},
+		{Kind: session.ActivityToolUse, Tool: "Edit", FilePath: "/r/a.go"},
+	})
+	s := startServer(t, m)
+	base := "http://" + s.Addr()
+
+	var sessions []live.Session
+	if code := getJSON(t, base+"/v1/sessions", &sessions); code != 200 || len(sessions) != 1 || sessions[0].PID != 7 || sessions[0].FilesChanged != 1 {
+		t.Errorf("sessions = %d %+v", code, sessions)
+	}
+
+	var one live.Session
+	if code := getJSON(t, base+"/v1/sessions/s1", &one); code != 200 || one.LastTool != "Edit" {
+		t.Errorf("session = %d %+v", code, one)
+	}
+
+	var acts []session.Activity
+	if code := getJSON(t, base+"/v1/sessions/s1/activity?limit=1", &acts); code != 200 || len(acts) != 1 || acts[0].Tool != "Edit" {
+		t.Errorf("activity = %d %+v", code, acts)
+	}
+
+	var e map[string]string
+	if code := getJSON(t, base+"/v1/sessions/nope", &e); code != 404 || e["error"] == "" {
+		t.Errorf("missing session = %d %v", code, e)
+	}
+	if code := getJSON(t, base+"/v1/sessions/s1/activity?limit=x", &e); code != 400 {
+		t.Errorf("bad limit = %d", code)
+	}
+}
+
+func TestServer_Events(t *testing.T) {
+	m := live.New(nil)
+	m.ObserveState(session.SessionInfo{SessionID: "s1", State: session.StateRunning})
+	s := startServer(t, m)
+
+	resp, err := http.Get("http://" + s.Addr() + "/v1/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("content type = %q", ct)
+	}
+
+	events := make(chan live.Session, 10)
+	go func() {
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			if data, ok := strings.CutPrefix(sc.Text(), "data: "); ok {
+				var sess live.Session
+				if json.Unmarshal([]byte(data), &sess) == nil {
+					events <- sess
+				}
+			}
+		}
+		close(events)
+	}()
+
+	next := func() live.Session {
+		t.Helper()
+		select {
+		case ev := <-events:
+			return ev
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event")
+			return live.Session{}
+		}
+	}
+
+	// Snapshot first, then updates.
+	if ev := next(); ev.SessionID != "s1" {
+		t.Errorf("snapshot = %+v", ev)
+	}
+	m.ObserveState(session.SessionInfo{SessionID: "s2", State: session.StateIdle})
+	if ev := next(); ev.SessionID != "s2" || ev.State != session.StateIdle {
+		t.Errorf("update = %+v", ev)
+	}
+	m.Forget("s2")
+	if ev := next(); ev.SessionID != "s2" || !ev.Removed {
+		t.Errorf("removal = %+v", ev)
+	}
+}
+
+func TestServer_Redacts(t *testing.T) {
+	m := live.New(nil)
+	m.Observe(session.SessionInfo{SessionID: "s1"}, []session.Activity{{Kind: session.ActivityPrompt, Text: "mail me at dev@example.com"}})
+	r, err := redact.New(redact.Config{Detectors: []string{"email"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New("127.0.0.1:0", m, testLogger())
+	s.SetRedactor(r)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	resp, err := http.Get("http://" + s.Addr() + "/v1/sessions/s1/activity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if strings.Contains(string(body), "dev@example.com") || !strings.Contains(string(body), "[REDACTED:email]") {
+		t.Errorf("body = %s", body)
+	}
+}
+
+func TestServer_RejectsForeignHost(t *testing.T) {
+	s := startServer(t, live.New(nil))
+
+	for host, want := range map[string]int{
+		s.Addr():            http.StatusOK,
+		"localhost:7777":    http.StatusOK,
+		"[::1]:7777":        http.StatusOK,
+		"evil.example:7777": http.StatusForbidden,
+		"192.168.1.10:7777": http.StatusForbidden,
+	} {
+		req, _ := http.NewRequest(http.MethodGet, "http://"+s.Addr()+"/v1/sessions", nil)
+		req.Host = host
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("Host %s: status %d, want %d", host, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestServer_Token(t *testing.T) {
+	if err := New("0.0.0.0:0", live.New(nil), testLogger()).Start(); err == nil {
+		t.Fatal("non-loopback listen without a token succeeded")
+	}
+
+	s := New("0.0.0.0:0", live.New(nil), testLogger())
+	s.SetToken("secret")
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Stop)
+
+	for auth, want := range map[string]int{
+		"":              http.StatusUnauthorized,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"secret":        http.StatusUnauthorized,
+		"Bearer secret": http.StatusOK,
+	} {
+		req, _ := http.NewRequest(http.MethodGet, "http://"+s.Addr()+"/v1/sessions", nil)
+		req.Host = "sidecar.example:7777"
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("Authorization %q: status %d, want %d", auth, resp.StatusCode, want)
+		}
+	}
+}
@@ -0,0 +1,501 @@
+// Package archive uploads finished transcripts to a JetStream Object Store so
+// that consumers on other machines can read the full conversation.
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/redact"
+	"github.com/klauspost/compress/zstd"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// DefaultBucket is the object store bucket holding transcripts.
+const DefaultBucket = "CC_TRANSCRIPTS"
+
+// Compression algorithms.
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// metaSHA256 is the object metadata key holding a digest: of the
+// uncompressed transcript on a manifest, used to skip re-uploads, and of the
+// stored bytes on a part, used to skip parts already stored.
+const metaSHA256 = "sha256"
+
+// manifestType is the Content-Type header of manifests.
+const manifestType = "application/json"
+
+const (
+	defaultChunkSize = 128 * 1024
+	defaultPartSize  = 8 * 1024 * 1024
+	uploadAttempts   = 3
+	retryBackoff     = 2 * time.Second
+)
+
+// Object describes an uploaded transcript. It is embedded in session events.
+type Object struct {
+	Bucket      string `json:"bucket"`
+	Name        string `json:"name"`
+	Compression string `json:"compression"`
+	// Size is the stored (compressed) size in bytes.
+	Size uint64 `json:"size"`
+	// RawSize is the size of the stored transcript before compression.
+	RawSize int64 `json:"raw_size"`
+	// SHA256 is the hex digest of the uncompressed transcript as stored,
+	// i.e. after redaction.
+	SHA256 string `json:"sha256"`
+	// Parts is the number of part objects the manifest at Name lists.
+	Parts int `json:"parts"`
+	// Redactions counts what was scrubbed from the stored transcript.
+	Redactions redact.Report `json:"redactions,omitempty"`
+}
+
+// Manifest is the content of the object named after a session. It lists the
+// part objects which, concatenated, hold the compressed transcript.
+type Manifest struct {
+	Compression string `json:"compression"`
+	Size        uint64 `json:"size"`
+	RawSize     int64  `json:"raw_size"`
+	SHA256      string `json:"sha256"`
+	Parts       []Part `json:"parts"`
+}
+
+// Part is one object of a stored transcript.
+type Part struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// SHA256 is the hex digest of the part as stored.
+	SHA256 string `json:"sha256"`
+
+	offset int64 // in the snapshot
+}
+
+// Config configures an Uploader.
+type Config struct {
+	Bucket      string
+	Compression string
+	// ChunkSize is the object store chunk size in bytes.
+	ChunkSize int
+	// PartSize is the size in bytes of the part objects a transcript is
+	// split into, the unit a failed upload is resumed in.
+	PartSize int
+	// MaxAge expires stored transcripts. Zero keeps them forever.
+	MaxAge   time.Duration
+	Replicas int
+}
+
+// Uploader stores transcripts in an object store bucket. Each transcript is
+// first snapshotted to a compressed temporary file, then split into numbered
+// part objects of PartSize bytes, named after the manifest and the
+// transcript's digest. The manifest, stored last under the session's object
+// name, lists the parts; Open reads them back. A failed upload is retried
+// from the first part not yet stored, so a large transcript on a flaky
+// connection is not sent again from the start. A manifest whose recorded
+// digest matches the transcript is not uploaded again, so a republish of an
+// archived session is free; replacing it deletes the parts of the previous
+// version. Parts of an upload that never completes are left to MaxAge.
+type Uploader struct {
+	js     jetstream.JetStream
+	cfg    Config
+	logger *slog.Logger
+
+	redactor *redact.Redactor
+
+	mu    sync.Mutex
+	store jetstream.ObjectStore
+}
+
+// New creates an uploader. The bucket is created on first use if it does not
+// exist.
+func New(js jetstream.JetStream, cfg Config, logger *slog.Logger) (*Uploader, error) {
+	if cfg.Bucket == "" {
+		cfg.Bucket = DefaultBucket
+	}
+	switch cfg.Compression {
+	case "":
+		cfg.Compression = CompressionZstd
+	case CompressionNone, CompressionGzip, CompressionZstd:
+	default:
+		return nil, fmt.Errorf("unknown compression %q", cfg.Compression)
+	}
+	if cfg.ChunkSize <= 0 {
+		cfg.ChunkSize = defaultChunkSize
+	}
+	if cfg.PartSize <= 0 {
+		cfg.PartSize = defaultPartSize
+	}
+	return &Uploader{
+		js:     js,
+		cfg:    cfg,
+		logger: logger.With("component", "archive"),
+	}, nil
+}
+
+// SetRedactor scrubs each transcript line before it is stored.
+func (u *Uploader) SetRedactor(r *redact.Redactor) {
+	u.redactor = r
+}
+
+// ObjectName returns the object name for a session's transcript.
+func (u *Uploader) ObjectName(sessionID string) string {
+	switch u.cfg.Compression {
+	case CompressionGzip:
+		return sessionID + ".jsonl.gz"
+	case CompressionZstd:
+		return sessionID + ".jsonl.zst"
+	}
+	return sessionID + ".jsonl"
+}
+
+// Upload stores the transcript at path under the session ID.
+func (u *Uploader) Upload(ctx context.Context, sessionID, path string) (*Object, error) {
+	obj := &Object{
+		Bucket:      u.cfg.Bucket,
+		Name:        u.ObjectName(sessionID),
+		Compression: u.cfg.Compression,
+	}
+	snap, err := u.snapshot(path, obj)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot transcript: %w", err)
+	}
+	defer func() {
+		snap.Close()
+		os.Remove(snap.Name())
+	}()
+
+	parts, err := u.split(snap, obj)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot transcript: %w", err)
+	}
+
+	store, err := u.objectStore(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("object store: %w", err)
+	}
+
+	var previous *Manifest
+	if info, err := store.GetInfo(ctx, obj.Name); err == nil && isManifest(info) {
+		if info.Metadata[metaSHA256] == obj.SHA256 {
+			u.logger.Debug("transcript already uploaded", "session_id", sessionID, "object", obj.Name)
+			return obj, nil
+		}
+		previous, _ = readManifest(ctx, store, obj.Name)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = u.put(ctx, store, obj, snap, parts)
+		if err == nil {
+			break
+		}
+		if attempt == uploadAttempts || ctx.Err() != nil {
+			return nil, fmt.Errorf("upload %s: %w", obj.Name, err)
+		}
+		u.logger.Warn("transcript upload failed, resuming", "session_id", sessionID, "attempt", attempt, "error", err)
+		select {
+		case <-time.After(retryBackoff * time.Duration(attempt)):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("upload %s: %w", obj.Name, ctx.Err())
+		}
+	}
+	if previous != nil {
+		u.prune(ctx, store, previous, parts)
+	}
+
+	u.logger.Info("uploaded transcript", "session_id", sessionID, "object", obj.Name, "raw_size", obj.RawSize, "size", obj.Size, "parts", obj.Parts)
+	return obj, nil
+}
+
+// snapshot reads the transcript once, redacting, hashing and compressing it
+// into a temporary file, and records the digest, raw size and redactions in
+// obj. The digest therefore always describes the stored content, even if
+// the transcript is appended to meanwhile.
+func (u *Uploader) snapshot(path string, obj *Object) (*os.File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tmp, err := os.CreateTemp("", "cc-sidecar-archive-*")
+	if err != nil {
+		return nil, err
+	}
+
+	rep := redact.Report{}
+	src := u.source(f, rep)
+	defer src.Close()
+	h := sha256.New()
+	var n counter
+	if err := compress(tmp, io.TeeReader(src, io.MultiWriter(h, &n)), obj.Compression); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	obj.SHA256 = hex.EncodeToString(h.Sum(nil))
+	obj.RawSize = int64(n)
+	if len(rep) > 0 {
+		obj.Redactions = rep
+	}
+	return tmp, nil
+}
+
+// split divides the snapshot into parts, hashing each, and records the
+// stored size and part count in obj. Parts are named after the transcript's
+// digest so that those of different versions never mix.
+func (u *Uploader) split(snap *os.File, obj *Object) ([]Part, error) {
+	fi, err := snap.Stat()
+	if err != nil {
+		return nil, err
+	}
+	var parts []Part
+	for off := int64(0); off < fi.Size(); off += int64(u.cfg.PartSize) {
+		size := min(int64(u.cfg.PartSize), fi.Size()-off)
+		h := sha256.New()
+		if _, err := io.Copy(h, io.NewSectionReader(snap, off, size)); err != nil {
+			return nil, err
+		}
+		parts = append(parts, Part{
+			Name:   fmt.Sprintf("%s.parts/%s/%05d", obj.Name, obj.SHA256[:16], len(parts)),
+			Size:   size,
+			SHA256: hex.EncodeToString(h.Sum(nil)),
+			offset: off,
+		})
+	}
+	obj.Size = uint64(fi.Size())
+	obj.Parts = len(parts)
+	return parts, nil
+}
+
+// put stores the parts not stored yet, then the manifest listing them.
+func (u *Uploader) put(ctx context.Context, store jetstream.ObjectStore, obj *Object, snap *os.File, parts []Part) error {
+	for _, p := range parts {
+		if info, err := store.GetInfo(ctx, p.Name); err == nil && info.Metadata[metaSHA256] == p.SHA256 && int64(info.Size) == p.Size {
+			continue
+		}
+		_, err := store.Put(ctx, jetstream.ObjectMeta{
+			Name:        p.Name,
+			Description: "claude code transcript part",
+			Metadata:    map[string]string{metaSHA256: p.SHA256},
+			Opts:        &jetstream.ObjectMetaOptions{ChunkSize: uint32(u.cfg.ChunkSize)},
+		}, io.NewSectionReader(snap, p.offset, p.Size))
+		if err != nil {
+			return fmt.Errorf("part %s: %w", p.Name, err)
+		}
+	}
+
+	data, err := json.Marshal(Manifest{
+		Compression: obj.Compression,
+		Size:        obj.Size,
+		RawSize:     obj.RawSize,
+		SHA256:      obj.SHA256,
+		Parts:       parts,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = store.Put(ctx, jetstream.ObjectMeta{
+		Name:        obj.Name,
+		Description: "claude code transcript manifest",
+		Headers:     map[string][]string{"Content-Type": {manifestType}},
+		Metadata:    map[string]string{metaSHA256: obj.SHA256},
+	}, bytes.NewReader(data))
+	return err
+}
+
+// prune deletes the parts of a replaced manifest that the new one does not
+// list. Failures only leave garbage behind, so they are logged.
+func (u *Uploader) prune(ctx context.Context, store jetstream.ObjectStore, previous *Manifest, parts []Part) {
+	keep := make(map[string]bool, len(parts))
+	for _, p := range parts {
+		keep[p.Name] = true
+	}
+	for _, p := range previous.Parts {
+		if keep[p.Name] {
+			continue
+		}
+		if err := store.Delete(ctx, p.Name); err != nil && !errors.Is(err, jetstream.ErrObjectNotFound) {
+			u.logger.Warn("failed to delete part of replaced transcript", "object", p.Name, "error", err)
+		}
+	}
+}
+
+// isManifest tells a manifest from a transcript stored whole, as earlier
+// versions did.
+func isManifest(info *jetstream.ObjectInfo) bool {
+	return info.Headers.Get("Content-Type") == manifestType
+}
+
+// readManifest fetches and parses the manifest stored under name.
+func readManifest(ctx context.Context, store jetstream.ObjectStore, name string) (*Manifest, error) {
+	data, err := store.GetBytes(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parse manifest %s: %w", name, err)
+	}
+	return &m, nil
+}
+
+// Open returns the transcript stored under name, reassembled from its parts
+// and decompressed. Consumers use it to read transcripts back.
+func Open(ctx context.Context, store jetstream.ObjectStore, name string) (io.ReadCloser, error) {
+	m, err := readManifest(ctx, store, name)
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		for _, p := range m.Parts {
+			res, err := store.Get(ctx, p.Name)
+			if err != nil {
+				pw.CloseWithError(fmt.Errorf("part %s: %w", p.Name, err))
+				return
+			}
+			_, err = io.Copy(pw, res)
+			res.Close()
+			if err != nil {
+				pw.CloseWithError(fmt.Errorf("part %s: %w", p.Name, err))
+				return
+			}
+		}
+		pw.Close()
+	}()
+	r, err := Decompress(pr, m.Compression)
+	if err != nil {
+		pr.CloseWithError(err)
+		return nil, err
+	}
+	return &readCloser{Reader: r, close: func() error {
+		r.Close()
+		return pr.Close()
+	}}, nil
+}
+
+// readCloser pairs a reader with a custom Close.
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r *readCloser) Close() error { return r.close() }
+
+// counter counts the bytes written to it.
+type counter int64
+
+func (c *counter) Write(p []byte) (int, error) {
+	*c += counter(len(p))
+	return len(p), nil
+}
+
+// objectStore returns the bucket handle, creating the bucket if needed.
+func (u *Uploader) objectStore(ctx context.Context) (jetstream.ObjectStore, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.store != nil {
+		return u.store, nil
+	}
+	store, err := u.js.ObjectStore(ctx, u.cfg.Bucket)
+	if errors.Is(err, jetstream.ErrBucketNotFound) {
+		store, err = u.js.CreateObjectStore(ctx, jetstream.ObjectStoreConfig{
+			Bucket:      u.cfg.Bucket,
+			Description: "cc-sidecar session transcripts",
+			TTL:         u.cfg.MaxAge,
+			Replicas:    u.cfg.Replicas,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.store = store
+	return store, nil
+}
+
+// compress copies src to dst using the given algorithm.
+func compress(dst io.Writer, src io.Reader, algorithm string) error {
+	var w io.WriteCloser
+	switch algorithm {
+	case CompressionGzip:
+		w = gzip.NewWriter(dst)
+	case CompressionZstd:
+		zw, err := zstd.NewWriter(dst)
+		if err != nil {
+			return err
+		}
+		w = zw
+	default:
+		_, err := io.Copy(dst, src)
+		return err
+	}
+	if _, err := io.Copy(w, src); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Decompress wraps r to undo the given compression.
+func Decompress(r io.Reader, algorithm string) (io.ReadCloser, error) {
+	switch algorithm {
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	case CompressionZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return io.NopCloser(r), nil
+}
+
+// source returns the transcript content as it should be stored, with each
+// line redacted when a redactor is set. Closing it stops the redaction.
+func (u *Uploader) source(f io.Reader, rep redact.Report) io.ReadCloser {
+	if u.redactor == nil {
+		return io.NopCloser(f)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		t := u.redactor.Transcript()
+		br := bufio.NewReader(f)
+		for {
+			line, err := br.ReadBytes('\n')
+			if len(line) > 0 {
+				out := t.Line(bytes.TrimSuffix(line, []byte{'\n'}), rep)
+				if line[len(line)-1] == '\n' {
+					out = append(out, '\n')
+				}
+				if _, werr := pw.Write(out); werr != nil {
+					return
+				}
+			}
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				pw.CloseWithError(err)
+				return
+			}
+		}
+	}()
+	return pr
+}
@@ -0,0 +1,100 @@
+// Package attribution matches completed sessions to the task that launched
+// them. Several strategies are supported behind the Resolver interface and
+// tried in a configured order.
+package attribution
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"time"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/registry"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/session"
+)
+
+// Resolver attributes a session to a task. Resolve returns nil when the
+// resolver has no opinion about the session.
+type Resolver interface {
+	Name() string
+	Resolve(s *session.CompletedSession) *registry.TaskMapping
+}
+
+// Chain tries resolvers in order and returns the first mapping found.
+type Chain struct {
+	resolvers []Resolver
+	logger    *slog.Logger
+}
+
+// NewChain creates a resolver chain.
+func NewChain(logger *slog.Logger, resolvers ...Resolver) *Chain {
+	return &Chain{
+		resolvers: resolvers,
+		logger:    logger.With("component", "attribution"),
+	}
+}
+
+// Resolve returns the first mapping found and the name of the resolver that
+// produced it, or nil and "" if no resolver matched.
+func (c *Chain) Resolve(s *session.CompletedSession) (*registry.TaskMapping, string) {
+	for _, r := range c.resolvers {
+		if m := r.Resolve(s); m != nil && m.TaskID != "" {
+			c.logger.Debug("session attributed", "session_id", s.SessionID, "resolver", r.Name(), "task_id", m.TaskID)
+			return m, r.Name()
+		}
+	}
+	return nil, ""
+}
+
+// RegistryResolver looks the session ID up in the registry KV bucket,
+// optionally waiting for a mapping the orchestrator writes late.
+type RegistryResolver struct {
+	reg  *registry.Registry
+	wait time.Duration
+}
+
+// NewRegistryResolver creates a registry-backed resolver. A positive wait
+// makes Resolve block up to that long for a missing mapping.
+func NewRegistryResolver(reg *registry.Registry, wait time.Duration) *RegistryResolver {
+	return &RegistryResolver{reg: reg, wait: wait}
+}
+
+// Name implements Resolver.
+func (r *RegistryResolver) Name() string { return "registry" }
+
+// Resolve implements Resolver.
+func (r *RegistryResolver) Resolve(s *session.CompletedSession) *registry.TaskMapping {
+	if r.wait <= 0 {
+		return r.reg.Lookup(s.SessionID)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), r.wait)
+	defer cancel()
+	return r.reg.WaitFor(ctx, s.SessionID)
+}
+
+// mappingFromMatch builds a mapping from a regexp match using the named
+// groups "task" and "owner". Without a "task" group the first submatch is
+// used as the task ID.
+func mappingFromMatch(re *regexp.Regexp, s string) *registry.TaskMapping {
+	match := re.FindStringSubmatch(s)
+	if match == nil {
+		return nil
+	}
+
+	var m registry.TaskMapping
+	for i, name := range re.SubexpNames() {
+		switch name {
+		case "task":
+			m.TaskID = match[i]
+		case "owner":
+			m.OwnerUUID = match[i]
+		}
+	}
+	if m.TaskID == "" && re.SubexpIndex("task") < 0 && len(match) > 1 {
+		m.TaskID = match[1]
+	}
+	if m.TaskID == "" {
+		return nil
+	}
+	return &m
+}
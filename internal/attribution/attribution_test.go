@@ -0,0 +1,220 @@
+package attribution
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/registry"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/session"
+)
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+}
+
+// staticResolver always returns the same mapping.
+type staticResolver struct {
+	name    string
+	mapping *registry.TaskMapping
+	calls   int
+}
+
+func (r *staticResolver) Name() string { return r.name }
+
+func (r *staticResolver) Resolve(*session.CompletedSession) *registry.TaskMapping {
+	r.calls++
+	return r.mapping
+}
+
+func TestChain_FirstMatchWins(t *testing.T) {
+	none := &staticResolver{name: "none"}
+	first := &staticResolver{name: "first", mapping: &registry.TaskMapping{TaskID: "t1"}}
+	second := &staticResolver{name: "second", mapping: &registry.TaskMapping{TaskID: "t2"}}
+
+	m, by := NewChain(testLogger(), none, first, second).Resolve(&session.CompletedSession{SessionID: "s"})
+	if m == nil || m.TaskID != "t1" || by != "first" {
+		t.Errorf("Resolve = %+v, %q; want t1 from first", m, by)
+	}
+	if none.calls != 1 || second.calls != 0 {
+		t.Errorf("calls: none=%d second=%d, want 1 and 0", none.calls, second.calls)
+	}
+}
+
+func TestChain_SkipsEmptyTaskID(t *testing.T) {
+	empty := &staticResolver{name: "empty", mapping: &registry.TaskMapping{OwnerUUID: "o"}}
+	real := &staticResolver{name: "real", mapping: &registry.TaskMapping{TaskID: "t"}}
+
+	_, by := NewChain(testLogger(), empty, real).Resolve(&session.CompletedSession{})
+	if by != "real" {
+		t.Errorf("resolver = %q, want real", by)
+	}
+}
+
+func TestChain_NoMatch(t *testing.T) {
+	m, by := NewChain(testLogger()).Resolve(&session.CompletedSession{})
+	if m != nil || by != "" {
+		t.Errorf("Resolve = %+v, %q; want nil", m, by)
+	}
+}
+
+func TestPromptResolver(t *testing.T) {
+	r, err := NewPromptResolver("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		prompt string
+		want   string
+	}{
+		{"[task:ABC-123] fix the login bug", "ABC-123"},
+		{"Please fix the bug.\n\n[task:xyz]", "xyz"},
+		{"no marker here", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		m := r.Resolve(&session.CompletedSession{FirstPrompt: tt.prompt})
+		got := ""
+		if m != nil {
+			got = m.TaskID
+		}
+		if got != tt.want {
+			t.Errorf("Resolve(%q) = %q, want %q", tt.prompt, got, tt.want)
+		}
+	}
+}
+
+func TestPromptResolver_CustomPatternWithOwner(t *testing.T) {
+	r, err := NewPromptResolver(`task=(?P<task>\S+) owner=(?P<owner>\S+)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := r.Resolve(&session.CompletedSession{FirstPrompt: "task=T1 owner=O1 go"})
+	if m == nil || m.TaskID != "T1" || m.OwnerUUID != "O1" {
+		t.Errorf("Resolve = %+v, want T1/O1", m)
+	}
+
+	if _, err := NewPromptResolver("(unclosed"); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+}
+
+// fakeProc writes a /proc/<pid>/environ file under a temp root for a claude
+// process with a file descriptor open on /p/s.jsonl.
+func fakeProc(t *testing.T, pid int, env ...string) string {
+	t.Helper()
+	root := t.TempDir()
+	dir := filepath.Join(root, fmt.Sprint(pid))
+	os.MkdirAll(filepath.Join(dir, "fd"), 0755)
+	os.Symlink("/p/s.jsonl", filepath.Join(dir, "fd", "3"))
+	os.WriteFile(filepath.Join(dir, "cmdline"), []byte("claude\x00"), 0644)
+	var data []byte
+	for _, kv := range env {
+		data = append(data, kv...)
+		data = append(data, 0)
+	}
+	os.WriteFile(filepath.Join(dir, "environ"), data, 0644)
+	return root
+}
+
+func TestEnvResolver_ObserveThenResolve(t *testing.T) {
+	r := NewEnvResolver("", "")
+	r.procRoot = fakeProc(t, 42, "HOME=/home/mike", "SWARM_TASK_ID=task-env", "SWARM_OWNER_UUID=owner-env")
+
+	r.Observe("/p/s.jsonl", 42)
+
+	// The process has exited by completion time.
+	r.procRoot = t.TempDir()
+
+	m := r.Resolve(&session.CompletedSession{TranscriptPath: "/p/s.jsonl", PID: 42})
+	if m == nil || m.TaskID != "task-env" || m.OwnerUUID != "owner-env" {
+		t.Fatalf("Resolve = %+v, want task-env/owner-env", m)
+	}
+
+	// Resolving again (e.g. mid-session, then on completion) still works.
+	if m := r.Resolve(&session.CompletedSession{TranscriptPath: "/p/s.jsonl", PID: 42}); m == nil {
+		t.Error("expected capture to survive a second Resolve")
+	}
+
+	// Forget drops the capture once the session is done.
+	r.Forget("/p/s.jsonl")
+	if m := r.Resolve(&session.CompletedSession{TranscriptPath: "/p/s.jsonl", PID: 42}); m != nil {
+		t.Errorf("expected capture to be forgotten, got %+v", m)
+	}
+}
+
+func TestEnvResolver_LiveProcessAndCustomVars(t *testing.T) {
+	r := NewEnvResolver("MY_TASK", "MY_OWNER")
+	r.procRoot = fakeProc(t, 7, "MY_TASK=t7", "SWARM_TASK_ID=ignored")
+
+	m := r.Resolve(&session.CompletedSession{TranscriptPath: "/p/s.jsonl", PID: 7})
+	if m == nil || m.TaskID != "t7" {
+		t.Errorf("Resolve = %+v, want t7", m)
+	}
+	if m := r.Resolve(&session.CompletedSession{TranscriptPath: "/p/s.jsonl"}); m != nil {
+		t.Errorf("expected nil without PID or capture, got %+v", m)
+	}
+	// Same process, but it is writing another session in the project.
+	if m := r.Resolve(&session.CompletedSession{TranscriptPath: "/p/other.jsonl", PID: 7}); m != nil {
+		t.Errorf("expected nil for a process not holding the transcript, got %+v", m)
+	}
+}
+
+func TestPathResolver_CWD(t *testing.T) {
+	r, err := NewPathResolver([]PathRule{{Source: SourceCWD, Pattern: `/worktrees/(?P<task>[^/]+)`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := r.Resolve(&session.CompletedSession{WorkingDir: "/home/mike/worktrees/TASK-9/src"})
+	if m == nil || m.TaskID != "TASK-9" {
+		t.Errorf("Resolve = %+v, want TASK-9", m)
+	}
+	if m := r.Resolve(&session.CompletedSession{WorkingDir: "/home/mike/Warren"}); m != nil {
+		t.Errorf("expected no match, got %+v", m)
+	}
+}
+
+func TestPathResolver_Branch(t *testing.T) {
+	repo := t.TempDir()
+	os.MkdirAll(filepath.Join(repo, ".git"), 0755)
+	os.WriteFile(filepath.Join(repo, ".git", "HEAD"), []byte("ref: refs/heads/task/ABC-42\n"), 0644)
+	sub := filepath.Join(repo, "internal", "api")
+	os.MkdirAll(sub, 0755)
+
+	r, err := NewPathResolver([]PathRule{{Source: SourceBranch, Pattern: `^task/(?P<task>.+)$`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := r.Resolve(&session.CompletedSession{WorkingDir: sub})
+	if m == nil || m.TaskID != "ABC-42" {
+		t.Errorf("Resolve = %+v, want ABC-42", m)
+	}
+}
+
+func TestGitBranch_WorktreeAndDetached(t *testing.T) {
+	gitDir := t.TempDir()
+	os.WriteFile(filepath.Join(gitDir, "HEAD"), []byte("ref: refs/heads/feature/x\n"), 0644)
+	wt := t.TempDir()
+	os.WriteFile(filepath.Join(wt, ".git"), []byte("gitdir: "+gitDir+"\n"), 0644)
+
+	if got := gitBranch(wt); got != "feature/x" {
+		t.Errorf("gitBranch(worktree) = %q, want feature/x", got)
+	}
+
+	os.WriteFile(filepath.Join(gitDir, "HEAD"), []byte("0123456789abcdef\n"), 0644)
+	if got := gitBranch(wt); got != "" {
+		t.Errorf("gitBranch(detached) = %q, want empty", got)
+	}
+}
+
+func TestNewPathResolver_Errors(t *testing.T) {
+	if _, err := NewPathResolver([]PathRule{{Source: "hostname", Pattern: "."}}); err == nil {
+		t.Error("expected error for unknown source")
+	}
+	if _, err := NewPathResolver([]PathRule{{Source: SourceCWD, Pattern: "("}}); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+}
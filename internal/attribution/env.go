@@ -0,0 +1,135 @@
+package attribution
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/registry"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/session"
+)
+
+// Default environment variables read by EnvResolver.
+const (
+	DefaultTaskVar  = "SWARM_TASK_ID"
+	DefaultOwnerVar = "SWARM_OWNER_UUID"
+)
+
+// captureTTL bounds how long a captured environment is kept if Forget is
+// never called. Captures are not consumed on Resolve because a running
+// session may be resolved several times (e.g. for policy violations) before
+// it completes.
+const captureTTL = 24 * time.Hour
+
+type envCapture struct {
+	mapping registry.TaskMapping
+	at      time.Time
+}
+
+// EnvResolver reads task variables from the environment of the claude
+// process. The process has usually exited by the time a session completes,
+// so the environment is captured when the tracker sees the process (via
+// Observe), on first sight and again on resume, and kept until Forget. Only
+// the process session.FindWriter picks is trusted, as another claude process
+// in the same project would carry a different task.
+type EnvResolver struct {
+	taskVar  string
+	ownerVar string
+	procRoot string
+
+	mu       sync.Mutex
+	captured map[string]envCapture // keyed by transcript path
+}
+
+// NewEnvResolver creates a resolver reading taskVar and ownerVar.
+func NewEnvResolver(taskVar, ownerVar string) *EnvResolver {
+	if taskVar == "" {
+		taskVar = DefaultTaskVar
+	}
+	if ownerVar == "" {
+		ownerVar = DefaultOwnerVar
+	}
+	return &EnvResolver{
+		taskVar:  taskVar,
+		ownerVar: ownerVar,
+		procRoot: "/proc",
+		captured: make(map[string]envCapture),
+	}
+}
+
+// Name implements Resolver.
+func (r *EnvResolver) Name() string { return "env" }
+
+// Observe captures the task variables of pid, the process writing a
+// transcript. It matches session.OnProcess so it can be registered with
+// the tracker directly.
+func (r *EnvResolver) Observe(transcriptPath string, pid int) {
+	m, err := r.read(pid)
+	if err != nil || m.TaskID == "" {
+		return
+	}
+	now := time.Now()
+	r.mu.Lock()
+	for path, c := range r.captured {
+		if now.Sub(c.at) > captureTTL {
+			delete(r.captured, path)
+		}
+	}
+	r.captured[transcriptPath] = envCapture{mapping: m, at: now}
+	r.mu.Unlock()
+}
+
+// Resolve implements Resolver. A captured environment is preferred; if none
+// was captured the process is read directly, provided it is still the
+// transcript's writer.
+func (r *EnvResolver) Resolve(s *session.CompletedSession) *registry.TaskMapping {
+	r.mu.Lock()
+	c, ok := r.captured[s.TranscriptPath]
+	r.mu.Unlock()
+	if ok {
+		return &c.mapping
+	}
+
+	if !session.IsWriter(r.procRoot, s.PID, s.TranscriptPath) {
+		// Exited, or the PID now belongs to another process.
+		return nil
+	}
+	m, err := r.read(s.PID)
+	if err != nil || m.TaskID == "" {
+		return nil
+	}
+	return &m
+}
+
+// Forget drops the captured environment of a session whose transcript is
+// gone.
+func (r *EnvResolver) Forget(transcriptPath string) {
+	r.mu.Lock()
+	delete(r.captured, transcriptPath)
+	r.mu.Unlock()
+}
+
+// read parses /proc/<pid>/environ.
+func (r *EnvResolver) read(pid int) (registry.TaskMapping, error) {
+	raw, err := os.ReadFile(fmt.Sprintf("%s/%d/environ", r.procRoot, pid))
+	if err != nil {
+		return registry.TaskMapping{}, err
+	}
+
+	var m registry.TaskMapping
+	for _, kv := range bytes.Split(raw, []byte{0}) {
+		key, value, ok := bytes.Cut(kv, []byte{'='})
+		if !ok {
+			continue
+		}
+		switch string(key) {
+		case r.taskVar:
+			m.TaskID = string(value)
+		case r.ownerVar:
+			m.OwnerUUID = string(value)
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,129 @@
+package attribution
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/registry"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/session"
+)
+
+// Sources a PathRule can match against.
+const (
+	SourceCWD    = "cwd"
+	SourceBranch = "branch"
+)
+
+// PathRule extracts a task ID from the session's working directory or the
+// git branch checked out there.
+type PathRule struct {
+	Source  string // SourceCWD or SourceBranch
+	Pattern string // regexp with a "task" (and optionally "owner") group
+}
+
+type compiledRule struct {
+	source string
+	re     *regexp.Regexp
+}
+
+// PathResolver matches working directories and git branches against naming
+// conventions, e.g. worktrees at /work/TASK-123 or branches named task/TASK-123.
+type PathResolver struct {
+	rules []compiledRule
+}
+
+// NewPathResolver compiles the given rules.
+func NewPathResolver(rules []PathRule) (*PathResolver, error) {
+	r := &PathResolver{}
+	for i, rule := range rules {
+		if rule.Source != SourceCWD && rule.Source != SourceBranch {
+			return nil, fmt.Errorf("path rule %d: unknown source %q", i, rule.Source)
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("path rule %d: %w", i, err)
+		}
+		r.rules = append(r.rules, compiledRule{source: rule.Source, re: re})
+	}
+	return r, nil
+}
+
+// Name implements Resolver.
+func (r *PathResolver) Name() string { return "path" }
+
+// Resolve implements Resolver. Rules are tried in order.
+func (r *PathResolver) Resolve(s *session.CompletedSession) *registry.TaskMapping {
+	if s.WorkingDir == "" {
+		return nil
+	}
+
+	var branch string
+	branchRead := false
+	for _, rule := range r.rules {
+		subject := s.WorkingDir
+		if rule.source == SourceBranch {
+			if !branchRead {
+				branch = gitBranch(s.WorkingDir)
+				branchRead = true
+			}
+			subject = branch
+		}
+		if subject == "" {
+			continue
+		}
+		if m := mappingFromMatch(rule.re, subject); m != nil {
+			return m
+		}
+	}
+	return nil
+}
+
+// gitBranch returns the branch checked out in the repository containing dir,
+// or "" if dir is not in a repository or HEAD is detached. Worktrees, whose
+// .git is a file pointing at the real git dir, are supported.
+func gitBranch(dir string) string {
+	for d := dir; ; d = filepath.Dir(d) {
+		gitPath := filepath.Join(d, ".git")
+		info, err := os.Stat(gitPath)
+		if err == nil {
+			gitDir := gitPath
+			if !info.IsDir() {
+				gitDir = worktreeGitDir(d, gitPath)
+				if gitDir == "" {
+					return ""
+				}
+			}
+			head, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+			if err != nil {
+				return ""
+			}
+			ref, ok := strings.CutPrefix(strings.TrimSpace(string(head)), "ref: ")
+			if !ok {
+				return "" // detached HEAD
+			}
+			return strings.TrimPrefix(ref, "refs/heads/")
+		}
+		if parent := filepath.Dir(d); parent == d {
+			return ""
+		}
+	}
+}
+
+// worktreeGitDir resolves the "gitdir: <path>" pointer in a .git file.
+func worktreeGitDir(repoDir, gitFile string) string {
+	raw, err := os.ReadFile(gitFile)
+	if err != nil {
+		return ""
+	}
+	gitDir, ok := strings.CutPrefix(strings.TrimSpace(string(raw)), "gitdir: ")
+	if !ok {
+		return ""
+	}
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(repoDir, gitDir)
+	}
+	return gitDir
+}
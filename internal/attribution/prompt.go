@@ -0,0 +1,42 @@
+package attribution
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/registry"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/session"
+)
+
+// DefaultPromptPattern matches markers such as "[task:XYZ-123]".
+const DefaultPromptPattern = `\[task:(?P<task>[^\]\s]+)\]`
+
+// PromptResolver reads a task marker from the first user prompt.
+type PromptResolver struct {
+	re *regexp.Regexp
+}
+
+// NewPromptResolver creates a resolver matching pattern against the first
+// prompt. The pattern should capture the task ID in a group named "task" and
+// may capture an owner in a group named "owner".
+func NewPromptResolver(pattern string) (*PromptResolver, error) {
+	if pattern == "" {
+		pattern = DefaultPromptPattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("prompt pattern: %w", err)
+	}
+	return &PromptResolver{re: re}, nil
+}
+
+// Name implements Resolver.
+func (r *PromptResolver) Name() string { return "prompt" }
+
+// Resolve implements Resolver.
+func (r *PromptResolver) Resolve(s *session.CompletedSession) *registry.TaskMapping {
+	if s.FirstPrompt == "" {
+		return nil
+	}
+	return mappingFromMatch(r.re, s.FirstPrompt)
+}
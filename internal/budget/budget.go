@@ -0,0 +1,616 @@
+// Package budget tracks spend per task and per owner as transcripts grow and
+// alerts when a budget crosses its thresholds.
+package budget
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/pricing"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/session"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Budget scopes.
+const (
+	ScopeTask  = "task"
+	ScopeOwner = "owner"
+)
+
+// DefaultBucket is the KV bucket holding budgets set at runtime. Keys are
+// "task.<task_id>" or "owner.<owner_uuid>"; values are {"limit_usd": 25}.
+const DefaultBucket = "CC_BUDGETS"
+
+const (
+	// resolveRetry is how often an unattributed session is re-resolved.
+	resolveRetry = 30 * time.Second
+	// watchRetryInterval is how long to wait before re-establishing the KV
+	// watch after the bucket was unavailable or the watch ended.
+	watchRetryInterval = 5 * time.Second
+)
+
+// DefaultThresholds are the fractions of a budget that raise an alert.
+var DefaultThresholds = []float64{0.5, 0.8, 1.0}
+
+// Limit caps the spend of one task or owner.
+type Limit struct {
+	Scope    string  `json:"scope"`
+	ID       string  `json:"id"`
+	LimitUSD float64 `json:"limit_usd"`
+}
+
+// Alert reports a budget crossing a threshold.
+type Alert struct {
+	Scope     string  `json:"scope"`
+	ID        string  `json:"id"`
+	LimitUSD  float64 `json:"limit_usd"`
+	SpentUSD  float64 `json:"spent_usd"`
+	Threshold float64 `json:"threshold"`
+	// Signal names the signal sent to the session's claude process, if any.
+	Signal string    `json:"signal,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// Attribution is the task and owner a session's spend is charged to.
+type Attribution struct {
+	TaskID    string
+	OwnerUUID string
+}
+
+// Resolver attributes a running session. It returns false while the session
+// cannot be attributed yet and may block briefly.
+type Resolver func(info session.SessionInfo) (Attribution, bool)
+
+// OnAlert is called, from the enforcer's goroutine, for each threshold
+// crossed. info is the session whose spend crossed it, attributed to attr.
+type OnAlert func(info session.SessionInfo, attr Attribution, a Alert)
+
+// Config configures an Enforcer.
+type Config struct {
+	Limits []Limit
+	// Thresholds are fractions of the limit, in increasing order. Each
+	// fires once per budget. Defaults to DefaultThresholds.
+	Thresholds []float64
+	// Signal, if non-zero, is sent to the claude process of any session that
+	// spends against an exhausted budget.
+	Signal syscall.Signal
+}
+
+// Validate checks limits and thresholds.
+func (c Config) Validate() error {
+	for _, l := range c.Limits {
+		if err := l.validate(); err != nil {
+			return err
+		}
+	}
+	for i, t := range c.Thresholds {
+		if t <= 0 {
+			return fmt.Errorf("budget threshold %v must be positive", t)
+		}
+		if i > 0 && t <= c.Thresholds[i-1] {
+			return fmt.Errorf("budget thresholds must be increasing")
+		}
+	}
+	return nil
+}
+
+func (l Limit) validate() error {
+	if l.Scope != ScopeTask && l.Scope != ScopeOwner {
+		return fmt.Errorf("budget %q: unknown scope %q", l.ID, l.Scope)
+	}
+	if l.ID == "" {
+		return fmt.Errorf("budget: %s limit without id", l.Scope)
+	}
+	if l.LimitUSD <= 0 {
+		return fmt.Errorf("budget %s %q: limit_usd must be positive", l.Scope, l.ID)
+	}
+	return nil
+}
+
+// key identifies a budget.
+type key struct {
+	scope string
+	id    string
+}
+
+func (k key) String() string { return k.scope + "." + k.id }
+
+// parseKey parses a KV key such as "task.XYZ-123".
+func parseKey(s string) (key, bool) {
+	scope, id, ok := strings.Cut(s, ".")
+	if !ok || id == "" || (scope != ScopeTask && scope != ScopeOwner) {
+		return key{}, false
+	}
+	return key{scope, id}, true
+}
+
+// sessionState is what the enforcer knows about one session.
+type sessionState struct {
+	info        session.SessionInfo
+	attributed  bool
+	attr        Attribution
+	keys        []key
+	pending     float64 // spend not yet charged, while unattributed
+	lastResolve time.Time
+	signalled   bool
+}
+
+// observation is a batch of usage from one session.
+type observation struct {
+	info   session.SessionInfo
+	usages []session.Activity
+}
+
+// Enforcer charges session spend to task and owner budgets.
+//
+// Totals are kept in memory and cover the sessions this sidecar has seen
+// since it started. Transcripts are read from the beginning when first
+// seen, so sessions running across a restart are counted in full.
+//
+// Only the claude process writing a session's transcript is signalled (see
+// session.FindWriter), looked up right before the signal is sent: the PID
+// recorded when the session was first seen may have been reused since. A
+// session sharing its project directory with another claude process cannot
+// be told apart and is not signalled.
+type Enforcer struct {
+	prices     *pricing.Table
+	resolve    Resolver
+	onAlert    OnAlert
+	thresholds []float64
+	signal     syscall.Signal
+	logger     *slog.Logger
+
+	// kill sends a signal to a process and findWriter returns the process
+	// writing a transcript; replaced in tests.
+	kill       func(pid int, sig syscall.Signal) error
+	findWriter func(transcriptPath string) int
+
+	bucket string
+
+	mu        sync.Mutex
+	js        jetstream.JetStream
+	stopWatch context.CancelFunc // ends the running bucket watch
+	queue     []observation
+	limits    map[key]float64 // from config
+	kvLimits  map[key]float64 // from the KV bucket; take precedence
+	spent     map[key]float64
+	fired     map[key]int // number of thresholds already alerted
+	sessions  map[string]*sessionState
+	unpriced  map[string]bool
+
+	wake    chan struct{}
+	done    chan struct{}
+	rewatch chan struct{}
+}
+
+// New creates an enforcer. Call Start to begin charging spend.
+func New(cfg Config, prices *pricing.Table, resolve Resolver, onAlert OnAlert, logger *slog.Logger) (*Enforcer, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	thresholds := cfg.Thresholds
+	if len(thresholds) == 0 {
+		thresholds = DefaultThresholds
+	}
+	if prices == nil {
+		prices = pricing.Default()
+	}
+
+	e := &Enforcer{
+		prices:     prices,
+		resolve:    resolve,
+		onAlert:    onAlert,
+		thresholds: slices.Clone(thresholds),
+		signal:     cfg.Signal,
+		logger:     logger.With("component", "budget"),
+		kill:       syscall.Kill,
+		findWriter: session.FindWriter,
+		limits:     make(map[key]float64),
+		kvLimits:   make(map[key]float64),
+		spent:      make(map[key]float64),
+		fired:      make(map[key]int),
+		sessions:   make(map[string]*sessionState),
+		unpriced:   make(map[string]bool),
+		wake:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+		rewatch:    make(chan struct{}, 1),
+	}
+	for _, l := range cfg.Limits {
+		e.limits[key{l.Scope, l.ID}] = l.LimitUSD
+	}
+	return e, nil
+}
+
+// SetBucket loads additional budgets from a KV bucket, kept current by a
+// watch while Start runs. KV budgets override configured ones with the same
+// key. It must be called before Start.
+func (e *Enforcer) SetBucket(js jetstream.JetStream, bucket string) {
+	e.js = js
+	e.bucket = bucket
+}
+
+// SetJetStream moves the bucket watch to a new connection, e.g. after the
+// publisher reconnects. Spend and budgets already loaded are kept. It has
+// no effect without a bucket.
+func (e *Enforcer) SetJetStream(js jetstream.JetStream) {
+	e.mu.Lock()
+	if e.js == nil {
+		e.mu.Unlock()
+		return
+	}
+	e.js = js
+	stop := e.stopWatch
+	e.mu.Unlock()
+
+	if stop != nil {
+		stop()
+	}
+	select {
+	case e.rewatch <- struct{}{}:
+	default:
+	}
+}
+
+// Observe queues the usage in acts. It never blocks on attribution or
+// publishing and is intended as a Tracker OnActivity callback.
+func (e *Enforcer) Observe(info session.SessionInfo, acts []session.Activity) {
+	var usages []session.Activity
+	for _, a := range acts {
+		if a.Kind == session.ActivityUsage && a.Usage != nil {
+			usages = append(usages, a)
+		}
+	}
+	if len(usages) == 0 {
+		return
+	}
+
+	e.mu.Lock()
+	e.queue = append(e.queue, observation{info: info, usages: usages})
+	e.mu.Unlock()
+
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Forget drops per-session state. Spend already charged stays on its
+// budgets.
+func (e *Enforcer) Forget(sessionID string) {
+	e.mu.Lock()
+	delete(e.sessions, sessionID)
+	e.mu.Unlock()
+}
+
+// Spent returns the spend charged to a budget so far.
+func (e *Enforcer) Spent(scope, id string) float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.spent[key{scope, id}]
+}
+
+// Start charges queued usage and watches the KV bucket, if set. Blocks
+// until Stop.
+func (e *Enforcer) Start() {
+	e.mu.Lock()
+	watch := e.js != nil && e.bucket != ""
+	e.mu.Unlock()
+	if watch {
+		go e.watchBucket()
+	}
+
+	retry := time.NewTicker(resolveRetry)
+	defer retry.Stop()
+
+	for {
+		select {
+		case <-e.wake:
+			e.drain()
+		case <-retry.C:
+			e.retryPending(time.Now())
+		case <-e.done:
+			return
+		}
+	}
+}
+
+// Stop halts the enforcer.
+func (e *Enforcer) Stop() {
+	close(e.done)
+}
+
+// drain charges every queued observation.
+func (e *Enforcer) drain() {
+	e.mu.Lock()
+	queue := e.queue
+	e.queue = nil
+	e.mu.Unlock()
+
+	for _, o := range queue {
+		e.charge(o, time.Now())
+	}
+}
+
+// charge prices an observation and charges it to the session's budgets.
+func (e *Enforcer) charge(o observation, now time.Time) {
+	var cost float64
+	for _, a := range o.usages {
+		if a.Usage == nil {
+			continue
+		}
+		c, ok := e.prices.Cost(a.Model, *a.Usage)
+		if !ok {
+			e.warnUnpriced(a.Model)
+			continue
+		}
+		cost += c
+	}
+
+	e.mu.Lock()
+	st := e.sessions[o.info.SessionID]
+	if st == nil {
+		st = &sessionState{}
+		e.sessions[o.info.SessionID] = st
+	}
+	st.info = o.info
+	st.pending += cost
+	e.mu.Unlock()
+
+	e.settle(st, now)
+}
+
+// retryPending re-resolves sessions whose spend is waiting for attribution.
+func (e *Enforcer) retryPending(now time.Time) {
+	e.mu.Lock()
+	var waiting []*sessionState
+	for _, st := range e.sessions {
+		if !st.attributed && st.pending > 0 {
+			waiting = append(waiting, st)
+		}
+	}
+	e.mu.Unlock()
+
+	for _, st := range waiting {
+		e.settle(st, now)
+	}
+}
+
+// settle attributes the session if needed and charges its pending spend.
+func (e *Enforcer) settle(st *sessionState, now time.Time) {
+	e.mu.Lock()
+	info := st.info
+	needResolve := !st.attributed && now.Sub(st.lastResolve) >= resolveRetry
+	if needResolve {
+		st.lastResolve = now
+	}
+	e.mu.Unlock()
+
+	if needResolve && e.resolve != nil {
+		if attr, ok := e.resolve(info); ok {
+			var keys []key
+			if attr.TaskID != "" {
+				keys = append(keys, key{ScopeTask, attr.TaskID})
+			}
+			if attr.OwnerUUID != "" {
+				keys = append(keys, key{ScopeOwner, attr.OwnerUUID})
+			}
+			e.mu.Lock()
+			st.attributed = true
+			st.attr = attr
+			st.keys = keys
+			e.mu.Unlock()
+		}
+	}
+
+	e.mu.Lock()
+	if !st.attributed || st.pending == 0 {
+		e.mu.Unlock()
+		return
+	}
+	cost := st.pending
+	st.pending = 0
+	attr := st.attr
+
+	var alerts []Alert
+	exhausted := false
+	for _, k := range st.keys {
+		e.spent[k] += cost
+		limit, ok := e.limit(k)
+		if !ok {
+			continue
+		}
+		alerts = append(alerts, e.crossed(k, limit, now)...)
+		if e.spent[k] >= limit {
+			exhausted = true
+		}
+	}
+	signal := exhausted && e.signal != 0 && !st.signalled
+	e.mu.Unlock()
+
+	// settle runs on the enforcer's goroutine only, so st.signalled cannot
+	// change meanwhile.
+	if signal {
+		signal = e.signalWriter(info)
+		if signal {
+			e.mu.Lock()
+			st.signalled = true
+			e.mu.Unlock()
+		}
+	}
+
+	for _, a := range alerts {
+		if signal && a.Threshold >= 1 {
+			a.Signal = e.signal.String()
+		}
+		e.logger.Info("budget threshold crossed", "scope", a.Scope, "id", a.ID, "threshold", a.Threshold, "spent_usd", a.SpentUSD, "limit_usd", a.LimitUSD, "session_id", info.SessionID)
+		if e.onAlert != nil {
+			e.onAlert(info, attr, a)
+		}
+	}
+}
+
+// signalWriter signals the process writing the session's transcript, if it
+// can be identified, and reports whether it was signalled.
+func (e *Enforcer) signalWriter(info session.SessionInfo) bool {
+	pid := e.findWriter(info.TranscriptPath)
+	if pid == 0 {
+		e.logger.Warn("cannot identify the process writing the over-budget session's transcript, not signalling", "session_id", info.SessionID, "path", info.TranscriptPath)
+		return false
+	}
+	if err := e.kill(pid, e.signal); err != nil {
+		e.logger.Warn("failed to signal over-budget session", "session_id", info.SessionID, "pid", pid, "error", err)
+		return false
+	}
+	e.logger.Info("signalled over-budget session", "session_id", info.SessionID, "pid", pid, "signal", e.signal.String())
+	return true
+}
+
+// limit returns the budget for k. Caller must hold e.mu.
+func (e *Enforcer) limit(k key) (float64, bool) {
+	if l, ok := e.kvLimits[k]; ok {
+		return l, true
+	}
+	l, ok := e.limits[k]
+	return l, ok
+}
+
+// crossed returns alerts for thresholds of k newly crossed, in increasing
+// order. Caller must hold e.mu.
+func (e *Enforcer) crossed(k key, limit float64, now time.Time) []Alert {
+	spent := e.spent[k]
+	var alerts []Alert
+	for e.fired[k] < len(e.thresholds) && spent >= e.thresholds[e.fired[k]]*limit {
+		alerts = append(alerts, Alert{
+			Scope:     k.scope,
+			ID:        k.id,
+			LimitUSD:  limit,
+			SpentUSD:  spent,
+			Threshold: e.thresholds[e.fired[k]],
+			At:        now,
+		})
+		e.fired[k]++
+	}
+	return alerts
+}
+
+// setLimit changes a KV budget. Thresholds no longer crossed under the new
+// limit are re-armed. A zero limit removes the budget.
+func (e *Enforcer) setLimit(k key, limit float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if limit <= 0 {
+		delete(e.kvLimits, k)
+	} else {
+		e.kvLimits[k] = limit
+	}
+
+	current, ok := e.limit(k)
+	if !ok {
+		delete(e.fired, k)
+		return
+	}
+	n := 0
+	for n < len(e.thresholds) && e.spent[k] >= e.thresholds[n]*current {
+		n++
+	}
+	if e.fired[k] > n {
+		e.fired[k] = n
+	}
+}
+
+func (e *Enforcer) warnUnpriced(model string) {
+	e.mu.Lock()
+	seen := e.unpriced[model]
+	e.unpriced[model] = true
+	e.mu.Unlock()
+	if !seen {
+		e.logger.Warn("no price for model, its usage is not charged", "model", model)
+	}
+}
+
+// watchBucket keeps kvLimits current until Stop.
+func (e *Enforcer) watchBucket() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-e.done
+		cancel()
+	}()
+
+	for {
+		e.watch(ctx)
+
+		select {
+		case <-e.done:
+			return
+		case <-e.rewatch:
+		case <-time.After(watchRetryInterval):
+		}
+	}
+}
+
+// watch runs a single WatchAll subscription until it ends or ctx is done.
+func (e *Enforcer) watch(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	e.mu.Lock()
+	js := e.js
+	e.stopWatch = cancel
+	e.mu.Unlock()
+
+	kv, err := js.KeyValue(ctx, e.bucket)
+	if err != nil {
+		e.logger.Debug("budget bucket not available, will retry", "bucket", e.bucket, "error", err)
+		return
+	}
+	w, err := kv.WatchAll(ctx)
+	if err != nil {
+		e.logger.Warn("failed to watch budget bucket, will retry", "bucket", e.bucket, "error", err)
+		return
+	}
+	defer func() { _ = w.Stop() }()
+
+	for {
+		select {
+		case entry, ok := <-w.Updates():
+			if !ok {
+				return
+			}
+			if entry != nil {
+				e.apply(entry)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (e *Enforcer) apply(entry jetstream.KeyValueEntry) {
+	k, ok := parseKey(entry.Key())
+	if !ok {
+		e.logger.Warn("ignoring budget with malformed key", "key", entry.Key())
+		return
+	}
+	if entry.Operation() != jetstream.KeyValuePut {
+		e.setLimit(k, 0)
+		return
+	}
+
+	var v struct {
+		LimitUSD float64 `json:"limit_usd"`
+	}
+	if err := json.Unmarshal(entry.Value(), &v); err != nil || v.LimitUSD <= 0 {
+		e.logger.Warn("ignoring invalid budget", "key", entry.Key(), "error", err)
+		return
+	}
+	e.setLimit(k, v.LimitUSD)
+	e.logger.Debug("budget updated", "key", k.String(), "limit_usd", v.LimitUSD)
+}
@@ -0,0 +1,341 @@
+package budget
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/natstest"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/pricing"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/session"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+}
+
+// dollarPrices charges $1 per input token of model "m".
+func dollarPrices(t *testing.T) *pricing.Table {
+	t.Helper()
+	p, err := pricing.New(map[string]pricing.Price{"m": {Input: 1_000_000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func usage(tokens int64) []session.Activity {
+	return []session.Activity{
+		{Kind: session.ActivityText, Text: "hi"},
+		{Kind: session.ActivityUsage, Model: "m", Usage: &session.TokenUsage{InputTokens: tokens}},
+	}
+}
+
+type recorder struct {
+	mu     sync.Mutex
+	alerts []Alert
+}
+
+func (r *recorder) onAlert(_ session.SessionInfo, _ Attribution, a Alert) {
+	r.mu.Lock()
+	r.alerts = append(r.alerts, a)
+	r.mu.Unlock()
+}
+
+func (r *recorder) thresholds(scope string) []float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []float64
+	for _, a := range r.alerts {
+		if a.Scope == scope {
+			out = append(out, a.Threshold)
+		}
+	}
+	return out
+}
+
+func attributeTo(task, owner string) Resolver {
+	return func(session.SessionInfo) (Attribution, bool) {
+		return Attribution{TaskID: task, OwnerUUID: owner}, true
+	}
+}
+
+func TestEnforcer_Thresholds(t *testing.T) {
+	rec := &recorder{}
+	e, err := New(Config{Limits: []Limit{
+		{Scope: ScopeTask, ID: "T1", LimitUSD: 10},
+		{Scope: ScopeOwner, ID: "O1", LimitUSD: 100},
+	}}, dollarPrices(t), attributeTo("T1", "O1"), rec.onAlert, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := session.SessionInfo{SessionID: "s1"}
+	now := time.Now()
+	e.Observe(info, usage(4))
+	e.drain()
+	if got := rec.thresholds(ScopeTask); len(got) != 0 {
+		t.Fatalf("alerts at 40%% = %v", got)
+	}
+
+	// 4 → 9 crosses 50% and 80% in one step.
+	e.Observe(info, usage(5))
+	e.drain()
+	if got := rec.thresholds(ScopeTask); len(got) != 2 || got[0] != 0.5 || got[1] != 0.8 {
+		t.Fatalf("alerts at 90%% = %v", got)
+	}
+
+	// A second session on the same task pushes it over.
+	e.charge(observation{info: session.SessionInfo{SessionID: "s2"}, usages: usage(2)}, now)
+	if got := rec.thresholds(ScopeTask); len(got) != 3 || got[2] != 1.0 {
+		t.Fatalf("alerts at 110%% = %v", got)
+	}
+	if got := e.Spent(ScopeTask, "T1"); got != 11 {
+		t.Errorf("spent = %v, want 11", got)
+	}
+	if got := rec.thresholds(ScopeOwner); len(got) != 0 {
+		t.Errorf("owner alerts = %v", got)
+	}
+
+	// Thresholds fire once.
+	e.Observe(info, usage(50))
+	e.drain()
+	if got := rec.thresholds(ScopeTask); len(got) != 3 {
+		t.Errorf("repeat alerts = %v", got)
+	}
+	if got := rec.thresholds(ScopeOwner); len(got) != 1 || got[0] != 0.5 {
+		t.Errorf("owner alerts = %v", got)
+	}
+}
+
+func TestEnforcer_PendingUntilAttributed(t *testing.T) {
+	rec := &recorder{}
+	var attributed bool
+	resolve := func(session.SessionInfo) (Attribution, bool) {
+		return Attribution{TaskID: "T1"}, attributed
+	}
+	e, err := New(Config{Limits: []Limit{{Scope: ScopeTask, ID: "T1", LimitUSD: 10}}}, dollarPrices(t), resolve, rec.onAlert, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	info := session.SessionInfo{SessionID: "s1"}
+	e.charge(observation{info: info, usages: usage(6)}, now)
+	if got := e.Spent(ScopeTask, "T1"); got != 0 {
+		t.Fatalf("spent before attribution = %v", got)
+	}
+
+	// Not re-resolved before the retry interval.
+	attributed = true
+	e.retryPending(now.Add(time.Second))
+	if got := e.Spent(ScopeTask, "T1"); got != 0 {
+		t.Fatalf("spent before retry = %v", got)
+	}
+
+	e.retryPending(now.Add(resolveRetry))
+	if got := e.Spent(ScopeTask, "T1"); got != 6 {
+		t.Errorf("spent after attribution = %v, want 6", got)
+	}
+	if got := rec.thresholds(ScopeTask); len(got) != 1 || got[0] != 0.5 {
+		t.Errorf("alerts = %v", got)
+	}
+}
+
+func TestEnforcer_SignalsOverBudgetSession(t *testing.T) {
+	rec := &recorder{}
+	e, err := New(Config{
+		Limits: []Limit{{Scope: ScopeTask, ID: "T1", LimitUSD: 10}},
+		Signal: syscall.SIGINT,
+	}, dollarPrices(t), attributeTo("T1", ""), rec.onAlert, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var killed []int
+	e.kill = func(pid int, sig syscall.Signal) error {
+		if sig != syscall.SIGINT {
+			t.Errorf("signal = %v", sig)
+		}
+		killed = append(killed, pid)
+		return nil
+	}
+	writers := map[string]int{"/p/s1.jsonl": 100, "/p/s2.jsonl": 200}
+	e.findWriter = func(path string) int { return writers[path] }
+
+	now := time.Now()
+	// The PID recorded in SessionInfo is never trusted.
+	s1 := session.SessionInfo{SessionID: "s1", TranscriptPath: "/p/s1.jsonl", PID: 999}
+	e.charge(observation{info: s1, usages: usage(12)}, now)
+	e.charge(observation{info: s1, usages: usage(1)}, now)
+	// Another session spending against the exhausted budget is signalled too.
+	e.charge(observation{info: session.SessionInfo{SessionID: "s2", TranscriptPath: "/p/s2.jsonl"}, usages: usage(1)}, now)
+	// No process holds s3's transcript open, so it is left alone until one does.
+	s3 := session.SessionInfo{SessionID: "s3", TranscriptPath: "/p/s3.jsonl", PID: 300}
+	e.charge(observation{info: s3, usages: usage(1)}, now)
+
+	if len(killed) != 2 || killed[0] != 100 || killed[1] != 200 {
+		t.Errorf("killed = %v", killed)
+	}
+	writers["/p/s3.jsonl"] = 301
+	e.charge(observation{info: s3, usages: usage(1)}, now)
+	if len(killed) != 3 || killed[2] != 301 {
+		t.Errorf("killed = %v, want s3's writer signalled once found", killed)
+	}
+	if n := len(rec.alerts); n != 3 || rec.alerts[2].Signal != "interrupt" || rec.alerts[0].Signal != "" {
+		t.Errorf("alerts = %+v", rec.alerts)
+	}
+}
+
+func TestEnforcer_UnpricedModel(t *testing.T) {
+	e, err := New(Config{}, dollarPrices(t), attributeTo("T1", ""), nil, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	acts := []session.Activity{{Kind: session.ActivityUsage, Model: "unknown", Usage: &session.TokenUsage{InputTokens: 5}}}
+	e.charge(observation{info: session.SessionInfo{SessionID: "s1"}, usages: acts}, time.Now())
+	if got := e.Spent(ScopeTask, "T1"); got != 0 {
+		t.Errorf("spent = %v", got)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	bad := []Config{
+		{Limits: []Limit{{Scope: "team", ID: "x", LimitUSD: 1}}},
+		{Limits: []Limit{{Scope: ScopeTask, LimitUSD: 1}}},
+		{Limits: []Limit{{Scope: ScopeTask, ID: "x"}}},
+		{Thresholds: []float64{0.8, 0.5}},
+		{Thresholds: []float64{0}},
+	}
+	for i, c := range bad {
+		if err := c.Validate(); err == nil {
+			t.Errorf("config %d: expected error", i)
+		}
+	}
+}
+
+func TestEnforcer_BudgetsFromKV(t *testing.T) {
+	s := natstest.Run(t, nil)
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	kv, err := js.CreateKeyValue(ctx, jetstream.KeyValueConfig{Bucket: DefaultBucket})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kv.Put(ctx, "task.T1", []byte(`{"limit_usd": 4}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := &recorder{}
+	e, err := New(Config{Limits: []Limit{{Scope: ScopeTask, ID: "T1", LimitUSD: 100}}}, dollarPrices(t), attributeTo("T1", ""), rec.onAlert, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SetBucket(js, DefaultBucket)
+	go e.Start()
+	defer e.Stop()
+
+	waitFor(t, func() bool {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		return e.kvLimits[key{ScopeTask, "T1"}] == 4
+	})
+
+	e.Observe(session.SessionInfo{SessionID: "s1"}, usage(3))
+	waitFor(t, func() bool { return len(rec.thresholds(ScopeTask)) == 1 })
+
+	// Raising the limit re-arms thresholds no longer crossed.
+	if _, err := kv.Put(ctx, "task.T1", []byte(`{"limit_usd": 10}`)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		return e.fired[key{ScopeTask, "T1"}] == 0
+	})
+	e.Observe(session.SessionInfo{SessionID: "s1"}, usage(3))
+	waitFor(t, func() bool { return len(rec.thresholds(ScopeTask)) == 2 })
+
+	// Deleting the KV entry falls back to the configured limit.
+	if err := kv.Delete(ctx, "task.T1"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		_, ok := e.kvLimits[key{ScopeTask, "T1"}]
+		return !ok
+	})
+}
+
+func TestEnforcer_SetJetStream(t *testing.T) {
+	bucket := func() (jetstream.JetStream, jetstream.KeyValue) {
+		s := natstest.Run(t, nil)
+		nc, err := nats.Connect(s.ClientURL())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(nc.Close)
+		js, err := jetstream.New(nc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kv, err := js.CreateKeyValue(context.Background(), jetstream.KeyValueConfig{Bucket: DefaultBucket})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return js, kv
+	}
+	js, _ := bucket()
+	js2, kv2 := bucket()
+
+	rec := &recorder{}
+	e, err := New(Config{}, dollarPrices(t), attributeTo("T1", ""), rec.onAlert, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SetBucket(js, DefaultBucket)
+	go e.Start()
+	defer e.Stop()
+
+	e.Observe(session.SessionInfo{SessionID: "s1"}, usage(3))
+	waitFor(t, func() bool { return e.Spent(ScopeTask, "T1") == 3 })
+
+	e.SetJetStream(js2)
+	if _, err := kv2.Put(context.Background(), "task.T1", []byte(`{"limit_usd": 4}`)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		return e.kvLimits[key{ScopeTask, "T1"}] == 4
+	})
+	// Spend charged before the move counts against the new budget.
+	e.Observe(session.SessionInfo{SessionID: "s1"}, usage(1))
+	waitFor(t, func() bool { return slices.Contains(rec.thresholds(ScopeTask), 1.0) })
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
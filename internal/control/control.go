@@ -0,0 +1,383 @@
+// Package control answers request/reply queries from remote operators over
+// NATS. Each sidecar listens on swarm.cc.sidecar.{host}.{command}.
+package control
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/redact"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/session"
+	"github.com/nats-io/nats.go"
+)
+
+// SubjectPrefix is the subject namespace for control requests.
+const SubjectPrefix = "swarm.cc.sidecar"
+
+const (
+	defaultTailLines = 20
+	maxTailLines     = 1000
+)
+
+// Tracker is the subset of session.Tracker used by the control plane.
+// ForceComplete is answered as soon as it returns, so its completion
+// callback must hand the session off rather than publish it inline.
+type Tracker interface {
+	Sessions() []session.SessionInfo
+	TranscriptPath(sessionID string) (string, bool)
+	ForceComplete(sessionID string) (*session.CompletedSession, error)
+}
+
+// Republisher publishes a completion event for a parsed session.
+type Republisher func(s *session.CompletedSession) error
+
+// Request is the JSON body of a control request. Fields not used by a
+// command are ignored.
+type Request struct {
+	SessionID string `json:"session_id"`
+	Lines     int    `json:"lines"`
+}
+
+// Response is the JSON reply to every control request.
+type Response struct {
+	OK    bool   `json:"ok"`
+	Host  string `json:"host"`
+	Error string `json:"error,omitempty"`
+	Data  any    `json:"data,omitempty"`
+	// Redactions counts what was scrubbed from Data.
+	Redactions redact.Report `json:"redactions,omitempty"`
+}
+
+// Server handles control requests for one host.
+type Server struct {
+	mu   sync.Mutex // guards nc, host and sub, which Resubscribe replaces
+	nc   *nats.Conn
+	host string
+	sub  *nats.Subscription
+
+	watchDirs []string
+	tracker   Tracker
+	republish Republisher
+	logger    *slog.Logger
+	redactor  *redact.Redactor
+	allow     func(transcriptPath string) bool
+}
+
+// New creates a control server. watchDirs are searched for transcripts of
+// sessions the tracker no longer holds.
+func New(nc *nats.Conn, host string, watchDirs []string, tracker Tracker, republish Republisher, logger *slog.Logger) *Server {
+	return &Server{
+		nc:        nc,
+		host:      SubjectToken(host),
+		watchDirs: watchDirs,
+		tracker:   tracker,
+		republish: republish,
+		logger:    logger.With("component", "control"),
+	}
+}
+
+// SetRedactor scrubs reply data before it is sent. Must be called before
+// Start.
+func (s *Server) SetRedactor(r *redact.Redactor) {
+	s.redactor = r
+}
+
+// SetAllow restricts the transcripts found on disk to those allow accepts,
+// so sessions excluded from watching cannot be republished or read. Must be
+// called before Start.
+func (s *Server) SetAllow(allow func(transcriptPath string) bool) {
+	s.allow = allow
+}
+
+// SubjectToken makes a hostname safe to use as a single subject token.
+func SubjectToken(host string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t':
+			return '-'
+		}
+		return r
+	}, host)
+}
+
+// Subject returns the request subject for a command on this host.
+func (s *Server) Subject(command string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return subject(s.host, command)
+}
+
+func subject(host, command string) string {
+	return fmt.Sprintf("%s.%s.%s", SubjectPrefix, host, command)
+}
+
+// Start subscribes to control requests.
+func (s *Server) Start() error {
+	return s.Resubscribe(s.nc, s.host)
+}
+
+// Resubscribe moves the server to a new connection or host, e.g. after a
+// config reload. If subscribing fails the server keeps answering where it
+// did.
+func (s *Server) Resubscribe(nc *nats.Conn, host string) error {
+	host = SubjectToken(host)
+	sub, err := nc.Subscribe(subject(host, ">"), s.handle)
+	if err != nil {
+		return fmt.Errorf("subscribe control subject: %w", err)
+	}
+	s.mu.Lock()
+	old := s.sub
+	s.nc, s.host, s.sub = nc, host, sub
+	s.mu.Unlock()
+
+	if old != nil {
+		_ = old.Unsubscribe()
+	}
+	s.logger.Info("control plane listening", "subject", sub.Subject)
+	return nil
+}
+
+// Stop unsubscribes from control requests.
+func (s *Server) Stop() {
+	s.mu.Lock()
+	sub := s.sub
+	s.mu.Unlock()
+	if sub != nil {
+		_ = sub.Unsubscribe()
+	}
+}
+
+// conn returns the current connection and host.
+func (s *Server) conn() (*nats.Conn, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nc, s.host
+}
+
+func (s *Server) handle(msg *nats.Msg) {
+	command := msg.Subject[strings.LastIndex(msg.Subject, ".")+1:]
+
+	var req Request
+	if len(msg.Data) > 0 {
+		if err := json.Unmarshal(msg.Data, &req); err != nil {
+			s.reply(msg, nil, fmt.Errorf("invalid request: %w", err))
+			return
+		}
+	}
+
+	var (
+		data any
+		err  error
+	)
+	switch command {
+	case "list":
+		data = s.list()
+	case "get":
+		data, err = s.get(req)
+	case "force-complete":
+		data, err = s.forceComplete(req)
+	case "republish":
+		data, err = s.doRepublish(req)
+	case "transcript-tail":
+		data, err = s.tail(req)
+	default:
+		err = fmt.Errorf("unknown command %q", command)
+	}
+
+	s.logger.Debug("control request", "command", command, "session_id", req.SessionID, "error", err)
+	s.reply(msg, data, err)
+}
+
+func (s *Server) reply(msg *nats.Msg, data any, err error) {
+	if msg.Reply == "" {
+		return
+	}
+	_, host := s.conn()
+	resp := Response{OK: err == nil, Host: host}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	if data != nil {
+		resp.Data, resp.Redactions = s.redactData(data)
+	}
+	raw, mErr := json.Marshal(resp)
+	if mErr != nil {
+		raw, _ = json.Marshal(Response{Host: host, Error: "marshal response: " + mErr.Error()})
+	}
+	if err := msg.Respond(raw); err != nil {
+		s.logger.Warn("failed to send control reply", "error", err)
+	}
+}
+
+// redactData marshals reply data through the redactor.
+func (s *Server) redactData(data any) (any, redact.Report) {
+	if s.redactor == nil {
+		return data, nil
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return data, nil // reported when the response is marshalled
+	}
+	rep := redact.Report{}
+	if raw, err = s.redactor.JSON(raw, rep); err != nil {
+		return nil, nil
+	}
+	if len(rep) == 0 {
+		rep = nil
+	}
+	return json.RawMessage(raw), rep
+}
+
+func (s *Server) list() []session.SessionInfo {
+	sessions := s.tracker.Sessions()
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastActivity.After(sessions[j].LastActivity)
+	})
+	return sessions
+}
+
+func (s *Server) get(req Request) (*session.CompletedSession, error) {
+	path, err := s.locate(req.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	parsed := session.ParseTranscript(path, s.logger)
+	if parsed == nil {
+		return nil, fmt.Errorf("could not parse transcript %s", path)
+	}
+	return parsed, nil
+}
+
+func (s *Server) forceComplete(req Request) (*session.CompletedSession, error) {
+	if req.SessionID == "" {
+		return nil, errors.New("session_id is required")
+	}
+	return s.tracker.ForceComplete(req.SessionID)
+}
+
+func (s *Server) doRepublish(req Request) (*session.CompletedSession, error) {
+	parsed, err := s.get(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.republish(parsed); err != nil {
+		return nil, fmt.Errorf("republish: %w", err)
+	}
+	return parsed, nil
+}
+
+func (s *Server) tail(req Request) ([]json.RawMessage, error) {
+	path, err := s.locate(req.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	n := req.Lines
+	if n <= 0 {
+		n = defaultTailLines
+	}
+	n = min(n, maxTailLines)
+
+	// Leave headroom for the response envelope.
+	nc, _ := s.conn()
+	maxBytes := int(nc.MaxPayload()) - 1024
+	return tailLines(path, n, maxBytes)
+}
+
+// locate finds the transcript for a session, first among tracked sessions
+// and then on disk under the watch dirs: <project>/<id>.jsonl, or for a
+// subagent keyed <parent>-agent-<id>, <project>/<parent>/subagents/agent-<id>.jsonl.
+func (s *Server) locate(sessionID string) (string, error) {
+	if sessionID == "" {
+		return "", errors.New("session_id is required")
+	}
+	if strings.ContainsAny(sessionID, `/\`) || strings.Contains(sessionID, "..") {
+		return "", errors.New("invalid session_id")
+	}
+	if path, ok := s.tracker.TranscriptPath(sessionID); ok {
+		return path, nil
+	}
+	patterns := []string{filepath.Join("*", sessionID+".jsonl")}
+	if parent, agent, ok := strings.Cut(sessionID, "-agent-"); ok {
+		patterns = append(patterns, filepath.Join("*", parent, "subagents", "agent-"+agent+".jsonl"))
+	}
+	for _, dir := range s.watchDirs {
+		for _, pattern := range patterns {
+			matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+			for _, path := range matches {
+				if session.SessionIDFromPath(path) == sessionID && (s.allow == nil || s.allow(path)) {
+					return path, nil
+				}
+			}
+		}
+	}
+	return "", fmt.Errorf("session %s not found", sessionID)
+}
+
+// tailLines returns up to n trailing JSON lines of a file whose combined size
+// stays within maxBytes. Malformed lines are skipped.
+func tailLines(path string, n, maxBytes int) ([]json.RawMessage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// Read backwards in chunks until enough lines have been seen.
+	const chunk = 64 * 1024
+	var (
+		buf    []byte
+		offset = info.Size()
+	)
+	for offset > 0 && bytes.Count(buf, []byte{'\n'}) <= n && len(buf) < maxBytes+chunk {
+		size := int64(chunk)
+		if offset < size {
+			size = offset
+		}
+		offset -= size
+		part := make([]byte, size)
+		if _, err := f.ReadAt(part, offset); err != nil {
+			return nil, err
+		}
+		buf = append(part, buf...)
+	}
+
+	lines := strings.Split(strings.TrimRight(string(buf), "\n"), "\n")
+	if offset > 0 && len(lines) > 0 {
+		lines = lines[1:] // first line is likely partial
+	}
+
+	var (
+		out   []json.RawMessage
+		total int
+	)
+	for i := len(lines) - 1; i >= 0 && len(out) < n; i-- {
+		line := lines[i]
+		if line == "" || !json.Valid([]byte(line)) {
+			continue
+		}
+		if total+len(line) > maxBytes {
+			break
+		}
+		total += len(line)
+		out = append(out, json.RawMessage(line))
+	}
+
+	// Restore chronological order.
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out, nil
+}
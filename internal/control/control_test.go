@@ -0,0 +1,303 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/natstest"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/redact"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/session"
+	"github.com/nats-io/nats.go"
+)
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+}
+
+// fakeTracker is a test double for Tracker.
+type fakeTracker struct {
+	sessions []session.SessionInfo
+	paths    map[string]string
+	forced   []string
+}
+
+func (f *fakeTracker) Sessions() []session.SessionInfo { return f.sessions }
+
+func (f *fakeTracker) TranscriptPath(id string) (string, bool) {
+	p, ok := f.paths[id]
+	return p, ok
+}
+
+func (f *fakeTracker) ForceComplete(id string) (*session.CompletedSession, error) {
+	if _, ok := f.paths[id]; !ok {
+		return nil, fmt.Errorf("session %s is not tracked", id)
+	}
+	f.forced = append(f.forced, id)
+	return &session.CompletedSession{SessionID: id}, nil
+}
+
+const testSessionID = "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee"
+
+func writeTranscript(t *testing.T, dir string, lines int) string {
+	t.Helper()
+	projectDir := filepath.Join(dir, "-home-mike-Warren")
+	os.MkdirAll(projectDir, 0755)
+	var b strings.Builder
+	fmt.Fprintf(&b, `{"type":"summary","sessionId":%q,"cwd":"/home/mike/Warren","timestamp":"2026-02-14T10:00:00Z"}`+"\n", testSessionID)
+	for i := 1; i < lines; i++ {
+		fmt.Fprintf(&b, `{"type":"assistant","n":%d,"message":{"role":"assistant","content":[{"type":"text","text":"line %d"}]},"timestamp":"2026-02-14T10:01:00Z"}`+"\n", i, i)
+	}
+	path := filepath.Join(projectDir, testSessionID+".jsonl")
+	os.WriteFile(path, []byte(b.String()), 0644)
+	return path
+}
+
+type testServer struct {
+	nc        *nats.Conn
+	tracker   *fakeTracker
+	server    *Server
+	published []string
+}
+
+func newTestServer(t *testing.T, watchDir string, republishErr error) *testServer {
+	t.Helper()
+	s := natstest.Run(t, nil)
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+
+	ts := &testServer{nc: nc, tracker: &fakeTracker{paths: map[string]string{}}}
+	ts.server = New(nc, "dev.box.local", []string{watchDir}, ts.tracker, func(s *session.CompletedSession) error {
+		ts.published = append(ts.published, s.SessionID)
+		return republishErr
+	}, testLogger())
+	if err := ts.server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(ts.server.Stop)
+	return ts
+}
+
+func (ts *testServer) request(t *testing.T, command string, req any) Response {
+	t.Helper()
+	var body []byte
+	if req != nil {
+		body, _ = json.Marshal(req)
+	}
+	msg, err := ts.nc.Request("swarm.cc.sidecar.dev-box-local."+command, body, 2*time.Second)
+	if err != nil {
+		t.Fatalf("request %s: %v", command, err)
+	}
+	var resp Response
+	if err := json.Unmarshal(msg.Data, &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	return resp
+}
+
+func TestList(t *testing.T) {
+	ts := newTestServer(t, t.TempDir(), nil)
+	now := time.Now()
+	ts.tracker.sessions = []session.SessionInfo{
+		{SessionID: "old", State: session.StateIdle, LastActivity: now.Add(-time.Minute)},
+		{SessionID: "new", State: session.StateRunning, LastActivity: now},
+	}
+
+	resp := ts.request(t, "list", nil)
+	if !resp.OK || resp.Host != "dev-box-local" {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	list := resp.Data.([]interface{})
+	if len(list) != 2 || list[0].(map[string]interface{})["session_id"] != "new" {
+		t.Errorf("expected most recent session first, got %v", list)
+	}
+}
+
+func TestGet_FromWatchDir(t *testing.T) {
+	dir := t.TempDir()
+	writeTranscript(t, dir, 3)
+	ts := newTestServer(t, dir, nil)
+
+	resp := ts.request(t, "get", Request{SessionID: testSessionID})
+	if !resp.OK {
+		t.Fatalf("get failed: %s", resp.Error)
+	}
+	data := resp.Data.(map[string]interface{})
+	if data["session_id"] != testSessionID || data["working_dir"] != "/home/mike/Warren" {
+		t.Errorf("unexpected session summary: %v", data)
+	}
+}
+
+func TestGet_Errors(t *testing.T) {
+	ts := newTestServer(t, t.TempDir(), nil)
+
+	for _, req := range []Request{{}, {SessionID: "../../etc/passwd"}, {SessionID: "missing"}} {
+		if resp := ts.request(t, "get", req); resp.OK || resp.Error == "" {
+			t.Errorf("get %+v: expected error, got %+v", req, resp)
+		}
+	}
+	if resp := ts.request(t, "reboot", nil); resp.OK || !strings.Contains(resp.Error, "unknown command") {
+		t.Errorf("expected unknown command error, got %+v", resp)
+	}
+}
+
+func TestLocate_SkipsDisallowed(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTranscript(t, dir, 1)
+	s := New(nil, "h", []string{dir}, &fakeTracker{}, nil, testLogger())
+
+	if got, err := s.locate(testSessionID); err != nil || got != path {
+		t.Fatalf("locate = %q, %v", got, err)
+	}
+	s.SetAllow(func(p string) bool { return p != path })
+	if _, err := s.locate(testSessionID); err == nil {
+		t.Error("excluded transcript was located")
+	}
+}
+
+func TestLocate_Subagent(t *testing.T) {
+	dir := t.TempDir()
+	subagents := filepath.Join(dir, "-home-mike-api", testSessionID, "subagents")
+	os.MkdirAll(subagents, 0755)
+	path := filepath.Join(subagents, "agent-a1b2c3.jsonl")
+	os.WriteFile(path, []byte(`{"type":"user"}`+"\n"), 0644)
+	s := New(nil, "h", []string{dir}, &fakeTracker{}, nil, testLogger())
+
+	if got, err := s.locate(testSessionID + "-agent-a1b2c3"); err != nil || got != path {
+		t.Errorf("locate = %q, %v, want %s", got, err, path)
+	}
+	if _, err := s.locate(testSessionID + "-agent-other"); err == nil {
+		t.Error("missing subagent was located")
+	}
+}
+
+func TestResubscribe(t *testing.T) {
+	ts := newTestServer(t, t.TempDir(), nil)
+
+	if err := ts.server.Resubscribe(ts.nc, "new.host"); err != nil {
+		t.Fatal(err)
+	}
+	if got := ts.server.Subject("list"); got != "swarm.cc.sidecar.new-host.list" {
+		t.Errorf("Subject = %q", got)
+	}
+	msg, err := ts.nc.Request("swarm.cc.sidecar.new-host.list", nil, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp Response
+	if err := json.Unmarshal(msg.Data, &resp); err != nil || !resp.OK || resp.Host != "new-host" {
+		t.Errorf("response = %+v, %v", resp, err)
+	}
+	if _, err := ts.nc.Request("swarm.cc.sidecar.dev-box-local.list", nil, 200*time.Millisecond); err == nil {
+		t.Error("old subject still answered")
+	}
+}
+
+func TestForceComplete(t *testing.T) {
+	ts := newTestServer(t, t.TempDir(), nil)
+	ts.tracker.paths[testSessionID] = "/p/x.jsonl"
+
+	if resp := ts.request(t, "force-complete", Request{SessionID: testSessionID}); !resp.OK {
+		t.Fatalf("force-complete failed: %s", resp.Error)
+	}
+	if len(ts.tracker.forced) != 1 {
+		t.Errorf("expected tracker.ForceComplete to be called once, got %v", ts.tracker.forced)
+	}
+	if resp := ts.request(t, "force-complete", Request{SessionID: "other"}); resp.OK {
+		t.Error("expected error for untracked session")
+	}
+}
+
+func TestRepublish(t *testing.T) {
+	dir := t.TempDir()
+	writeTranscript(t, dir, 2)
+
+	ts := newTestServer(t, dir, nil)
+	if resp := ts.request(t, "republish", Request{SessionID: testSessionID}); !resp.OK {
+		t.Fatalf("republish failed: %s", resp.Error)
+	}
+	if len(ts.published) != 1 || ts.published[0] != testSessionID {
+		t.Errorf("published = %v", ts.published)
+	}
+
+	failing := newTestServer(t, dir, errors.New("nats down"))
+	if resp := failing.request(t, "republish", Request{SessionID: testSessionID}); resp.OK || !strings.Contains(resp.Error, "nats down") {
+		t.Errorf("expected republish error, got %+v", resp)
+	}
+}
+
+func TestTranscriptTail(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTranscript(t, dir, 50)
+	ts := newTestServer(t, dir, nil)
+	ts.tracker.paths[testSessionID] = path
+
+	resp := ts.request(t, "transcript-tail", Request{SessionID: testSessionID, Lines: 5})
+	if !resp.OK {
+		t.Fatalf("tail failed: %s", resp.Error)
+	}
+	lines := resp.Data.([]interface{})
+	if len(lines) != 5 {
+		t.Fatalf("got %d lines, want 5", len(lines))
+	}
+	first := lines[0].(map[string]interface{})
+	last := lines[4].(map[string]interface{})
+	if first["n"] != float64(45) || last["n"] != float64(49) {
+		t.Errorf("tail returned lines %v..%v, want 45..49", first["n"], last["n"])
+	}
+}
+
+func TestTailLines_ByteLimit(t *testing.T) {
+	path := writeTranscript(t, t.TempDir(), 100)
+
+	lines, err := tailLines(path, 100, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, l := range lines {
+		total += len(l)
+	}
+	if total > 500 || len(lines) == 0 {
+		t.Errorf("got %d lines totalling %d bytes, want non-empty within 500", len(lines), total)
+	}
+}
+
+func TestSubjectToken(t *testing.T) {
+	if got := SubjectToken("dev.box *>"); got != "dev-box---" {
+		t.Errorf("SubjectToken = %q", got)
+	}
+}
+
+func TestTranscriptTail_Redacts(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTranscript(t, dir, 2)
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString(`{"type":"user","message":{"content":"mail jane@example.com"}}` + "\n")
+	f.Close()
+
+	ts := newTestServer(t, dir, nil)
+	r, err := redact.New(redact.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.server.SetRedactor(r)
+
+	resp := ts.request(t, "transcript-tail", Request{SessionID: testSessionID, Lines: 1})
+	if !resp.OK || resp.Redactions["email"] != 1 {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	raw, _ := json.Marshal(resp.Data)
+	if strings.Contains(string(raw), "jane@example.com") {
+		t.Errorf("reply not redacted: %s", raw)
+	}
+}
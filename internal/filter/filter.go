@@ -0,0 +1,242 @@
+// Package filter decides which transcripts the sidecar follows, from
+// include and exclude rules on the project and the transcript filename.
+//
+// A rule is one of:
+//
+//   - an absolute path glob such as "/home/mike/scratch/**", matched
+//     against the project directory;
+//   - any other glob, matched against the project slug (e.g.
+//     "-home-mike-scratch*") and the transcript filename (e.g.
+//     "agent-*.jsonl");
+//   - "re:" followed by a regular expression, matched against the project
+//     directory, the slug and the filename.
+//
+// Claude Code names project dirs by replacing every character of the
+// working directory other than letters and digits with "-", so a slug does
+// not say which path it came from: "-home-mike-scratch-other" may be
+// /home/mike/scratch-other or /home/mike/scratch/other. The project
+// directory is therefore found on the filesystem, by looking for an
+// existing directory whose path encodes to the slug. If both exist, the one
+// first in name order at the first differing level wins. If none does
+// (e.g. the directory was removed, or the sidecar cannot read its parent),
+// path globs are encoded like the slug and compared with it, and regular
+// expressions see the slug with each "-" read as "/"; then
+// "/home/mike/scratch/**" also matches /home/mike/scratch-other.
+//
+// A transcript is followed when no include rule is given or one matches,
+// and no exclude rule matches.
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/glob"
+)
+
+// regexPrefix marks a rule as a regular expression.
+const regexPrefix = "re:"
+
+// matcher is one compiled rule.
+type matcher struct {
+	rule    string
+	project bool // match the project only
+	glob    *glob.Pattern
+	dir     *glob.Pattern // the directory itself of a project glob ending in "/**"
+	// path and pathDir are glob and dir of a project glob before encoding,
+	// matched against the project directory when it is known.
+	path    *glob.Pattern
+	pathDir *glob.Pattern
+	re      *regexp.Regexp
+}
+
+// Filter holds compiled include and exclude rules. A nil Filter allows
+// everything.
+type Filter struct {
+	include []matcher
+	exclude []matcher
+
+	// resolve finds the project directory of a slug; replaced in tests.
+	resolve func(slug string) string
+
+	mu   sync.Mutex
+	dirs map[string]string // resolved project dirs by slug, "" if none
+}
+
+// New compiles include and exclude rules. It returns nil when there are no
+// rules.
+func New(include, exclude []string) (*Filter, error) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+	f := &Filter{resolve: projectDir, dirs: make(map[string]string)}
+	var err error
+	if f.include, err = compile("include", include); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = compile("exclude", exclude); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func compile(kind string, rules []string) ([]matcher, error) {
+	out := make([]matcher, 0, len(rules))
+	for i, rule := range rules {
+		m := matcher{rule: rule}
+		var err error
+		switch {
+		case rule == "" || rule == regexPrefix:
+			err = errors.New("empty rule")
+		case strings.HasPrefix(rule, regexPrefix):
+			m.re, err = regexp.Compile(strings.TrimPrefix(rule, regexPrefix))
+		case strings.HasPrefix(rule, "/"):
+			m.project = true
+			m.glob, err = glob.Compile(Slug(rule))
+			if err == nil {
+				m.path, err = glob.Compile(rule)
+			}
+			if dir, ok := strings.CutSuffix(rule, "/**"); ok && err == nil {
+				if m.dir, err = glob.Compile(Slug(dir)); err == nil {
+					m.pathDir, err = glob.Compile(dir)
+				}
+			}
+		default:
+			m.glob, err = glob.Compile(rule)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s rule %d %q: %w", kind, i, rule, err)
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// Slug encodes a path the way Claude Code names project directories. Glob
+// wildcards are kept.
+func Slug(path string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '*', r == '?':
+			return r
+		}
+		return '-'
+	}, path)
+}
+
+// Allow reports whether a transcript is followed. slug is the project
+// directory name under the watch root and name the transcript filename.
+func (f *Filter) Allow(slug, name string) bool {
+	if f == nil {
+		return true
+	}
+	t := target{slug: slug, name: name, filter: f}
+	if len(f.include) > 0 && !t.matchAny(f.include) {
+		return false
+	}
+	return !t.matchAny(f.exclude)
+}
+
+// target is a transcript being matched. Its project directory is looked up
+// on first use.
+type target struct {
+	slug, name string
+	filter     *Filter
+	dir        string
+	resolved   bool
+}
+
+func (t *target) projectDir() string {
+	if !t.resolved {
+		t.dir = t.filter.projectDir(t.slug)
+		t.resolved = true
+	}
+	return t.dir
+}
+
+func (t *target) matchAny(ms []matcher) bool {
+	for _, m := range ms {
+		if m.match(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m matcher) match(t *target) bool {
+	switch {
+	case m.re != nil:
+		path := t.projectDir()
+		if path == "" {
+			path = strings.ReplaceAll(t.slug, "-", "/")
+		}
+		return m.re.MatchString(path) || m.re.MatchString(t.slug) || m.re.MatchString(t.name)
+	case m.project:
+		if dir := t.projectDir(); dir != "" {
+			return m.path.Match(dir) || m.pathDir != nil && m.pathDir.Match(dir)
+		}
+		// Older Claude Code versions kept some punctuation in slugs.
+		slug := Slug(t.slug)
+		return m.glob.Match(slug) || m.dir != nil && m.dir.Match(slug)
+	default:
+		return m.glob.Match(t.slug) || m.glob.Match(t.name)
+	}
+}
+
+// projectDir returns the cached project directory of slug, resolving it on
+// first use.
+func (f *Filter) projectDir(slug string) string {
+	f.mu.Lock()
+	dir, ok := f.dirs[slug]
+	f.mu.Unlock()
+	if ok {
+		return dir
+	}
+	dir = f.resolve(slug)
+	f.mu.Lock()
+	f.dirs[slug] = dir
+	f.mu.Unlock()
+	return dir
+}
+
+// projectDir finds the existing directory whose path encodes to slug, or
+// returns "" if there is none. Each level is searched in name order, so of
+// several candidates the first in that order is returned.
+func projectDir(slug string) string {
+	slug = Slug(slug)
+	if !strings.HasPrefix(slug, "-") {
+		return ""
+	}
+	return findDir("/", slug)
+}
+
+// findDir returns the directory below dir whose path relative to dir
+// encodes to rest, a slug starting with "-".
+func findDir(dir, rest string) string {
+	if rest == "" {
+		return dir
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	for _, e := range entries {
+		seg := "-" + Slug(e.Name())
+		if !strings.HasPrefix(rest, seg) || len(rest) > len(seg) && rest[len(seg)] != '-' {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		if info, err := os.Stat(path); err != nil || !info.IsDir() {
+			continue
+		}
+		if found := findDir(path, rest[len(seg):]); found != "" {
+			return found
+		}
+	}
+	return ""
+}
@@ -0,0 +1,115 @@
+package filter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAllow(t *testing.T) {
+	cases := []struct {
+		name             string
+		include, exclude []string
+		slug, file       string
+		want             bool
+	}{
+		{"no rules", nil, nil, "-home-mike-api", "s.jsonl", true},
+		{"project glob", nil, []string{"/home/mike/scratch/**"}, "-home-mike-scratch-demo", "s.jsonl", false},
+		{"project glob on the dir itself", nil, []string{"/home/mike/scratch/**"}, "-home-mike-scratch", "s.jsonl", false},
+		{"project glob elsewhere", nil, []string{"/home/mike/scratch/**"}, "-home-mike-api", "s.jsonl", true},
+		{"project glob with dots", nil, []string{"/home/mike/side.projects/**"}, "-home-mike-side-projects-x", "s.jsonl", false},
+		{"legacy slug with dots", nil, []string{"/home/mike/side.projects/**"}, "-home-mike-side.projects-x", "s.jsonl", false},
+		{"project wildcard", nil, []string{"/home/*/tmp/**"}, "-home-anna-tmp-x", "s.jsonl", false},
+		{"slug glob", nil, []string{"-home-mike-play*"}, "-home-mike-playground", "s.jsonl", false},
+		{"filename glob", nil, []string{"agent-*.jsonl"}, "-home-mike-api", "agent-1.jsonl", false},
+		{"filename glob miss", nil, []string{"agent-*.jsonl"}, "-home-mike-api", "s.jsonl", true},
+		{"regex on path", nil, []string{"re:(?i)/side-?proj"}, "-home-mike-SideProj", "s.jsonl", false},
+		{"regex on filename", nil, []string{`re:^tmp-.*\.jsonl$`}, "-home-mike-api", "tmp-1.jsonl", false},
+		{"include match", []string{"/srv/work/**"}, nil, "-srv-work-api", "s.jsonl", true},
+		{"include miss", []string{"/srv/work/**"}, nil, "-home-mike-api", "s.jsonl", false},
+		{"exclude beats include", []string{"/srv/work/**"}, []string{"/srv/work/secret/**"}, "-srv-work-secret-x", "s.jsonl", false},
+	}
+	for _, c := range cases {
+		f, err := New(c.include, c.exclude)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if f != nil {
+			f.resolve = func(string) string { return "" } // projects not on disk
+		}
+		if got := f.Allow(c.slug, c.file); got != c.want {
+			t.Errorf("%s: Allow(%q, %q) = %v, want %v", c.name, c.slug, c.file, got, c.want)
+		}
+	}
+}
+
+func TestAllow_ResolvesProjectDir(t *testing.T) {
+	base := t.TempDir()
+	scratch := filepath.Join(base, "scratch")
+	other := filepath.Join(base, "scratch-other")
+	os.MkdirAll(filepath.Join(scratch, "demo"), 0755)
+	os.MkdirAll(other, 0755)
+
+	f, err := New(nil, []string{scratch + "/**"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Allow(Slug(filepath.Join(scratch, "demo")), "s.jsonl") {
+		t.Error("project below the excluded dir allowed")
+	}
+	if f.Allow(Slug(scratch), "s.jsonl") {
+		t.Error("excluded dir itself allowed")
+	}
+	// The same slug as scratch/other, but a different directory.
+	if !f.Allow(Slug(other), "s.jsonl") {
+		t.Errorf("%s excluded by %s/**", other, scratch)
+	}
+
+	// Without the directory on disk, the slug is all there is to go by.
+	f.resolve = func(string) string { return "" }
+	f.dirs = make(map[string]string)
+	if f.Allow(Slug(other), "s.jsonl") {
+		t.Error("unresolvable slug not matched like the rule's slug")
+	}
+}
+
+func TestProjectDir(t *testing.T) {
+	base := t.TempDir()
+	os.MkdirAll(filepath.Join(base, "my_app.v2", "web"), 0755)
+	os.MkdirAll(filepath.Join(base, "a", "b"), 0755)
+	os.MkdirAll(filepath.Join(base, "a-b"), 0755)
+
+	cases := map[string]string{
+		Slug(filepath.Join(base, "my_app.v2", "web")): filepath.Join(base, "my_app.v2", "web"),
+		Slug(filepath.Join(base, "a-b")):              filepath.Join(base, "a", "b"), // "a" sorts before "a-b"
+		Slug(filepath.Join(base, "missing")):          "",
+		"not-a-slug":                                  "",
+	}
+	for slug, want := range cases {
+		if got := projectDir(slug); got != want {
+			t.Errorf("projectDir(%q) = %q, want %q", slug, got, want)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	f, err := New(nil, nil)
+	if err != nil || f != nil {
+		t.Errorf("New(nil, nil) = %v, %v, want a nil filter", f, err)
+	}
+	if !f.Allow("-x", "y.jsonl") {
+		t.Error("nil filter must allow everything")
+	}
+
+	for _, bad := range []string{"", "re:", "re:(unclosed"} {
+		if _, err := New(nil, []string{bad}); err == nil {
+			t.Errorf("rule %q: expected an error", bad)
+		}
+	}
+}
+
+func TestSlug(t *testing.T) {
+	if got := Slug("/home/mike/my_app.v2/**"); got != "-home-mike-my-app-v2-**" {
+		t.Errorf("Slug = %q", got)
+	}
+}
@@ -0,0 +1,63 @@
+// Package glob matches file paths against shell-style globs with "**"
+// support.
+package glob
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Pattern is a compiled path glob.
+type Pattern struct {
+	glob string
+	re   *regexp.Regexp
+}
+
+// Compile converts a path glob to a matcher. "**" matches any number of
+// directories, "*" and "?" stay within one path segment. Relative globs
+// match at any depth, so "infra/**" covers /home/me/repo/infra/main.tf.
+func Compile(glob string) (*Pattern, error) {
+	expr := glob
+	if !strings.HasPrefix(expr, "/") && !strings.HasPrefix(expr, "**") {
+		expr = "**/" + expr
+	}
+
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		switch {
+		case c == '*' && i+1 < len(expr) && expr[i+1] == '*':
+			i++
+			if i+1 < len(expr) && expr[i+1] == '/' {
+				i++
+				b.WriteString("(?:.*/)?")
+			} else {
+				b.WriteString(".*")
+			}
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, err
+	}
+	return &Pattern{glob: glob, re: re}, nil
+}
+
+// Match reports whether path matches the glob.
+func (p *Pattern) Match(path string) bool {
+	return p.re.MatchString(path)
+}
+
+// String returns the original glob.
+func (p *Pattern) String() string {
+	return p.glob
+}
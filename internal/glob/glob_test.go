@@ -0,0 +1,31 @@
+package glob
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		glob, path string
+		want       bool
+	}{
+		{"**/.env*", "/repo/.env", true},
+		{"**/.env*", "/repo/sub/.env.local", true},
+		{"**/.env*", "/repo/env", false},
+		{".env*", "/repo/.env", true},
+		{"infra/**", "/home/me/repo/infra/prod/main.tf", true},
+		{"infra/**", "/home/me/repo/infrastructure/main.tf", false},
+		{"/etc/*", "/etc/passwd", true},
+		{"/etc/*", "/etc/ssl/cert.pem", false},
+		{"*.pem", "/x/key.pem", true},
+		{"key?.pem", "/x/key1.pem", true},
+		{"key?.pem", "/x/key10.pem", false},
+	}
+	for _, c := range cases {
+		p, err := Compile(c.glob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.Match(c.path); got != c.want {
+			t.Errorf("%q.Match(%q) = %v, want %v", c.glob, c.path, got, c.want)
+		}
+	}
+}
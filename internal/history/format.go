@@ -0,0 +1,125 @@
+package history
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Output formats.
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+	FormatCSV   = "csv"
+)
+
+var csvHeader = []string{
+	"session_id", "completed_at", "project", "working_dir", "task_id", "owner_uuid",
+	"duration_ms", "exit_code", "failure_reason", "model",
+	"input_tokens", "output_tokens", "cache_creation_input_tokens", "cache_read_input_tokens", "cost_usd",
+	"files", "published", "publish_error",
+}
+
+// Write renders records in the given format.
+func Write(w io.Writer, format string, recs []Record) error {
+	switch format {
+	case FormatTable, "":
+		return writeTable(w, recs)
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if recs == nil {
+			recs = []Record{}
+		}
+		return enc.Encode(recs)
+	case FormatCSV:
+		return writeCSV(w, recs)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+func writeTable(w io.Writer, recs []Record) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "COMPLETED\tSESSION\tPROJECT\tTASK\tSTATUS\tDURATION\tTOKENS\tCOST\tFILES\tPUBLISHED")
+	for _, r := range recs {
+		status := "ok"
+		if r.ExitCode != 0 {
+			status = "failed"
+			if r.FailureReason != "" {
+				status += " (" + r.FailureReason + ")"
+			}
+		}
+		published := "yes"
+		if !r.Published {
+			published = "no"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t$%.2f\t%d\t%s\n",
+			r.CompletedAt.Local().Format("2006-01-02 15:04"),
+			shortID(r.SessionID),
+			r.Project,
+			dash(r.TaskID),
+			status,
+			(time.Duration(r.DurationMs) * time.Millisecond).Round(time.Second),
+			r.Tokens.Total(),
+			r.CostUSD,
+			len(r.Files),
+			published,
+		)
+	}
+	return tw.Flush()
+}
+
+func writeCSV(w io.Writer, recs []Record) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, r := range recs {
+		row := []string{
+			r.SessionID,
+			r.CompletedAt.Format(time.RFC3339),
+			r.Project,
+			r.WorkingDir,
+			r.TaskID,
+			r.OwnerUUID,
+			strconv.FormatInt(r.DurationMs, 10),
+			strconv.Itoa(r.ExitCode),
+			r.FailureReason,
+			r.Model,
+			strconv.FormatInt(r.Tokens.InputTokens, 10),
+			strconv.FormatInt(r.Tokens.OutputTokens, 10),
+			strconv.FormatInt(r.Tokens.CacheCreationInputTokens, 10),
+			strconv.FormatInt(r.Tokens.CacheReadInputTokens, 10),
+			strconv.FormatFloat(r.CostUSD, 'f', 4, 64),
+			strings.Join(r.Files, ";"),
+			strconv.FormatBool(r.Published),
+			r.PublishError,
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// shortID abbreviates a session UUID for tables.
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
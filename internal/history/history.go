@@ -0,0 +1,291 @@
+// Package history records completed sessions in a local SQLite database so
+// they can be queried offline after their events have been published.
+package history
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/session"
+
+	_ "modernc.org/sqlite"
+)
+
+// Failure reasons recorded for sessions with a non-zero exit code.
+const (
+	// ReasonNoAssistantResponse marks a session in which the agent never
+	// answered, usually a startup failure or crash.
+	ReasonNoAssistantResponse = "no_assistant_response"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS sessions (
+	session_id      TEXT PRIMARY KEY,
+	project         TEXT NOT NULL,
+	working_dir     TEXT NOT NULL,
+	transcript_path TEXT NOT NULL,
+	task_id         TEXT NOT NULL DEFAULT '',
+	owner_uuid      TEXT NOT NULL DEFAULT '',
+	attributed_by   TEXT NOT NULL DEFAULT '',
+	completed_at    INTEGER NOT NULL,
+	duration_ms     INTEGER NOT NULL,
+	exit_code       INTEGER NOT NULL,
+	failure_reason  TEXT NOT NULL DEFAULT '',
+	model           TEXT NOT NULL DEFAULT '',
+	input_tokens    INTEGER NOT NULL DEFAULT 0,
+	output_tokens   INTEGER NOT NULL DEFAULT 0,
+	cache_creation_input_tokens INTEGER NOT NULL DEFAULT 0,
+	cache_read_input_tokens     INTEGER NOT NULL DEFAULT 0,
+	cost_usd        REAL NOT NULL DEFAULT 0,
+	files           TEXT NOT NULL DEFAULT '[]',
+	tools           TEXT NOT NULL DEFAULT '{}',
+	published       INTEGER NOT NULL,
+	publish_error   TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS sessions_completed_at ON sessions (completed_at);
+CREATE INDEX IF NOT EXISTS sessions_task_id ON sessions (task_id);
+CREATE INDEX IF NOT EXISTS sessions_project ON sessions (project);
+`
+
+const columns = `session_id, project, working_dir, transcript_path, task_id, owner_uuid, attributed_by,
+	completed_at, duration_ms, exit_code, failure_reason, model,
+	input_tokens, output_tokens, cache_creation_input_tokens, cache_read_input_tokens, cost_usd,
+	files, tools, published, publish_error`
+
+// Record is one completed session.
+type Record struct {
+	SessionID      string `json:"session_id"`
+	Project        string `json:"project"`
+	WorkingDir     string `json:"working_dir"`
+	TranscriptPath string `json:"transcript_path"`
+	TaskID         string `json:"task_id,omitempty"`
+	OwnerUUID      string `json:"owner_uuid,omitempty"`
+	AttributedBy   string `json:"attributed_by,omitempty"`
+
+	CompletedAt   time.Time `json:"completed_at"`
+	DurationMs    int64     `json:"duration_ms"`
+	ExitCode      int       `json:"exit_code"`
+	FailureReason string    `json:"failure_reason,omitempty"`
+
+	// Model is the model that produced most of the session.
+	Model   string             `json:"model,omitempty"`
+	Tokens  session.TokenUsage `json:"tokens"`
+	CostUSD float64            `json:"cost_usd"`
+
+	Files []string                     `json:"files"`
+	Tools map[string]session.ToolStats `json:"tools,omitempty"`
+
+	// Published reports whether the completion event was published;
+	// PublishError says why not.
+	Published    bool   `json:"published"`
+	PublishError string `json:"publish_error,omitempty"`
+}
+
+// NewRecord fills the session-derived fields of a record. Attribution, cost
+// and publish status are left to the caller.
+func NewRecord(s *session.CompletedSession, completedAt time.Time) Record {
+	project := session.ProjectSlug(s.TranscriptPath)
+	if project == "" {
+		project = filepath.Base(filepath.Dir(s.TranscriptPath))
+	}
+	r := Record{
+		SessionID:      s.SessionID,
+		Project:        project,
+		WorkingDir:     s.WorkingDir,
+		TranscriptPath: s.TranscriptPath,
+		CompletedAt:    completedAt,
+		DurationMs:     s.DurationMs,
+		ExitCode:       s.ExitCode,
+		Files:          s.FilesChanged,
+	}
+	if s.ExitCode != 0 {
+		r.FailureReason = ReasonNoAssistantResponse
+	}
+	if s.Metadata != nil {
+		r.Model = s.Metadata.Model
+	}
+	for _, u := range s.Usage {
+		r.Tokens.Add(u)
+	}
+	if s.Summary != nil {
+		r.Tools = s.Summary.Tools
+	}
+	return r
+}
+
+// Filter selects records. Zero fields match everything.
+type Filter struct {
+	// Project matches a substring of the project directory or working dir.
+	Project   string
+	TaskID    string
+	OwnerUUID string
+	Since     time.Time
+	Until     time.Time
+	// FailureReason matches exactly.
+	FailureReason string
+	// Failed selects sessions with a non-zero exit code.
+	Failed bool
+	// Unpublished selects sessions whose event was not published.
+	Unpublished bool
+	// Limit caps the number of records, most recent first.
+	Limit int
+}
+
+// Store is a session history database.
+type Store struct {
+	db *sql.DB
+}
+
+// Open opens or creates the database at path. The daemon and the CLI may
+// have it open at the same time.
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("create history dir: %w", err)
+	}
+	dsn := "file:" + path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open history: %w", err)
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create history schema: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Record inserts r, replacing an earlier record of the same session.
+func (s *Store) Record(ctx context.Context, r Record) error {
+	files, err := json.Marshal(nonNil(r.Files))
+	if err != nil {
+		return fmt.Errorf("marshal files: %w", err)
+	}
+	tools := []byte("{}")
+	if len(r.Tools) > 0 {
+		if tools, err = json.Marshal(r.Tools); err != nil {
+			return fmt.Errorf("marshal tools: %w", err)
+		}
+	}
+
+	_, err = s.db.ExecContext(ctx, `INSERT OR REPLACE INTO sessions (`+columns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.SessionID, r.Project, r.WorkingDir, r.TranscriptPath, r.TaskID, r.OwnerUUID, r.AttributedBy,
+		r.CompletedAt.UnixMilli(), r.DurationMs, r.ExitCode, r.FailureReason, r.Model,
+		r.Tokens.InputTokens, r.Tokens.OutputTokens, r.Tokens.CacheCreationInputTokens, r.Tokens.CacheReadInputTokens, r.CostUSD,
+		string(files), string(tools), r.Published, r.PublishError)
+	if err != nil {
+		return fmt.Errorf("record session %s: %w", r.SessionID, err)
+	}
+	return nil
+}
+
+// Attribute records a task mapping that arrived after the session was
+// recorded. Sessions that already have a task are left as they are.
+func (s *Store) Attribute(ctx context.Context, sessionID, taskID, ownerUUID, attributedBy string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE sessions SET task_id = ?, owner_uuid = ?, attributed_by = ?
+		WHERE session_id = ? AND task_id = ''`,
+		taskID, ownerUUID, attributedBy, sessionID)
+	if err != nil {
+		return fmt.Errorf("attribute session %s: %w", sessionID, err)
+	}
+	return nil
+}
+
+// Query returns the records matching f, most recent first.
+func (s *Store) Query(ctx context.Context, f Filter) ([]Record, error) {
+	var (
+		where []string
+		args  []any
+	)
+	if f.Project != "" {
+		where = append(where, "(instr(project, ?) > 0 OR instr(working_dir, ?) > 0)")
+		args = append(args, f.Project, f.Project)
+	}
+	if f.TaskID != "" {
+		where = append(where, "task_id = ?")
+		args = append(args, f.TaskID)
+	}
+	if f.OwnerUUID != "" {
+		where = append(where, "owner_uuid = ?")
+		args = append(args, f.OwnerUUID)
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "completed_at >= ?")
+		args = append(args, f.Since.UnixMilli())
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "completed_at < ?")
+		args = append(args, f.Until.UnixMilli())
+	}
+	if f.FailureReason != "" {
+		where = append(where, "failure_reason = ?")
+		args = append(args, f.FailureReason)
+	}
+	if f.Failed {
+		where = append(where, "exit_code != 0")
+	}
+	if f.Unpublished {
+		where = append(where, "published = 0")
+	}
+
+	q := "SELECT " + columns + " FROM sessions"
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	q += " ORDER BY completed_at DESC, session_id"
+	if f.Limit > 0 {
+		q += " LIMIT ?"
+		args = append(args, f.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query history: %w", err)
+	}
+	defer rows.Close()
+
+	var out []Record
+	for rows.Next() {
+		var (
+			r            Record
+			completedAt  int64
+			files, tools string
+		)
+		if err := rows.Scan(&r.SessionID, &r.Project, &r.WorkingDir, &r.TranscriptPath, &r.TaskID, &r.OwnerUUID, &r.AttributedBy,
+			&completedAt, &r.DurationMs, &r.ExitCode, &r.FailureReason, &r.Model,
+			&r.Tokens.InputTokens, &r.Tokens.OutputTokens, &r.Tokens.CacheCreationInputTokens, &r.Tokens.CacheReadInputTokens, &r.CostUSD,
+			&files, &tools, &r.Published, &r.PublishError); err != nil {
+			return nil, fmt.Errorf("scan history: %w", err)
+		}
+		r.CompletedAt = time.UnixMilli(completedAt).UTC()
+		if err := json.Unmarshal([]byte(files), &r.Files); err != nil {
+			return nil, fmt.Errorf("session %s files: %w", r.SessionID, err)
+		}
+		if err := json.Unmarshal([]byte(tools), &r.Tools); err != nil {
+			return nil, fmt.Errorf("session %s tools: %w", r.SessionID, err)
+		}
+		if len(r.Tools) == 0 {
+			r.Tools = nil
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,205 @@
+package history
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/session"
+)
+
+func openTest(t *testing.T) *Store {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "sub", "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestNewRecord(t *testing.T) {
+	s := &session.CompletedSession{
+		SessionID:      "s1",
+		TranscriptPath: "/home/u/.claude/projects/-home-u-repo/s1.jsonl",
+		WorkingDir:     "/home/u/repo",
+		FilesChanged:   []string{"a.go"},
+		DurationMs:     1500,
+		ExitCode:       1,
+		Metadata:       &session.Metadata{Model: "claude-sonnet-4-5"},
+		Summary:        &session.Summary{Tools: map[string]session.ToolStats{"Edit": {Calls: 2}}},
+		Usage: map[string]session.TokenUsage{
+			"a": {InputTokens: 1, OutputTokens: 2},
+			"b": {InputTokens: 10, CacheReadInputTokens: 5},
+		},
+	}
+	r := NewRecord(s, time.Unix(100, 0))
+	if r.Project != "-home-u-repo" || r.Model != "claude-sonnet-4-5" || r.FailureReason != ReasonNoAssistantResponse {
+		t.Errorf("record = %+v", r)
+	}
+	if r.Tokens != (session.TokenUsage{InputTokens: 11, OutputTokens: 2, CacheReadInputTokens: 5}) {
+		t.Errorf("tokens = %+v", r.Tokens)
+	}
+	if r.Tools["Edit"].Calls != 2 {
+		t.Errorf("tools = %v", r.Tools)
+	}
+
+	sub := NewRecord(&session.CompletedSession{
+		SessionID:      "s1-agent-a1",
+		TranscriptPath: "/home/u/.claude/projects/-home-u-repo/s1/subagents/agent-a1.jsonl",
+	}, time.Unix(100, 0))
+	if sub.Project != "-home-u-repo" {
+		t.Errorf("subagent project = %q, want -home-u-repo", sub.Project)
+	}
+}
+
+func TestStore_RecordAndQuery(t *testing.T) {
+	st := openTest(t)
+	ctx := context.Background()
+	day := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+
+	recs := []Record{
+		{SessionID: "a", Project: "-home-u-api", WorkingDir: "/home/u/api", TaskID: "T1", CompletedAt: day, Published: true,
+			Files: []string{"x.go"}, Tools: map[string]session.ToolStats{"Edit": {Calls: 3, Errors: 1}}, CostUSD: 1.5},
+		{SessionID: "b", Project: "-home-u-web", WorkingDir: "/home/u/web", TaskID: "T2", CompletedAt: day.Add(-48 * time.Hour),
+			ExitCode: 1, FailureReason: ReasonNoAssistantResponse, Published: true},
+		{SessionID: "c", Project: "-home-u-api", WorkingDir: "/home/u/api", CompletedAt: day.Add(time.Hour), PublishError: "nats: timeout"},
+	}
+	for _, r := range recs {
+		if err := st.Record(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids := func(f Filter) string {
+		t.Helper()
+		got, err := st.Query(ctx, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, r := range got {
+			out = append(out, r.SessionID)
+		}
+		return strings.Join(out, ",")
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   string
+	}{
+		{"all, most recent first", Filter{}, "c,a,b"},
+		{"project", Filter{Project: "api"}, "c,a"},
+		{"task", Filter{TaskID: "T2"}, "b"},
+		{"since", Filter{Since: day.Add(-time.Hour)}, "c,a"},
+		{"until", Filter{Until: day}, "b"},
+		{"failed", Filter{Failed: true}, "b"},
+		{"reason", Filter{FailureReason: ReasonNoAssistantResponse}, "b"},
+		{"unpublished", Filter{Unpublished: true}, "c"},
+		{"limit", Filter{Limit: 1}, "c"},
+	}
+	for _, tt := range tests {
+		if got := ids(tt.filter); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	got, err := st.Query(ctx, Filter{TaskID: "T1"})
+	if err != nil || len(got) != 1 {
+		t.Fatalf("query T1: %v %v", got, err)
+	}
+	a := got[0]
+	if !a.CompletedAt.Equal(day) || a.Files[0] != "x.go" || a.Tools["Edit"].Errors != 1 || a.CostUSD != 1.5 || !a.Published {
+		t.Errorf("round trip = %+v", a)
+	}
+
+	// Re-recording a session replaces it, e.g. after a republish.
+	recs[2].Published, recs[2].PublishError = true, ""
+	if err := st.Record(ctx, recs[2]); err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(Filter{Unpublished: true}); got != "" {
+		t.Errorf("unpublished after republish = %q", got)
+	}
+}
+
+func TestStore_Attribute(t *testing.T) {
+	st := openTest(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	for _, r := range []Record{
+		{SessionID: "late", CompletedAt: now, Published: true},
+		{SessionID: "known", TaskID: "T1", AttributedBy: "env", CompletedAt: now, Published: true},
+	} {
+		if err := st.Record(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []string{"late", "known", "missing"} {
+		if err := st.Attribute(ctx, id, "T2", "owner-2", "registry"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := st.Query(ctx, Filter{TaskID: "T2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].SessionID != "late" || got[0].OwnerUUID != "owner-2" || got[0].AttributedBy != "registry" {
+		t.Fatalf("attributed = %+v", got)
+	}
+	// A session attributed at publish time keeps its task.
+	if got, err := st.Query(ctx, Filter{TaskID: "T1"}); err != nil || len(got) != 1 || got[0].AttributedBy != "env" {
+		t.Errorf("known = %+v, %v", got, err)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	recs := []Record{{
+		SessionID:   "0123456789abcdef",
+		Project:     "-home-u-api",
+		CompletedAt: time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC),
+		DurationMs:  90_000,
+		Files:       []string{"a.go", "b.go"},
+		Tokens:      session.TokenUsage{InputTokens: 100, OutputTokens: 20},
+		CostUSD:     0.25,
+		Published:   true,
+	}}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, FormatTable, recs); err != nil {
+		t.Fatal(err)
+	}
+	if out := buf.String(); !strings.Contains(out, "01234567") || !strings.Contains(out, "$0.25") || !strings.Contains(out, "1m30s") {
+		t.Errorf("table = %q", out)
+	}
+
+	buf.Reset()
+	if err := Write(&buf, FormatJSON, recs); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []Record
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || len(decoded) != 1 || decoded[0].Tokens.InputTokens != 100 {
+		t.Errorf("json = %s (%v)", buf.String(), err)
+	}
+
+	buf.Reset()
+	if err := Write(&buf, FormatCSV, recs); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil || len(rows) != 2 || rows[1][0] != "0123456789abcdef" || rows[1][15] != "a.go;b.go" {
+		t.Errorf("csv = %v (%v)", rows, err)
+	}
+
+	if err := Write(&buf, "xml", recs); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
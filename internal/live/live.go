@@ -0,0 +1,243 @@
+// Package live keeps a real-time view of tracked sessions (token usage,
+// last tool call, files changed and recent activity) for the admin API and
+// the top dashboard.
+package live
+
+import (
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/pricing"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/session"
+)
+
+const (
+	// maxRecent bounds the activities kept per session for tailing.
+	maxRecent = 500
+	// maxDetail bounds the tool detail shown for the last tool call.
+	maxDetail = 200
+	// subscriberBuffer is how many updates a slow subscriber may lag by
+	// before updates to it are dropped.
+	subscriberBuffer = 256
+)
+
+// editTools modify the file named in their input.
+var editTools = map[string]bool{
+	"Write":        true,
+	"Edit":         true,
+	"MultiEdit":    true,
+	"NotebookEdit": true,
+}
+
+// Session is the live view of one session.
+type Session struct {
+	session.SessionInfo
+	Tokens  session.TokenUsage `json:"tokens"`
+	CostUSD float64            `json:"cost_usd"`
+	// LastTool is the most recent tool call; LastToolDetail is its file path
+	// or command.
+	LastTool       string    `json:"last_tool,omitempty"`
+	LastToolDetail string    `json:"last_tool_detail,omitempty"`
+	LastToolAt     time.Time `json:"last_tool_at,omitzero"`
+	FilesChanged   int       `json:"files_changed"`
+	// Removed is set on the final update of a session that completed.
+	Removed bool `json:"removed,omitempty"`
+}
+
+type state struct {
+	view   Session
+	files  map[string]bool
+	recent []session.Activity
+}
+
+// Monitor aggregates tracker callbacks into live session views.
+type Monitor struct {
+	prices *pricing.Table
+
+	mu       sync.Mutex
+	sessions map[string]*state
+	subs     map[chan Session]struct{}
+}
+
+// New creates a monitor. A nil price table uses the built-in prices.
+func New(prices *pricing.Table) *Monitor {
+	if prices == nil {
+		prices = pricing.Default()
+	}
+	return &Monitor{
+		prices:   prices,
+		sessions: make(map[string]*state),
+		subs:     make(map[chan Session]struct{}),
+	}
+}
+
+// ObserveState records a session's liveness. It is intended as a Tracker
+// OnState callback.
+func (m *Monitor) ObserveState(info session.SessionInfo) {
+	m.mu.Lock()
+	st := m.get(info)
+	st.view.SessionInfo = info
+	view := st.view
+	m.mu.Unlock()
+	m.notify(view)
+}
+
+// Observe records parsed activity. It is intended as a Tracker OnActivity
+// callback.
+func (m *Monitor) Observe(info session.SessionInfo, acts []session.Activity) {
+	m.mu.Lock()
+	st := m.get(info)
+	st.view.SessionInfo = info
+	for _, a := range acts {
+		switch a.Kind {
+		case session.ActivityUsage:
+			if a.Usage != nil {
+				st.view.Tokens.Add(*a.Usage)
+				if c, ok := m.prices.Cost(a.Model, *a.Usage); ok {
+					st.view.CostUSD += c
+				}
+			}
+			continue
+		case session.ActivityToolUse:
+			st.view.LastTool = a.Tool
+			st.view.LastToolAt = a.Timestamp
+			st.view.LastToolDetail = truncate(firstNonEmpty(a.FilePath, a.Command), maxDetail)
+			if editTools[a.Tool] && a.FilePath != "" && !st.files[a.FilePath] {
+				st.files[a.FilePath] = true
+				st.view.FilesChanged = len(st.files)
+			}
+		}
+		st.recent = append(st.recent, a)
+	}
+	if over := len(st.recent) - maxRecent; over > 0 {
+		st.recent = append(st.recent[:0:0], st.recent[over:]...)
+	}
+	view := st.view
+	m.mu.Unlock()
+	m.notify(view)
+}
+
+// Forget removes a session, publishing a final update with Removed set.
+func (m *Monitor) Forget(sessionID string) {
+	m.mu.Lock()
+	st, ok := m.sessions[sessionID]
+	delete(m.sessions, sessionID)
+	m.mu.Unlock()
+	if ok {
+		view := st.view
+		view.Removed = true
+		m.notify(view)
+	}
+}
+
+// get returns the state for info, creating it. Caller must hold m.mu.
+func (m *Monitor) get(info session.SessionInfo) *state {
+	st := m.sessions[info.SessionID]
+	if st == nil {
+		st = &state{files: make(map[string]bool)}
+		m.sessions[info.SessionID] = st
+	}
+	return st
+}
+
+// Sessions returns all sessions, most recently active first.
+func (m *Monitor) Sessions() []Session {
+	m.mu.Lock()
+	out := make([]Session, 0, len(m.sessions))
+	for _, st := range m.sessions {
+		out = append(out, st.view)
+	}
+	m.mu.Unlock()
+	Sort(out)
+	return out
+}
+
+// Session returns one session.
+func (m *Monitor) Session(sessionID string) (Session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st, ok := m.sessions[sessionID]
+	if !ok {
+		return Session{}, false
+	}
+	return st.view, true
+}
+
+// Activity returns up to limit of the session's most recent activities,
+// oldest first. limit <= 0 returns all that are kept.
+func (m *Monitor) Activity(sessionID string, limit int) ([]session.Activity, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st, ok := m.sessions[sessionID]
+	if !ok {
+		return nil, false
+	}
+	recent := st.recent
+	if limit > 0 && len(recent) > limit {
+		recent = recent[len(recent)-limit:]
+	}
+	return append([]session.Activity(nil), recent...), true
+}
+
+// Subscribe returns a channel receiving every session update and a function
+// that cancels the subscription. Updates are dropped for subscribers that
+// fall too far behind.
+func (m *Monitor) Subscribe() (<-chan Session, func()) {
+	ch := make(chan Session, subscriberBuffer)
+	m.mu.Lock()
+	m.subs[ch] = struct{}{}
+	m.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			m.mu.Lock()
+			delete(m.subs, ch)
+			m.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+func (m *Monitor) notify(view Session) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for ch := range m.subs {
+		select {
+		case ch <- view:
+		default:
+		}
+	}
+}
+
+// Sort orders sessions most recently active first.
+func Sort(sessions []Session) {
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].LastActivity.Equal(sessions[j].LastActivity) {
+			return sessions[i].LastActivity.After(sessions[j].LastActivity)
+		}
+		return sessions[i].SessionID < sessions[j].SessionID
+	})
+}
+
+func firstNonEmpty(ss ...string) string {
+	for _, s := range ss {
+		if s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// truncate shortens s to at most n bytes without splitting a rune.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "…"
+}
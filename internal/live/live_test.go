@@ -0,0 +1,97 @@
+package live
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/session"
+)
+
+func TestMonitor_Observe(t *testing.T) {
+	m := New(nil)
+	updates, cancel := m.Subscribe()
+	defer cancel()
+
+	t0 := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	info := session.SessionInfo{SessionID: "s1", State: session.StateRunning, PID: 42, LastActivity: t0}
+	m.ObserveState(info)
+	m.Observe(info, []session.Activity{
+		{Kind: session.ActivityPrompt, Text: "fix it"},
+		{Kind: session.ActivityToolUse, Tool: "Edit", FilePath: "/r/a.go", Timestamp: t0},
+		{Kind: session.ActivityToolUse, Tool: "Write", FilePath: "/r/a.go"},
+		{Kind: session.ActivityToolUse, Tool: "Read", FilePath: "/r/b.go"},
+		{Kind: session.ActivityToolUse, Tool: "Bash", Command: "go test ./...", Timestamp: t0.Add(time.Second)},
+		{Kind: session.ActivityUsage, Model: "claude-sonnet-4-5", Usage: &session.TokenUsage{InputTokens: 1_000_000}},
+	})
+
+	s, ok := m.Session("s1")
+	if !ok {
+		t.Fatal("session not found")
+	}
+	if s.PID != 42 || s.FilesChanged != 1 || s.LastTool != "Bash" || s.LastToolDetail != "go test ./..." || !s.LastToolAt.Equal(t0.Add(time.Second)) {
+		t.Errorf("view = %+v", s)
+	}
+	if s.Tokens.InputTokens != 1_000_000 || s.CostUSD != 3 {
+		t.Errorf("usage = %+v $%v", s.Tokens, s.CostUSD)
+	}
+
+	// Usage is summarised, not kept as activity.
+	acts, _ := m.Activity("s1", 0)
+	if len(acts) != 5 {
+		t.Errorf("activities = %d, want 5", len(acts))
+	}
+	if acts, _ := m.Activity("s1", 2); len(acts) != 2 || acts[1].Tool != "Bash" {
+		t.Errorf("limited activities = %+v", acts)
+	}
+
+	if n := len(updates); n != 2 {
+		t.Errorf("updates = %d, want 2", n)
+	}
+
+	m.Forget("s1")
+	var last Session
+	for len(updates) > 0 {
+		last = <-updates
+	}
+	if !last.Removed || last.SessionID != "s1" {
+		t.Errorf("final update = %+v", last)
+	}
+	if len(m.Sessions()) != 0 {
+		t.Error("session not forgotten")
+	}
+}
+
+func TestMonitor_RecentIsBounded(t *testing.T) {
+	m := New(nil)
+	info := session.SessionInfo{SessionID: "s1"}
+	for i := 0; i < maxRecent+10; i++ {
+		m.Observe(info, []session.Activity{{Kind: session.ActivityText, Text: strings.Repeat("x", i%3)}})
+	}
+	if acts, _ := m.Activity("s1", 0); len(acts) != maxRecent {
+		t.Errorf("kept %d activities, want %d", len(acts), maxRecent)
+	}
+}
+
+func TestMonitor_SessionsOrder(t *testing.T) {
+	m := New(nil)
+	t0 := time.Now()
+	m.ObserveState(session.SessionInfo{SessionID: "old", LastActivity: t0.Add(-time.Minute)})
+	m.ObserveState(session.SessionInfo{SessionID: "new", LastActivity: t0})
+
+	got := m.Sessions()
+	if len(got) != 2 || got[0].SessionID != "new" {
+		t.Errorf("order = %+v", got)
+	}
+}
+
+func TestSubscribe_Cancel(t *testing.T) {
+	m := New(nil)
+	updates, cancel := m.Subscribe()
+	cancel()
+	cancel()
+	m.ObserveState(session.SessionInfo{SessionID: "s1"})
+	if _, ok := <-updates; ok {
+		t.Error("expected closed channel")
+	}
+}
@@ -0,0 +1,47 @@
+// Package natstest runs embedded NATS servers for tests.
+package natstest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+)
+
+// Options returns default server options: loopback, random port, JetStream
+// enabled with storage in a per-test temp dir.
+func Options(t testing.TB) *server.Options {
+	t.Helper()
+	return &server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		NoLog:     true,
+		NoSigs:    true,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+	}
+}
+
+// Run starts an embedded server with the given options and registers a
+// cleanup to shut it down when the test finishes.
+func Run(t testing.TB, opts *server.Options) *server.Server {
+	t.Helper()
+	if opts == nil {
+		opts = Options(t)
+	}
+
+	s, err := server.NewServer(opts)
+	if err != nil {
+		t.Fatalf("new nats server: %v", err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(10 * time.Second) {
+		s.Shutdown()
+		t.Fatal("nats server not ready for connections")
+	}
+	t.Cleanup(func() {
+		s.Shutdown()
+		s.WaitForShutdown()
+	})
+	return s
+}
@@ -0,0 +1,240 @@
+// Package policy flags risky agent behaviour as transcripts grow: touching
+// sensitive files, running dangerous commands, editing outside the working
+// directory and piling up tool errors.
+package policy
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/glob"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/session"
+)
+
+// Rule types.
+const (
+	TypeFile           = "file"                // a tool touched a file matching Patterns (globs)
+	TypeCommand        = "command"             // a Bash command matched Patterns (regexps)
+	TypeOutsideWorkDir = "outside_working_dir" // a file was edited outside the working directory
+	TypeToolErrors     = "tool_errors"         // more than Max tool calls failed
+)
+
+// maxViolations bounds the violations kept per session.
+const maxViolations = 100
+
+// editTools modify the file named in their input.
+var editTools = map[string]bool{"Write": true, "Edit": true, "MultiEdit": true, "NotebookEdit": true}
+
+// Rule is one configured policy.
+type Rule struct {
+	Name     string
+	Type     string
+	Severity string
+	// Patterns are globs for file rules and regexps for command rules.
+	Patterns []string
+	// Max is the tool error limit for tool_errors rules.
+	Max int
+}
+
+// Violation is a rule breach, with the evidence that triggered it.
+type Violation struct {
+	Rule     string    `json:"rule"`
+	Type     string    `json:"type"`
+	Severity string    `json:"severity,omitempty"`
+	Detail   string    `json:"detail"`
+	Tool     string    `json:"tool,omitempty"`
+	FilePath string    `json:"file_path,omitempty"`
+	Command  string    `json:"command,omitempty"`
+	At       time.Time `json:"at,omitzero"`
+}
+
+// OnViolation is called once for each new violation.
+type OnViolation func(info session.SessionInfo, v Violation)
+
+type compiledRule struct {
+	Rule
+	globs []*glob.Pattern
+	res   []*regexp.Regexp
+}
+
+// sessionState is what the engine remembers about one session.
+type sessionState struct {
+	root        string
+	rootFromCWD bool
+	toolErrors  int
+	seen        map[string]bool
+	violations  []Violation
+}
+
+// Engine evaluates rules against session activity.
+type Engine struct {
+	rules       []compiledRule
+	onViolation OnViolation
+	logger      *slog.Logger
+
+	mu       sync.Mutex
+	sessions map[string]*sessionState
+}
+
+// New compiles the rules.
+func New(rules []Rule, onViolation OnViolation, logger *slog.Logger) (*Engine, error) {
+	e := &Engine{
+		onViolation: onViolation,
+		logger:      logger.With("component", "policy"),
+		sessions:    make(map[string]*sessionState),
+	}
+	for i, r := range rules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule-%d", i)
+		}
+		cr := compiledRule{Rule: r}
+		switch r.Type {
+		case TypeFile:
+			for _, p := range r.Patterns {
+				g, err := glob.Compile(p)
+				if err != nil {
+					return nil, fmt.Errorf("rule %s: %w", r.Name, err)
+				}
+				cr.globs = append(cr.globs, g)
+			}
+		case TypeCommand:
+			for _, p := range r.Patterns {
+				re, err := regexp.Compile(p)
+				if err != nil {
+					return nil, fmt.Errorf("rule %s: %w", r.Name, err)
+				}
+				cr.res = append(cr.res, re)
+			}
+		case TypeOutsideWorkDir:
+		case TypeToolErrors:
+			if r.Max <= 0 {
+				return nil, fmt.Errorf("rule %s: max must be positive", r.Name)
+			}
+		default:
+			return nil, fmt.Errorf("rule %s: unknown type %q", r.Name, r.Type)
+		}
+		e.rules = append(e.rules, cr)
+	}
+	return e, nil
+}
+
+// Observe evaluates new activity. It matches session.OnActivity so it can be
+// registered with the tracker directly.
+func (e *Engine) Observe(info session.SessionInfo, acts []session.Activity) {
+	var found []Violation
+
+	e.mu.Lock()
+	st, ok := e.sessions[info.SessionID]
+	if !ok {
+		st = &sessionState{root: info.WorkingDir, seen: make(map[string]bool)}
+		e.sessions[info.SessionID] = st
+	}
+	for _, a := range acts {
+		// The first cwd in the transcript is where the session started;
+		// until one is seen, the project dir stands in.
+		if a.CWD != "" && !st.rootFromCWD {
+			st.root, st.rootFromCWD = a.CWD, true
+		}
+		if a.Kind == session.ActivityToolResult && a.IsError {
+			st.toolErrors++
+		}
+		for _, r := range e.rules {
+			v, key, ok := r.evaluate(a, st)
+			if !ok || st.seen[key] {
+				continue
+			}
+			st.seen[key] = true
+			if len(st.violations) < maxViolations {
+				st.violations = append(st.violations, v)
+			}
+			found = append(found, v)
+		}
+	}
+	e.mu.Unlock()
+
+	for _, v := range found {
+		e.logger.Warn("policy violation", "session_id", info.SessionID, "rule", v.Rule, "detail", v.Detail)
+		if e.onViolation != nil {
+			e.onViolation(info, v)
+		}
+	}
+}
+
+// evaluate checks one activity against the rule, returning the violation and
+// a key identifying it for deduplication.
+func (r compiledRule) evaluate(a session.Activity, st *sessionState) (Violation, string, bool) {
+	v := Violation{Rule: r.Name, Type: r.Type, Severity: r.Severity, Tool: a.Tool, At: a.Timestamp}
+
+	switch r.Type {
+	case TypeFile:
+		if a.Kind != session.ActivityToolUse || a.FilePath == "" {
+			break
+		}
+		path := absPath(a.FilePath, a.CWD)
+		for _, g := range r.globs {
+			if g.Match(path) {
+				v.FilePath = path
+				v.Detail = fmt.Sprintf("%s touched %s (matches %s)", a.Tool, path, g)
+				return v, r.Name + "\x00" + path, true
+			}
+		}
+	case TypeCommand:
+		if a.Kind != session.ActivityToolUse || a.Command == "" {
+			break
+		}
+		for _, re := range r.res {
+			if re.MatchString(a.Command) {
+				v.Command = a.Command
+				v.Detail = fmt.Sprintf("command matches %s", re)
+				return v, r.Name + "\x00" + a.Command, true
+			}
+		}
+	case TypeOutsideWorkDir:
+		if a.Kind != session.ActivityToolUse || !editTools[a.Tool] || a.FilePath == "" || st.root == "" {
+			break
+		}
+		path := absPath(a.FilePath, a.CWD)
+		if rel, err := filepath.Rel(st.root, path); err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			v.FilePath = path
+			v.Detail = fmt.Sprintf("%s edited %s outside %s", a.Tool, path, st.root)
+			return v, r.Name + "\x00" + path, true
+		}
+	case TypeToolErrors:
+		if st.toolErrors > r.Max {
+			v.Tool = ""
+			v.Detail = fmt.Sprintf("%d tool errors exceed the limit of %d", st.toolErrors, r.Max)
+			return v, r.Name, true
+		}
+	}
+	return Violation{}, "", false
+}
+
+// Violations returns the violations recorded for a session.
+func (e *Engine) Violations(sessionID string) []Violation {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	st, ok := e.sessions[sessionID]
+	if !ok || len(st.violations) == 0 {
+		return nil
+	}
+	return append([]Violation(nil), st.violations...)
+}
+
+// Forget drops the state of a finished session.
+func (e *Engine) Forget(sessionID string) {
+	e.mu.Lock()
+	delete(e.sessions, sessionID)
+	e.mu.Unlock()
+}
+
+func absPath(path, cwd string) string {
+	if filepath.IsAbs(path) || cwd == "" {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(cwd, path)
+}
@@ -0,0 +1,114 @@
+// Package pricing turns token usage into an estimated cost in US dollars.
+package pricing
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/session"
+)
+
+// Price is the cost of a model in US dollars per million tokens.
+type Price struct {
+	Input      float64 `yaml:"input" json:"input"`
+	Output     float64 `yaml:"output" json:"output"`
+	CacheWrite float64 `yaml:"cache_write" json:"cache_write"`
+	CacheRead  float64 `yaml:"cache_read" json:"cache_read"`
+}
+
+// defaults are list prices keyed by model name prefix.
+var defaults = map[string]Price{
+	"claude-opus-4-5":   {Input: 5, Output: 25, CacheWrite: 6.25, CacheRead: 0.50},
+	"claude-opus-4":     {Input: 15, Output: 75, CacheWrite: 18.75, CacheRead: 1.50},
+	"claude-3-opus":     {Input: 15, Output: 75, CacheWrite: 18.75, CacheRead: 1.50},
+	"claude-sonnet-4":   {Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.30},
+	"claude-3-7-sonnet": {Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.30},
+	"claude-3-5-sonnet": {Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.30},
+	"claude-haiku-4-5":  {Input: 1, Output: 5, CacheWrite: 1.25, CacheRead: 0.10},
+	"claude-3-5-haiku":  {Input: 0.80, Output: 4, CacheWrite: 1, CacheRead: 0.08},
+	"claude-3-haiku":    {Input: 0.25, Output: 1.25, CacheWrite: 0.30, CacheRead: 0.03},
+}
+
+type entry struct {
+	prefix string
+	price  Price
+}
+
+// Table looks up prices by the longest matching model name prefix.
+type Table struct {
+	entries []entry
+}
+
+// New builds a table from the built-in prices with overrides applied.
+// Override keys are model names or name prefixes.
+func New(overrides map[string]Price) (*Table, error) {
+	merged := make(map[string]Price, len(defaults)+len(overrides))
+	for k, v := range defaults {
+		merged[k] = v
+	}
+	for k, v := range overrides {
+		if k == "" {
+			return nil, fmt.Errorf("pricing: empty model name")
+		}
+		if v.Input < 0 || v.Output < 0 || v.CacheWrite < 0 || v.CacheRead < 0 {
+			return nil, fmt.Errorf("pricing: negative price for %q", k)
+		}
+		merged[k] = v
+	}
+
+	t := &Table{entries: make([]entry, 0, len(merged))}
+	for k, v := range merged {
+		t.entries = append(t.entries, entry{prefix: k, price: v})
+	}
+	sort.Slice(t.entries, func(i, j int) bool {
+		if len(t.entries[i].prefix) != len(t.entries[j].prefix) {
+			return len(t.entries[i].prefix) > len(t.entries[j].prefix)
+		}
+		return t.entries[i].prefix < t.entries[j].prefix
+	})
+	return t, nil
+}
+
+// Default returns a table of the built-in prices.
+func Default() *Table {
+	t, _ := New(nil)
+	return t
+}
+
+// Lookup returns the price of model and whether it is known.
+func (t *Table) Lookup(model string) (Price, bool) {
+	for _, e := range t.entries {
+		if strings.HasPrefix(model, e.prefix) {
+			return e.price, true
+		}
+	}
+	return Price{}, false
+}
+
+// Cost returns the cost of u on model, and false if the model has no price.
+func (t *Table) Cost(model string, u session.TokenUsage) (float64, bool) {
+	p, ok := t.Lookup(model)
+	if !ok {
+		return 0, false
+	}
+	return (float64(u.InputTokens)*p.Input +
+		float64(u.OutputTokens)*p.Output +
+		float64(u.CacheCreationInputTokens)*p.CacheWrite +
+		float64(u.CacheReadInputTokens)*p.CacheRead) / 1e6, true
+}
+
+// Total returns the cost of per-model usage. Models without a price are
+// listed in unpriced and contribute nothing.
+func (t *Table) Total(usage map[string]session.TokenUsage) (cost float64, unpriced []string) {
+	for model, u := range usage {
+		c, ok := t.Cost(model, u)
+		if !ok {
+			unpriced = append(unpriced, model)
+			continue
+		}
+		cost += c
+	}
+	sort.Strings(unpriced)
+	return cost, unpriced
+}
@@ -0,0 +1,63 @@
+package pricing
+
+import (
+	"math"
+	"testing"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/session"
+)
+
+func TestLookup_LongestPrefix(t *testing.T) {
+	tab := Default()
+
+	p, ok := tab.Lookup("claude-opus-4-5-20251101")
+	if !ok || p.Input != 5 {
+		t.Errorf("opus 4.5 price = %+v, %v", p, ok)
+	}
+	p, ok = tab.Lookup("claude-opus-4-1-20250805")
+	if !ok || p.Input != 15 {
+		t.Errorf("opus 4.1 price = %+v, %v", p, ok)
+	}
+	if _, ok := tab.Lookup("gpt-4"); ok {
+		t.Error("unknown model should have no price")
+	}
+}
+
+func TestCost(t *testing.T) {
+	tab := Default()
+	u := session.TokenUsage{InputTokens: 1_000_000, OutputTokens: 100_000, CacheCreationInputTokens: 200_000, CacheReadInputTokens: 1_000_000}
+
+	// 3 + 1.5 + 0.75 + 0.3
+	c, ok := tab.Cost("claude-sonnet-4-5-20250929", u)
+	if !ok || math.Abs(c-5.55) > 1e-9 {
+		t.Errorf("cost = %v, %v; want 5.55", c, ok)
+	}
+}
+
+func TestNew_Overrides(t *testing.T) {
+	tab, err := New(map[string]Price{
+		"claude-sonnet-4-5": {Input: 1, Output: 2},
+		"internal-model":    {Input: 10},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := tab.Lookup("claude-sonnet-4-5-20250929"); p.Input != 1 {
+		t.Errorf("override not applied: %+v", p)
+	}
+	if p, _ := tab.Lookup("claude-sonnet-4-20250514"); p.Input != 3 {
+		t.Errorf("default lost: %+v", p)
+	}
+
+	cost, unpriced := tab.Total(map[string]session.TokenUsage{
+		"internal-model": {InputTokens: 100_000},
+		"mystery":        {InputTokens: 5},
+	})
+	if math.Abs(cost-1) > 1e-9 || len(unpriced) != 1 || unpriced[0] != "mystery" {
+		t.Errorf("total = %v, unpriced = %v", cost, unpriced)
+	}
+
+	if _, err := New(map[string]Price{"x": {Input: -1}}); err == nil {
+		t.Error("expected error for negative price")
+	}
+}
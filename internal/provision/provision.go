@@ -0,0 +1,349 @@
+// Package provision creates or updates the JetStream resources the sidecar
+// depends on: the stream capturing session events and the session registry
+// KV bucket.
+package provision
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// StreamConfig describes the stream that captures session events.
+type StreamConfig struct {
+	Name            string
+	Subjects        []string
+	Retention       string // "limits", "interest" or "workqueue"
+	Storage         string // "file" or "memory"
+	MaxAge          time.Duration
+	Replicas        int
+	DuplicateWindow time.Duration
+}
+
+// BucketConfig describes a KV bucket.
+type BucketConfig struct {
+	Bucket   string
+	TTL      time.Duration
+	History  uint8
+	Replicas int
+	Storage  string
+}
+
+// Drift records a difference between the desired and actual configuration.
+type Drift struct {
+	Resource string
+	Field    string
+	Want     string
+	Got      string
+}
+
+func (d Drift) String() string {
+	return fmt.Sprintf("%s %s: want %s, got %s", d.Resource, d.Field, d.Want, d.Got)
+}
+
+// Result summarises a provisioning run.
+type Result struct {
+	Drift   []Drift
+	Created []string
+	Updated []string
+}
+
+// Provisioner applies stream and bucket configuration.
+type Provisioner struct {
+	js     jetstream.JetStream
+	dryRun bool
+	logger *slog.Logger
+}
+
+// New creates a provisioner. In dry-run mode drift is reported but nothing
+// is created or updated.
+func New(js jetstream.JetStream, dryRun bool, logger *slog.Logger) *Provisioner {
+	return &Provisioner{
+		js:     js,
+		dryRun: dryRun,
+		logger: logger.With("component", "provision"),
+	}
+}
+
+// Stream ensures the session event stream exists with the given config. If a
+// stream with a different name already binds the first subject, that stream
+// is used instead so that provisioning never creates overlapping streams.
+// Such a stream may serve other producers: it only gets the subjects it is
+// missing, and drift in its other settings is reported but left alone.
+func (p *Provisioner) Stream(ctx context.Context, cfg StreamConfig, res *Result) error {
+	want, err := cfg.streamConfig()
+	if err != nil {
+		return err
+	}
+
+	adopted := false
+	if len(want.Subjects) > 0 {
+		name, err := p.js.StreamNameBySubject(ctx, want.Subjects[0])
+		switch {
+		case err == nil && name != want.Name:
+			p.addDrift(res, Drift{Resource: "stream", Field: "name", Want: want.Name, Got: name})
+			want.Name = name
+			adopted = true
+		case err != nil && !errors.Is(err, jetstream.ErrStreamNotFound):
+			return fmt.Errorf("lookup stream by subject: %w", err)
+		}
+	}
+
+	stream, err := p.js.Stream(ctx, want.Name)
+	if errors.Is(err, jetstream.ErrStreamNotFound) {
+		return p.apply(ctx, "stream "+want.Name, res, true, func() error {
+			_, err := p.js.CreateStream(ctx, want)
+			return err
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("stream info: %w", err)
+	}
+
+	got := stream.CachedInfo().Config
+	if adopted {
+		return p.extend(ctx, want, got, res)
+	}
+	drift := streamDrift("stream "+want.Name, want, got)
+	for _, d := range drift {
+		p.addDrift(res, d)
+	}
+	if len(drift) == 0 {
+		return nil
+	}
+
+	// Preserve settings we don't manage.
+	merged := got
+	merged.Subjects = want.Subjects
+	merged.Retention = want.Retention
+	merged.Storage = want.Storage
+	merged.MaxAge = want.MaxAge
+	merged.Replicas = want.Replicas
+	merged.Duplicates = want.Duplicates
+	return p.apply(ctx, "stream "+want.Name, res, false, func() error {
+		_, err := p.js.UpdateStream(ctx, merged)
+		return err
+	})
+}
+
+// extend adds the wanted subjects an adopted stream does not already
+// capture. Its other settings belong to whoever created it and are only
+// reported.
+func (p *Provisioner) extend(ctx context.Context, want, got jetstream.StreamConfig, res *Result) error {
+	resource := "stream " + want.Name
+	for _, d := range streamDrift(resource, want, got) {
+		if d.Field != "subjects" {
+			p.addDrift(res, d)
+		}
+	}
+
+	subjects := slices.Clone(got.Subjects)
+	for _, s := range want.Subjects {
+		if !slices.ContainsFunc(got.Subjects, func(have string) bool { return covers(have, s) }) {
+			subjects = append(subjects, s)
+		}
+	}
+	if len(subjects) == len(got.Subjects) {
+		return nil
+	}
+	p.addDrift(res, Drift{Resource: resource, Field: "subjects", Want: strings.Join(subjects, ","), Got: strings.Join(got.Subjects, ",")})
+
+	merged := got
+	merged.Subjects = subjects
+	return p.apply(ctx, resource, res, false, func() error {
+		_, err := p.js.UpdateStream(ctx, merged)
+		return err
+	})
+}
+
+// covers reports whether every subject matching subject also matches
+// pattern.
+func covers(pattern, subject string) bool {
+	pt, st := strings.Split(pattern, "."), strings.Split(subject, ".")
+	for i, tok := range pt {
+		switch {
+		case tok == ">":
+			return i < len(st)
+		case i >= len(st):
+			return false
+		case tok == "*":
+			if st[i] == ">" {
+				return false
+			}
+		case tok != st[i]:
+			return false
+		}
+	}
+	return len(pt) == len(st)
+}
+
+// Bucket ensures a KV bucket exists with the given TTL, history and replicas.
+func (p *Provisioner) Bucket(ctx context.Context, cfg BucketConfig, res *Result) error {
+	storage, err := parseStorage(cfg.Storage)
+	if err != nil {
+		return err
+	}
+	want := jetstream.KeyValueConfig{
+		Bucket:   cfg.Bucket,
+		TTL:      cfg.TTL,
+		History:  max(cfg.History, 1),
+		Replicas: max(cfg.Replicas, 1),
+		Storage:  storage,
+	}
+	resource := "bucket " + cfg.Bucket
+
+	// A KV bucket is backed by a stream named KV_<bucket>; its config
+	// carries the fields we compare.
+	stream, err := p.js.Stream(ctx, "KV_"+cfg.Bucket)
+	if errors.Is(err, jetstream.ErrStreamNotFound) {
+		return p.apply(ctx, resource, res, true, func() error {
+			_, err := p.js.CreateKeyValue(ctx, want)
+			return err
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("bucket info: %w", err)
+	}
+
+	got := stream.CachedInfo().Config
+	var drift []Drift
+	if got.MaxAge != want.TTL {
+		drift = append(drift, Drift{Resource: resource, Field: "ttl", Want: want.TTL.String(), Got: got.MaxAge.String()})
+	}
+	if got.MaxMsgsPerSubject != int64(want.History) {
+		drift = append(drift, Drift{Resource: resource, Field: "history", Want: fmt.Sprint(want.History), Got: fmt.Sprint(got.MaxMsgsPerSubject)})
+	}
+	if got.Replicas != want.Replicas {
+		drift = append(drift, Drift{Resource: resource, Field: "replicas", Want: fmt.Sprint(want.Replicas), Got: fmt.Sprint(got.Replicas)})
+	}
+	if got.Storage != want.Storage {
+		drift = append(drift, Drift{Resource: resource, Field: "storage", Want: want.Storage.String(), Got: got.Storage.String()})
+	}
+	for _, d := range drift {
+		p.addDrift(res, d)
+	}
+	if len(drift) == 0 {
+		return nil
+	}
+
+	return p.apply(ctx, resource, res, false, func() error {
+		_, err := p.js.UpdateKeyValue(ctx, want)
+		return err
+	})
+}
+
+func (p *Provisioner) addDrift(res *Result, d Drift) {
+	res.Drift = append(res.Drift, d)
+	p.logger.Warn("configuration drift", "resource", d.Resource, "field", d.Field, "want", d.Want, "got", d.Got)
+}
+
+// apply runs fn unless in dry-run mode and records the outcome.
+func (p *Provisioner) apply(ctx context.Context, resource string, res *Result, create bool, fn func() error) error {
+	action := "update"
+	if create {
+		action = "create"
+	}
+	if p.dryRun {
+		p.logger.Info("dry run: would "+action, "resource", resource)
+		if create {
+			p.addDrift(res, Drift{Resource: resource, Field: "exists", Want: "true", Got: "false"})
+		}
+		return nil
+	}
+	if err := fn(); err != nil {
+		return fmt.Errorf("%s %s: %w", action, resource, err)
+	}
+	if create {
+		res.Created = append(res.Created, resource)
+	} else {
+		res.Updated = append(res.Updated, resource)
+	}
+	p.logger.Info("provisioned", "resource", resource, "action", action)
+	return nil
+}
+
+func (c StreamConfig) streamConfig() (jetstream.StreamConfig, error) {
+	retention, err := parseRetention(c.Retention)
+	if err != nil {
+		return jetstream.StreamConfig{}, err
+	}
+	storage, err := parseStorage(c.Storage)
+	if err != nil {
+		return jetstream.StreamConfig{}, err
+	}
+	if c.Name == "" {
+		return jetstream.StreamConfig{}, errors.New("stream name is required")
+	}
+	return jetstream.StreamConfig{
+		Name:       c.Name,
+		Subjects:   c.Subjects,
+		Retention:  retention,
+		Storage:    storage,
+		MaxAge:     c.MaxAge,
+		Replicas:   max(c.Replicas, 1),
+		Duplicates: c.DuplicateWindow,
+	}, nil
+}
+
+// streamDrift compares the managed fields of two stream configs.
+func streamDrift(resource string, want, got jetstream.StreamConfig) []Drift {
+	var drift []Drift
+	add := func(field string, w, g any) {
+		drift = append(drift, Drift{Resource: resource, Field: field, Want: fmt.Sprint(w), Got: fmt.Sprint(g)})
+	}
+
+	if !slices.Equal(sortedCopy(want.Subjects), sortedCopy(got.Subjects)) {
+		add("subjects", strings.Join(want.Subjects, ","), strings.Join(got.Subjects, ","))
+	}
+	if want.Retention != got.Retention {
+		add("retention", want.Retention, got.Retention)
+	}
+	if want.Storage != got.Storage {
+		add("storage", want.Storage, got.Storage)
+	}
+	if want.MaxAge != got.MaxAge {
+		add("max_age", want.MaxAge, got.MaxAge)
+	}
+	if want.Replicas != got.Replicas {
+		add("replicas", want.Replicas, got.Replicas)
+	}
+	// The server applies a default duplicate window when none is requested.
+	if want.Duplicates != 0 && want.Duplicates != got.Duplicates {
+		add("duplicate_window", want.Duplicates, got.Duplicates)
+	}
+	return drift
+}
+
+func sortedCopy(s []string) []string {
+	c := slices.Clone(s)
+	slices.Sort(c)
+	return c
+}
+
+func parseRetention(s string) (jetstream.RetentionPolicy, error) {
+	switch strings.ToLower(s) {
+	case "", "limits":
+		return jetstream.LimitsPolicy, nil
+	case "interest":
+		return jetstream.InterestPolicy, nil
+	case "workqueue":
+		return jetstream.WorkQueuePolicy, nil
+	}
+	return 0, fmt.Errorf("unknown retention policy %q", s)
+}
+
+func parseStorage(s string) (jetstream.StorageType, error) {
+	switch strings.ToLower(s) {
+	case "", "file":
+		return jetstream.FileStorage, nil
+	case "memory":
+		return jetstream.MemoryStorage, nil
+	}
+	return 0, fmt.Errorf("unknown storage type %q", s)
+}
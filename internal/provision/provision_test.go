@@ -0,0 +1,283 @@
+package provision
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/natstest"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+}
+
+func testJetStream(t *testing.T) jetstream.JetStream {
+	t.Helper()
+	s := natstest.Run(t, nil)
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return js
+}
+
+func testStreamConfig() StreamConfig {
+	return StreamConfig{
+		Name:            "CC_SESSIONS",
+		Subjects:        []string{"swarm.cc.session.>"},
+		Retention:       "limits",
+		MaxAge:          24 * time.Hour,
+		Replicas:        1,
+		DuplicateWindow: time.Minute,
+	}
+}
+
+func TestStream_CreateThenNoDrift(t *testing.T) {
+	js := testJetStream(t)
+	ctx := context.Background()
+	p := New(js, false, testLogger())
+
+	var res Result
+	if err := p.Stream(ctx, testStreamConfig(), &res); err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	if len(res.Created) != 1 {
+		t.Fatalf("expected stream to be created, got %+v", res)
+	}
+
+	stream, err := js.Stream(ctx, "CC_SESSIONS")
+	if err != nil {
+		t.Fatalf("stream not created: %v", err)
+	}
+	cfg := stream.CachedInfo().Config
+	if cfg.MaxAge != 24*time.Hour || cfg.Duplicates != time.Minute {
+		t.Errorf("unexpected stream config: max_age=%v duplicates=%v", cfg.MaxAge, cfg.Duplicates)
+	}
+
+	// A second run against the same config reports nothing.
+	var again Result
+	if err := p.Stream(ctx, testStreamConfig(), &again); err != nil {
+		t.Fatalf("Stream (second run): %v", err)
+	}
+	if len(again.Drift) != 0 || len(again.Created) != 0 || len(again.Updated) != 0 {
+		t.Errorf("expected no changes on second run, got %+v", again)
+	}
+}
+
+func TestStream_ReportsAndFixesDrift(t *testing.T) {
+	js := testJetStream(t)
+	ctx := context.Background()
+	p := New(js, false, testLogger())
+
+	if err := p.Stream(ctx, testStreamConfig(), &Result{}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := testStreamConfig()
+	cfg.MaxAge = 48 * time.Hour
+	var res Result
+	if err := p.Stream(ctx, cfg, &res); err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	if len(res.Drift) != 1 || res.Drift[0].Field != "max_age" {
+		t.Fatalf("expected max_age drift, got %+v", res.Drift)
+	}
+	if len(res.Updated) != 1 {
+		t.Errorf("expected stream to be updated, got %+v", res)
+	}
+
+	stream, _ := js.Stream(ctx, "CC_SESSIONS")
+	if got := stream.CachedInfo().Config.MaxAge; got != 48*time.Hour {
+		t.Errorf("max_age after update = %v, want 48h", got)
+	}
+}
+
+func TestStream_DryRun(t *testing.T) {
+	js := testJetStream(t)
+	ctx := context.Background()
+
+	var res Result
+	if err := New(js, true, testLogger()).Stream(ctx, testStreamConfig(), &res); err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	if len(res.Drift) != 1 || res.Drift[0].Field != "exists" {
+		t.Errorf("expected missing stream to be reported, got %+v", res.Drift)
+	}
+	if _, err := js.Stream(ctx, "CC_SESSIONS"); err == nil {
+		t.Error("dry run must not create the stream")
+	}
+}
+
+func TestStream_AdoptsExistingStreamBySubject(t *testing.T) {
+	js := testJetStream(t)
+	ctx := context.Background()
+
+	if _, err := js.CreateStream(ctx, jetstream.StreamConfig{
+		Name:     "SWARM",
+		Subjects: []string{"swarm.cc.session.>"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var res Result
+	if err := New(js, false, testLogger()).Stream(ctx, testStreamConfig(), &res); err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	if len(res.Drift) == 0 || res.Drift[0].Field != "name" || res.Drift[0].Got != "SWARM" {
+		t.Errorf("expected name drift pointing at SWARM, got %+v", res.Drift)
+	}
+	if _, err := js.Stream(ctx, "CC_SESSIONS"); err == nil {
+		t.Error("expected no overlapping stream to be created")
+	}
+}
+
+func TestStream_LeavesAdoptedStreamSettingsAlone(t *testing.T) {
+	js := testJetStream(t)
+	ctx := context.Background()
+
+	// Another team's stream captures everything under swarm.>.
+	theirs := jetstream.StreamConfig{
+		Name:      "SWARM",
+		Subjects:  []string{"swarm.>"},
+		Retention: jetstream.InterestPolicy,
+		MaxAge:    time.Hour,
+	}
+	if _, err := js.CreateStream(ctx, theirs); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := testStreamConfig()
+	cfg.Subjects = append(cfg.Subjects, "swarm.cc.report.>")
+	var res Result
+	if err := New(js, false, testLogger()).Stream(ctx, cfg, &res); err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	if len(res.Updated) != 0 {
+		t.Errorf("adopted stream updated: %+v", res)
+	}
+	stream, err := js.Stream(ctx, "SWARM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := stream.CachedInfo().Config
+	if !slices.Equal(got.Subjects, theirs.Subjects) || got.Retention != theirs.Retention || got.MaxAge != theirs.MaxAge {
+		t.Errorf("adopted stream changed: subjects %v, retention %v, max_age %v", got.Subjects, got.Retention, got.MaxAge)
+	}
+	fields := map[string]bool{}
+	for _, d := range res.Drift {
+		fields[d.Field] = true
+	}
+	if !fields["retention"] || !fields["max_age"] || fields["subjects"] {
+		t.Errorf("drift = %+v", res.Drift)
+	}
+}
+
+func TestStream_AddsMissingSubjectsToAdoptedStream(t *testing.T) {
+	js := testJetStream(t)
+	ctx := context.Background()
+
+	if _, err := js.CreateStream(ctx, jetstream.StreamConfig{
+		Name:     "SWARM",
+		Subjects: []string{"swarm.cc.session.>", "other.>"},
+		MaxAge:   time.Hour,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := testStreamConfig()
+	cfg.Subjects = append(cfg.Subjects, "swarm.cc.report.>")
+	if err := New(js, false, testLogger()).Stream(ctx, cfg, &Result{}); err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	stream, _ := js.Stream(ctx, "SWARM")
+	got := stream.CachedInfo().Config
+	want := []string{"swarm.cc.session.>", "other.>", "swarm.cc.report.>"}
+	if !slices.Equal(got.Subjects, want) || got.MaxAge != time.Hour {
+		t.Errorf("subjects = %v, max_age = %v; want %v and 1h", got.Subjects, got.MaxAge, want)
+	}
+}
+
+func TestCovers(t *testing.T) {
+	tests := []struct {
+		pattern, subject string
+		want             bool
+	}{
+		{"swarm.>", "swarm.cc.session.>", true},
+		{"swarm.cc.session.>", "swarm.cc.session.>", true},
+		{"swarm.cc.session.>", "swarm.cc.report.>", false},
+		{"swarm.*.session.>", "swarm.cc.session.>", true},
+		{"swarm.*", "swarm.>", false},
+		{"swarm.cc", "swarm.cc.session", false},
+		{"swarm.>", "swarm", false},
+	}
+	for _, tt := range tests {
+		if got := covers(tt.pattern, tt.subject); got != tt.want {
+			t.Errorf("covers(%q, %q) = %v, want %v", tt.pattern, tt.subject, got, tt.want)
+		}
+	}
+}
+
+func TestBucket_CreateAndDrift(t *testing.T) {
+	js := testJetStream(t)
+	ctx := context.Background()
+	p := New(js, false, testLogger())
+
+	cfg := BucketConfig{Bucket: "CC_SESSION_REGISTRY", TTL: time.Hour}
+	var res Result
+	if err := p.Bucket(ctx, cfg, &res); err != nil {
+		t.Fatalf("Bucket: %v", err)
+	}
+	if len(res.Created) != 1 {
+		t.Fatalf("expected bucket to be created, got %+v", res)
+	}
+
+	kv, err := js.KeyValue(ctx, "CC_SESSION_REGISTRY")
+	if err != nil {
+		t.Fatalf("bucket not created: %v", err)
+	}
+	status, _ := kv.Status(ctx)
+	if status.TTL() != time.Hour {
+		t.Errorf("TTL = %v, want 1h", status.TTL())
+	}
+
+	cfg.TTL = 2 * time.Hour
+	var drift Result
+	if err := p.Bucket(ctx, cfg, &drift); err != nil {
+		t.Fatalf("Bucket (drift): %v", err)
+	}
+	if len(drift.Drift) != 1 || drift.Drift[0].Field != "ttl" {
+		t.Errorf("expected ttl drift, got %+v", drift.Drift)
+	}
+	status, _ = kv.Status(ctx)
+	if status.TTL() != 2*time.Hour {
+		t.Errorf("TTL after update = %v, want 2h", status.TTL())
+	}
+}
+
+func TestParseRetention(t *testing.T) {
+	for in, want := range map[string]jetstream.RetentionPolicy{
+		"":          jetstream.LimitsPolicy,
+		"limits":    jetstream.LimitsPolicy,
+		"Interest":  jetstream.InterestPolicy,
+		"workqueue": jetstream.WorkQueuePolicy,
+	} {
+		got, err := parseRetention(in)
+		if err != nil || got != want {
+			t.Errorf("parseRetention(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if _, err := parseRetention("forever"); err == nil {
+		t.Error("expected error for unknown retention")
+	}
+}
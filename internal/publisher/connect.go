@@ -0,0 +1,129 @@
+package publisher
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Options configures the NATS connection used by the publisher.
+//
+// At most one authentication method may be set: a token (inline or read from
+// TokenFile), a user/password pair, an NKEY seed file, or a JWT .creds file.
+// TLS settings are independent and may be combined with any of them.
+type Options struct {
+	// URLs lists the seed servers to connect to. Multiple entries allow the
+	// client to fail over between cluster members.
+	URLs []string
+
+	Token        string
+	TokenFile    string
+	User         string
+	Password     string
+	NKeySeedFile string
+	CredsFile    string
+
+	TLS TLSOptions
+}
+
+// TLSOptions configures TLS for the NATS connection. Setting CertFile and
+// KeyFile enables mutual TLS; CAFile pins the server's certificate authority.
+type TLSOptions struct {
+	CertFile string
+	KeyFile  string
+	CAFile   string
+}
+
+func (t TLSOptions) enabled() bool {
+	return t.CertFile != "" || t.KeyFile != "" || t.CAFile != ""
+}
+
+// natsOptions translates Options into nats.Option values.
+func (o Options) natsOptions() ([]nats.Option, error) {
+	opts := []nats.Option{
+		nats.Name("cc-sidecar"),
+		nats.Timeout(5 * time.Second),
+		nats.ReconnectWait(2 * time.Second),
+		nats.MaxReconnects(-1),
+	}
+
+	authMethods := 0
+	for _, set := range []bool{
+		o.Token != "" || o.TokenFile != "",
+		o.User != "" || o.Password != "",
+		o.NKeySeedFile != "",
+		o.CredsFile != "",
+	} {
+		if set {
+			authMethods++
+		}
+	}
+	if authMethods > 1 {
+		return nil, errors.New("only one of token, user/password, nkey or creds may be configured")
+	}
+
+	switch {
+	case o.Token != "" || o.TokenFile != "":
+		token := o.Token
+		if token == "" {
+			raw, err := os.ReadFile(o.TokenFile)
+			if err != nil {
+				return nil, fmt.Errorf("read token file: %w", err)
+			}
+			token = strings.TrimSpace(string(raw))
+			if token == "" {
+				return nil, fmt.Errorf("token file %s is empty", o.TokenFile)
+			}
+		}
+		opts = append(opts, nats.Token(token))
+	case o.User != "" || o.Password != "":
+		if o.User == "" {
+			return nil, errors.New("password configured without user")
+		}
+		opts = append(opts, nats.UserInfo(o.User, o.Password))
+	case o.NKeySeedFile != "":
+		opt, err := nats.NkeyOptionFromSeed(o.NKeySeedFile)
+		if err != nil {
+			return nil, fmt.Errorf("load nkey seed: %w", err)
+		}
+		opts = append(opts, opt)
+	case o.CredsFile != "":
+		if _, err := os.Stat(o.CredsFile); err != nil {
+			return nil, fmt.Errorf("creds file: %w", err)
+		}
+		opts = append(opts, nats.UserCredentials(o.CredsFile))
+	}
+
+	if o.TLS.enabled() {
+		if (o.TLS.CertFile == "") != (o.TLS.KeyFile == "") {
+			return nil, errors.New("tls cert_file and key_file must be set together")
+		}
+		if o.TLS.CertFile != "" {
+			opts = append(opts, nats.ClientCert(o.TLS.CertFile, o.TLS.KeyFile))
+		}
+		if o.TLS.CAFile != "" {
+			opts = append(opts, nats.RootCAs(o.TLS.CAFile))
+		}
+	}
+
+	return opts, nil
+}
+
+// serverList joins the configured URLs into the comma-separated form
+// accepted by nats.Connect.
+func (o Options) serverList() string {
+	urls := make([]string, 0, len(o.URLs))
+	for _, u := range o.URLs {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	if len(urls) == 0 {
+		return nats.DefaultURL
+	}
+	return strings.Join(urls, ",")
+}
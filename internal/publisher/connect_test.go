@@ -0,0 +1,340 @@
+package publisher
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/natstest"
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nkeys"
+)
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+}
+
+func writeFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func mustConnect(t *testing.T, o Options) *Publisher {
+	t.Helper()
+	pub, err := New(o, testLogger())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(pub.Close)
+	if !pub.nc.IsConnected() {
+		t.Fatal("expected publisher to be connected")
+	}
+	return pub
+}
+
+func TestNew_Token(t *testing.T) {
+	opts := natstest.Options(t)
+	opts.Authorization = "s3cret"
+	s := natstest.Run(t, opts)
+
+	mustConnect(t, Options{URLs: []string{s.ClientURL()}, Token: "s3cret"})
+
+	if _, err := New(Options{URLs: []string{s.ClientURL()}, Token: "wrong"}, testLogger()); err == nil {
+		t.Error("expected connect with wrong token to fail")
+	}
+}
+
+func TestNew_TokenFile(t *testing.T) {
+	opts := natstest.Options(t)
+	opts.Authorization = "from-file"
+	s := natstest.Run(t, opts)
+
+	tokenFile := writeFile(t, t.TempDir(), "token", []byte("from-file\n"))
+	mustConnect(t, Options{URLs: []string{s.ClientURL()}, TokenFile: tokenFile})
+}
+
+func TestNew_TokenFileEmpty(t *testing.T) {
+	tokenFile := writeFile(t, t.TempDir(), "token", []byte("  \n"))
+	_, err := New(Options{URLs: []string{"nats://127.0.0.1:1"}, TokenFile: tokenFile}, testLogger())
+	if err == nil || !strings.Contains(err.Error(), "empty") {
+		t.Errorf("expected empty token file error, got %v", err)
+	}
+}
+
+func TestNew_UserPassword(t *testing.T) {
+	opts := natstest.Options(t)
+	opts.Username = "sidecar"
+	opts.Password = "pa55"
+	s := natstest.Run(t, opts)
+
+	mustConnect(t, Options{URLs: []string{s.ClientURL()}, User: "sidecar", Password: "pa55"})
+
+	if _, err := New(Options{URLs: []string{s.ClientURL()}, User: "sidecar", Password: "nope"}, testLogger()); err == nil {
+		t.Error("expected connect with wrong password to fail")
+	}
+}
+
+func TestNew_NKey(t *testing.T) {
+	kp, err := nkeys.CreateUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, _ := kp.PublicKey()
+	seed, _ := kp.Seed()
+
+	opts := natstest.Options(t)
+	opts.Nkeys = []*server.NkeyUser{{Nkey: pubKey}}
+	s := natstest.Run(t, opts)
+
+	seedFile := writeFile(t, t.TempDir(), "user.nk", seed)
+	mustConnect(t, Options{URLs: []string{s.ClientURL()}, NKeySeedFile: seedFile})
+}
+
+func TestNew_CredsFile(t *testing.T) {
+	okp, _ := nkeys.CreateOperator()
+	opub, _ := okp.PublicKey()
+	oc := jwt.NewOperatorClaims(opub)
+	ojwt, err := oc.Encode(okp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	operator, err := jwt.DecodeOperatorClaims(ojwt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	akp, _ := nkeys.CreateAccount()
+	apub, _ := akp.PublicKey()
+	ac := jwt.NewAccountClaims(apub)
+	ac.Limits.JetStreamLimits.DiskStorage = -1
+	ac.Limits.JetStreamLimits.MemoryStorage = -1
+	ajwt, err := ac.Encode(okp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ukp, _ := nkeys.CreateUser()
+	upub, _ := ukp.PublicKey()
+	useed, _ := ukp.Seed()
+	uc := jwt.NewUserClaims(upub)
+	uc.IssuerAccount = apub
+	ujwt, err := uc.Encode(akp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := jwt.FormatUserConfig(ujwt, useed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolver := &server.MemAccResolver{}
+	if err := resolver.Store(apub, ajwt); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := natstest.Options(t)
+	opts.JetStream = false
+	opts.TrustedOperators = []*jwt.OperatorClaims{operator}
+	opts.AccountResolver = resolver
+	s := natstest.Run(t, opts)
+
+	credsFile := writeFile(t, t.TempDir(), "user.creds", creds)
+	mustConnect(t, Options{URLs: []string{s.ClientURL()}, CredsFile: credsFile})
+}
+
+func TestNew_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	caFile := writeFile(t, dir, "ca.pem", ca.certPEM)
+	srvCert, srvKey := ca.issue(t, "server", x509.ExtKeyUsageServerAuth)
+	cliCert, cliKey := ca.issue(t, "client", x509.ExtKeyUsageClientAuth)
+	srvCertFile := writeFile(t, dir, "server.pem", srvCert)
+	srvKeyFile := writeFile(t, dir, "server-key.pem", srvKey)
+	cliCertFile := writeFile(t, dir, "client.pem", cliCert)
+	cliKeyFile := writeFile(t, dir, "client-key.pem", cliKey)
+
+	tlsConfig, err := server.GenTLSConfig(&server.TLSConfigOpts{
+		CertFile: srvCertFile,
+		KeyFile:  srvKeyFile,
+		CaFile:   caFile,
+		Verify:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := natstest.Options(t)
+	opts.TLS = true
+	opts.TLSVerify = true
+	opts.TLSConfig = tlsConfig
+	opts.TLSTimeout = 2
+	s := natstest.Run(t, opts)
+	url := "tls://" + s.Addr().String()
+
+	mustConnect(t, Options{
+		URLs: []string{url},
+		TLS:  TLSOptions{CertFile: cliCertFile, KeyFile: cliKeyFile, CAFile: caFile},
+	})
+
+	// Without a client certificate the server must reject the connection.
+	if _, err := New(Options{URLs: []string{url}, TLS: TLSOptions{CAFile: caFile}}, testLogger()); err == nil {
+		t.Error("expected connect without client certificate to fail")
+	}
+}
+
+func TestNew_ClusterURLs(t *testing.T) {
+	s := natstest.Run(t, nil)
+
+	// The first seed is unreachable; the client must fail over to the second.
+	pub := mustConnect(t, Options{URLs: []string{"nats://127.0.0.1:1", s.ClientURL()}})
+	if !strings.Contains(pub.ConnectedURL(), s.Addr().String()) {
+		t.Errorf("ConnectedURL = %q, want server at %s", pub.ConnectedURL(), s.Addr())
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	first := natstest.Run(t, nil)
+	opts := natstest.Options(t)
+	opts.Authorization = "rotated"
+	second := natstest.Run(t, opts)
+
+	pub := mustConnect(t, Options{URLs: []string{first.ClientURL()}})
+	old := pub.Conn()
+
+	// A failed reconnect keeps the current connection.
+	if err := pub.Reconnect(Options{URLs: []string{second.ClientURL()}, Token: "wrong"}); err == nil {
+		t.Fatal("expected reconnect with wrong token to fail")
+	}
+	if pub.Conn() != old || !old.IsConnected() {
+		t.Fatal("failed reconnect replaced the connection")
+	}
+
+	if err := pub.Reconnect(Options{URLs: []string{second.ClientURL()}, Token: "rotated"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(pub.ConnectedURL(), second.Addr().String()) {
+		t.Errorf("ConnectedURL = %q, want server at %s", pub.ConnectedURL(), second.Addr())
+	}
+	if _, err := pub.JetStream().AccountInfo(context.Background()); err != nil {
+		t.Errorf("JetStream on the new connection: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !old.IsClosed() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !old.IsClosed() {
+		t.Error("old connection was not closed")
+	}
+}
+
+func TestNatsOptions_Validation(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"token and user", Options{Token: "t", User: "u"}, "only one of"},
+		{"nkey and creds", Options{NKeySeedFile: "a", CredsFile: "b"}, "only one of"},
+		{"password without user", Options{Password: "p"}, "without user"},
+		{"cert without key", Options{TLS: TLSOptions{CertFile: "c"}}, "set together"},
+		{"missing creds", Options{CredsFile: "/nonexistent/user.creds"}, "creds file"},
+	}
+
+	for _, tt := range tests {
+		_, err := tt.opts.natsOptions()
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want containing %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestServerList(t *testing.T) {
+	if got := (Options{}).serverList(); got != "nats://127.0.0.1:4222" {
+		t.Errorf("empty serverList = %q, want default URL", got)
+	}
+	got := Options{URLs: []string{"nats://a:4222", " ", "nats://b:4222"}}.serverList()
+	if got != "nats://a:4222,nats://b:4222" {
+		t.Errorf("serverList = %q", got)
+	}
+}
+
+// testCA is a throwaway certificate authority for TLS tests.
+type testCA struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "cc-sidecar test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issue returns a PEM certificate and key signed by the CA.
+func (ca *testCA) issue(t *testing.T, cn string, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
@@ -5,18 +5,49 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"sync"
 	"time"
 
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/archive"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/attribution"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/budget"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/policy"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/pricing"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/redact"
 	"github.com/MikeSquared-Agency/cc-sidecar/internal/registry"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/report"
 	"github.com/MikeSquared-Agency/cc-sidecar/internal/session"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/stall"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/stream"
 	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 )
 
 const (
-	subjectCompleted = "swarm.cc.session.completed"
-	subjectFailed    = "swarm.cc.session.failed"
+	subjectCompleted  = "swarm.cc.session.completed"
+	subjectFailed     = "swarm.cc.session.failed"
+	subjectAttributed = "swarm.cc.session.attributed"
+	subjectCommands   = "swarm.cc.session.commands"
+	subjectViolation  = "swarm.cc.session.policy_violation"
+	subjectStalled    = "swarm.cc.session.stalled"
+	subjectBudget     = "swarm.cc.session.budget"
+	// subjectStream wraps the session ID: swarm.cc.session.{id}.stream.
+	subjectStream = "swarm.cc.session.%s.stream"
+	// subjectReport is followed by the report period, daily or weekly.
+	subjectReport = "swarm.cc.report."
+)
+
+// archiveTimeout bounds a transcript upload, including retries.
+const archiveTimeout = 2 * time.Minute
+
+const (
+	// maxCommands caps the Bash commands listed in one event.
+	maxCommands = 1000
+	// maxCommandsBytes caps the JSON size of the commands listed in one
+	// event. It is lowered to half the server's max payload, leaving room
+	// for the rest of the event.
+	maxCommandsBytes = 256 * 1024
 )
 
 // Event is the standardised Hermes envelope.
@@ -33,95 +64,559 @@ type SessionData struct {
 	SessionID      string   `json:"session_id"`
 	TaskID         string   `json:"task_id,omitempty"`
 	OwnerUUID      string   `json:"owner_uuid,omitempty"`
+	AttributedBy   string   `json:"attributed_by,omitempty"`
 	AgentType      string   `json:"agent_type"`
 	TranscriptPath string   `json:"transcript_path"`
 	FilesChanged   []string `json:"files_changed"`
 	ExitCode       int      `json:"exit_code"`
 	DurationMs     int64    `json:"duration_ms"`
 	WorkingDir     string   `json:"working_dir"`
+	Owner          string   `json:"owner,omitempty"`
 	Timestamp      string   `json:"timestamp"`
+
+	// ParentSessionID is the session a subagent belongs to.
+	ParentSessionID string `json:"parent_session_id,omitempty"`
+	// Transcript locates the uploaded transcript when archiving is enabled.
+	Transcript *archive.Object `json:"transcript,omitempty"`
+	// Metadata records CLI version, git branch, models and permission mode.
+	Metadata *session.Metadata `json:"metadata,omitempty"`
+	// Summary is included when enabled in EventOptions.
+	Summary *session.Summary `json:"summary,omitempty"`
+	// Commands is included when EventOptions.Commands is CommandsInline.
+	Commands []session.Command `json:"commands,omitempty"`
+	// CommandsTruncated is set when later commands were left out to keep
+	// the event within the server's max payload.
+	CommandsTruncated bool `json:"commands_truncated,omitempty"`
+	// PolicyViolations lists the rules the session broke.
+	PolicyViolations []policy.Violation `json:"policy_violations,omitempty"`
+	// Usage totals tokens per model.
+	Usage map[string]session.TokenUsage `json:"usage,omitempty"`
+	// CostUSD estimates the cost of Usage. Models without a price are listed
+	// in UnpricedModels and not counted.
+	CostUSD        float64  `json:"cost_usd,omitempty"`
+	UnpricedModels []string `json:"unpriced_models,omitempty"`
+}
+
+// ViolationData is the payload for cc.session.policy_violation events,
+// published as soon as a violation is detected.
+type ViolationData struct {
+	SessionID      string           `json:"session_id"`
+	TaskID         string           `json:"task_id,omitempty"`
+	OwnerUUID      string           `json:"owner_uuid,omitempty"`
+	TranscriptPath string           `json:"transcript_path"`
+	WorkingDir     string           `json:"working_dir"`
+	PID            int              `json:"pid,omitempty"`
+	Owner          string           `json:"owner,omitempty"`
+	Violation      policy.Violation `json:"violation"`
+	Timestamp      string           `json:"timestamp"`
+}
+
+// CommandsData is the payload for cc.session.commands events, the audit log
+// of Bash commands a session ran.
+type CommandsData struct {
+	SessionID   string            `json:"session_id"`
+	TaskID      string            `json:"task_id,omitempty"`
+	OwnerUUID   string            `json:"owner_uuid,omitempty"`
+	WorkingDir  string            `json:"working_dir"`
+	SourceEvent string            `json:"source_event_id"`
+	Commands    []session.Command `json:"commands"`
+	// Truncated is set when later commands were left out, as in
+	// SessionData.
+	Truncated bool   `json:"commands_truncated,omitempty"`
+	Timestamp string `json:"timestamp"`
+}
+
+// Where the Bash command audit log is published.
+const (
+	CommandsOff    = "off"
+	CommandsInline = "inline" // a commands section in completed/failed events
+	CommandsEvent  = "event"  // a separate cc.session.commands event
+)
+
+// EventOptions selects optional sections of session events.
+type EventOptions struct {
+	// Summary adds the first prompt, final message, turn count and tool
+	// histogram.
+	Summary bool
+	// Commands is CommandsOff, CommandsInline or CommandsEvent.
+	Commands string
+}
+
+// AttributedData is the payload for cc.session.attributed events, published
+// when a registry mapping arrives after the session's completion event went
+// out without one.
+type AttributedData struct {
+	SessionID     string `json:"session_id"`
+	TaskID        string `json:"task_id"`
+	OwnerUUID     string `json:"owner_uuid,omitempty"`
+	OriginalEvent string `json:"original_event_id"`
+	OriginalType  string `json:"original_type"`
+	Timestamp     string `json:"timestamp"`
+}
+
+// StalledData is the payload for cc.session.stalled events.
+type StalledData struct {
+	SessionID      string      `json:"session_id"`
+	TaskID         string      `json:"task_id,omitempty"`
+	OwnerUUID      string      `json:"owner_uuid,omitempty"`
+	TranscriptPath string      `json:"transcript_path"`
+	WorkingDir     string      `json:"working_dir"`
+	PID            int         `json:"pid,omitempty"`
+	Owner          string      `json:"owner,omitempty"`
+	Stall          stall.Stall `json:"stall"`
+	Timestamp      string      `json:"timestamp"`
+}
+
+// BudgetData is the payload for cc.session.budget events, published when a
+// task or owner budget crosses a threshold.
+type BudgetData struct {
+	SessionID      string       `json:"session_id"`
+	TaskID         string       `json:"task_id,omitempty"`
+	OwnerUUID      string       `json:"owner_uuid,omitempty"`
+	TranscriptPath string       `json:"transcript_path"`
+	WorkingDir     string       `json:"working_dir"`
+	PID            int          `json:"pid,omitempty"`
+	Owner          string       `json:"owner,omitempty"`
+	Budget         budget.Alert `json:"budget"`
+	Timestamp      string       `json:"timestamp"`
+}
+
+// ReportData is the payload for cc.report.daily and cc.report.weekly events.
+// Markdown carries the rendered report when Markdown output is configured.
+type ReportData struct {
+	*report.Report
+	Markdown string `json:"markdown,omitempty"`
+}
+
+// OnPublished is called after a session event publish attempt. data is the
+// payload before redaction.
+type OnPublished func(s *session.CompletedSession, data SessionData, err error)
+
+// OnAttributed is called when a registry mapping arrives for a session that
+// was published unattributed within the attribution window.
+type OnAttributed func(sessionID string, mapping registry.TaskMapping)
+
+// unattributed is a published session still waiting for a registry mapping.
+type unattributed struct {
+	eventID     string
+	eventType   string
+	publishedAt time.Time
 }
 
 // Publisher publishes CC session events to NATS.
 type Publisher struct {
-	nc     *nats.Conn
-	js     jetstream.JetStream
 	logger *slog.Logger
+
+	// conn guards what a config reload may replace while events are being
+	// published.
+	conn     sync.RWMutex
+	nc       *nats.Conn
+	js       jetstream.JetStream
+	archiver *archive.Uploader
+	events   EventOptions
+
+	attributionWindow time.Duration
+	redactor          *redact.Redactor
+	policy            *policy.Engine
+	prices            *pricing.Table
+	onPublished       OnPublished
+	onAttributed      OnAttributed
+
+	mu           sync.Mutex
+	unattributed map[string]unattributed
 }
 
 // New creates a publisher and connects to NATS.
-func New(url, token string, logger *slog.Logger) (*Publisher, error) {
-	opts := []nats.Option{
-		nats.Name("cc-sidecar"),
-		nats.Timeout(5 * time.Second),
-		nats.ReconnectWait(2 * time.Second),
-		nats.MaxReconnects(-1),
+func New(o Options, logger *slog.Logger) (*Publisher, error) {
+	nc, js, err := connect(o)
+	if err != nil {
+		return nil, err
 	}
-	if token != "" {
-		opts = append(opts, nats.Token(token))
+	return &Publisher{
+		nc:           nc,
+		js:           js,
+		logger:       logger.With("component", "publisher"),
+		prices:       pricing.Default(),
+		unattributed: make(map[string]unattributed),
+	}, nil
+}
+
+func connect(o Options) (*nats.Conn, jetstream.JetStream, error) {
+	opts, err := o.natsOptions()
+	if err != nil {
+		return nil, nil, fmt.Errorf("nats options: %w", err)
 	}
 
-	nc, err := nats.Connect(url, opts...)
+	nc, err := nats.Connect(o.serverList(), opts...)
 	if err != nil {
-		return nil, fmt.Errorf("nats connect: %w", err)
+		return nil, nil, fmt.Errorf("nats connect: %w", err)
 	}
 
 	js, err := jetstream.New(nc)
 	if err != nil {
 		nc.Close()
-		return nil, fmt.Errorf("jetstream: %w", err)
+		return nil, nil, fmt.Errorf("jetstream: %w", err)
 	}
+	return nc, js, nil
+}
 
-	return &Publisher{
-		nc:     nc,
-		js:     js,
-		logger: logger.With("component", "publisher"),
-	}, nil
+// Reconnect connects with new options and moves publishing to the new
+// connection, then drains the old one. If the new connection fails the
+// publisher keeps the current one. Holders of the old Conn or JetStream
+// must switch to the new ones themselves.
+func (p *Publisher) Reconnect(o Options) error {
+	nc, js, err := connect(o)
+	if err != nil {
+		return err
+	}
+	p.conn.Lock()
+	old := p.nc
+	p.nc, p.js = nc, js
+	p.conn.Unlock()
+
+	if old != nil {
+		_ = old.Drain()
+	}
+	p.logger.Info("reconnected to NATS", "url", nc.ConnectedUrlRedacted())
+	return nil
+}
+
+// SetAttributionWindow configures handling of registry mappings that arrive
+// after a session completes. Sessions published without a mapping are
+// remembered for window, and Attribute publishes a follow-up event if their
+// mapping shows up in that time. Zero disables follow-ups.
+func (p *Publisher) SetAttributionWindow(window time.Duration) {
+	p.attributionWindow = window
+}
+
+// SetArchiver enables uploading transcripts before their completion event is
+// published, so the object exists once consumers see the event. The upload
+// holds up that event only, as long as callers publish each session on its
+// own goroutine. A failed upload is logged and the event goes out without
+// it. It may be called while publishing, e.g. to move to a new connection.
+func (p *Publisher) SetArchiver(u *archive.Uploader) {
+	p.conn.Lock()
+	p.archiver = u
+	p.conn.Unlock()
+}
+
+// SetEventOptions selects the optional sections included in session events.
+// It may be called while publishing.
+func (p *Publisher) SetEventOptions(o EventOptions) {
+	p.conn.Lock()
+	p.events = o
+	p.conn.Unlock()
+}
+
+// SetPolicy attaches the violations recorded by e to completion events.
+func (p *Publisher) SetPolicy(e *policy.Engine) {
+	p.policy = e
+}
+
+// SetPricing replaces the built-in model prices used to estimate session
+// cost.
+func (p *Publisher) SetPricing(t *pricing.Table) {
+	p.prices = t
+}
+
+// SetOnPublished registers a callback invoked after every completed/failed
+// event publish attempt, with the payload and the publish error, if any.
+func (p *Publisher) SetOnPublished(fn OnPublished) {
+	p.onPublished = fn
+}
+
+// SetOnAttributed registers a callback invoked for every late attribution,
+// whether or not the follow-up event could be published.
+func (p *Publisher) SetOnAttributed(fn OnAttributed) {
+	p.onAttributed = fn
+}
+
+// SetRedactor scrubs every event payload before it is published. Events in
+// which something was redacted carry a "redactions" count per detector.
+func (p *Publisher) SetRedactor(r *redact.Redactor) {
+	p.redactor = r
+}
+
+// ConnectedURL returns the URL of the server the publisher is connected to.
+func (p *Publisher) ConnectedURL() string {
+	return p.Conn().ConnectedUrlRedacted()
 }
 
 // JetStream returns the underlying JetStream context for KV access.
 func (p *Publisher) JetStream() jetstream.JetStream {
+	p.conn.RLock()
+	defer p.conn.RUnlock()
 	return p.js
 }
 
+// Conn returns the underlying NATS connection.
+func (p *Publisher) Conn() *nats.Conn {
+	p.conn.RLock()
+	defer p.conn.RUnlock()
+	return p.nc
+}
+
 // PublishCompleted publishes a session completed event.
-func (p *Publisher) PublishCompleted(s *session.CompletedSession, reg *registry.Registry) error {
-	return p.publish(subjectCompleted, "cc.session.completed", s, reg)
+func (p *Publisher) PublishCompleted(s *session.CompletedSession, attr *attribution.Chain) error {
+	return p.publish(subjectCompleted, "cc.session.completed", s, attr)
 }
 
 // PublishFailed publishes a session failed event.
-func (p *Publisher) PublishFailed(s *session.CompletedSession, reg *registry.Registry) error {
-	return p.publish(subjectFailed, "cc.session.failed", s, reg)
+func (p *Publisher) PublishFailed(s *session.CompletedSession, attr *attribution.Chain) error {
+	return p.publish(subjectFailed, "cc.session.failed", s, attr)
+}
+
+// Attribute publishes a cc.session.attributed event if the session was
+// published without a mapping within the attribution window. It is intended
+// as the registry's update callback.
+func (p *Publisher) Attribute(sessionID string, mapping registry.TaskMapping) {
+	p.mu.Lock()
+	p.pruneUnattributed(time.Now())
+	u, ok := p.unattributed[sessionID]
+	delete(p.unattributed, sessionID)
+	p.mu.Unlock()
+
+	if !ok || mapping.TaskID == "" {
+		return
+	}
+	if p.onAttributed != nil {
+		p.onAttributed(sessionID, mapping)
+	}
+
+	data := AttributedData{
+		SessionID:     sessionID,
+		TaskID:        mapping.TaskID,
+		OwnerUUID:     mapping.OwnerUUID,
+		OriginalEvent: u.eventID,
+		OriginalType:  u.eventType,
+		Timestamp:     time.Now().UTC().Format(time.RFC3339),
+	}
+	if err := p.publishEvent(uuid.New().String(), subjectAttributed, "cc.session.attributed", data); err != nil {
+		p.logger.Error("failed to publish session attributed", "error", err, "session_id", sessionID)
+		return
+	}
+	p.logger.Info("published late attribution", "session_id", sessionID, "task_id", mapping.TaskID, "delay", time.Since(u.publishedAt))
+}
+
+// PublishViolation publishes a policy violation for a running session.
+func (p *Publisher) PublishViolation(info session.SessionInfo, v policy.Violation, attr *attribution.Chain) error {
+	data := ViolationData{
+		SessionID:      info.SessionID,
+		TranscriptPath: info.TranscriptPath,
+		WorkingDir:     info.WorkingDir,
+		PID:            info.PID,
+		Owner:          info.Owner,
+		Violation:      v,
+		Timestamp:      time.Now().UTC().Format(time.RFC3339),
+	}
+	if mapping, _ := attr.Resolve(info.Partial()); mapping != nil {
+		data.TaskID = mapping.TaskID
+		data.OwnerUUID = mapping.OwnerUUID
+	}
+	return p.publishEvent(uuid.New().String(), subjectViolation, "cc.session.policy_violation", data)
+}
+
+// PublishStalled publishes a stuck or looping session.
+func (p *Publisher) PublishStalled(info session.SessionInfo, st stall.Stall, attr *attribution.Chain) error {
+	data := StalledData{
+		SessionID:      info.SessionID,
+		TranscriptPath: info.TranscriptPath,
+		WorkingDir:     info.WorkingDir,
+		PID:            info.PID,
+		Owner:          info.Owner,
+		Stall:          st,
+		Timestamp:      time.Now().UTC().Format(time.RFC3339),
+	}
+	if mapping, _ := attr.Resolve(info.Partial()); mapping != nil {
+		data.TaskID = mapping.TaskID
+		data.OwnerUUID = mapping.OwnerUUID
+	}
+	return p.publishEvent(uuid.New().String(), subjectStalled, "cc.session.stalled", data)
+}
+
+// PublishBudget publishes a budget threshold crossed by a running session.
+func (p *Publisher) PublishBudget(info session.SessionInfo, attr budget.Attribution, a budget.Alert) error {
+	data := BudgetData{
+		SessionID:      info.SessionID,
+		TaskID:         attr.TaskID,
+		OwnerUUID:      attr.OwnerUUID,
+		TranscriptPath: info.TranscriptPath,
+		WorkingDir:     info.WorkingDir,
+		PID:            info.PID,
+		Owner:          info.Owner,
+		Budget:         a,
+		Timestamp:      time.Now().UTC().Format(time.RFC3339),
+	}
+	return p.publishEvent(uuid.New().String(), subjectBudget, "cc.session.budget", data)
+}
+
+// PublishStream publishes one live transcript message on
+// swarm.cc.session.{id}.stream. The event ID is derived from the session and
+// the message's place in the transcript, so a message read again after a
+// restart gets the same ID and JetStream drops it if it is still within the
+// stream's duplicate window. Older repeats are published again.
+func (p *Publisher) PublishStream(m stream.Message) error {
+	id := fmt.Sprintf("stream-%s-%d-%d", m.SessionID, m.Offset, m.Index)
+	return p.publishEvent(id, fmt.Sprintf(subjectStream, m.SessionID), "cc.session.stream", m)
+}
+
+// PublishReport publishes a usage report on swarm.cc.report.{period}. The
+// event ID is derived from the period, host and start date so re-running a
+// report within the stream's duplicate window does not publish it twice.
+func (p *Publisher) PublishReport(r *report.Report, markdown string) error {
+	id := fmt.Sprintf("report-%s-%s-%s", r.Period, r.Host, r.Start.Format("2006-01-02"))
+	return p.publishEvent(id, subjectReport+r.Period, "cc.report."+r.Period, ReportData{Report: r, Markdown: markdown})
+}
+
+// pruneUnattributed drops sessions whose attribution window has passed.
+// Caller must hold p.mu.
+func (p *Publisher) pruneUnattributed(now time.Time) {
+	for id, u := range p.unattributed {
+		if now.Sub(u.publishedAt) > p.attributionWindow {
+			delete(p.unattributed, id)
+		}
+	}
 }
 
-func (p *Publisher) publish(subject, eventType string, s *session.CompletedSession, reg *registry.Registry) error {
-	// Look up task mapping.
-	var taskID, ownerUUID string
-	if mapping := reg.Lookup(s.SessionID); mapping != nil {
+func (p *Publisher) publish(subject, eventType string, s *session.CompletedSession, attr *attribution.Chain) error {
+	// Resolve the task this session belongs to.
+	var taskID, ownerUUID, attributedBy string
+	if mapping, by := attr.Resolve(s); mapping != nil {
 		taskID = mapping.TaskID
 		ownerUUID = mapping.OwnerUUID
+		attributedBy = by
 	}
 
 	data := SessionData{
 		SessionID:      s.SessionID,
 		TaskID:         taskID,
 		OwnerUUID:      ownerUUID,
+		AttributedBy:   attributedBy,
 		AgentType:      "claude-code",
 		TranscriptPath: s.TranscriptPath,
 		FilesChanged:   s.FilesChanged,
 		ExitCode:       s.ExitCode,
 		DurationMs:     s.DurationMs,
 		WorkingDir:     s.WorkingDir,
+		Owner:          s.Owner,
 		Timestamp:      time.Now().UTC().Format(time.RFC3339),
+		Metadata:       s.Metadata,
+		Usage:          s.Usage,
+
+		ParentSessionID: s.ParentSessionID,
+	}
+	p.conn.RLock()
+	events, archiver := p.events, p.archiver
+	p.conn.RUnlock()
+
+	data.CostUSD, data.UnpricedModels = p.prices.Total(s.Usage)
+	if events.Summary {
+		data.Summary = s.Summary
+	}
+	if events.Commands == CommandsInline {
+		data.Commands, data.CommandsTruncated = p.capCommands(s.Commands)
+	}
+	if p.policy != nil {
+		data.PolicyViolations = p.policy.Violations(s.SessionID)
+	}
+
+	if archiver != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), archiveTimeout)
+		obj, err := archiver.Upload(ctx, s.SessionID, s.TranscriptPath)
+		cancel()
+		if err != nil {
+			p.logger.Warn("failed to archive transcript", "session_id", s.SessionID, "error", err)
+		}
+		data.Transcript = obj
+	}
+
+	// Remember unattributed sessions before publishing so that a mapping
+	// landing while the publish is in flight is not missed.
+	eventID := uuid.New().String()
+	track := taskID == "" && p.attributionWindow > 0
+	if track {
+		p.mu.Lock()
+		p.pruneUnattributed(time.Now())
+		p.unattributed[s.SessionID] = unattributed{
+			eventID:     eventID,
+			eventType:   eventType,
+			publishedAt: time.Now(),
+		}
+		p.mu.Unlock()
+	}
+
+	if err := p.publishEvent(eventID, subject, eventType, data); err != nil {
+		if track {
+			p.mu.Lock()
+			delete(p.unattributed, s.SessionID)
+			p.mu.Unlock()
+		}
+		if p.onPublished != nil {
+			p.onPublished(s, data, err)
+		}
+		return err
+	}
+	if p.onPublished != nil {
+		p.onPublished(s, data, nil)
+	}
+
+	p.logger.Info("published session event", "subject", subject, "session_id", s.SessionID, "task_id", taskID, "attributed_by", attributedBy, "event_id", eventID)
+
+	if events.Commands == CommandsEvent && len(s.Commands) > 0 {
+		commands := CommandsData{
+			SessionID:   s.SessionID,
+			TaskID:      taskID,
+			OwnerUUID:   ownerUUID,
+			WorkingDir:  s.WorkingDir,
+			SourceEvent: eventID,
+			Timestamp:   data.Timestamp,
+		}
+		commands.Commands, commands.Truncated = p.capCommands(s.Commands)
+		// The session event is out; a lost audit event is logged rather
+		// than failing the publish.
+		if err := p.publishEvent(uuid.New().String(), subjectCommands, "cc.session.commands", commands); err != nil {
+			p.logger.Error("failed to publish session commands", "error", err, "session_id", s.SessionID)
+		}
+	}
+	return nil
+}
+
+// capCommands returns the leading commands that fit within the caps, and
+// whether any were left out.
+func (p *Publisher) capCommands(cmds []session.Command) ([]session.Command, bool) {
+	limit := maxCommandsBytes
+	if nc := p.Conn(); nc != nil {
+		if half := int(nc.MaxPayload() / 2); half > 0 {
+			limit = min(limit, half)
+		}
+	}
+	size := 0
+	for i, c := range cmds {
+		raw, err := json.Marshal(c)
+		if err != nil {
+			return cmds[:i], true
+		}
+		size += len(raw) + 1 // and a comma
+		if i == maxCommands || size > limit {
+			return cmds[:i], true
+		}
 	}
+	return cmds, false
+}
 
+// publishEvent wraps data in the event envelope and publishes it to
+// JetStream. The event ID doubles as the JetStream message ID for dedupe.
+func (p *Publisher) publishEvent(id, subject, eventType string, data any) error {
 	raw, err := json.Marshal(data)
 	if err != nil {
-		return fmt.Errorf("marshal session data: %w", err)
+		return fmt.Errorf("marshal %s data: %w", eventType, err)
+	}
+	if raw, err = p.redactPayload(raw); err != nil {
+		return fmt.Errorf("redact %s data: %w", eventType, err)
 	}
 
 	ev := Event{
-		ID:        uuid.New().String(),
+		ID:        id,
 		Type:      eventType,
 		Source:    "cc-sidecar",
 		Timestamp: time.Now().UTC(),
@@ -136,18 +631,40 @@ func (p *Publisher) publish(subject, eventType string, s *session.CompletedSessi
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	ack, err := p.js.Publish(ctx, subject, evBytes)
+	ack, err := p.JetStream().Publish(ctx, subject, evBytes, jetstream.WithMsgID(ev.ID))
 	if err != nil {
 		return fmt.Errorf("jetstream publish: %w", err)
 	}
 
-	p.logger.Info("published session event", "subject", subject, "session_id", s.SessionID, "task_id", taskID, "stream", ack.Stream, "seq", ack.Sequence)
+	p.logger.Debug("published event", "subject", subject, "type", eventType, "stream", ack.Stream, "seq", ack.Sequence)
 	return nil
 }
 
 // Close drains and closes the NATS connection.
 func (p *Publisher) Close() {
-	if p.nc != nil {
-		_ = p.nc.Drain()
+	if nc := p.Conn(); nc != nil {
+		_ = nc.Drain()
+	}
+}
+
+// redactPayload scrubs an event payload and records what was redacted in
+// its "redactions" field.
+func (p *Publisher) redactPayload(raw []byte) ([]byte, error) {
+	if p.redactor == nil {
+		return raw, nil
+	}
+	rep := redact.Report{}
+	raw, err := p.redactor.JSON(raw, rep)
+	if err != nil || len(rep) == 0 {
+		return raw, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return raw, nil // not an object; nowhere to report
+	}
+	if fields["redactions"], err = json.Marshal(rep); err != nil {
+		return nil, err
 	}
+	return json.Marshal(fields)
 }
@@ -1,11 +1,94 @@
 package publisher
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/archive"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/attribution"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/budget"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/natstest"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/policy"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/redact"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/registry"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/report"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/session"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/stall"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/stream"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 )
 
+// testEnv is a publisher and registry backed by an embedded JetStream server,
+// with a subscription capturing every session event.
+type testEnv struct {
+	pub    *Publisher
+	attr   *attribution.Chain
+	kv     jetstream.KeyValue
+	events *nats.Subscription
+}
+
+func newTestEnv(t *testing.T, mappingWait time.Duration) *testEnv {
+	t.Helper()
+	s := natstest.Run(t, nil)
+	ctx := context.Background()
+
+	pub := mustConnect(t, Options{URLs: []string{s.ClientURL()}})
+	if _, err := pub.js.CreateStream(ctx, jetstream.StreamConfig{
+		Name:     "CC_SESSIONS",
+		Subjects: []string{"swarm.cc.session.>", "swarm.cc.report.>"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	kv, err := pub.js.CreateKeyValue(ctx, jetstream.KeyValueConfig{Bucket: registry.BucketName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := pub.nc.SubscribeSync("swarm.cc.session.>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reg := registry.New(pub.js, testLogger())
+	reg.SetOnUpdate(pub.Attribute)
+	go reg.Start()
+	t.Cleanup(reg.Stop)
+
+	attr := attribution.NewChain(testLogger(), attribution.NewRegistryResolver(reg, mappingWait))
+
+	return &testEnv{pub: pub, attr: attr, kv: kv, events: events}
+}
+
+func (e *testEnv) putMapping(t *testing.T, sessionID, taskID string) {
+	t.Helper()
+	raw, _ := json.Marshal(registry.TaskMapping{TaskID: taskID, OwnerUUID: "owner"})
+	if _, err := e.kv.Put(context.Background(), sessionID, raw); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (e *testEnv) nextEvent(t *testing.T) (Event, map[string]interface{}) {
+	t.Helper()
+	msg, err := e.events.NextMsg(5 * time.Second)
+	if err != nil {
+		t.Fatalf("no event received: %v", err)
+	}
+	var ev Event
+	if err := json.Unmarshal(msg.Data, &ev); err != nil {
+		t.Fatal(err)
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(ev.Data, &data); err != nil {
+		t.Fatal(err)
+	}
+	return ev, data
+}
+
 func TestSessionDataJSON(t *testing.T) {
 	data := SessionData{
 		SessionID:      "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee",
@@ -206,3 +289,475 @@ func TestEventRoundTrip(t *testing.T) {
 		t.Errorf("FilesChanged count = %d, want 2", len(decodedData.FilesChanged))
 	}
 }
+
+func TestPublish_WaitsForLateMapping(t *testing.T) {
+	env := newTestEnv(t, 5*time.Second)
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		env.putMapping(t, "late-sess", "task-late")
+	}()
+
+	if err := env.pub.PublishCompleted(&session.CompletedSession{SessionID: "late-sess"}, env.attr); err != nil {
+		t.Fatalf("PublishCompleted: %v", err)
+	}
+
+	ev, data := env.nextEvent(t)
+	if ev.Type != "cc.session.completed" {
+		t.Errorf("type = %q, want cc.session.completed", ev.Type)
+	}
+	if data["task_id"] != "task-late" {
+		t.Errorf("task_id = %v, want task-late", data["task_id"])
+	}
+	if data["attributed_by"] != "registry" {
+		t.Errorf("attributed_by = %v, want registry", data["attributed_by"])
+	}
+}
+
+func TestPublish_WaitTimesOut(t *testing.T) {
+	env := newTestEnv(t, 100*time.Millisecond)
+
+	start := time.Now()
+	if err := env.pub.PublishCompleted(&session.CompletedSession{SessionID: "adhoc"}, env.attr); err != nil {
+		t.Fatalf("PublishCompleted: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("publish took %v, expected to give up after the wait window", elapsed)
+	}
+
+	_, data := env.nextEvent(t)
+	if _, ok := data["task_id"]; ok {
+		t.Errorf("expected no task_id, got %v", data["task_id"])
+	}
+}
+
+func TestAttribute_PublishesFollowUp(t *testing.T) {
+	env := newTestEnv(t, 0)
+	env.pub.SetAttributionWindow(time.Minute)
+
+	if err := env.pub.PublishFailed(&session.CompletedSession{SessionID: "sess-x", ExitCode: 1}, env.attr); err != nil {
+		t.Fatalf("PublishFailed: %v", err)
+	}
+	original, _ := env.nextEvent(t)
+
+	attributed := make(chan string, 2)
+	env.pub.SetOnAttributed(func(sessionID string, m registry.TaskMapping) {
+		attributed <- sessionID + "=" + m.TaskID
+	})
+	env.putMapping(t, "sess-x", "task-x")
+
+	ev, data := env.nextEvent(t)
+	if ev.Type != "cc.session.attributed" {
+		t.Fatalf("type = %q, want cc.session.attributed", ev.Type)
+	}
+	if data["task_id"] != "task-x" || data["session_id"] != "sess-x" {
+		t.Errorf("unexpected attributed data: %v", data)
+	}
+	if data["original_event_id"] != original.ID || data["original_type"] != "cc.session.failed" {
+		t.Errorf("attributed event does not reference original: %v", data)
+	}
+	if got := <-attributed; got != "sess-x=task-x" {
+		t.Errorf("OnAttributed got %q", got)
+	}
+
+	// A second update for the same session must not publish again.
+	env.putMapping(t, "sess-x", "task-y")
+	if msg, err := env.events.NextMsg(300 * time.Millisecond); err == nil {
+		t.Errorf("unexpected extra event: %s", msg.Data)
+	}
+	if len(attributed) != 0 {
+		t.Errorf("OnAttributed called again: %q", <-attributed)
+	}
+}
+
+func TestAttribute_IgnoresSessionsOutsideWindow(t *testing.T) {
+	env := newTestEnv(t, 0)
+	env.pub.SetAttributionWindow(50 * time.Millisecond)
+
+	if err := env.pub.PublishCompleted(&session.CompletedSession{SessionID: "old"}, env.attr); err != nil {
+		t.Fatal(err)
+	}
+	env.nextEvent(t)
+
+	time.Sleep(100 * time.Millisecond)
+	env.putMapping(t, "old", "task-old")
+
+	if msg, err := env.events.NextMsg(300 * time.Millisecond); err == nil {
+		t.Errorf("unexpected attributed event outside window: %s", msg.Data)
+	}
+}
+
+func TestPublish_ArchivesTranscript(t *testing.T) {
+	env := newTestEnv(t, 0)
+	uploader, err := archive.New(env.pub.js, archive.Config{Compression: archive.CompressionGzip}, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.pub.SetArchiver(uploader)
+
+	path := writeFile(t, t.TempDir(), "sess-a.jsonl", []byte(`{"type":"user"}`+"\n"))
+
+	if err := env.pub.PublishCompleted(&session.CompletedSession{SessionID: "sess-a", TranscriptPath: path}, env.attr); err != nil {
+		t.Fatalf("PublishCompleted: %v", err)
+	}
+	_, data := env.nextEvent(t)
+	transcript, ok := data["transcript"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected transcript in event, got %v", data)
+	}
+	if transcript["name"] != "sess-a.jsonl.gz" || transcript["sha256"] == "" {
+		t.Errorf("unexpected transcript object: %v", transcript)
+	}
+
+	// A missing transcript still publishes the event, without the object.
+	if err := env.pub.PublishCompleted(&session.CompletedSession{SessionID: "sess-b", TranscriptPath: "/nonexistent"}, env.attr); err != nil {
+		t.Fatalf("PublishCompleted: %v", err)
+	}
+	if _, data := env.nextEvent(t); data["transcript"] != nil {
+		t.Errorf("expected no transcript, got %v", data["transcript"])
+	}
+}
+
+func TestPublish_Redacts(t *testing.T) {
+	env := newTestEnv(t, 0)
+	r, err := redact.New(redact.Config{DenyPaths: []string{"**/.env*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.pub.SetRedactor(r)
+
+	s := &session.CompletedSession{SessionID: "sess-r", FilesChanged: []string{"/repo/main.go", "/repo/.env"}}
+	if err := env.pub.PublishCompleted(s, env.attr); err != nil {
+		t.Fatalf("PublishCompleted: %v", err)
+	}
+	_, data := env.nextEvent(t)
+	files := data["files_changed"].([]interface{})
+	if files[0] != "/repo/main.go" || files[1] != "[REDACTED:path]" {
+		t.Errorf("files_changed = %v", files)
+	}
+	if rep := data["redactions"].(map[string]interface{}); rep["path"] != float64(1) {
+		t.Errorf("redactions = %v", rep)
+	}
+
+	// Clean events carry no report.
+	if err := env.pub.PublishCompleted(&session.CompletedSession{SessionID: "sess-c"}, env.attr); err != nil {
+		t.Fatal(err)
+	}
+	if _, data := env.nextEvent(t); data["redactions"] != nil {
+		t.Errorf("unexpected redactions: %v", data["redactions"])
+	}
+}
+
+func TestPublish_SummaryOptional(t *testing.T) {
+	env := newTestEnv(t, 0)
+	s := &session.CompletedSession{
+		SessionID: "sess-s",
+		Summary: &session.Summary{
+			FirstPrompt: "fix it",
+			Turns:       1,
+			Tools:       map[string]session.ToolStats{"Bash": {Calls: 3, Errors: 1}},
+		},
+	}
+
+	if err := env.pub.PublishCompleted(s, env.attr); err != nil {
+		t.Fatal(err)
+	}
+	if _, data := env.nextEvent(t); data["summary"] != nil {
+		t.Errorf("summary included while disabled: %v", data["summary"])
+	}
+
+	env.pub.SetEventOptions(EventOptions{Summary: true})
+	if err := env.pub.PublishCompleted(s, env.attr); err != nil {
+		t.Fatal(err)
+	}
+	_, data := env.nextEvent(t)
+	summary, ok := data["summary"].(map[string]interface{})
+	if !ok || summary["first_prompt"] != "fix it" {
+		t.Fatalf("summary = %v", data["summary"])
+	}
+	bash := summary["tools"].(map[string]interface{})["Bash"].(map[string]interface{})
+	if bash["calls"] != float64(3) || bash["errors"] != float64(1) {
+		t.Errorf("Bash stats = %v", bash)
+	}
+}
+
+func TestPublish_Metadata(t *testing.T) {
+	env := newTestEnv(t, 0)
+	s := &session.CompletedSession{
+		SessionID: "sess-m",
+		Metadata:  &session.Metadata{Version: "2.0.14", Model: "claude-opus-4-1", Models: []string{"claude-opus-4-1"}},
+	}
+	if err := env.pub.PublishCompleted(s, env.attr); err != nil {
+		t.Fatal(err)
+	}
+	_, data := env.nextEvent(t)
+	meta, ok := data["metadata"].(map[string]interface{})
+	if !ok || meta["version"] != "2.0.14" || meta["model"] != "claude-opus-4-1" {
+		t.Errorf("metadata = %v", data["metadata"])
+	}
+}
+
+func TestPublish_UsageAndCost(t *testing.T) {
+	env := newTestEnv(t, 0)
+	s := &session.CompletedSession{
+		SessionID: "sess-u",
+		Usage: map[string]session.TokenUsage{
+			"claude-sonnet-4-5": {InputTokens: 1_000_000, OutputTokens: 100_000},
+			"mystery-model":     {InputTokens: 10},
+		},
+	}
+	if err := env.pub.PublishCompleted(s, env.attr); err != nil {
+		t.Fatal(err)
+	}
+	_, data := env.nextEvent(t)
+	if cost, _ := data["cost_usd"].(float64); math.Abs(cost-4.5) > 1e-9 {
+		t.Errorf("cost_usd = %v, want 4.5", data["cost_usd"])
+	}
+	if unpriced, _ := data["unpriced_models"].([]interface{}); len(unpriced) != 1 || unpriced[0] != "mystery-model" {
+		t.Errorf("unpriced_models = %v", data["unpriced_models"])
+	}
+	if usage, _ := data["usage"].(map[string]interface{}); len(usage) != 2 {
+		t.Errorf("usage = %v", data["usage"])
+	}
+}
+
+func TestPublish_OnPublished(t *testing.T) {
+	env := newTestEnv(t, 0)
+	env.putMapping(t, "sess-op", "task-op")
+
+	var (
+		gotData SessionData
+		gotErr  error
+		calls   int
+	)
+	env.pub.SetOnPublished(func(s *session.CompletedSession, data SessionData, err error) {
+		calls++
+		gotData, gotErr = data, err
+	})
+	if err := env.pub.PublishCompleted(&session.CompletedSession{SessionID: "sess-op"}, env.attr); err != nil {
+		t.Fatal(err)
+	}
+	env.nextEvent(t)
+	if calls != 1 || gotErr != nil || gotData.TaskID != "task-op" || gotData.AttributedBy != "registry" {
+		t.Errorf("callback: calls=%d data=%+v err=%v", calls, gotData, gotErr)
+	}
+}
+
+func TestPublish_Commands(t *testing.T) {
+	env := newTestEnv(t, 0)
+	code := 0
+	s := &session.CompletedSession{
+		SessionID: "sess-cmd",
+		Commands:  []session.Command{{Command: "go test", ExitCode: &code}},
+	}
+
+	env.pub.SetEventOptions(EventOptions{Commands: CommandsInline})
+	if err := env.pub.PublishCompleted(s, env.attr); err != nil {
+		t.Fatal(err)
+	}
+	_, data := env.nextEvent(t)
+	if cmds, ok := data["commands"].([]interface{}); !ok || len(cmds) != 1 {
+		t.Errorf("inline commands = %v", data["commands"])
+	}
+
+	env.pub.SetEventOptions(EventOptions{Commands: CommandsEvent})
+	if err := env.pub.PublishCompleted(s, env.attr); err != nil {
+		t.Fatal(err)
+	}
+	completed, data := env.nextEvent(t)
+	if data["commands"] != nil {
+		t.Errorf("commands inlined in event mode: %v", data["commands"])
+	}
+	ev, data := env.nextEvent(t)
+	if ev.Type != "cc.session.commands" || data["source_event_id"] != completed.ID {
+		t.Errorf("commands event = %+v %v", ev, data)
+	}
+	cmd := data["commands"].([]interface{})[0].(map[string]interface{})
+	if cmd["command"] != "go test" || cmd["exit_code"] != float64(0) {
+		t.Errorf("command = %v", cmd)
+	}
+}
+
+func TestPublish_CommandsTruncated(t *testing.T) {
+	env := newTestEnv(t, 0)
+	// Well past the server's 1MB max payload in total.
+	cmds := make([]session.Command, 600)
+	for i := range cmds {
+		cmds[i] = session.Command{Command: fmt.Sprintf("make %d", i), Output: strings.Repeat("x", 2000)}
+	}
+	s := &session.CompletedSession{SessionID: "sess-many", Commands: cmds}
+
+	for _, mode := range []string{CommandsInline, CommandsEvent} {
+		env.pub.SetEventOptions(EventOptions{Commands: mode})
+		if err := env.pub.PublishCompleted(s, env.attr); err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+		_, data := env.nextEvent(t)
+		if mode == CommandsEvent {
+			_, data = env.nextEvent(t)
+		}
+		got, _ := data["commands"].([]interface{})
+		if len(got) == 0 || len(got) >= len(cmds) || data["commands_truncated"] != true {
+			t.Errorf("%s: %d commands, truncated=%v", mode, len(got), data["commands_truncated"])
+		}
+		if first := got[0].(map[string]interface{}); first["command"] != "make 0" {
+			t.Errorf("%s: first command = %v, want the earliest", mode, first["command"])
+		}
+	}
+}
+
+func TestPublish_PolicyViolations(t *testing.T) {
+	env := newTestEnv(t, 0)
+	engine, err := policy.New([]policy.Rule{{Name: "force-push", Type: policy.TypeCommand, Patterns: []string{`push --force`}}}, nil, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.pub.SetPolicy(engine)
+
+	info := session.SessionInfo{SessionID: "sess-p", WorkingDir: "/repo"}
+	engine.Observe(info, []session.Activity{{Kind: session.ActivityToolUse, Tool: "Bash", Command: "git push --force"}})
+	v := engine.Violations("sess-p")[0]
+
+	if err := env.pub.PublishViolation(info, v, env.attr); err != nil {
+		t.Fatal(err)
+	}
+	ev, data := env.nextEvent(t)
+	if ev.Type != "cc.session.policy_violation" || data["violation"].(map[string]interface{})["rule"] != "force-push" {
+		t.Errorf("violation event = %+v %v", ev, data)
+	}
+
+	if err := env.pub.PublishCompleted(&session.CompletedSession{SessionID: "sess-p"}, env.attr); err != nil {
+		t.Fatal(err)
+	}
+	_, data = env.nextEvent(t)
+	if vs, ok := data["policy_violations"].([]interface{}); !ok || len(vs) != 1 {
+		t.Errorf("policy_violations = %v", data["policy_violations"])
+	}
+}
+
+func TestPublishStalled(t *testing.T) {
+	env := newTestEnv(t, 0)
+	env.putMapping(t, "sess-st", "task-st")
+
+	info := session.SessionInfo{SessionID: "sess-st", TranscriptPath: "/p/sess-st.jsonl", PID: 99}
+	st := stall.Stall{Reason: stall.ReasonRepeatedCall, Tool: "Bash", Count: 5, Detail: "looping"}
+	if err := env.pub.PublishStalled(info, st, env.attr); err != nil {
+		t.Fatal(err)
+	}
+	ev, data := env.nextEvent(t)
+	if ev.Type != "cc.session.stalled" || data["task_id"] != "task-st" || data["pid"] != float64(99) {
+		t.Errorf("stalled event = %+v %v", ev, data)
+	}
+	if evidence := data["stall"].(map[string]interface{}); evidence["reason"] != stall.ReasonRepeatedCall || evidence["count"] != float64(5) {
+		t.Errorf("stall = %v", evidence)
+	}
+}
+
+func TestPublishBudget(t *testing.T) {
+	env := newTestEnv(t, 0)
+
+	info := session.SessionInfo{SessionID: "sess-b", TranscriptPath: "/p/sess-b.jsonl", PID: 42, Owner: "alice"}
+	alert := budget.Alert{Scope: budget.ScopeTask, ID: "task-b", LimitUSD: 10, SpentUSD: 8.5, Threshold: 0.8}
+	if err := env.pub.PublishBudget(info, budget.Attribution{TaskID: "task-b", OwnerUUID: "owner-b"}, alert); err != nil {
+		t.Fatal(err)
+	}
+	ev, data := env.nextEvent(t)
+	if ev.Type != "cc.session.budget" || data["task_id"] != "task-b" || data["owner_uuid"] != "owner-b" || data["pid"] != float64(42) || data["owner"] != "alice" {
+		t.Errorf("budget event = %+v %v", ev, data)
+	}
+	if b := data["budget"].(map[string]interface{}); b["threshold"] != 0.8 || b["spent_usd"] != 8.5 || b["scope"] != "task" {
+		t.Errorf("budget = %v", b)
+	}
+}
+
+func TestPublishStream(t *testing.T) {
+	env := newTestEnv(t, 0)
+	sub, err := env.pub.nc.SubscribeSync("swarm.cc.session.*.stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := stream.Message{SessionID: "sess-s", Seq: 7, Offset: 1234, Index: 1, Role: stream.RoleAssistant, Kind: session.ActivityText, Text: "done"}
+	if err := env.pub.PublishStream(m); err != nil {
+		t.Fatal(err)
+	}
+	// The same transcript entry read again after a restart, numbered anew,
+	// is deduplicated by the stream.
+	m.Seq = 1
+	if err := env.pub.PublishStream(m); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := sub.NextMsg(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Subject != "swarm.cc.session.sess-s.stream" {
+		t.Errorf("subject = %q", msg.Subject)
+	}
+	var ev Event
+	if err := json.Unmarshal(msg.Data, &ev); err != nil {
+		t.Fatal(err)
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(ev.Data, &data); err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != "cc.session.stream" || ev.ID != "stream-sess-s-1234-1" || data["seq"] != float64(7) || data["text"] != "done" || data["role"] != "assistant" {
+		t.Errorf("stream event = %+v %v", ev, data)
+	}
+
+	str, err := env.pub.js.Stream(context.Background(), "CC_SESSIONS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := str.Info(context.Background(), jetstream.WithSubjectFilter("swarm.cc.session.sess-s.stream"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := info.State.Subjects["swarm.cc.session.sess-s.stream"]; n != 1 {
+		t.Errorf("stored %d stream messages, want 1", n)
+	}
+}
+
+func TestPublishReport(t *testing.T) {
+	env := newTestEnv(t, 0)
+	reports, err := env.pub.nc.SubscribeSync("swarm.cc.report.>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	day := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	r := report.Build(report.PeriodDaily, day, day.AddDate(0, 0, 1), nil, 0)
+	r.Host = "build-1"
+	// Publishing the same report twice is deduplicated by the stream.
+	for range 2 {
+		if err := env.pub.PublishReport(r, "# Daily"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	msg, err := reports.NextMsg(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ev Event
+	if err := json.Unmarshal(msg.Data, &ev); err != nil {
+		t.Fatal(err)
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(ev.Data, &data); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Subject != "swarm.cc.report.daily" || ev.Type != "cc.report.daily" || data["period"] != "daily" || data["markdown"] != "# Daily" || data["host"] != "build-1" {
+		t.Errorf("report event = %s %+v %v", msg.Subject, ev, data)
+	}
+
+	info, err := env.pub.js.Stream(context.Background(), "CC_SESSIONS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := info.CachedInfo().State.Msgs; n != 1 {
+		t.Errorf("stream messages = %d, want 1", n)
+	}
+}
@@ -0,0 +1,396 @@
+// Package redact scrubs secrets and personal data from payloads before they
+// leave the machine. Built-in detectors cover common credentials and email
+// addresses; custom patterns and path deny lists can be added.
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/glob"
+)
+
+// PathDetector is the report key for values withheld by the path deny list.
+const PathDetector = "path"
+
+// secretGroup is the optional named group limiting a replacement to part of
+// a match, e.g. the value after "aws_secret_access_key=".
+const secretGroup = "secret"
+
+// builtins are the detectors enabled by default, keyed by name.
+var builtins = map[string]string{
+	"aws_access_key":      `\b(?:AKIA|ASIA|ABIA|ACCA)[0-9A-Z]{16}\b`,
+	"aws_secret_key":      `(?i)aws_secret_access_key["']?\s*[:=]\s*["']?(?P<secret>[A-Za-z0-9/+=]{40})`,
+	"gcp_api_key":         `\bAIza[0-9A-Za-z_\-]{35}`,
+	"gcp_service_account": `"private_key_id"\s*:\s*"(?P<secret>[0-9a-f]{40})"`,
+	"anthropic_key":       `\bsk-ant-[A-Za-z0-9_\-]{20,}`,
+	"github_token":        `\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})`,
+	"private_key":         `-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z0-9 ]*PRIVATE KEY-----`,
+	"jwt":                 `\beyJ[A-Za-z0-9_\-]{8,}\.eyJ[A-Za-z0-9_\-]{8,}\.[A-Za-z0-9_\-]{8,}`,
+	"email":               `\b[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}\b`,
+}
+
+// Builtins returns the names of the built-in detectors.
+func Builtins() []string {
+	return slices.Sorted(maps.Keys(builtins))
+}
+
+// pathKeys are object keys holding a file path. When the path is denied, the
+// other strings in the same object (file contents, edits) are withheld too.
+var pathKeys = []string{"file_path", "filePath", "notebook_path", "path"}
+
+// idKeys are object keys naming a tool call. A call whose input names a
+// denied path is remembered, and objects referring to it by tool_use_id,
+// such as its tool_result, are withheld as well.
+var idKeys = []string{"id", "tool_use_id"}
+
+// keepKeys are structural keys left in place when an object is withheld.
+var keepKeys = map[string]bool{"type": true, "kind": true, "tool": true, "id": true, "tool_use_id": true}
+
+// Pattern is a custom detector.
+type Pattern struct {
+	Name  string
+	Regex string
+}
+
+// Config selects detectors and path rules.
+type Config struct {
+	// Detectors lists built-in detectors by name. Nil enables all of them.
+	Detectors []string
+	Patterns  []Pattern
+	// DenyPaths are globs of paths whose mention, and whose content in tool
+	// inputs and results, is withheld. "**" matches across directories and
+	// relative globs match at any depth.
+	DenyPaths []string
+	// AllowPaths are globs exempted from DenyPaths.
+	AllowPaths []string
+}
+
+// Report counts redactions by detector name.
+type Report map[string]int
+
+// Add merges other into r.
+func (r Report) Add(other Report) {
+	for k, v := range other {
+		r[k] += v
+	}
+}
+
+type detector struct {
+	name string
+	re   *regexp.Regexp
+	// group is the index of the secret group, or 0 to replace the whole match.
+	group int
+}
+
+// Redactor applies detectors and path rules. A nil Redactor, or one
+// without rules, passes everything through unchanged. It is safe for
+// concurrent use, including with Update.
+type Redactor struct {
+	rules atomic.Pointer[rules]
+}
+
+// rules is one compiled Config.
+type rules struct {
+	detectors []detector
+	deny      []*glob.Pattern
+	allow     []*glob.Pattern
+}
+
+// New compiles a redactor.
+func New(cfg Config) (*Redactor, error) {
+	names := cfg.Detectors
+	if names == nil {
+		names = Builtins()
+	}
+
+	r := &rules{}
+	for _, name := range names {
+		expr, ok := builtins[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown detector %q", name)
+		}
+		r.detectors = append(r.detectors, compileDetector(name, regexp.MustCompile(expr)))
+	}
+	for _, p := range cfg.Patterns {
+		if p.Name == "" {
+			return nil, fmt.Errorf("pattern %q: name is required", p.Regex)
+		}
+		re, err := regexp.Compile(p.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %s: %w", p.Name, err)
+		}
+		r.detectors = append(r.detectors, compileDetector(p.Name, re))
+	}
+	for _, g := range cfg.DenyPaths {
+		p, err := glob.Compile(g)
+		if err != nil {
+			return nil, fmt.Errorf("deny path %q: %w", g, err)
+		}
+		r.deny = append(r.deny, p)
+	}
+	for _, g := range cfg.AllowPaths {
+		p, err := glob.Compile(g)
+		if err != nil {
+			return nil, fmt.Errorf("allow path %q: %w", g, err)
+		}
+		r.allow = append(r.allow, p)
+	}
+	red := &Redactor{}
+	red.rules.Store(r)
+	return red, nil
+}
+
+// Update replaces the rules of r with those of next, or removes them when
+// next is nil. Calls already running finish with the old rules.
+func (r *Redactor) Update(next *Redactor) {
+	if next == nil {
+		r.rules.Store(nil)
+		return
+	}
+	r.rules.Store(next.rules.Load())
+}
+
+// load returns the current rules, or nil when there are none.
+func (r *Redactor) load() *rules {
+	if r == nil {
+		return nil
+	}
+	return r.rules.Load()
+}
+
+func compileDetector(name string, re *regexp.Regexp) detector {
+	d := detector{name: name, re: re}
+	if i := re.SubexpIndex(secretGroup); i > 0 {
+		d.group = i
+	}
+	return d
+}
+
+// Placeholder is what a value found by the named detector is replaced with.
+func Placeholder(name string) string {
+	return "[REDACTED:" + name + "]"
+}
+
+// String redacts s, counting each replacement in rep.
+func (r *Redactor) String(s string, rep Report) string {
+	rs := r.load()
+	if rs == nil {
+		return s
+	}
+	return rs.string(s, rep)
+}
+
+func (r *rules) string(s string, rep Report) string {
+	if r.denied(s) {
+		rep[PathDetector]++
+		return Placeholder(PathDetector)
+	}
+	for _, d := range r.detectors {
+		s = d.redact(s, rep)
+	}
+	return s
+}
+
+func (d detector) redact(s string, rep Report) string {
+	matches := d.re.FindAllStringSubmatchIndex(s, -1)
+	if len(matches) == 0 {
+		return s
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		if d.group > 0 {
+			start, end = m[2*d.group], m[2*d.group+1]
+			if start < 0 {
+				continue
+			}
+		}
+		b.WriteString(s[last:start])
+		b.WriteString(Placeholder(d.name))
+		last = end
+		rep[d.name]++
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// Denied reports whether path is matched by the deny list and not the allow
+// list.
+func (r *Redactor) Denied(path string) bool {
+	rs := r.load()
+	return rs != nil && rs.denied(path)
+}
+
+// denied reports whether s is a path matched by the deny list and not the
+// allow list.
+func (r *rules) denied(s string) bool {
+	if len(r.deny) == 0 || !strings.Contains(s, "/") || strings.ContainsAny(s, "\n") {
+		return false
+	}
+	for _, p := range r.allow {
+		if p.Match(s) {
+			return false
+		}
+	}
+	for _, p := range r.deny {
+		if p.Match(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// JSON redacts every string value in a JSON document. Object keys are kept.
+// The document is returned byte-for-byte unchanged when nothing is redacted.
+func (r *Redactor) JSON(raw []byte, rep Report) ([]byte, error) {
+	return r.json(raw, rep, make(map[string]bool))
+}
+
+// json redacts a JSON document, adding the tool calls on denied paths it
+// finds to denied first, so that their results are withheld wherever they
+// appear in the document.
+func (r *Redactor) json(raw []byte, rep Report, denied map[string]bool) ([]byte, error) {
+	rs := r.load()
+	if rs == nil {
+		return raw, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	rs.collect(v, denied)
+	local := Report{}
+	v = rs.value(v, local, denied)
+	if len(local) == 0 {
+		return raw, nil
+	}
+	rep.Add(local)
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte{'\n'}), nil
+}
+
+// Line redacts one line of a JSONL transcript, falling back to plain text
+// redaction for lines that are not valid JSON.
+func (r *Redactor) Line(line []byte, rep Report) []byte {
+	return r.line(line, rep, make(map[string]bool))
+}
+
+func (r *Redactor) line(line []byte, rep Report, denied map[string]bool) []byte {
+	if r.load() == nil {
+		return line
+	}
+	if out, err := r.json(line, rep, denied); err == nil {
+		return out
+	}
+	return []byte(r.String(string(line), rep))
+}
+
+// Transcript redacts the lines of one transcript in order. Tool calls on
+// denied paths are remembered, so their results, which arrive on later
+// lines and name the call only by its ID, are withheld too.
+type Transcript struct {
+	r      *Redactor
+	denied map[string]bool
+}
+
+// Transcript starts redacting a transcript.
+func (r *Redactor) Transcript() *Transcript {
+	return &Transcript{r: r, denied: make(map[string]bool)}
+}
+
+// Line redacts the next line of the transcript, like Redactor.Line.
+func (t *Transcript) Line(line []byte, rep Report) []byte {
+	return t.r.line(line, rep, t.denied)
+}
+
+// collect adds the IDs of tool calls on denied paths in v to denied.
+func (r *rules) collect(v any, denied map[string]bool) {
+	switch t := v.(type) {
+	case []any:
+		for _, e := range t {
+			r.collect(e, denied)
+		}
+	case map[string]any:
+		input, _ := t["input"].(map[string]any)
+		if r.deniedObject(t) || input != nil && r.deniedObject(input) {
+			for _, k := range idKeys {
+				if id, ok := t[k].(string); ok && id != "" {
+					denied[id] = true
+				}
+			}
+		}
+		for _, e := range t {
+			r.collect(e, denied)
+		}
+	}
+}
+
+func (r *rules) value(v any, rep Report, denied map[string]bool) any {
+	switch t := v.(type) {
+	case string:
+		return r.string(t, rep)
+	case []any:
+		for i := range t {
+			t[i] = r.value(t[i], rep, denied)
+		}
+	case map[string]any:
+		if id, _ := t["tool_use_id"].(string); r.deniedObject(t) || denied[id] {
+			return withhold(t, rep)
+		}
+		for k := range t {
+			t[k] = r.value(t[k], rep, denied)
+		}
+	}
+	return v
+}
+
+// withhold replaces every string in v, except structural keys of objects,
+// with the path placeholder.
+func withhold(v any, rep Report) any {
+	switch t := v.(type) {
+	case string:
+		if t != "" {
+			rep[PathDetector]++
+		}
+		return Placeholder(PathDetector)
+	case []any:
+		for i := range t {
+			t[i] = withhold(t[i], rep)
+		}
+	case map[string]any:
+		for k := range t {
+			if !keepKeys[k] {
+				t[k] = withhold(t[k], rep)
+			}
+		}
+	}
+	return v
+}
+
+// deniedObject reports whether an object describes a denied file, e.g. a
+// Write tool input or a Read tool result.
+func (r *rules) deniedObject(m map[string]any) bool {
+	for _, k := range pathKeys {
+		if s, ok := m[k].(string); ok && r.denied(s) {
+			return true
+		}
+	}
+	return false
+}
@@ -4,12 +4,28 @@ import (
 	"context"
 	"encoding/json"
 	"log/slog"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nats-io/nats.go/jetstream"
 )
 
-const bucketName = "CC_SESSION_REGISTRY"
+// BucketName is the KV bucket holding session→task mappings.
+const BucketName = "CC_SESSION_REGISTRY"
+
+const (
+	// watchRetryInterval is how long to wait before re-establishing the KV
+	// watch after the bucket was unavailable or the watch ended.
+	watchRetryInterval = 5 * time.Second
+
+	// statsLogInterval is how often cache statistics are logged.
+	statsLogInterval = 5 * time.Minute
+
+	// waitPollInterval is how often WaitFor falls back to a direct lookup
+	// while the watch is not synced.
+	waitPollInterval = time.Second
+)
 
 // TaskMapping holds the task_id and owner_uuid for a CC session.
 type TaskMapping struct {
@@ -17,27 +33,343 @@ type TaskMapping struct {
 	OwnerUUID string `json:"owner_uuid"`
 }
 
+// OnUpdate is called when a mapping is created or changed in the bucket.
+type OnUpdate func(sessionID string, mapping TaskMapping)
+
+// updateQueue bounds the updates waiting for the OnUpdate callback. The
+// watch only waits for the callback once this many are queued.
+const updateQueue = 1024
+
+type update struct {
+	sessionID string
+	mapping   TaskMapping
+}
+
+// Stats reports registry cache counters.
+type Stats struct {
+	Entries   int    `json:"entries"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Fallbacks uint64 `json:"fallbacks"`
+	Synced    bool   `json:"synced"`
+}
+
 // Registry looks up task→session mappings from a NATS KV bucket.
+//
+// Once Start is running, mappings are served from an in-memory cache kept
+// current by a watch on the whole bucket, so lookups never block on the
+// network and keep working through brief NATS outages. Each time the watch
+// (re)starts the cache is rebuilt from the bucket, and until the watch has
+// delivered the initial bucket contents, lookups fall back to a direct KV get.
 type Registry struct {
-	js     jetstream.JetStream
 	logger *slog.Logger
+
+	mu        sync.RWMutex
+	js        jetstream.JetStream
+	stopWatch context.CancelFunc // ends the running watch
+	cache     map[string]TaskMapping
+	synced    bool
+	onUpdate  OnUpdate
+	waiters   map[string][]chan TaskMapping
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	fallbacks atomic.Uint64
+
+	updates chan update // to the OnUpdate callback
+	done    chan struct{}
+	rewatch chan struct{}
 }
 
 // New creates a new registry client.
 func New(js jetstream.JetStream, logger *slog.Logger) *Registry {
 	return &Registry{
-		js:     js,
-		logger: logger.With("component", "registry"),
+		js:      js,
+		logger:  logger.With("component", "registry"),
+		cache:   make(map[string]TaskMapping),
+		waiters: make(map[string][]chan TaskMapping),
+		updates: make(chan update, updateQueue),
+		done:    make(chan struct{}),
+		rewatch: make(chan struct{}, 1),
+	}
+}
+
+// SetJetStream moves the registry to a new connection, e.g. after the
+// publisher reconnects. The watch restarts on js, rebuilding the cache, and
+// lookups fall back to direct gets until it has synced again.
+func (r *Registry) SetJetStream(js jetstream.JetStream) {
+	r.mu.Lock()
+	r.js = js
+	r.synced = false
+	stop := r.stopWatch
+	r.mu.Unlock()
+
+	if stop != nil {
+		stop()
+	}
+	select {
+	case r.rewatch <- struct{}{}:
+	default:
+	}
+}
+
+// jetStream returns the current connection.
+func (r *Registry) jetStream() jetstream.JetStream {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.js
+}
+
+// SetOnUpdate registers a callback invoked for every mapping received from
+// the watch after the initial sync. It runs on its own goroutine, in the
+// order the mappings arrived, so a slow callback does not hold up the cache.
+// It must be called before Start.
+func (r *Registry) SetOnUpdate(fn OnUpdate) {
+	r.mu.Lock()
+	r.onUpdate = fn
+	r.mu.Unlock()
+}
+
+// Start watches the bucket and keeps the cache current. Blocks until Stop.
+func (r *Registry) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-r.done
+		cancel()
+	}()
+
+	go r.notify()
+
+	statsTicker := time.NewTicker(statsLogInterval)
+	defer statsTicker.Stop()
+
+	for {
+		r.watch(ctx, statsTicker.C)
+
+		select {
+		case <-r.done:
+			return
+		case <-r.rewatch:
+		case <-time.After(watchRetryInterval):
+		}
+	}
+}
+
+// Stop halts the watch. Cached mappings remain available to Lookup.
+func (r *Registry) Stop() {
+	close(r.done)
+}
+
+// notify passes queued updates to the OnUpdate callback until Stop.
+func (r *Registry) notify() {
+	for {
+		select {
+		case u := <-r.updates:
+			r.mu.RLock()
+			onUpdate := r.onUpdate
+			r.mu.RUnlock()
+			onUpdate(u.sessionID, u.mapping)
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// watch runs a single WatchAll subscription until it ends or ctx is done.
+func (r *Registry) watch(ctx context.Context, statsC <-chan time.Time) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	r.mu.Lock()
+	js := r.js
+	r.stopWatch = cancel
+	r.mu.Unlock()
+
+	kv, err := js.KeyValue(ctx, BucketName)
+	if err != nil {
+		r.logger.Debug("KV bucket not available, will retry", "error", err)
+		return
+	}
+
+	w, err := kv.WatchAll(ctx)
+	if err != nil {
+		r.logger.Warn("failed to watch registry bucket, will retry", "error", err)
+		return
+	}
+	defer func() { _ = w.Stop() }()
+	// Once the watch ends the cache is no longer authoritative.
+	defer r.setSynced(false)
+
+	// Keys deleted while no watch was running would otherwise be served
+	// forever, so start over from the initial values.
+	r.mu.Lock()
+	r.cache = make(map[string]TaskMapping)
+	r.synced = false
+	r.mu.Unlock()
+
+	for {
+		select {
+		case entry, ok := <-w.Updates():
+			if !ok {
+				return
+			}
+			if entry == nil {
+				// A nil entry marks the end of the initial values.
+				r.setSynced(true)
+				r.logger.Info("registry cache synced", "entries", r.Stats().Entries)
+				continue
+			}
+			r.apply(entry)
+		case <-statsC:
+			s := r.Stats()
+			r.logger.Info("registry cache stats", "entries", s.Entries, "hits", s.Hits, "misses", s.Misses, "fallbacks", s.Fallbacks, "synced", s.Synced)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *Registry) apply(entry jetstream.KeyValueEntry) {
+	sessionID := entry.Key()
+
+	if entry.Operation() != jetstream.KeyValuePut {
+		r.mu.Lock()
+		delete(r.cache, sessionID)
+		r.mu.Unlock()
+		return
+	}
+
+	var mapping TaskMapping
+	if err := json.Unmarshal(entry.Value(), &mapping); err != nil {
+		r.logger.Warn("failed to unmarshal task mapping", "session_id", sessionID, "error", err)
+		return
+	}
+
+	r.mu.Lock()
+	r.cache[sessionID] = mapping
+	synced := r.synced
+	onUpdate := r.onUpdate
+	waiters := r.waiters[sessionID]
+	delete(r.waiters, sessionID)
+	r.mu.Unlock()
+
+	for _, ch := range waiters {
+		ch <- mapping
+	}
+
+	if synced && onUpdate != nil {
+		r.logger.Debug("registry mapping updated", "session_id", sessionID, "task_id", mapping.TaskID)
+		select {
+		case r.updates <- update{sessionID, mapping}:
+		case <-r.done:
+		}
+	}
+}
+
+func (r *Registry) setSynced(synced bool) {
+	r.mu.Lock()
+	r.synced = synced
+	r.mu.Unlock()
+}
+
+// Stats returns a snapshot of the cache counters.
+func (r *Registry) Stats() Stats {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return Stats{
+		Entries:   len(r.cache),
+		Hits:      r.hits.Load(),
+		Misses:    r.misses.Load(),
+		Fallbacks: r.fallbacks.Load(),
+		Synced:    r.synced,
 	}
 }
 
 // Lookup retrieves the task mapping for a session ID.
 // Returns nil if no mapping exists.
 func (r *Registry) Lookup(sessionID string) *TaskMapping {
+	r.mu.RLock()
+	mapping, ok := r.cache[sessionID]
+	synced := r.synced
+	r.mu.RUnlock()
+
+	if ok {
+		r.hits.Add(1)
+		return &mapping
+	}
+	if synced {
+		// The watch is live, so the cache is authoritative.
+		r.misses.Add(1)
+		return nil
+	}
+
+	r.fallbacks.Add(1)
+	m := r.get(sessionID)
+	if m != nil {
+		r.mu.Lock()
+		r.cache[sessionID] = *m
+		r.mu.Unlock()
+	}
+	return m
+}
+
+// WaitFor returns the mapping for a session, waiting until it appears in the
+// bucket or ctx is done. Returns nil if no mapping arrived in time.
+func (r *Registry) WaitFor(ctx context.Context, sessionID string) *TaskMapping {
+	if m := r.Lookup(sessionID); m != nil {
+		return m
+	}
+
+	ch := make(chan TaskMapping, 1)
+	r.mu.Lock()
+	r.waiters[sessionID] = append(r.waiters[sessionID], ch)
+	r.mu.Unlock()
+	defer r.removeWaiter(sessionID, ch)
+
+	// Without a live watch nothing will signal the waiter, so poll as well.
+	poll := time.NewTicker(waitPollInterval)
+	defer poll.Stop()
+
+	for {
+		select {
+		case m := <-ch:
+			return &m
+		case <-poll.C:
+			r.mu.RLock()
+			synced := r.synced
+			r.mu.RUnlock()
+			if !synced {
+				if m := r.Lookup(sessionID); m != nil {
+					return m
+				}
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (r *Registry) removeWaiter(sessionID string, ch chan TaskMapping) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	waiters := r.waiters[sessionID]
+	for i, w := range waiters {
+		if w == ch {
+			r.waiters[sessionID] = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(r.waiters[sessionID]) == 0 {
+		delete(r.waiters, sessionID)
+	}
+}
+
+// get reads a single mapping directly from the bucket.
+func (r *Registry) get(sessionID string) *TaskMapping {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	kv, err := r.js.KeyValue(ctx, bucketName)
+	kv, err := r.jetStream().KeyValue(ctx, BucketName)
 	if err != nil {
 		r.logger.Debug("KV bucket not available", "error", err)
 		return nil
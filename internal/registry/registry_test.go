@@ -1,10 +1,63 @@
 package registry
 
 import (
+	"context"
 	"encoding/json"
+	"log/slog"
+	"os"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/natstest"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 )
 
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+}
+
+// testBucket starts an embedded server and creates the registry bucket.
+func testBucket(t *testing.T) (jetstream.JetStream, jetstream.KeyValue) {
+	t.Helper()
+	s := natstest.Run(t, nil)
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv, err := js.CreateKeyValue(context.Background(), jetstream.KeyValueConfig{Bucket: BucketName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return js, kv
+}
+
+func putMapping(t *testing.T, kv jetstream.KeyValue, sessionID, taskID string) {
+	t.Helper()
+	raw, _ := json.Marshal(TaskMapping{TaskID: taskID, OwnerUUID: "owner"})
+	if _, err := kv.Put(context.Background(), sessionID, raw); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitFor polls cond until it returns true or the deadline passes.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before deadline")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
 func TestTaskMappingJSON(t *testing.T) {
 	mapping := TaskMapping{
 		TaskID:    "task-abc-123",
@@ -73,7 +126,182 @@ func TestTaskMappingUnmarshalFromKV(t *testing.T) {
 
 func TestBucketName(t *testing.T) {
 	// Verify the bucket name constant hasn't been accidentally changed.
-	if bucketName != "CC_SESSION_REGISTRY" {
-		t.Errorf("bucketName = %q, want CC_SESSION_REGISTRY", bucketName)
+	if BucketName != "CC_SESSION_REGISTRY" {
+		t.Errorf("BucketName = %q, want CC_SESSION_REGISTRY", BucketName)
+	}
+}
+
+func TestLookup_FallbackBeforeSync(t *testing.T) {
+	js, kv := testBucket(t)
+	putMapping(t, kv, "sess-1", "task-1")
+
+	reg := New(js, testLogger())
+	m := reg.Lookup("sess-1")
+	if m == nil || m.TaskID != "task-1" {
+		t.Fatalf("Lookup = %+v, want task-1", m)
+	}
+	if reg.Lookup("missing") != nil {
+		t.Error("expected nil for missing session")
+	}
+
+	stats := reg.Stats()
+	if stats.Fallbacks != 2 || stats.Synced {
+		t.Errorf("stats = %+v, want 2 fallbacks and not synced", stats)
+	}
+
+	// The fallback result is cached for subsequent lookups.
+	reg.Lookup("sess-1")
+	if reg.Stats().Hits != 1 {
+		t.Errorf("expected cached fallback result to count as a hit, stats = %+v", reg.Stats())
+	}
+}
+
+func TestLookup_ServedFromWatchCache(t *testing.T) {
+	js, kv := testBucket(t)
+	putMapping(t, kv, "sess-1", "task-1")
+
+	reg := New(js, testLogger())
+	go reg.Start()
+	defer reg.Stop()
+
+	waitFor(t, func() bool { return reg.Stats().Synced })
+
+	if m := reg.Lookup("sess-1"); m == nil || m.TaskID != "task-1" {
+		t.Fatalf("Lookup = %+v, want task-1", m)
+	}
+	if reg.Lookup("missing") != nil {
+		t.Error("expected nil for missing session")
+	}
+
+	stats := reg.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Fallbacks != 0 {
+		t.Errorf("stats = %+v, want 1 hit, 1 miss, 0 fallbacks", stats)
+	}
+}
+
+func TestWatch_LateMappingAndDelete(t *testing.T) {
+	js, kv := testBucket(t)
+
+	var mu sync.Mutex
+	var updates []string
+
+	reg := New(js, testLogger())
+	reg.SetOnUpdate(func(sessionID string, m TaskMapping) {
+		mu.Lock()
+		updates = append(updates, sessionID+"="+m.TaskID)
+		mu.Unlock()
+	})
+	go reg.Start()
+	defer reg.Stop()
+
+	waitFor(t, func() bool { return reg.Stats().Synced })
+
+	putMapping(t, kv, "late-session", "task-late")
+	waitFor(t, func() bool { return reg.Lookup("late-session") != nil })
+
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(updates) > 0
+	})
+	mu.Lock()
+	got := append([]string(nil), updates...)
+	mu.Unlock()
+	if len(got) != 1 || got[0] != "late-session=task-late" {
+		t.Errorf("updates = %v, want [late-session=task-late]", got)
+	}
+
+	if err := kv.Delete(context.Background(), "late-session"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return reg.Lookup("late-session") == nil })
+}
+
+func TestWatch_SlowOnUpdateDoesNotBlockCache(t *testing.T) {
+	js, kv := testBucket(t)
+
+	release := make(chan struct{})
+	defer close(release)
+	reg := New(js, testLogger())
+	reg.SetOnUpdate(func(string, TaskMapping) { <-release })
+	go reg.Start()
+	defer reg.Stop()
+
+	waitFor(t, func() bool { return reg.Stats().Synced })
+
+	putMapping(t, kv, "sess-1", "task-1")
+	putMapping(t, kv, "sess-2", "task-2")
+	waitFor(t, func() bool { return reg.Lookup("sess-2") != nil })
+}
+
+func TestLookup_CacheSurvivesStop(t *testing.T) {
+	js, kv := testBucket(t)
+	putMapping(t, kv, "sess-1", "task-1")
+
+	reg := New(js, testLogger())
+	go reg.Start()
+	waitFor(t, func() bool { return reg.Stats().Synced })
+	reg.Stop()
+
+	if m := reg.Lookup("sess-1"); m == nil || m.TaskID != "task-1" {
+		t.Errorf("Lookup after Stop = %+v, want cached task-1", m)
+	}
+}
+
+func TestSetJetStream_RewatchesNewConnection(t *testing.T) {
+	js, kv := testBucket(t)
+	putMapping(t, kv, "sess-1", "task-1")
+
+	reg := New(js, testLogger())
+	go reg.Start()
+	defer reg.Stop()
+	waitFor(t, func() bool { return reg.Lookup("sess-1") != nil })
+
+	js2, kv2 := testBucket(t)
+	reg.SetJetStream(js2)
+	putMapping(t, kv2, "sess-2", "task-2")
+
+	// The watch moves without waiting out the retry interval.
+	start := time.Now()
+	waitFor(t, func() bool { return reg.Stats().Synced && reg.Lookup("sess-2") != nil })
+	if d := time.Since(start); d >= watchRetryInterval {
+		t.Errorf("rewatch took %v", d)
+	}
+	// The cache is rebuilt from the new bucket, which never had sess-1.
+	if m := reg.Lookup("sess-1"); m != nil {
+		t.Errorf("Lookup of a mapping cached before the move = %+v, want nil", m)
+	}
+}
+
+func TestWatch_RestartDropsKeysDeletedMeanwhile(t *testing.T) {
+	js, kv := testBucket(t)
+	putMapping(t, kv, "sess-1", "task-1")
+	putMapping(t, kv, "sess-2", "task-2")
+
+	reg := New(js, testLogger())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		reg.watch(ctx, nil)
+		close(done)
+	}()
+	waitFor(t, func() bool { return reg.Stats().Synced })
+	cancel()
+	<-done
+
+	// Deleted while no watch is running.
+	if err := kv.Delete(context.Background(), "sess-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	go reg.watch(ctx, nil)
+	waitFor(t, func() bool { return reg.Stats().Synced })
+	if m := reg.Lookup("sess-1"); m != nil {
+		t.Errorf("Lookup of a key deleted while the watch was down = %+v, want nil", m)
+	}
+	if m := reg.Lookup("sess-2"); m == nil || m.TaskID != "task-2" {
+		t.Errorf("Lookup(sess-2) = %+v, want task-2", m)
 	}
 }
@@ -0,0 +1,327 @@
+// Package report aggregates session history into daily and weekly usage
+// reports per project and per owner.
+package report
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/history"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/session"
+)
+
+// Report periods.
+const (
+	PeriodDaily  = "daily"
+	PeriodWeekly = "weekly"
+)
+
+// Output formats.
+const (
+	FormatMarkdown = "markdown"
+	FormatJSON     = "json"
+)
+
+// DefaultTop is how many files and tools are listed per group.
+const DefaultTop = 10
+
+// unattributed groups sessions without an owner.
+const unattributed = "(unattributed)"
+
+// Report summarises the sessions completed in [Start, End).
+type Report struct {
+	Period      string    `json:"period"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Host        string    `json:"host,omitempty"`
+	GeneratedAt time.Time `json:"generated_at"`
+	Totals      Stats     `json:"totals"`
+	Projects    []Group   `json:"projects"`
+	Owners      []Group   `json:"owners"`
+}
+
+// Stats aggregates a set of sessions.
+type Stats struct {
+	Sessions    int                `json:"sessions"`
+	Succeeded   int                `json:"succeeded"`
+	Failed      int                `json:"failed"`
+	SuccessRate float64            `json:"success_rate"`
+	DurationMs  int64              `json:"duration_ms"`
+	Tokens      session.TokenUsage `json:"tokens"`
+	CostUSD     float64            `json:"cost_usd"`
+	// TopFiles counts the sessions that changed each file.
+	TopFiles []Count `json:"top_files,omitempty"`
+	// TopTools counts tool calls.
+	TopTools []Count `json:"top_tools,omitempty"`
+}
+
+// Group is the stats of one project or owner.
+type Group struct {
+	Key string `json:"key"`
+	Stats
+}
+
+// Count is a named tally.
+type Count struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// Window returns the last complete period before now, in now's location.
+// Weeks start on Monday.
+func Window(period string, now time.Time) (start, end time.Time, err error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch period {
+	case PeriodDaily:
+		return today.AddDate(0, 0, -1), today, nil
+	case PeriodWeekly:
+		monday := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+		return monday.AddDate(0, 0, -7), monday, nil
+	default:
+		return time.Time{}, time.Time{}, fmt.Errorf("unknown report period %q", period)
+	}
+}
+
+// WindowFor returns the period containing day.
+func WindowFor(period string, day time.Time) (start, end time.Time, err error) {
+	next := day.AddDate(0, 0, 1)
+	if period == PeriodWeekly {
+		next = day.AddDate(0, 0, 7)
+	}
+	return Window(period, next)
+}
+
+// accumulator builds Stats incrementally.
+type accumulator struct {
+	stats Stats
+	files map[string]int
+	tools map[string]int
+}
+
+func newAccumulator() *accumulator {
+	return &accumulator{files: make(map[string]int), tools: make(map[string]int)}
+}
+
+func (a *accumulator) add(r history.Record) {
+	a.stats.Sessions++
+	if r.ExitCode == 0 {
+		a.stats.Succeeded++
+	} else {
+		a.stats.Failed++
+	}
+	a.stats.DurationMs += r.DurationMs
+	a.stats.Tokens.Add(r.Tokens)
+	a.stats.CostUSD += r.CostUSD
+	for _, f := range r.Files {
+		a.files[f]++
+	}
+	for name, t := range r.Tools {
+		a.tools[name] += t.Calls
+	}
+}
+
+func (a *accumulator) result(top int) Stats {
+	s := a.stats
+	if s.Sessions > 0 {
+		s.SuccessRate = float64(s.Succeeded) / float64(s.Sessions)
+	}
+	s.TopFiles = topCounts(a.files, top)
+	s.TopTools = topCounts(a.tools, top)
+	return s
+}
+
+// FromHistory builds the report for [start, end) from the history store.
+func FromHistory(ctx context.Context, store *history.Store, period string, start, end time.Time, top int) (*Report, error) {
+	recs, err := store.Query(ctx, history.Filter{Since: start, Until: end})
+	if err != nil {
+		return nil, err
+	}
+	return Build(period, start, end, recs, top), nil
+}
+
+// Build aggregates recs into a report. top bounds the files and tools
+// listed per group; zero uses DefaultTop.
+func Build(period string, start, end time.Time, recs []history.Record, top int) *Report {
+	if top <= 0 {
+		top = DefaultTop
+	}
+	total := newAccumulator()
+	projects := make(map[string]*accumulator)
+	owners := make(map[string]*accumulator)
+
+	for _, r := range recs {
+		total.add(r)
+		groupOf(projects, projectKey(r)).add(r)
+		owner := r.OwnerUUID
+		if owner == "" {
+			owner = unattributed
+		}
+		groupOf(owners, owner).add(r)
+	}
+
+	return &Report{
+		Period:      period,
+		Start:       start,
+		End:         end,
+		GeneratedAt: time.Now().UTC(),
+		Totals:      total.result(top),
+		Projects:    groups(projects, top),
+		Owners:      groups(owners, top),
+	}
+}
+
+func projectKey(r history.Record) string {
+	if r.WorkingDir != "" {
+		return r.WorkingDir
+	}
+	return r.Project
+}
+
+func groupOf(m map[string]*accumulator, key string) *accumulator {
+	a := m[key]
+	if a == nil {
+		a = newAccumulator()
+		m[key] = a
+	}
+	return a
+}
+
+// groups orders groups by session count, then cost, then key.
+func groups(m map[string]*accumulator, top int) []Group {
+	out := make([]Group, 0, len(m))
+	for key, a := range m {
+		out = append(out, Group{Key: key, Stats: a.result(top)})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Sessions != out[j].Sessions {
+			return out[i].Sessions > out[j].Sessions
+		}
+		if out[i].CostUSD != out[j].CostUSD {
+			return out[i].CostUSD > out[j].CostUSD
+		}
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
+
+func topCounts(m map[string]int, n int) []Count {
+	out := make([]Count, 0, len(m))
+	for name, c := range m {
+		if c > 0 {
+			out = append(out, Count{Name: name, Count: c})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Name < out[j].Name
+	})
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+// Render formats the report as Markdown or JSON.
+func (r *Report) Render(format string) ([]byte, error) {
+	switch format {
+	case FormatMarkdown, "":
+		return r.markdown(), nil
+	case FormatJSON:
+		return json.MarshalIndent(r, "", "  ")
+	default:
+		return nil, fmt.Errorf("unknown report format %q", format)
+	}
+}
+
+// WriteFile renders the report into dir and returns the file's path.
+func (r *Report) WriteFile(dir, format string) (string, error) {
+	data, err := r.Render(format)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("create report dir: %w", err)
+	}
+	path := filepath.Join(dir, r.Name(format))
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return "", fmt.Errorf("write report: %w", err)
+	}
+	return path, nil
+}
+
+// Name is a file name for the report, e.g. "cc-report-daily-2026-03-01.md".
+func (r *Report) Name(format string) string {
+	ext := ".md"
+	if format == FormatJSON {
+		ext = ".json"
+	}
+	return fmt.Sprintf("cc-report-%s-%s%s", r.Period, r.Start.Format("2006-01-02"), ext)
+}
+
+func (r *Report) markdown() []byte {
+	var b bytes.Buffer
+	title := "Daily"
+	if r.Period == PeriodWeekly {
+		title = "Weekly"
+	}
+	fmt.Fprintf(&b, "# %s agent report: %s", title, r.Start.Format("2006-01-02"))
+	if r.Period == PeriodWeekly {
+		fmt.Fprintf(&b, " to %s", r.End.AddDate(0, 0, -1).Format("2006-01-02"))
+	}
+	b.WriteString("\n\n")
+	if r.Host != "" {
+		fmt.Fprintf(&b, "Host: %s\n\n", r.Host)
+	}
+
+	b.WriteString("## Summary\n\n")
+	writeStatsTable(&b, "", []Group{{Key: "All sessions", Stats: r.Totals}})
+	writeCounts(&b, "Most-edited files", "Sessions", r.Totals.TopFiles)
+	writeCounts(&b, "Most-used tools", "Calls", r.Totals.TopTools)
+
+	if len(r.Projects) > 0 {
+		b.WriteString("## By project\n\n")
+		writeStatsTable(&b, "Project", r.Projects)
+	}
+	if len(r.Owners) > 0 {
+		b.WriteString("## By owner\n\n")
+		writeStatsTable(&b, "Owner", r.Owners)
+	}
+	return b.Bytes()
+}
+
+func writeStatsTable(b *bytes.Buffer, keyHeader string, gs []Group) {
+	fmt.Fprintf(b, "| %s | Sessions | Succeeded | Failed | Success | Duration | Tokens | Cost |\n", keyHeader)
+	b.WriteString("|---|---:|---:|---:|---:|---:|---:|---:|\n")
+	for _, g := range gs {
+		fmt.Fprintf(b, "| %s | %d | %d | %d | %.0f%% | %s | %d | $%.2f |\n",
+			escape(g.Key), g.Sessions, g.Succeeded, g.Failed, g.SuccessRate*100,
+			(time.Duration(g.DurationMs) * time.Millisecond).Round(time.Second),
+			g.Tokens.Total(), g.CostUSD)
+	}
+	b.WriteString("\n")
+}
+
+func writeCounts(b *bytes.Buffer, title, unit string, cs []Count) {
+	if len(cs) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "### %s\n\n| | %s |\n|---|---:|\n", title, unit)
+	for _, c := range cs {
+		fmt.Fprintf(b, "| %s | %d |\n", escape(c.Name), c.Count)
+	}
+	b.WriteString("\n")
+}
+
+// escape keeps pipes in paths from breaking Markdown tables.
+func escape(s string) string {
+	return strings.ReplaceAll(s, "|", `\|`)
+}
@@ -0,0 +1,169 @@
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/history"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/session"
+)
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+}
+
+func testRecords(day time.Time) []history.Record {
+	return []history.Record{
+		{SessionID: "a", WorkingDir: "/repo/api", OwnerUUID: "alice", CompletedAt: day.Add(time.Hour), DurationMs: 60_000,
+			Tokens: session.TokenUsage{InputTokens: 100, OutputTokens: 10}, CostUSD: 1,
+			Files: []string{"main.go", "api.go"}, Tools: map[string]session.ToolStats{"Edit": {Calls: 3}, "Bash": {Calls: 1}}},
+		{SessionID: "b", WorkingDir: "/repo/api", OwnerUUID: "bob", CompletedAt: day.Add(2 * time.Hour), DurationMs: 30_000,
+			CostUSD: 0.5, Files: []string{"main.go"}, Tools: map[string]session.ToolStats{"Bash": {Calls: 5}}},
+		{SessionID: "c", WorkingDir: "/repo/web", CompletedAt: day.Add(3 * time.Hour), ExitCode: 1, FailureReason: history.ReasonNoAssistantResponse},
+	}
+}
+
+func TestBuild(t *testing.T) {
+	day := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	r := Build(PeriodDaily, day, day.AddDate(0, 0, 1), testRecords(day), 0)
+
+	tot := r.Totals
+	if tot.Sessions != 3 || tot.Succeeded != 2 || tot.Failed != 1 || tot.DurationMs != 90_000 || tot.CostUSD != 1.5 {
+		t.Errorf("totals = %+v", tot)
+	}
+	if tot.SuccessRate < 0.66 || tot.SuccessRate > 0.67 {
+		t.Errorf("success rate = %v", tot.SuccessRate)
+	}
+	if len(tot.TopFiles) != 2 || tot.TopFiles[0] != (Count{"main.go", 2}) {
+		t.Errorf("top files = %v", tot.TopFiles)
+	}
+	if len(tot.TopTools) != 2 || tot.TopTools[0] != (Count{"Bash", 6}) {
+		t.Errorf("top tools = %v", tot.TopTools)
+	}
+
+	if len(r.Projects) != 2 || r.Projects[0].Key != "/repo/api" || r.Projects[0].Sessions != 2 {
+		t.Errorf("projects = %+v", r.Projects)
+	}
+	if len(r.Owners) != 3 || r.Owners[0].Key != "alice" || r.Owners[2].Key != unattributed {
+		t.Errorf("owners = %+v", r.Owners)
+	}
+
+	if top := Build(PeriodDaily, day, day, testRecords(day), 1); len(top.Totals.TopFiles) != 1 {
+		t.Errorf("top limit not applied: %v", top.Totals.TopFiles)
+	}
+}
+
+func TestRender(t *testing.T) {
+	day := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	r := Build(PeriodWeekly, day, day.AddDate(0, 0, 7), testRecords(day), 0)
+	r.Host = "build-1"
+
+	md, err := r.Render(FormatMarkdown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"# Weekly agent report: 2026-03-02 to 2026-03-08", "Host: build-1", "| All sessions | 3 | 2 | 1 | 67% | 1m30s | 110 | $1.50 |", "## By project", "| /repo/api | 2 |", "## By owner", "| main.go | 2 |"} {
+		if !strings.Contains(string(md), want) {
+			t.Errorf("markdown missing %q:\n%s", want, md)
+		}
+	}
+
+	js, err := r.Render(FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(js, &decoded); err != nil || decoded.Totals.Sessions != 3 || decoded.Period != PeriodWeekly {
+		t.Errorf("json = %s (%v)", js, err)
+	}
+
+	if _, err := r.Render("html"); err == nil {
+		t.Error("expected error for unknown format")
+	}
+
+	dir := t.TempDir()
+	path, err := r.WriteFile(filepath.Join(dir, "reports"), FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(path) != "cc-report-weekly-2026-03-02.json" {
+		t.Errorf("path = %s", path)
+	}
+}
+
+func TestWindow(t *testing.T) {
+	// Wednesday.
+	now := time.Date(2026, 3, 4, 15, 30, 0, 0, time.UTC)
+
+	start, end, err := Window(PeriodDaily, now)
+	if err != nil || !start.Equal(time.Date(2026, 3, 3, 0, 0, 0, 0, time.UTC)) || !end.Equal(time.Date(2026, 3, 4, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("daily = %v..%v (%v)", start, end, err)
+	}
+	start, end, err = Window(PeriodWeekly, now)
+	if err != nil || !start.Equal(time.Date(2026, 2, 23, 0, 0, 0, 0, time.UTC)) || !end.Equal(time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("weekly = %v..%v (%v)", start, end, err)
+	}
+
+	start, end, _ = WindowFor(PeriodWeekly, now)
+	if !start.Equal(time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)) || !end.Equal(time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("week containing now = %v..%v", start, end)
+	}
+	if _, _, err := Window("monthly", now); err == nil {
+		t.Error("expected error for unknown period")
+	}
+}
+
+func TestNextRun(t *testing.T) {
+	at := 30 * time.Minute
+	// Wednesday 00:10: today's daily run is still ahead.
+	now := time.Date(2026, 3, 4, 0, 10, 0, 0, time.UTC)
+	if got := nextRun(PeriodDaily, at, now); !got.Equal(time.Date(2026, 3, 4, 0, 30, 0, 0, time.UTC)) {
+		t.Errorf("daily = %v", got)
+	}
+	if got := nextRun(PeriodWeekly, at, now); !got.Equal(time.Date(2026, 3, 9, 0, 30, 0, 0, time.UTC)) {
+		t.Errorf("weekly = %v", got)
+	}
+	now = time.Date(2026, 3, 4, 0, 30, 0, 0, time.UTC)
+	if got := nextRun(PeriodDaily, at, now); !got.Equal(time.Date(2026, 3, 5, 0, 30, 0, 0, time.UTC)) {
+		t.Errorf("daily at run time = %v", got)
+	}
+}
+
+func TestScheduler_Run(t *testing.T) {
+	st, err := history.Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	day := time.Date(2026, 3, 3, 0, 0, 0, 0, time.UTC)
+	for _, r := range append(testRecords(day), history.Record{SessionID: "old", CompletedAt: day.AddDate(0, 0, -1)}) {
+		if err := st.Record(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var delivered *Report
+	s, err := NewScheduler([]string{PeriodDaily}, 0, func(period string, start, end time.Time) (*Report, error) {
+		return FromHistory(context.Background(), st, period, start, end, 0)
+	}, func(r *Report) error {
+		delivered = r
+		return nil
+	}, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Run(PeriodDaily, day.AddDate(0, 0, 1).Add(time.Minute))
+	if delivered == nil || delivered.Totals.Sessions != 3 || !delivered.Start.Equal(day) {
+		t.Errorf("delivered = %+v", delivered)
+	}
+
+	if _, err := NewScheduler([]string{"hourly"}, 0, nil, nil, testLogger()); err == nil {
+		t.Error("expected error for unknown period")
+	}
+}
@@ -0,0 +1,123 @@
+package report
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Generate builds the report for a period.
+type Generate func(period string, start, end time.Time) (*Report, error)
+
+// Deliver publishes or stores a generated report.
+type Deliver func(r *Report) error
+
+// Scheduler generates reports for the previous day or week shortly after
+// each period ends.
+type Scheduler struct {
+	periods  []string
+	at       time.Duration
+	generate Generate
+	deliver  Deliver
+	logger   *slog.Logger
+	done     chan struct{}
+}
+
+// NewScheduler creates a scheduler for the given periods. Reports run at
+// the offset at past local midnight; weekly reports run on Mondays.
+func NewScheduler(periods []string, at time.Duration, generate Generate, deliver Deliver, logger *slog.Logger) (*Scheduler, error) {
+	for _, p := range periods {
+		if p != PeriodDaily && p != PeriodWeekly {
+			return nil, fmt.Errorf("unknown report period %q", p)
+		}
+	}
+	if at < 0 || at >= 24*time.Hour {
+		return nil, fmt.Errorf("report time %s must be within a day", at)
+	}
+	return &Scheduler{
+		periods:  periods,
+		at:       at,
+		generate: generate,
+		deliver:  deliver,
+		logger:   logger.With("component", "report"),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// Start runs reports as they fall due. Blocks until Stop.
+func (s *Scheduler) Start() {
+	if len(s.periods) == 0 {
+		<-s.done
+		return
+	}
+	for {
+		now := time.Now()
+		period, at := s.next(now)
+		s.logger.Debug("next report scheduled", "period", period, "at", at)
+
+		timer := time.NewTimer(at.Sub(now))
+		select {
+		case <-timer.C:
+			s.Run(period, at)
+		case <-s.done:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// Stop halts the scheduler.
+func (s *Scheduler) Stop() {
+	close(s.done)
+}
+
+// Run generates and delivers the report for the period before now.
+func (s *Scheduler) Run(period string, now time.Time) {
+	start, end, err := Window(period, now)
+	if err != nil {
+		s.logger.Error("invalid report period", "period", period, "error", err)
+		return
+	}
+	r, err := s.generate(period, start, end)
+	if err != nil {
+		s.logger.Error("failed to generate report", "period", period, "start", start, "error", err)
+		return
+	}
+	if err := s.deliver(r); err != nil {
+		s.logger.Error("failed to deliver report", "period", period, "start", start, "error", err)
+		return
+	}
+	s.logger.Info("report delivered", "period", period, "start", start, "sessions", r.Totals.Sessions)
+}
+
+// next returns the earliest due report after now.
+func (s *Scheduler) next(now time.Time) (string, time.Time) {
+	var (
+		period string
+		first  time.Time
+	)
+	for _, p := range s.periods {
+		if at := nextRun(p, s.at, now); first.IsZero() || at.Before(first) {
+			period, first = p, at
+		}
+	}
+	return period, first
+}
+
+// nextRun returns the next time after now a report for period is due.
+func nextRun(period string, at time.Duration, now time.Time) time.Time {
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	step := 1
+	if period == PeriodWeekly {
+		day = day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+		step = 7
+	}
+	for {
+		// Add the offset as wall-clock time so DST changes don't shift it.
+		run := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, int(at), day.Location())
+		if run.After(now) {
+			return run
+		}
+		day = day.AddDate(0, 0, step)
+	}
+}
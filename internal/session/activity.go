@@ -0,0 +1,56 @@
+package session
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ActivityKind classifies an Activity.
+type ActivityKind string
+
+const (
+	// ActivityPrompt is text typed by the user.
+	ActivityPrompt ActivityKind = "prompt"
+	// ActivityText is text written by the assistant.
+	ActivityText ActivityKind = "text"
+	// ActivityToolUse is a tool call made by the assistant.
+	ActivityToolUse ActivityKind = "tool_use"
+	// ActivityToolResult is the result of a tool call.
+	ActivityToolResult ActivityKind = "tool_result"
+	// ActivityUsage reports tokens newly consumed by an assistant message.
+	// Usage repeated across the lines of one message is only counted once.
+	ActivityUsage ActivityKind = "usage"
+)
+
+// Activity is a normalised item extracted from a transcript line. Several
+// activities may come from one line.
+type Activity struct {
+	Kind      ActivityKind `json:"kind"`
+	Timestamp time.Time    `json:"timestamp,omitzero"`
+	CWD       string       `json:"cwd,omitempty"`
+	MessageID string       `json:"message_id,omitempty"`
+	Text      string       `json:"text,omitempty"`
+
+	// Tool fields, set for tool_use and tool_result.
+	Tool      string          `json:"tool,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	FilePath  string          `json:"file_path,omitempty"`
+	Command   string          `json:"command,omitempty"`
+	IsError   bool            `json:"is_error,omitempty"`
+
+	// Usage fields, set for usage activities.
+	Model string      `json:"model,omitempty"`
+	Usage *TokenUsage `json:"usage,omitempty"`
+
+	// Offset is the byte offset of the transcript line the activity was
+	// parsed from, and Index its position among that line's activities.
+	// Together they identify the activity across restarts.
+	Offset int64 `json:"offset"`
+	Index  int   `json:"index,omitempty"`
+}
+
+// OnActivity is called with the activities parsed from newly written
+// transcript lines. It runs on the goroutine calling Touch, so it should not
+// block for long.
+type OnActivity func(info SessionInfo, acts []Activity)
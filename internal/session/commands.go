@@ -0,0 +1,111 @@
+package session
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxCommandOutput bounds the output kept per command.
+const maxCommandOutput = 2000
+
+// exitCodePattern matches the "Exit code N" prefix of a failed Bash result.
+var exitCodePattern = regexp.MustCompile(`^Exit code (\d+)`)
+
+// Command is a Bash command run by the agent.
+type Command struct {
+	Command     string    `json:"command"`
+	Description string    `json:"description,omitempty"`
+	WorkingDir  string    `json:"working_dir,omitempty"`
+	StartedAt   time.Time `json:"started_at,omitzero"`
+	// DurationMs is the time between the tool call and its result.
+	DurationMs int64 `json:"duration_ms,omitempty"`
+	// ExitCode is 0 for success and the reported code for failures. It is
+	// nil when no result was recorded or a failure carried no code.
+	ExitCode    *int `json:"exit_code,omitempty"`
+	Error       bool `json:"error,omitempty"`
+	Interrupted bool `json:"interrupted,omitempty"`
+	// Output is the combined stdout and stderr, truncated.
+	Output string `json:"output,omitempty"`
+}
+
+type bashInput struct {
+	Command     string `json:"command"`
+	Description string `json:"description"`
+}
+
+// bashResult is the toolUseResult recorded for a Bash call.
+type bashResult struct {
+	Stdout      string `json:"stdout"`
+	Stderr      string `json:"stderr"`
+	Interrupted bool   `json:"interrupted"`
+}
+
+// startCommand records a Bash tool call awaiting its result.
+func (p *transcriptParser) startCommand(block contentBlock, cwd string, ts time.Time) {
+	var input bashInput
+	if err := json.Unmarshal(block.Input, &input); err != nil || input.Command == "" {
+		return
+	}
+	p.commands = append(p.commands, Command{
+		Command:     input.Command,
+		Description: input.Description,
+		WorkingDir:  cwd,
+		StartedAt:   ts,
+	})
+	if block.ID != "" {
+		p.pendingCommands[block.ID] = len(p.commands) - 1
+	}
+}
+
+// finishCommand fills in the result of a pending Bash call.
+func (p *transcriptParser) finishCommand(block contentBlock, toolUseResult json.RawMessage, ts time.Time) {
+	i, ok := p.pendingCommands[block.ToolUseID]
+	if !ok {
+		return
+	}
+	delete(p.pendingCommands, block.ToolUseID)
+	cmd := &p.commands[i]
+
+	if !cmd.StartedAt.IsZero() && !ts.IsZero() {
+		cmd.DurationMs = ts.Sub(cmd.StartedAt).Milliseconds()
+	}
+
+	text := resultText(block.Content)
+	var res bashResult
+	if json.Unmarshal(toolUseResult, &res) == nil && (res.Stdout != "" || res.Stderr != "" || res.Interrupted) {
+		cmd.Interrupted = res.Interrupted
+		text = strings.TrimRight(strings.Join(nonEmpty(res.Stdout, res.Stderr), "\n"), "\n")
+	}
+	cmd.Output = truncate(text, maxCommandOutput)
+
+	if !block.IsError {
+		code := 0
+		cmd.ExitCode = &code
+		return
+	}
+	cmd.Error = true
+	if m := exitCodePattern.FindStringSubmatch(resultText(block.Content)); m != nil {
+		if code, err := strconv.Atoi(m[1]); err == nil {
+			cmd.ExitCode = &code
+		}
+	}
+}
+
+// resultText returns the text of a tool_result content, which may be a plain
+// string or a list of blocks.
+func resultText(raw json.RawMessage) string {
+	return messageText(messageContent{Content: raw})
+}
+
+func nonEmpty(values ...string) []string {
+	var out []string
+	for _, v := range values {
+		if v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,147 @@
+package session
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"time"
+)
+
+// maxFollowRead bounds how much of a transcript is read per Touch; the rest
+// is picked up on the next write.
+const maxFollowRead = 16 * 1024 * 1024
+
+// resumeTTL is how long the read offset of an evicted transcript is kept.
+// A session written to again within it carries on from that offset instead
+// of emitting every earlier activity a second time, which would, for
+// instance, charge its spend to budgets twice.
+const resumeTTL = 24 * time.Hour
+
+// resumePoint is where following an evicted transcript carries on.
+type resumePoint struct {
+	offset    int64
+	evictedAt time.Time
+}
+
+// follower incrementally parses one transcript as it grows.
+type follower struct {
+	offset  int64
+	partial []byte
+	parser  *transcriptParser
+	acts    []Activity
+
+	line  int64 // offset of the line being fed
+	index int   // activities emitted for it so far
+}
+
+func newFollower() *follower {
+	f := &follower{parser: newTranscriptParser()}
+	f.parser.emit = func(a Activity) {
+		a.Offset, a.Index = f.line, f.index
+		f.index++
+		f.acts = append(f.acts, a)
+	}
+	return f
+}
+
+// SetOnActivity registers a callback receiving activities as transcripts
+// grow, enabling incremental parsing. It must be called before Touch.
+func (t *Tracker) SetOnActivity(fn OnActivity) {
+	t.onActivity = fn
+}
+
+// follow parses lines appended to a transcript since the last call and
+// passes their activities to the activity callback.
+func (t *Tracker) follow(info SessionInfo) {
+	if t.onActivity == nil {
+		return
+	}
+
+	t.followMu.Lock()
+	acts := t.readNew(info.TranscriptPath)
+	t.followMu.Unlock()
+
+	if len(acts) > 0 {
+		t.onActivity(info, acts)
+	}
+}
+
+// readNew reads complete lines appended since the last read. Caller must
+// hold t.followMu.
+func (t *Tracker) readNew(path string) []Activity {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil
+	}
+
+	fl, ok := t.followers[path]
+	if !ok {
+		fl = newFollower()
+		t.followers[path] = fl
+		if rp, ok := t.resume[path]; ok {
+			delete(t.resume, path)
+			if info.Size() >= rp.offset {
+				fl.offset = rp.offset
+			}
+		}
+	}
+	if info.Size() < fl.offset {
+		// Truncated or replaced; start over.
+		t.logger.Debug("transcript shrank, re-reading", "path", path)
+		fl = newFollower()
+		t.followers[path] = fl
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(io.NewSectionReader(file, fl.offset, info.Size()-fl.offset), maxFollowRead))
+	if err != nil {
+		return nil
+	}
+	start := fl.offset - int64(len(fl.partial)) // of data
+	fl.offset += int64(len(buf))
+
+	data := append(fl.partial, buf...)
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		fl.partial = data
+		return nil
+	}
+	fl.partial = append([]byte(nil), data[end+1:]...)
+
+	for _, line := range bytes.Split(data[:end], []byte{'\n'}) {
+		fl.line, fl.index = start, 0
+		fl.parser.feed(line)
+		start += int64(len(line)) + 1
+	}
+	acts := fl.acts
+	fl.acts = nil
+	return acts
+}
+
+// dropFollower forgets the incremental state of an evicted transcript,
+// keeping only where to resume if it is written again.
+func (t *Tracker) dropFollower(path string, now time.Time) {
+	t.followMu.Lock()
+	defer t.followMu.Unlock()
+	if fl, ok := t.followers[path]; ok {
+		// Re-read an incomplete last line in full.
+		t.resume[path] = resumePoint{offset: fl.offset - int64(len(fl.partial)), evictedAt: now}
+		delete(t.followers, path)
+	}
+}
+
+// pruneResume drops resume points older than resumeTTL.
+func (t *Tracker) pruneResume(now time.Time) {
+	t.followMu.Lock()
+	defer t.followMu.Unlock()
+	for path, rp := range t.resume {
+		if now.Sub(rp.evictedAt) >= resumeTTL {
+			delete(t.resume, path)
+		}
+	}
+}
@@ -1,9 +1,12 @@
 package session
 
 import (
+	"fmt"
 	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -11,20 +14,107 @@ import (
 
 // CompletedSession holds parsed info about a completed CC session.
 type CompletedSession struct {
-	SessionID      string
-	TranscriptPath string
-	FilesChanged   []string
-	WorkingDir     string
-	DurationMs     int64
-	ExitCode       int
+	SessionID      string   `json:"session_id"`
+	TranscriptPath string   `json:"transcript_path"`
+	FilesChanged   []string `json:"files_changed"`
+	WorkingDir     string   `json:"working_dir"`
+	DurationMs     int64    `json:"duration_ms"`
+	ExitCode       int      `json:"exit_code"`
+
+	// ParentSessionID is the session a subagent transcript belongs to.
+	ParentSessionID string `json:"parent_session_id,omitempty"`
+	// FirstPrompt is the first text the user typed in the session.
+	FirstPrompt string `json:"first_prompt,omitempty"`
+	// PID is the claude process that was writing the transcript, if found.
+	PID int `json:"pid,omitempty"`
+	// Owner labels the watch root the transcript was found under.
+	Owner string `json:"owner,omitempty"`
+	// Summary describes the prompts, final answer and tool usage.
+	Summary *Summary `json:"summary,omitempty"`
+	// Metadata records CLI version, git branch, models and permission mode.
+	Metadata *Metadata `json:"metadata,omitempty"`
+	// Commands lists every Bash command the agent ran, in order.
+	Commands []Command `json:"commands,omitempty"`
+	// Usage totals tokens per model, counting each message once.
+	Usage map[string]TokenUsage `json:"usage,omitempty"`
 }
 
+// State is the liveness state of a tracked session.
+type State string
+
+const (
+	// StateRunning means the transcript is being written to.
+	StateRunning State = "running"
+	// StateIdle means the transcript has not been written to for the idle
+	// threshold but the claude process is still alive.
+	StateIdle State = "idle"
+	// StateReported means the session has been completed and handed to the
+	// completion callback; it stays tracked for a grace period.
+	StateReported State = "reported"
+)
+
+// SessionInfo is a snapshot of a tracked session.
+type SessionInfo struct {
+	SessionID      string    `json:"session_id"`
+	TranscriptPath string    `json:"transcript_path"`
+	WorkingDir     string    `json:"working_dir,omitempty"`
+	State          State     `json:"state"`
+	PID            int       `json:"pid,omitempty"`
+	Owner          string    `json:"owner,omitempty"`
+	StartedAt      time.Time `json:"started_at"`
+	LastActivity   time.Time `json:"last_activity"`
+
+	// ParentSessionID is the session a subagent transcript belongs to.
+	ParentSessionID string `json:"parent_session_id,omitempty"`
+}
+
+// Partial describes a running session as a CompletedSession, so that it can
+// be attributed before it finishes. Only the fields known while running are
+// set.
+func (si SessionInfo) Partial() *CompletedSession {
+	return &CompletedSession{
+		SessionID:      si.SessionID,
+		TranscriptPath: si.TranscriptPath,
+		WorkingDir:     si.WorkingDir,
+		PID:            si.PID,
+		Owner:          si.Owner,
+
+		ParentSessionID: si.ParentSessionID,
+	}
+}
+
+// OnState is called on every write to a tracked transcript (with
+// StateRunning) and when a session becomes idle. It is invoked outside the
+// tracker's lock but on the caller's goroutine, so it must not block.
+type OnState func(ev SessionInfo)
+
 // trackedFile tracks a JSONL transcript file being written to.
 type trackedFile struct {
 	path       string
+	sessionID  string
+	workingDir string
+	startedAt  time.Time
 	lastWrite  time.Time
 	reported   bool
 	reportedAt time.Time
+	idle       bool
+	pid        int
+	owner      string
+}
+
+func (tf *trackedFile) info(state State) SessionInfo {
+	return SessionInfo{
+		SessionID:      tf.sessionID,
+		TranscriptPath: tf.path,
+		WorkingDir:     tf.workingDir,
+		State:          state,
+		PID:            tf.pid,
+		Owner:          tf.owner,
+		StartedAt:      tf.startedAt,
+		LastActivity:   tf.lastWrite,
+
+		ParentSessionID: subagentParent(tf.path),
+	}
 }
 
 // cleanupGrace is how long a reported file stays in the map before eviction.
@@ -38,6 +128,20 @@ type OnComplete func(s *CompletedSession)
 // whose working directory matches the given transcript path's project.
 type ProcessChecker func(transcriptPath string) bool
 
+// ProcessFinder returns the PID of the claude process writing the given
+// transcript, or 0 if none is found or the match is ambiguous.
+type ProcessFinder func(transcriptPath string) int
+
+// OwnerFunc returns the owner label for a transcript path.
+type OwnerFunc func(transcriptPath string) string
+
+// OnForget is called when a session is dropped without completing, because
+// its transcript was deleted or moved out of the watched roots.
+type OnForget func(info SessionInfo)
+
+// OnProcess is called when the claude process for a transcript is found.
+type OnProcess func(transcriptPath string, pid int)
+
 // Tracker monitors active JSONL files and detects session completion.
 type Tracker struct {
 	mu            sync.Mutex
@@ -46,8 +150,19 @@ type Tracker struct {
 	pollInterval  time.Duration
 	onComplete    OnComplete
 	processCheck  ProcessChecker
+	processFind   ProcessFinder
+	onProcess     OnProcess
+	owner         OwnerFunc
+	onForget      OnForget
+	onState       OnState
+	onActivity    OnActivity
 	logger        *slog.Logger
 	done          chan struct{}
+	retick        chan struct{}
+
+	followMu  sync.Mutex
+	followers map[string]*follower
+	resume    map[string]resumePoint // offsets of evicted transcripts
 }
 
 // NewTracker creates a session tracker.
@@ -58,37 +173,312 @@ func NewTracker(idleThreshold, pollInterval time.Duration, logger *slog.Logger,
 		pollInterval:  pollInterval,
 		onComplete:    onComplete,
 		processCheck:  isClaudeRunningForTranscript,
+		processFind:   FindWriter,
 		logger:        logger.With("component", "tracker"),
 		done:          make(chan struct{}),
+		retick:        make(chan struct{}, 1),
+		followers:     make(map[string]*follower),
+		resume:        make(map[string]resumePoint),
+	}
+}
+
+// SetOnProcess registers a callback invoked when the claude process writing a
+// transcript is found: when it is first tracked, and again when a completed
+// session resumes, usually in a new process. It must be called before Touch.
+func (t *Tracker) SetOnProcess(fn OnProcess) {
+	t.onProcess = fn
+}
+
+// SetOwner registers a function labelling new transcripts with their
+// owner. It must be called before Touch.
+func (t *Tracker) SetOwner(fn OwnerFunc) {
+	t.owner = fn
+}
+
+// SetOnForget registers a callback for sessions dropped by Forget or
+// replaced by Rename. It must be called before Touch.
+func (t *Tracker) SetOnForget(fn OnForget) {
+	t.onForget = fn
+}
+
+// SetThresholds changes the idle threshold and poll interval. Unlike the
+// other setters it may be called while the tracker runs; tracked sessions
+// are kept and judged by the new threshold from the next check.
+func (t *Tracker) SetThresholds(idleThreshold, pollInterval time.Duration) {
+	t.mu.Lock()
+	t.idleThreshold = idleThreshold
+	changed := pollInterval != t.pollInterval
+	t.pollInterval = pollInterval
+	t.mu.Unlock()
+
+	if changed {
+		select {
+		case t.retick <- struct{}{}:
+		default:
+		}
 	}
 }
 
+// SetOnState registers a callback for session activity and liveness changes.
+// It must be called before Touch.
+func (t *Tracker) SetOnState(fn OnState) {
+	t.onState = fn
+}
+
 // Touch marks a transcript file as recently written.
 func (t *Tracker) Touch(path string) {
-	t.mu.Lock()
-	defer t.mu.Unlock()
+	now := time.Now()
 
+	t.mu.Lock()
 	if tf, ok := t.files[path]; ok {
-		tf.lastWrite = time.Now()
+		resumed := tf.reported
+		tf.lastWrite = now
 		tf.reported = false // reset if file is being written again
-	} else {
-		t.files[path] = &trackedFile{
-			path:      path,
-			lastWrite: time.Now(),
+		tf.idle = false
+		ev := tf.info(StateRunning)
+		t.mu.Unlock()
+		if resumed {
+			ev = t.identify(tf)
+		}
+		t.emitState(ev)
+		t.follow(ev)
+		return
+	}
+	tf := &trackedFile{
+		path:       path,
+		sessionID:  SessionIDFromPath(path),
+		workingDir: projectDirFromTranscript(path),
+		startedAt:  now,
+		lastWrite:  now,
+	}
+	if t.owner != nil {
+		tf.owner = t.owner(path)
+	}
+	t.files[path] = tf
+	t.mu.Unlock()
+	t.logger.Info("tracking new transcript", "path", path)
+
+	ev := t.identify(tf)
+	t.emitState(ev)
+	t.follow(ev)
+}
+
+// identify looks up the process writing tf, which is certain to be alive
+// right after a write, and returns the session as running. /proc is scanned
+// outside the lock as it is comparatively slow.
+func (t *Tracker) identify(tf *trackedFile) SessionInfo {
+	pid := t.processFind(tf.path)
+
+	t.mu.Lock()
+	if pid != 0 {
+		tf.pid = pid
+	}
+	ev := tf.info(StateRunning)
+	t.mu.Unlock()
+
+	if pid != 0 && t.onProcess != nil {
+		t.onProcess(ev.TranscriptPath, pid)
+	}
+	return ev
+}
+
+func (t *Tracker) emitState(ev SessionInfo) {
+	if t.onState != nil {
+		t.onState(ev)
+	}
+}
+
+// Sessions returns a snapshot of all tracked sessions.
+func (t *Tracker) Sessions() []SessionInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	out := make([]SessionInfo, 0, len(t.files))
+	for _, tf := range t.files {
+		state := StateRunning
+		switch {
+		case tf.reported:
+			state = StateReported
+		case tf.idle:
+			state = StateIdle
+		}
+		out = append(out, tf.info(state))
+	}
+	return out
+}
+
+// TranscriptPath returns the transcript path of a tracked session.
+func (t *Tracker) TranscriptPath(sessionID string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for path, tf := range t.files {
+		if tf.sessionID == sessionID {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// ForceComplete completes a tracked session immediately, regardless of idle
+// time or process liveness, and invokes the completion callback. If the
+// transcript cannot be parsed the session stays tracked and unreported.
+func (t *Tracker) ForceComplete(sessionID string) (*CompletedSession, error) {
+	t.mu.Lock()
+	var found *trackedFile
+	for _, tf := range t.files {
+		if tf.sessionID == sessionID {
+			found = tf
+			break
+		}
+	}
+	if found == nil {
+		t.mu.Unlock()
+		return nil, fmt.Errorf("session %s is not tracked", sessionID)
+	}
+	if found.reported {
+		t.mu.Unlock()
+		return nil, fmt.Errorf("session %s has already completed", sessionID)
+	}
+	// Claim the session so the idle check does not complete it as well.
+	found.reported = true
+	found.reportedAt = time.Now()
+	tf := *found
+	t.mu.Unlock()
+
+	t.logger.Info("force-completing session", "path", tf.path)
+	completed := parseTranscript(tf.path, t.logger)
+	if completed == nil {
+		t.mu.Lock()
+		if found.reported && found.reportedAt.Equal(tf.reportedAt) {
+			found.reported = false
+			found.reportedAt = time.Time{}
+		}
+		t.mu.Unlock()
+		return nil, fmt.Errorf("could not parse transcript %s", tf.path)
+	}
+	completed.PID = tf.pid
+	completed.Owner = tf.owner
+	t.onComplete(completed)
+	return completed, nil
+}
+
+// Forget stops tracking a deleted transcript, or every transcript under a
+// deleted directory, without completing its session.
+func (t *Tracker) Forget(path string) {
+	var dropped []SessionInfo
+	t.mu.Lock()
+	for p, tf := range t.files {
+		if !within(p, path) {
+			continue
+		}
+		delete(t.files, p)
+		if !tf.reported {
+			dropped = append(dropped, tf.info(StateRunning))
+		}
+	}
+	t.mu.Unlock()
+
+	t.followMu.Lock()
+	for p := range t.followers {
+		if within(p, path) {
+			delete(t.followers, p)
+		}
+	}
+	for p := range t.resume {
+		if within(p, path) {
+			delete(t.resume, p)
+		}
+	}
+	t.followMu.Unlock()
+
+	for _, info := range dropped {
+		t.logger.Info("transcript removed, forgetting session", "path", info.TranscriptPath, "session_id", info.SessionID)
+		t.emitForget(info)
+	}
+}
+
+// Rename moves tracked transcripts from oldPath to newPath, which may be
+// files or directories. Parsing carries on where it left off. A transcript
+// renamed over another replaces it, as atomic-rename writers do.
+func (t *Tracker) Rename(oldPath, newPath string) {
+	moved := func(p string) string { return newPath + strings.TrimPrefix(p, oldPath) }
+
+	var dropped []SessionInfo
+	t.mu.Lock()
+	var renamed []*trackedFile
+	for p, tf := range t.files {
+		if within(p, oldPath) {
+			renamed = append(renamed, tf)
+			delete(t.files, p)
+		}
+	}
+	for _, tf := range renamed {
+		to := moved(tf.path)
+		id := SessionIDFromPath(to)
+		if prev, ok := t.files[to]; ok && !prev.reported && prev.sessionID != id {
+			dropped = append(dropped, prev.info(StateRunning))
+		}
+		if id != tf.sessionID && !tf.reported {
+			// Now another session's transcript.
+			dropped = append(dropped, tf.info(StateRunning))
+		}
+		tf.path, tf.sessionID = to, id
+		t.files[to] = tf
+	}
+	t.mu.Unlock()
+
+	t.followMu.Lock()
+	followers := make(map[string]*follower)
+	for p, fl := range t.followers {
+		if within(p, oldPath) {
+			followers[moved(p)] = fl
+			delete(t.followers, p)
+		}
+	}
+	maps.Copy(t.followers, followers)
+	resume := make(map[string]resumePoint)
+	for p, rp := range t.resume {
+		if within(p, oldPath) {
+			resume[moved(p)] = rp
+			delete(t.resume, p)
 		}
-		t.logger.Info("tracking new transcript", "path", path)
 	}
+	maps.Copy(t.resume, resume)
+	t.followMu.Unlock()
+
+	t.logger.Debug("transcript renamed", "from", oldPath, "to", newPath)
+	for _, info := range dropped {
+		t.emitForget(info)
+	}
+}
+
+func (t *Tracker) emitForget(info SessionInfo) {
+	if t.onForget != nil {
+		t.onForget(info)
+	}
+}
+
+// within reports whether path is dir or below it.
+func within(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
 }
 
 // Start begins the polling loop to detect idle sessions. Blocks until Stop.
 func (t *Tracker) Start() {
+	t.mu.Lock()
 	ticker := time.NewTicker(t.pollInterval)
+	t.mu.Unlock()
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
 			t.check()
+		case <-t.retick:
+			t.mu.Lock()
+			ticker.Reset(t.pollInterval)
+			t.mu.Unlock()
 		case <-t.done:
 			return
 		}
@@ -101,8 +491,12 @@ func (t *Tracker) Stop() {
 }
 
 func (t *Tracker) check() {
-	// Collect paths to complete under the lock, then process outside it.
-	var readyPaths []string
+	// Collect files to complete under the lock, then process outside it.
+	var (
+		ready   []trackedFile
+		idleEv  []SessionInfo
+		evicted []string
+	)
 
 	t.mu.Lock()
 	now := time.Now()
@@ -114,6 +508,7 @@ func (t *Tracker) check() {
 		if tf.reported && !tf.reportedAt.IsZero() && now.Sub(tf.reportedAt) >= cleanupGrace {
 			t.logger.Debug("evicting completed transcript from tracker", "path", path)
 			delete(t.files, path)
+			evicted = append(evicted, path)
 			continue
 		}
 
@@ -128,21 +523,35 @@ func (t *Tracker) check() {
 
 		// Check if claude process is still running for this transcript.
 		if t.processCheck(path) {
+			if !tf.idle {
+				tf.idle = true
+				idleEv = append(idleEv, tf.info(StateIdle))
+			}
 			continue
 		}
 
 		t.logger.Info("session idle, no claude process — completing", "path", path, "idle", idle)
 		tf.reported = true
 		tf.reportedAt = now
-		readyPaths = append(readyPaths, path)
+		ready = append(ready, *tf)
 	}
 	t.mu.Unlock()
 
+	for _, path := range evicted {
+		t.dropFollower(path, now)
+	}
+	t.pruneResume(now)
+	for _, ev := range idleEv {
+		t.emitState(ev)
+	}
+
 	// Parse transcripts and invoke callbacks outside the lock to avoid
 	// blocking Touch() during network I/O (NATS publish, KV lookup).
-	for _, path := range readyPaths {
-		completed := parseTranscript(path, t.logger)
+	for _, tf := range ready {
+		completed := parseTranscript(tf.path, t.logger)
 		if completed != nil {
+			completed.PID = tf.pid
+			completed.Owner = tf.owner
 			t.onComplete(completed)
 		}
 	}
@@ -161,61 +570,165 @@ func (t *Tracker) check() {
 // (read from /proc/<pid>/cwd). If the transcript path doesn't contain the
 // expected structure, we fall back to the global "any claude process" check.
 func isClaudeRunningForTranscript(transcriptPath string) bool {
+	return findClaudeForTranscript(transcriptPath) != 0
+}
+
+// ProjectSlug returns the project directory name of a transcript, the
+// component right below "projects", e.g. "-home-mike-Warren" for both
+// projects/-home-mike-Warren/<session>.jsonl and a subagent's
+// projects/-home-mike-Warren/<session>/subagents/agent-<id>.jsonl. It is ""
+// if the path has no "projects" ancestor.
+func ProjectSlug(transcriptPath string) string {
+	dir := filepath.Dir(transcriptPath) // e.g., ~/.claude/projects/-home-mike-Warren
+	parent := filepath.Dir(dir)         // e.g., ~/.claude/projects
+	for filepath.Base(parent) != "projects" {
+		if parent == dir {
+			return "" // reached the filesystem root
+		}
+		dir, parent = parent, filepath.Dir(parent)
+	}
+	if slug := filepath.Base(dir); slug != "." {
+		return slug
+	}
+	return ""
+}
+
+// findClaudeForTranscript returns the PID of a claude process matching the
+// transcript's project, using the same rules as isClaudeRunningForTranscript,
+// or 0 if there is none. The match may be another session in the same
+// project, so it only serves liveness; FindWriter identifies the writer.
+func findClaudeForTranscript(transcriptPath string) int {
 	projectDir := projectDirFromTranscript(transcriptPath)
 
 	entries, err := os.ReadDir("/proc")
 	if err != nil {
-		return false
+		return 0
 	}
 
 	for _, entry := range entries {
-		if !entry.IsDir() {
+		// Only look at numeric PID dirs running claude.
+		pid := pidOf(entry.Name())
+		if pid == 0 || !isClaude("/proc", pid) {
 			continue
 		}
-		// Only look at numeric PID dirs.
-		name := entry.Name()
-		if len(name) == 0 || name[0] < '0' || name[0] > '9' {
-			continue
+
+		// If we have no project dir to match against, fall back to
+		// "any claude process is running".
+		if projectDir == "" {
+			return pid
 		}
 
-		cmdline, err := os.ReadFile(filepath.Join("/proc", name, "cmdline"))
+		// Read the process's working directory and compare.
+		cwd, err := os.Readlink(filepath.Join("/proc", entry.Name(), "cwd"))
 		if err != nil {
 			continue
 		}
+		if cwd == projectDir {
+			return pid
+		}
+	}
 
-		// cmdline is null-separated; check if any arg contains "claude"
-		isClaude := false
-		parts := strings.Split(string(cmdline), "\x00")
-		for _, part := range parts {
-			base := filepath.Base(part)
-			if base == "claude" || strings.HasPrefix(base, "claude-") {
-				isClaude = true
-				break
-			}
+	return 0
+}
+
+// FindWriter returns the PID of the claude process writing the transcript,
+// or 0 if it cannot be told apart from others. A process holding the
+// transcript open is the writer. Claude Code does not keep its transcript
+// open, though: it opens it for each append, so between writes the writer
+// is taken to be the claude process working in the transcript's project
+// directory, provided it is the only one there. Two sessions in one
+// project, or a transcript whose project directory cannot be decoded, give 0.
+func FindWriter(transcriptPath string) int {
+	return findWriter("/proc", transcriptPath)
+}
+
+// IsWriter reports whether pid is the process FindWriter picks for the
+// transcript, judged by the processes under procRoot.
+func IsWriter(procRoot string, pid int, transcriptPath string) bool {
+	return pid > 0 && findWriter(procRoot, transcriptPath) == pid
+}
+
+func findWriter(procRoot, transcriptPath string) int {
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return 0
+	}
+
+	projectDir := projectDirFromTranscript(transcriptPath)
+	var holders, inProject []int
+	for _, entry := range entries {
+		pid := pidOf(entry.Name())
+		if pid == 0 || !isClaude(procRoot, pid) {
+			continue
 		}
-		if !isClaude {
+		if HoldsOpen(procRoot, pid, transcriptPath) {
+			holders = append(holders, pid)
 			continue
 		}
-
-		// If we have no project dir to match against, fall back to
-		// "any claude process is running".
 		if projectDir == "" {
-			return true
-		}
-
-		// Read the process's working directory and compare.
-		cwd, err := os.Readlink(filepath.Join("/proc", name, "cwd"))
-		if err != nil {
 			continue
 		}
-		if cwd == projectDir {
+		if cwd, err := os.Readlink(filepath.Join(procRoot, entry.Name(), "cwd")); err == nil && cwd == projectDir {
+			inProject = append(inProject, pid)
+		}
+	}
+	switch {
+	case len(holders) == 1:
+		return holders[0]
+	case len(holders) == 0 && len(inProject) == 1:
+		return inProject[0]
+	}
+	return 0
+}
+
+// HoldsOpen reports whether process pid has path open, according to its
+// file descriptors under procRoot.
+func HoldsOpen(procRoot string, pid int, path string) bool {
+	if pid <= 0 {
+		return false
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		resolved = path
+	}
+	dir := filepath.Join(procRoot, strconv.Itoa(pid), "fd")
+	fds, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, fd := range fds {
+		target, err := os.Readlink(filepath.Join(dir, fd.Name()))
+		if err == nil && (target == path || target == resolved) {
 			return true
 		}
 	}
+	return false
+}
 
+// isClaude reports whether pid runs claude, judged by its command line.
+func isClaude(procRoot string, pid int) bool {
+	cmdline, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "cmdline"))
+	if err != nil {
+		return false
+	}
+	for _, part := range strings.Split(string(cmdline), "\x00") {
+		base := filepath.Base(part)
+		if base == "claude" || strings.HasPrefix(base, "claude-") {
+			return true
+		}
+	}
 	return false
 }
 
+// pidOf parses a /proc entry name into a PID.
+func pidOf(name string) int {
+	pid, err := strconv.Atoi(name)
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
 // projectDirFromTranscript extracts the working directory from a transcript
 // path. Claude Code stores transcripts at:
 //
@@ -228,15 +741,8 @@ func isClaudeRunningForTranscript(transcriptPath string) bool {
 // layout or the decoded directory doesn't exist, we return "" to signal that
 // the caller should fall back to the global check.
 func projectDirFromTranscript(transcriptPath string) string {
-	// Walk up to find the "projects" component.
-	dir := filepath.Dir(transcriptPath) // e.g., ~/.claude/projects/-home-mike-Warren
-	parent := filepath.Dir(dir)         // e.g., ~/.claude/projects
-	if filepath.Base(parent) != "projects" {
-		return ""
-	}
-
-	slug := filepath.Base(dir) // e.g., "-home-mike-Warren"
-	if slug == "" || slug == "." {
+	slug := ProjectSlug(transcriptPath) // e.g., "-home-mike-Warren"
+	if slug == "" {
 		return ""
 	}
 
@@ -3,6 +3,8 @@ package session
 import (
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"sync"
 	"testing"
 	"time"
@@ -12,6 +14,7 @@ import (
 func newTestTracker(idleThreshold, pollInterval time.Duration, onComplete OnComplete) *Tracker {
 	t := NewTracker(idleThreshold, pollInterval, testLogger(), onComplete)
 	t.processCheck = func(string) bool { return false } // no claude process in tests
+	t.processFind = func(string) int { return 0 }
 	return t
 }
 
@@ -52,6 +55,36 @@ func TestTrackerTouchAndCheck(t *testing.T) {
 	}
 }
 
+func TestTrackerOwner(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		completed *CompletedSession
+	)
+	tracker := newTestTracker(50*time.Millisecond, 20*time.Millisecond, func(s *CompletedSession) {
+		mu.Lock()
+		completed = s
+		mu.Unlock()
+	})
+	tracker.SetOwner(func(path string) string { return "alice" })
+	go tracker.Start()
+	defer tracker.Stop()
+
+	path := t.TempDir() + "/aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee.jsonl"
+	os.WriteFile(path, []byte(`{"type":"summary","sessionId":"aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee"}`+"\n"), 0644)
+	tracker.Touch(path)
+
+	if sessions := tracker.Sessions(); len(sessions) != 1 || sessions[0].Owner != "alice" {
+		t.Errorf("sessions = %+v, want owner alice", sessions)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if completed == nil || completed.Owner != "alice" {
+		t.Errorf("completed = %+v, want owner alice", completed)
+	}
+}
+
 func TestTrackerDoesNotDoubleReport(t *testing.T) {
 	var mu sync.Mutex
 	var count int
@@ -87,6 +120,39 @@ func TestTrackerDoesNotDoubleReport(t *testing.T) {
 	}
 }
 
+func TestTrackerSetThresholds(t *testing.T) {
+	var mu sync.Mutex
+	var count int
+
+	// Start with thresholds that never complete within the test.
+	tracker := newTestTracker(time.Hour, time.Hour, func(s *CompletedSession) {
+		mu.Lock()
+		count++
+		mu.Unlock()
+	})
+	go tracker.Start()
+	defer tracker.Stop()
+
+	dir := t.TempDir()
+	path := dir + "/66666666-2222-3333-4444-555555555555.jsonl"
+	content := `{"type":"summary","sessionId":"66666666-2222-3333-4444-555555555555","timestamp":"2026-02-14T10:00:00Z"}
+`
+	os.WriteFile(path, []byte(content), 0644)
+	tracker.Touch(path)
+
+	// The tracked session survives the change and completes under the new
+	// thresholds.
+	tracker.SetThresholds(50*time.Millisecond, 20*time.Millisecond)
+	time.Sleep(300 * time.Millisecond)
+
+	mu.Lock()
+	c := count
+	mu.Unlock()
+	if c != 1 {
+		t.Errorf("expected 1 completion after lowering thresholds, got %d", c)
+	}
+}
+
 func TestTrackerResetOnNewWrite(t *testing.T) {
 	var mu sync.Mutex
 	var count int
@@ -158,6 +224,182 @@ func TestTrackerProcessRunningBlocksCompletion(t *testing.T) {
 	}
 }
 
+func TestTrackerForceComplete(t *testing.T) {
+	var mu sync.Mutex
+	var count int
+
+	tracker := newTestTracker(time.Hour, time.Hour, func(s *CompletedSession) {
+		mu.Lock()
+		count++
+		mu.Unlock()
+	})
+
+	dir := t.TempDir()
+	id := "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee"
+	path := dir + "/" + id + ".jsonl"
+	content := `{"type":"summary","sessionId":"aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee","timestamp":"2026-02-14T10:00:00Z"}
+`
+	os.WriteFile(path, []byte(content), 0644)
+	tracker.Touch(path)
+
+	if got, ok := tracker.TranscriptPath(id); !ok || got != path {
+		t.Errorf("TranscriptPath = %q, %v", got, ok)
+	}
+	if sessions := tracker.Sessions(); len(sessions) != 1 || sessions[0].State != StateRunning {
+		t.Errorf("Sessions = %+v", sessions)
+	}
+
+	cs, err := tracker.ForceComplete(id)
+	if err != nil {
+		t.Fatalf("ForceComplete: %v", err)
+	}
+	if cs.SessionID != id {
+		t.Errorf("session_id = %q", cs.SessionID)
+	}
+	if _, err := tracker.ForceComplete(id); err == nil {
+		t.Error("expected error forcing an already reported session")
+	}
+	if _, err := tracker.ForceComplete("unknown"); err == nil {
+		t.Error("expected error for an untracked session")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if count != 1 {
+		t.Errorf("expected 1 completion, got %d", count)
+	}
+}
+
+func TestTrackerForceCompleteParseFailure(t *testing.T) {
+	var count int
+	tracker := newTestTracker(time.Hour, time.Hour, func(*CompletedSession) { count++ })
+
+	dir := t.TempDir()
+	id := "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee"
+	path := dir + "/" + id + ".jsonl"
+	tracker.Touch(path) // not written yet, so it cannot be parsed
+
+	if _, err := tracker.ForceComplete(id); err == nil {
+		t.Fatal("expected error for an unparseable transcript")
+	}
+	if sessions := tracker.Sessions(); len(sessions) != 1 || sessions[0].State != StateRunning {
+		t.Fatalf("Sessions after failed ForceComplete = %+v, want still running", sessions)
+	}
+
+	os.WriteFile(path, []byte(`{"type":"summary","sessionId":"`+id+`","timestamp":"2026-02-14T10:00:00Z"}`+"\n"), 0644)
+	if _, err := tracker.ForceComplete(id); err != nil {
+		t.Fatalf("retry ForceComplete: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("expected 1 completion, got %d", count)
+	}
+}
+
+func TestTrackerLooksUpProcess(t *testing.T) {
+	tracker := newTestTracker(time.Hour, time.Hour, func(*CompletedSession) {})
+	var lookups int
+	tracker.processFind = func(string) int {
+		lookups++
+		return 42
+	}
+
+	dir := t.TempDir()
+	tracker.Touch(filepath.Join(dir, "a.jsonl"))
+	if lookups != 1 {
+		t.Errorf("processFind called %d times without OnProcess, want 1", lookups)
+	}
+	if sessions := tracker.Sessions(); len(sessions) != 1 || sessions[0].PID != 42 {
+		t.Errorf("Sessions = %+v, want PID 42", sessions)
+	}
+
+	var got []int
+	tracker.SetOnProcess(func(_ string, pid int) { got = append(got, pid) })
+	path := filepath.Join(dir, "b.jsonl")
+	tracker.Touch(path)
+	tracker.Touch(path) // still running: no new lookup
+	if lookups != 2 || !slices.Equal(got, []int{42}) {
+		t.Errorf("lookups = %d, OnProcess pids = %v, want 2 and [42]", lookups, got)
+	}
+
+	// A resumed session usually runs in a new process.
+	tracker.processFind = func(string) int {
+		lookups++
+		return 43
+	}
+	tracker.mu.Lock()
+	tracker.files[path].reported = true
+	tracker.mu.Unlock()
+	tracker.Touch(path)
+	if lookups != 3 || !slices.Equal(got, []int{42, 43}) {
+		t.Errorf("after resume: lookups = %d, OnProcess pids = %v, want 3 and [42 43]", lookups, got)
+	}
+}
+
+func TestFindWriter(t *testing.T) {
+	project := t.TempDir()
+	transcript := filepath.Join(t.TempDir(), "projects", pathToSlug(project), "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee.jsonl")
+	elsewhere := t.TempDir()
+
+	// proc builds a fake /proc from pid -> cwd; holders also have the
+	// transcript open.
+	proc := func(cwds map[int]string, holders ...int) string {
+		root := t.TempDir()
+		for pid, cwd := range cwds {
+			dir := filepath.Join(root, strconv.Itoa(pid))
+			os.MkdirAll(filepath.Join(dir, "fd"), 0755)
+			os.WriteFile(filepath.Join(dir, "cmdline"), []byte("claude\x00-p\x00"), 0644)
+			os.Symlink(cwd, filepath.Join(dir, "cwd"))
+		}
+		for _, pid := range holders {
+			os.Symlink(transcript, filepath.Join(root, strconv.Itoa(pid), "fd", "3"))
+		}
+		return root
+	}
+
+	tests := []struct {
+		name    string
+		cwds    map[int]string
+		holders []int
+		want    int
+	}{
+		{"only claude in project", map[int]string{10: project, 11: elsewhere}, nil, 10},
+		{"two claudes in project", map[int]string{10: project, 11: project}, nil, 0},
+		{"holder preferred", map[int]string{10: project, 11: project, 12: elsewhere}, []int{12}, 12},
+		{"none", map[int]string{11: elsewhere}, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := proc(tt.cwds, tt.holders...)
+			if got := findWriter(root, transcript); got != tt.want {
+				t.Errorf("findWriter = %d, want %d", got, tt.want)
+			}
+			if tt.want != 0 && !IsWriter(root, tt.want, transcript) {
+				t.Errorf("IsWriter(%d) = false", tt.want)
+			}
+		})
+	}
+}
+
+func TestHoldsOpen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "s.jsonl")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if !HoldsOpen("/proc", os.Getpid(), path) {
+		t.Error("HoldsOpen = false for a file this process has open")
+	}
+	if HoldsOpen("/proc", os.Getpid(), filepath.Join(dir, "other.jsonl")) {
+		t.Error("HoldsOpen = true for a file this process does not have open")
+	}
+	if HoldsOpen("/proc", 0, path) {
+		t.Error("HoldsOpen = true for PID 0")
+	}
+}
+
 func TestProjectDirFromTranscript(t *testing.T) {
 	// Create a real directory to act as the "project dir" so that
 	// projectDirFromTranscript's os.Stat check passes.
@@ -179,6 +421,17 @@ func TestProjectDirFromTranscript(t *testing.T) {
 	}
 }
 
+func TestProjectDirFromTranscript_Subagent(t *testing.T) {
+	realDir := t.TempDir()
+	session := filepath.Join(t.TempDir(), "projects", pathToSlug(realDir), "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee", "subagents")
+	os.MkdirAll(session, 0755)
+
+	got := projectDirFromTranscript(filepath.Join(session, "agent-a1b2c3.jsonl"))
+	if got != realDir {
+		t.Errorf("projectDirFromTranscript(subagent) = %q, want %q", got, realDir)
+	}
+}
+
 func TestProjectDirFromTranscript_NoProjectsParent(t *testing.T) {
 	// Path without "projects" parent directory should return "".
 	got := projectDirFromTranscript("/some/random/path/session.jsonl")
@@ -209,3 +462,197 @@ func pathToSlug(p string) string {
 	}
 	return string(result)
 }
+
+func TestTrackerFollowsActivity(t *testing.T) {
+	tracker := newTestTracker(time.Hour, time.Hour, func(*CompletedSession) {})
+
+	var acts []Activity
+	tracker.SetOnActivity(func(info SessionInfo, a []Activity) {
+		acts = append(acts, a...)
+	})
+
+	path := filepath.Join(t.TempDir(), "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee.jsonl")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	first := `{"type":"user","cwd":"/repo","message":{"role":"user","content":"run it"}}` + "\n"
+	f.WriteString(first)
+	// A partially written line is held back until it is complete.
+	f.WriteString(`{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"t1","name":"Bash",`)
+	tracker.Touch(path)
+	if len(acts) != 1 || acts[0].Kind != ActivityPrompt || acts[0].Text != "run it" || acts[0].CWD != "/repo" {
+		t.Fatalf("after first write: %+v", acts)
+	}
+
+	f.WriteString(`"input":{"command":"make"}}]}}` + "\n")
+	f.WriteString(`{"type":"user","message":{"role":"user","content":[{"type":"tool_result","tool_use_id":"t1","is_error":true,"content":"Exit code 2"}]}}` + "\n")
+	tracker.Touch(path)
+	if len(acts) != 3 {
+		t.Fatalf("after second write: %+v", acts)
+	}
+	if acts[1].Kind != ActivityToolUse || acts[1].Command != "make" {
+		t.Errorf("tool_use = %+v", acts[1])
+	}
+	if acts[2].Kind != ActivityToolResult || acts[2].Tool != "Bash" || !acts[2].IsError {
+		t.Errorf("tool_result = %+v", acts[2])
+	}
+	// Activities are located by the offset of their line, however it was
+	// read.
+	if acts[0].Offset != 0 || acts[1].Offset != int64(len(first)) || acts[2].Offset <= acts[1].Offset {
+		t.Errorf("offsets = %d, %d, %d", acts[0].Offset, acts[1].Offset, acts[2].Offset)
+	}
+
+	// A result carries the file its call named, so it can be withheld
+	// along with the call.
+	f.WriteString(`{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"t2","name":"Read","input":{"file_path":"/repo/.env"}}]}}` + "\n")
+	f.WriteString(`{"type":"user","message":{"role":"user","content":[{"type":"tool_result","tool_use_id":"t2","content":"SECRET=1"}]}}` + "\n")
+	tracker.Touch(path)
+	if len(acts) != 5 || acts[4].Kind != ActivityToolResult || acts[4].FilePath != "/repo/.env" {
+		t.Fatalf("read result = %+v", acts[3:])
+	}
+
+	// Nothing new, nothing emitted.
+	tracker.Touch(path)
+	if len(acts) != 5 {
+		t.Errorf("expected no new activity, got %+v", acts[5:])
+	}
+}
+
+func TestTrackerResumesEvictedTranscript(t *testing.T) {
+	tracker := newTestTracker(time.Hour, time.Hour, func(*CompletedSession) {})
+
+	var acts []Activity
+	tracker.SetOnActivity(func(info SessionInfo, a []Activity) {
+		acts = append(acts, a...)
+	})
+
+	path := filepath.Join(t.TempDir(), "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee.jsonl")
+	usage := func(id string) string {
+		return `{"type":"assistant","message":{"id":"` + id + `","role":"assistant","model":"claude-sonnet-4-5","content":[],"usage":{"input_tokens":10,"output_tokens":5}}}` + "\n"
+	}
+	os.WriteFile(path, []byte(usage("msg-1")), 0644)
+	tracker.Touch(path)
+	if len(acts) != 1 {
+		t.Fatalf("first write: %+v", acts)
+	}
+
+	// Reported long enough ago to be evicted.
+	tracker.mu.Lock()
+	tracker.files[path].reported = true
+	tracker.files[path].reportedAt = time.Now().Add(-cleanupGrace - time.Second)
+	tracker.mu.Unlock()
+	tracker.check()
+	if len(tracker.Sessions()) != 0 {
+		t.Fatal("expected the transcript to be evicted")
+	}
+
+	// The session resumes; only the new message is emitted.
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString(usage("msg-2"))
+	f.Close()
+	tracker.Touch(path)
+	if len(acts) != 2 || acts[1].MessageID != "msg-2" {
+		t.Errorf("after resume: %+v", acts)
+	}
+}
+
+func TestTrackerForget(t *testing.T) {
+	var completed []*CompletedSession
+	tracker := newTestTracker(time.Millisecond, time.Hour, func(s *CompletedSession) {
+		completed = append(completed, s)
+	})
+	var forgotten []string
+	tracker.SetOnForget(func(info SessionInfo) {
+		forgotten = append(forgotten, info.SessionID)
+	})
+
+	dir := t.TempDir()
+	project := filepath.Join(dir, "-home-mike-api")
+	os.MkdirAll(project, 0755)
+	a := filepath.Join(project, "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee.jsonl")
+	b := filepath.Join(project, "bbbbbbbb-bbbb-cccc-dddd-eeeeeeeeeeee.jsonl")
+	c := filepath.Join(dir, "cccccccc-bbbb-cccc-dddd-eeeeeeeeeeee.jsonl")
+	for _, p := range []string{a, b, c} {
+		os.WriteFile(p, []byte(`{"type":"summary"}`+"\n"), 0644)
+		tracker.Touch(p)
+	}
+
+	// One transcript deleted, then its whole project directory.
+	os.Remove(c)
+	tracker.Forget(c)
+	os.RemoveAll(project)
+	tracker.Forget(project)
+
+	if len(tracker.Sessions()) != 0 {
+		t.Errorf("sessions = %+v, want none", tracker.Sessions())
+	}
+	slices.Sort(forgotten)
+	want := []string{"aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee", "bbbbbbbb-bbbb-cccc-dddd-eeeeeeeeeeee", "cccccccc-bbbb-cccc-dddd-eeeeeeeeeeee"}
+	if !slices.Equal(forgotten, want) {
+		t.Errorf("forgotten = %v, want %v", forgotten, want)
+	}
+	if len(tracker.followers) != 0 {
+		t.Errorf("%d followers left", len(tracker.followers))
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	tracker.check()
+	if len(completed) != 0 {
+		t.Errorf("forgotten sessions completed: %+v", completed)
+	}
+}
+
+func TestTrackerRename(t *testing.T) {
+	tracker := newTestTracker(time.Hour, time.Hour, func(*CompletedSession) {})
+	var acts []Activity
+	tracker.SetOnActivity(func(info SessionInfo, a []Activity) {
+		acts = append(acts, a...)
+	})
+	var forgotten []string
+	tracker.SetOnForget(func(info SessionInfo) {
+		forgotten = append(forgotten, info.SessionID)
+	})
+
+	dir := t.TempDir()
+	id := "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee"
+	oldDir, newDir := filepath.Join(dir, "-home-mike-api"), filepath.Join(dir, "-home-mike-api2")
+	os.MkdirAll(oldDir, 0755)
+	path := filepath.Join(oldDir, id+".jsonl")
+	os.WriteFile(path, []byte(`{"type":"user","message":{"role":"user","content":"one"}}`+"\n"), 0644)
+	tracker.Touch(path)
+
+	// The project directory is moved; parsing carries on at the new path.
+	os.Rename(oldDir, newDir)
+	tracker.Rename(oldDir, newDir)
+	moved := filepath.Join(newDir, id+".jsonl")
+	f, _ := os.OpenFile(moved, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString(`{"type":"user","message":{"role":"user","content":"two"}}` + "\n")
+	f.Close()
+	tracker.Touch(moved)
+
+	sessions := tracker.Sessions()
+	if len(sessions) != 1 || sessions[0].TranscriptPath != moved || sessions[0].SessionID != id {
+		t.Fatalf("sessions = %+v, want one at %s", sessions, moved)
+	}
+	if len(acts) != 2 || acts[1].Text != "two" {
+		t.Errorf("activity = %+v, want each prompt once", acts)
+	}
+	if len(forgotten) != 0 {
+		t.Errorf("forgotten = %v, want none", forgotten)
+	}
+
+	// A temp transcript renamed over the real one replaces it.
+	tmp := filepath.Join(newDir, "tmp.jsonl")
+	os.WriteFile(tmp, []byte(`{"type":"user","message":{"role":"user","content":"one"}}`+"\n"), 0644)
+	tracker.Touch(tmp)
+	tracker.Rename(tmp, moved)
+	if sessions := tracker.Sessions(); len(sessions) != 1 || sessions[0].SessionID != id {
+		t.Errorf("sessions after atomic rename = %+v", sessions)
+	}
+	if !slices.Equal(forgotten, []string{"tmp"}) {
+		t.Errorf("forgotten = %v, want the temp file's session", forgotten)
+	}
+}
@@ -6,10 +6,15 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
+// maxSummaryText bounds the prompt and final message kept in a Summary.
+const maxSummaryText = 2000
+
 // jsonlLine represents a line from a Claude Code JSONL transcript.
 type jsonlLine struct {
 	Type      string          `json:"type"`
@@ -18,25 +23,89 @@ type jsonlLine struct {
 	Message   json.RawMessage `json:"message"`
 	CWD       string          `json:"cwd"`
 
-	// Tool use fields (nested in message).
-	ToolName string `json:"-"`
-	FilePath string `json:"-"`
+	Version        string `json:"version"`
+	GitBranch      string `json:"gitBranch"`
+	UserType       string `json:"userType"`
+	PermissionMode string `json:"permissionMode"`
+
+	// ToolUseResult is the structured result of a tool call, alongside the
+	// tool_result block in the message.
+	ToolUseResult json.RawMessage `json:"toolUseResult"`
 }
 
-// messageContent is used for extracting tool_use data.
+// messageContent is the message object of user and assistant lines.
 type messageContent struct {
+	ID      string          `json:"id"`
 	Role    string          `json:"role"`
+	Model   string          `json:"model"`
 	Content json.RawMessage `json:"content"`
+	Usage   *TokenUsage     `json:"usage"`
 }
 
+// syntheticModel marks assistant messages the CLI generates itself, e.g. for
+// API errors. They do not indicate a model switch.
+const syntheticModel = "<synthetic>"
+
 type contentBlock struct {
-	Type  string          `json:"type"`
-	Name  string          `json:"name"`
-	Input json.RawMessage `json:"input"`
+	Type      string          `json:"type"`
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Text      string          `json:"text"`
+	Input     json.RawMessage `json:"input"`
+	ToolUseID string          `json:"tool_use_id"`
+	IsError   bool            `json:"is_error"`
+	Content   json.RawMessage `json:"content"`
 }
 
 type toolInput struct {
-	FilePath string `json:"file_path"`
+	FilePath     string `json:"file_path"`
+	NotebookPath string `json:"notebook_path"`
+}
+
+// Summary describes what a session did, for triage without opening the
+// transcript.
+type Summary struct {
+	// FirstPrompt is the first user prompt, truncated.
+	FirstPrompt string `json:"first_prompt,omitempty"`
+	// FinalMessage is the last text the assistant wrote, truncated.
+	FinalMessage string `json:"final_message,omitempty"`
+	// Turns counts the prompts the user typed.
+	Turns int `json:"turns"`
+	// Tools counts invocations and failures per tool name.
+	Tools map[string]ToolStats `json:"tools,omitempty"`
+}
+
+// Metadata records the Claude Code environment of a session. Where a value
+// can change mid-session the latest one is kept.
+type Metadata struct {
+	Version        string `json:"version,omitempty"`
+	GitBranch      string `json:"git_branch,omitempty"`
+	UserType       string `json:"user_type,omitempty"`
+	PermissionMode string `json:"permission_mode,omitempty"`
+	// Model is the last model that responded.
+	Model string `json:"model,omitempty"`
+	// Models lists every model used, in order of first use.
+	Models        []string      `json:"models,omitempty"`
+	ModelSwitches []ModelSwitch `json:"model_switches,omitempty"`
+}
+
+// ModelSwitch is a change of model between assistant messages.
+type ModelSwitch struct {
+	From string    `json:"from"`
+	To   string    `json:"to"`
+	At   time.Time `json:"at,omitzero"`
+}
+
+// ToolStats counts invocations of one tool.
+type ToolStats struct {
+	Calls  int `json:"calls"`
+	Errors int `json:"errors"`
+}
+
+// ParseTranscript extracts session metadata from a JSONL transcript file.
+// It returns nil if the file cannot be read or has no session ID.
+func ParseTranscript(path string, logger *slog.Logger) *CompletedSession {
+	return parseTranscript(path, logger)
 }
 
 // parseTranscript extracts session metadata from a JSONL transcript file.
@@ -48,140 +117,404 @@ func parseTranscript(path string, logger *slog.Logger) *CompletedSession {
 	}
 	defer f.Close()
 
-	var (
-		sessionID        string
-		workingDir       string
-		filesChanged     = make(map[string]bool)
-		firstTS          time.Time
-		lastTS           time.Time
-		hasAssistantMsg  bool
-	)
+	p := newTranscriptParser()
 
 	scanner := bufio.NewScanner(f)
 	scanner.Buffer(make([]byte, 0, 1024*1024), 10*1024*1024) // 10MB max line
 
 	for scanner.Scan() {
-		line := scanner.Bytes()
-		if len(line) == 0 {
-			continue
-		}
+		p.feed(scanner.Bytes())
+	}
+
+	if scanner.Err() != nil {
+		logger.Warn("scanner error reading transcript", "path", path, "error", scanner.Err())
+	}
+
+	s := p.result(path)
+	if s == nil {
+		logger.Warn("could not determine session ID", "path", path)
+	}
+	return s
+}
+
+// transcriptParser accumulates session metadata one transcript line at a
+// time.
+type transcriptParser struct {
+	sessionID       string
+	workingDir      string
+	firstPrompt     string
+	filesChanged    map[string]bool
+	firstTS         time.Time
+	lastTS          time.Time
+	hasAssistantMsg bool
+
+	turns        int
+	finalMessage string
+	finalMsgID   string
+	tools        map[string]ToolStats
+	toolNames    map[string]string // tool_use ID -> tool name
+	toolPaths    map[string]string // tool_use ID -> file the call names
+
+	meta Metadata
+
+	commands        []Command
+	pendingCommands map[string]int // tool_use ID -> index in commands
+
+	messageUsage map[string]messageUsage // message ID -> usage so far
+	usage        map[string]TokenUsage   // model -> total
+
+	// emit, if set, receives the activities of each line as it is fed.
+	emit func(Activity)
+}
+
+func newTranscriptParser() *transcriptParser {
+	return &transcriptParser{
+		filesChanged: make(map[string]bool),
+		tools:        make(map[string]ToolStats),
+		toolNames:    make(map[string]string),
+		toolPaths:    make(map[string]string),
+
+		pendingCommands: make(map[string]int),
+		messageUsage:    make(map[string]messageUsage),
+		usage:           make(map[string]TokenUsage),
+	}
+}
 
-		var entry jsonlLine
-		if err := json.Unmarshal(line, &entry); err != nil {
-			continue // skip malformed lines
+// feed processes one transcript line. Malformed lines are skipped.
+func (p *transcriptParser) feed(line []byte) {
+	if len(line) == 0 {
+		return
+	}
+
+	var entry jsonlLine
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return // skip malformed lines
+	}
+
+	// Extract session ID.
+	if entry.SessionID != "" && p.sessionID == "" {
+		p.sessionID = entry.SessionID
+	}
+
+	// Extract working directory.
+	if entry.CWD != "" && p.workingDir == "" {
+		p.workingDir = entry.CWD
+	}
+
+	// Extract timestamps.
+	var ts time.Time
+	if entry.Timestamp != "" {
+		if t, err := time.Parse(time.RFC3339Nano, entry.Timestamp); err == nil {
+			ts = t
+			if p.firstTS.IsZero() {
+				p.firstTS = ts
+			}
+			p.lastTS = ts
 		}
+	}
+
+	p.feedMetadata(entry)
 
-		// Extract session ID.
-		if entry.SessionID != "" && sessionID == "" {
-			sessionID = entry.SessionID
+	// Track whether the session produced any assistant responses.
+	if entry.Type == "assistant" {
+		p.hasAssistantMsg = true
+	}
+
+	var msg messageContent
+	if len(entry.Message) == 0 || json.Unmarshal(entry.Message, &msg) != nil {
+		return
+	}
+	switch msg.Role {
+	case "user":
+		p.feedUser(msg, entry, ts)
+	case "assistant":
+		p.feedModel(msg.Model, ts)
+		p.feedAssistant(msg, entry, ts)
+		if msg.Usage != nil {
+			if delta := p.feedUsage(msg.ID, msg.Model, *msg.Usage); !delta.IsZero() {
+				p.emitActivity(Activity{
+					Kind:      ActivityUsage,
+					Timestamp: ts,
+					CWD:       entry.CWD,
+					MessageID: msg.ID,
+					Model:     msg.Model,
+					Usage:     &delta,
+				})
+			}
 		}
+	}
+}
 
-		// Extract working directory.
-		if entry.CWD != "" && workingDir == "" {
-			workingDir = entry.CWD
+// feedMetadata keeps the latest CLI environment fields.
+func (p *transcriptParser) feedMetadata(entry jsonlLine) {
+	if entry.Version != "" {
+		p.meta.Version = entry.Version
+	}
+	if entry.GitBranch != "" {
+		p.meta.GitBranch = entry.GitBranch
+	}
+	if entry.UserType != "" {
+		p.meta.UserType = entry.UserType
+	}
+	if entry.PermissionMode != "" {
+		p.meta.PermissionMode = entry.PermissionMode
+	}
+}
+
+// feedModel records the model of an assistant message and any switch from
+// the previous one.
+func (p *transcriptParser) feedModel(model string, at time.Time) {
+	if model == "" || model == syntheticModel || model == p.meta.Model {
+		return
+	}
+	if p.meta.Model != "" {
+		p.meta.ModelSwitches = append(p.meta.ModelSwitches, ModelSwitch{From: p.meta.Model, To: model, At: at})
+	}
+	if !slices.Contains(p.meta.Models, model) {
+		p.meta.Models = append(p.meta.Models, model)
+	}
+	p.meta.Model = model
+}
+
+// feedUser counts typed prompts and records tool results.
+func (p *transcriptParser) feedUser(msg messageContent, entry jsonlLine, ts time.Time) {
+	base := Activity{Timestamp: ts, CWD: entry.CWD}
+
+	if text := messageText(msg); text != "" {
+		p.turns++
+		// Capture the first prompt typed by the user.
+		if p.firstPrompt == "" {
+			p.firstPrompt = text
 		}
+		act := base
+		act.Kind, act.Text = ActivityPrompt, text
+		p.emitActivity(act)
+		return
+	}
 
-		// Extract timestamps.
-		if entry.Timestamp != "" {
-			if ts, err := time.Parse(time.RFC3339Nano, entry.Timestamp); err == nil {
-				if firstTS.IsZero() {
-					firstTS = ts
-				}
-				lastTS = ts
-			}
+	var blocks []contentBlock
+	if err := json.Unmarshal(msg.Content, &blocks); err != nil {
+		return
+	}
+	for _, block := range blocks {
+		if block.Type != "tool_result" {
+			continue
 		}
+		p.finishCommand(block, entry.ToolUseResult, ts)
 
-		// Track whether the session produced any assistant responses.
-		if entry.Type == "assistant" {
-			hasAssistantMsg = true
+		name := p.toolNames[block.ToolUseID]
+		if name == "" {
+			name = "unknown"
+		}
+		if block.IsError {
+			st := p.tools[name]
+			st.Errors++
+			p.tools[name] = st
 		}
 
-		// Extract file changes from tool_use entries.
-		extractFileChanges(line, filesChanged)
+		act := base
+		act.Kind = ActivityToolResult
+		act.Tool, act.ToolUseID, act.IsError = name, block.ToolUseID, block.IsError
+		act.FilePath = p.toolPaths[block.ToolUseID]
+		delete(p.toolPaths, block.ToolUseID)
+		act.Text = resultText(block.Content)
+		p.emitActivity(act)
 	}
+}
 
-	if scanner.Err() != nil {
-		logger.Warn("scanner error reading transcript", "path", path, "error", scanner.Err())
+// feedAssistant records tool calls, file changes and the latest text.
+func (p *transcriptParser) feedAssistant(msg messageContent, entry jsonlLine, ts time.Time) {
+	var blocks []contentBlock
+	if err := json.Unmarshal(msg.Content, &blocks); err != nil {
+		return
 	}
 
-	// Fall back to extracting session ID from filename.
-	if sessionID == "" {
-		sessionID = extractSessionIDFromPath(path)
+	base := Activity{Timestamp: ts, CWD: entry.CWD, MessageID: msg.ID}
+	for _, block := range blocks {
+		switch block.Type {
+		case "text":
+			if strings.TrimSpace(block.Text) == "" {
+				continue
+			}
+			// One message may be split across several lines; keep all of
+			// its text.
+			if msg.ID != "" && msg.ID == p.finalMsgID {
+				p.finalMessage += "\n" + block.Text
+			} else {
+				p.finalMessage = block.Text
+			}
+			p.finalMsgID = msg.ID
+
+			act := base
+			act.Kind, act.Text = ActivityText, block.Text
+			p.emitActivity(act)
+		case "tool_use":
+			p.toolNames[block.ID] = block.Name
+			st := p.tools[block.Name]
+			st.Calls++
+			p.tools[block.Name] = st
+
+			act := base
+			act.Kind = ActivityToolUse
+			act.Tool, act.ToolUseID, act.Input = block.Name, block.ID, block.Input
+
+			var input toolInput
+			_ = json.Unmarshal(block.Input, &input)
+			act.FilePath = input.FilePath
+			if act.FilePath == "" {
+				act.FilePath = input.NotebookPath
+			}
+			if act.FilePath != "" {
+				p.toolPaths[block.ID] = act.FilePath
+			}
+
+			switch block.Name {
+			case "Bash":
+				p.startCommand(block, entry.CWD, ts)
+				var bash bashInput
+				_ = json.Unmarshal(block.Input, &bash)
+				act.Command = bash.Command
+			case "Write", "Edit":
+				// Extract file changes from Write/Edit tool_use blocks.
+				if input.FilePath != "" {
+					p.filesChanged[input.FilePath] = true
+				}
+			}
+			p.emitActivity(act)
+		}
+	}
+}
+
+func (p *transcriptParser) emitActivity(a Activity) {
+	if p.emit != nil {
+		p.emit(a)
 	}
+}
 
+// result builds the completed session, or returns nil if no session ID
+// could be determined.
+func (p *transcriptParser) result(path string) *CompletedSession {
+	// Fall back to extracting session ID from filename. Subagent entries
+	// carry their parent's session ID, so those always use the filename.
+	parent := subagentParent(path)
+	sessionID := p.sessionID
+	if sessionID == "" || parent != "" {
+		sessionID = SessionIDFromPath(path)
+	}
 	if sessionID == "" {
-		logger.Warn("could not determine session ID", "path", path)
 		return nil
 	}
 
-	files := make([]string, 0, len(filesChanged))
-	for f := range filesChanged {
+	files := make([]string, 0, len(p.filesChanged))
+	for f := range p.filesChanged {
 		files = append(files, f)
 	}
 
 	var durationMs int64
-	if !firstTS.IsZero() && !lastTS.IsZero() {
-		durationMs = lastTS.Sub(firstTS).Milliseconds()
+	if !p.firstTS.IsZero() && !p.lastTS.IsZero() {
+		durationMs = p.lastTS.Sub(p.firstTS).Milliseconds()
 	}
 
 	// Determine exit code heuristically. CC JSONL transcripts don't record
 	// explicit exit codes. A session with no assistant messages likely indicates
 	// a startup failure or crash.
 	exitCode := 0
-	if !hasAssistantMsg {
+	if !p.hasAssistantMsg {
 		exitCode = 1
 	}
 
+	summary := &Summary{
+		FirstPrompt:  truncate(p.firstPrompt, maxSummaryText),
+		FinalMessage: truncate(strings.TrimSpace(p.finalMessage), maxSummaryText),
+		Turns:        p.turns,
+	}
+	if len(p.tools) > 0 {
+		summary.Tools = make(map[string]ToolStats, len(p.tools))
+		for name, st := range p.tools {
+			summary.Tools[name] = st
+		}
+	}
+
 	return &CompletedSession{
 		SessionID:      sessionID,
 		TranscriptPath: path,
 		FilesChanged:   files,
-		WorkingDir:     workingDir,
+		WorkingDir:     p.workingDir,
+		FirstPrompt:    p.firstPrompt,
 		DurationMs:     durationMs,
 		ExitCode:       exitCode,
+		Summary:        summary,
+		Metadata:       p.metadata(),
+		Commands:       slices.Clone(p.commands),
+		Usage:          p.usageByModel(),
+
+		ParentSessionID: parent,
 	}
 }
 
-// extractFileChanges looks for Write/Edit tool_use blocks and extracts file_path.
-func extractFileChanges(line []byte, files map[string]bool) {
-	var msg struct {
-		Message messageContent `json:"message"`
+// usageByModel returns a copy of the token totals, or nil if there are none.
+func (p *transcriptParser) usageByModel() map[string]TokenUsage {
+	if len(p.usage) == 0 {
+		return nil
 	}
-	if err := json.Unmarshal(line, &msg); err != nil {
-		return
+	out := make(map[string]TokenUsage, len(p.usage))
+	for model, u := range p.usage {
+		out[model] = u
 	}
+	return out
+}
 
-	if msg.Message.Role != "assistant" {
-		return
+// metadata returns a copy of the collected metadata, or nil if there is none.
+func (p *transcriptParser) metadata() *Metadata {
+	m := p.meta
+	if m.Version == "" && m.GitBranch == "" && m.UserType == "" && m.PermissionMode == "" && m.Model == "" {
+		return nil
 	}
+	m.Models = slices.Clone(m.Models)
+	m.ModelSwitches = slices.Clone(m.ModelSwitches)
+	return &m
+}
 
-	var blocks []contentBlock
-	if err := json.Unmarshal(msg.Message.Content, &blocks); err != nil {
-		return
+// messageText returns the text of a message. Content may be a plain string
+// or a list of blocks; tool results carried in user messages are not text and
+// yield "".
+func messageText(msg messageContent) string {
+	var text string
+	if err := json.Unmarshal(msg.Content, &text); err == nil {
+		return strings.TrimSpace(text)
 	}
 
+	var blocks []contentBlock
+	if err := json.Unmarshal(msg.Content, &blocks); err != nil {
+		return ""
+	}
+	var parts []string
 	for _, block := range blocks {
-		if block.Type != "tool_use" {
-			continue
-		}
-		if block.Name != "Write" && block.Name != "Edit" {
-			continue
+		if block.Type == "text" && block.Text != "" {
+			parts = append(parts, block.Text)
 		}
+	}
+	return strings.TrimSpace(strings.Join(parts, "\n"))
+}
 
-		var input toolInput
-		if err := json.Unmarshal(block.Input, &input); err != nil {
-			continue
-		}
-		if input.FilePath != "" {
-			files[input.FilePath] = true
-		}
+// truncate shortens s to at most n runes, marking the cut with an ellipsis.
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
 	}
+	runes := []rune(s)
+	return string(runes[:n-1]) + "…"
 }
 
-// extractSessionIDFromPath pulls a UUID-like portion from the transcript filename.
-func extractSessionIDFromPath(path string) string {
+// SessionIDFromPath returns the ID a transcript is tracked under: a
+// UUID-like portion of the filename. Subagent transcripts are named agent-<id>.jsonl, which is only
+// unique within their session, so they are keyed <parent>-agent-<id>.
+func SessionIDFromPath(path string) string {
 	base := filepath.Base(path)
 	base = strings.TrimSuffix(base, ".jsonl")
+	if parent := subagentParent(path); parent != "" {
+		return parent + "-" + base
+	}
 
 	// The filename is typically a UUID like cfa3335c-ea38-4cf8-a1f2-9e3cf9789708
 	// or may have a prefix. Return the last UUID-length segment.
@@ -196,6 +529,17 @@ func extractSessionIDFromPath(path string) string {
 	return base
 }
 
+// subagentParent returns the session a subagent transcript, at
+// <project>/<session>/subagents/agent-<id>.jsonl, belongs to, or "" for
+// any other transcript.
+func subagentParent(path string) string {
+	dir := filepath.Dir(path)
+	if filepath.Base(dir) != "subagents" {
+		return ""
+	}
+	return filepath.Base(filepath.Dir(dir))
+}
+
 func isUUIDLike(s string) bool {
 	if len(s) != 36 {
 		return false
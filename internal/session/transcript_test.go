@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func testLogger() *slog.Logger {
@@ -65,7 +66,7 @@ func TestParseTranscript_EmptyFile(t *testing.T) {
 
 	result := parseTranscript(path, testLogger())
 	// Empty file with non-UUID filename → no session ID can be determined → nil.
-	// But if filename is "empty" (5 chars, not UUID-like), extractSessionIDFromPath
+	// But if filename is "empty" (5 chars, not UUID-like), SessionIDFromPath
 	// returns "empty", which is non-empty. The function only returns nil when
 	// sessionID is "". Since "empty" is returned, the result is non-nil but with
 	// no useful data. This is acceptable — the sidecar only processes files from
@@ -118,6 +119,30 @@ func TestParseTranscript_MissingFile(t *testing.T) {
 	}
 }
 
+func TestParseTranscript_Subagent(t *testing.T) {
+	parent := "cfa3335c-ea38-4cf8-a1f2-9e3cf9789708"
+	dir := filepath.Join(t.TempDir(), "-home-mike", parent, "subagents")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "agent-a1b2.jsonl")
+	content := `{"type":"user","sessionId":"` + parent + `","agentId":"a1b2","message":{"role":"user","content":"look around"},"timestamp":"2026-02-14T10:00:00Z"}
+{"type":"assistant","sessionId":"` + parent + `","agentId":"a1b2","message":{"role":"assistant","content":[{"type":"text","text":"Done"}]},"timestamp":"2026-02-14T10:01:00Z"}
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result := parseTranscript(path, testLogger())
+	if result == nil {
+		t.Fatal("expected non-nil result")
+	}
+	// Keyed apart from the parent, whose ID its entries carry.
+	if result.SessionID != parent+"-agent-a1b2" || result.ParentSessionID != parent {
+		t.Errorf("session_id = %q, parent_session_id = %q", result.SessionID, result.ParentSessionID)
+	}
+}
+
 func TestExtractSessionIDFromPath(t *testing.T) {
 	tests := []struct {
 		path string
@@ -126,12 +151,13 @@ func TestExtractSessionIDFromPath(t *testing.T) {
 		{"/home/mike/.claude/projects/-home-mike/cfa3335c-ea38-4cf8-a1f2-9e3cf9789708.jsonl", "cfa3335c-ea38-4cf8-a1f2-9e3cf9789708"},
 		{"/tmp/abcdef01-2345-6789-abcd-ef0123456789.jsonl", "abcdef01-2345-6789-abcd-ef0123456789"},
 		{"/tmp/short.jsonl", "short"},
+		{"/p/-home-mike/cfa3335c-ea38-4cf8-a1f2-9e3cf9789708/subagents/agent-a1b2.jsonl", "cfa3335c-ea38-4cf8-a1f2-9e3cf9789708-agent-a1b2"},
 	}
 
 	for _, tt := range tests {
-		got := extractSessionIDFromPath(tt.path)
+		got := SessionIDFromPath(tt.path)
 		if got != tt.want {
-			t.Errorf("extractSessionIDFromPath(%q) = %q, want %q", tt.path, got, tt.want)
+			t.Errorf("SessionIDFromPath(%q) = %q, want %q", tt.path, got, tt.want)
 		}
 	}
 }
@@ -213,3 +239,195 @@ func TestParseTranscript_DeduplicatesFiles(t *testing.T) {
 		t.Errorf("expected 1 unique file, got %d", len(result.FilesChanged))
 	}
 }
+
+func TestParseTranscript_FirstPrompt(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cccc1111-2222-3333-4444-555555555555.jsonl")
+
+	// Tool results arrive as user messages and must not count as the prompt.
+	content := `{"type":"user","message":{"role":"user","content":[{"type":"tool_result","tool_use_id":"t1","content":"ok"}]},"timestamp":"2026-02-14T10:00:00Z"}
+{"type":"user","message":{"role":"user","content":[{"type":"text","text":"  [task:ABC-1] fix the bug  "}]},"timestamp":"2026-02-14T10:00:01Z"}
+{"type":"user","message":{"role":"user","content":"second prompt"},"timestamp":"2026-02-14T10:00:02Z"}
+`
+	os.WriteFile(path, []byte(content), 0644)
+
+	result := parseTranscript(path, testLogger())
+	if result == nil {
+		t.Fatal("expected non-nil result")
+	}
+	if result.FirstPrompt != "[task:ABC-1] fix the bug" {
+		t.Errorf("first prompt = %q, want %q", result.FirstPrompt, "[task:ABC-1] fix the bug")
+	}
+}
+
+func TestParseTranscript_Summary(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dddd1111-2222-3333-4444-555555555555.jsonl")
+
+	content := `{"type":"user","message":{"role":"user","content":"fix the tests"},"timestamp":"2026-02-14T10:00:00Z"}
+{"type":"assistant","message":{"id":"m1","role":"assistant","content":[{"type":"text","text":"Looking."}]},"timestamp":"2026-02-14T10:00:01Z"}
+{"type":"assistant","message":{"id":"m1","role":"assistant","content":[{"type":"tool_use","id":"t1","name":"Bash","input":{"command":"go test"}}]},"timestamp":"2026-02-14T10:00:02Z"}
+{"type":"user","message":{"role":"user","content":[{"type":"tool_result","tool_use_id":"t1","is_error":true,"content":"FAIL"}]},"timestamp":"2026-02-14T10:00:03Z"}
+{"type":"assistant","message":{"id":"m2","role":"assistant","content":[{"type":"tool_use","id":"t2","name":"Bash","input":{"command":"go test"}}]},"timestamp":"2026-02-14T10:00:04Z"}
+{"type":"assistant","message":{"id":"m2","role":"assistant","content":[{"type":"tool_use","id":"t3","name":"Read","input":{"file_path":"/x.go"}}]},"timestamp":"2026-02-14T10:00:04Z"}
+{"type":"user","message":{"role":"user","content":[{"type":"tool_result","tool_use_id":"t2","content":"ok"}]},"timestamp":"2026-02-14T10:00:05Z"}
+{"type":"user","message":{"role":"user","content":"thanks"},"timestamp":"2026-02-14T10:00:06Z"}
+{"type":"assistant","message":{"id":"m3","role":"assistant","content":[{"type":"text","text":"All tests pass."}]},"timestamp":"2026-02-14T10:00:07Z"}
+{"type":"assistant","message":{"id":"m3","role":"assistant","content":[{"type":"text","text":"Summary: fixed."}]},"timestamp":"2026-02-14T10:00:07Z"}
+`
+	os.WriteFile(path, []byte(content), 0644)
+
+	result := parseTranscript(path, testLogger())
+	if result == nil || result.Summary == nil {
+		t.Fatal("expected summary")
+	}
+	s := result.Summary
+	if s.FirstPrompt != "fix the tests" || s.Turns != 2 {
+		t.Errorf("first prompt = %q, turns = %d", s.FirstPrompt, s.Turns)
+	}
+	if s.FinalMessage != "All tests pass.\nSummary: fixed." {
+		t.Errorf("final message = %q", s.FinalMessage)
+	}
+	if s.Tools["Bash"] != (ToolStats{Calls: 2, Errors: 1}) || s.Tools["Read"] != (ToolStats{Calls: 1}) {
+		t.Errorf("tools = %+v", s.Tools)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got := truncate("héllo wörld", 5); got != "héll…" {
+		t.Errorf("truncate = %q", got)
+	}
+	if got := truncate("short", 10); got != "short" {
+		t.Errorf("truncate = %q", got)
+	}
+}
+
+func TestParseTranscript_Metadata(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "eeee1111-2222-3333-4444-555555555555.jsonl")
+
+	content := `{"type":"user","version":"2.0.14","gitBranch":"main","userType":"external","permissionMode":"default","message":{"role":"user","content":"hi"},"timestamp":"2026-02-14T10:00:00Z"}
+{"type":"assistant","version":"2.0.14","message":{"id":"m1","role":"assistant","model":"claude-sonnet-4-5","content":[{"type":"text","text":"a"}]},"timestamp":"2026-02-14T10:00:01Z"}
+{"type":"assistant","message":{"id":"m2","role":"assistant","model":"<synthetic>","content":[{"type":"text","text":"API Error"}]},"timestamp":"2026-02-14T10:00:02Z"}
+{"type":"user","version":"2.0.15","gitBranch":"feature/x","permissionMode":"acceptEdits","message":{"role":"user","content":"/model opus"},"timestamp":"2026-02-14T10:00:03Z"}
+{"type":"assistant","message":{"id":"m3","role":"assistant","model":"claude-opus-4-1","content":[{"type":"text","text":"b"}]},"timestamp":"2026-02-14T10:00:04Z"}
+{"type":"assistant","message":{"id":"m4","role":"assistant","model":"claude-sonnet-4-5","content":[{"type":"text","text":"c"}]},"timestamp":"2026-02-14T10:00:05Z"}
+`
+	os.WriteFile(path, []byte(content), 0644)
+
+	result := parseTranscript(path, testLogger())
+	if result == nil || result.Metadata == nil {
+		t.Fatal("expected metadata")
+	}
+	m := result.Metadata
+	if m.Version != "2.0.15" || m.GitBranch != "feature/x" || m.UserType != "external" || m.PermissionMode != "acceptEdits" {
+		t.Errorf("metadata = %+v", m)
+	}
+	if m.Model != "claude-sonnet-4-5" {
+		t.Errorf("model = %q", m.Model)
+	}
+	if len(m.Models) != 2 || m.Models[0] != "claude-sonnet-4-5" || m.Models[1] != "claude-opus-4-1" {
+		t.Errorf("models = %v", m.Models)
+	}
+	if len(m.ModelSwitches) != 2 {
+		t.Fatalf("model switches = %+v", m.ModelSwitches)
+	}
+	first := m.ModelSwitches[0]
+	if first.From != "claude-sonnet-4-5" || first.To != "claude-opus-4-1" || !first.At.Equal(time.Date(2026, 2, 14, 10, 0, 4, 0, time.UTC)) {
+		t.Errorf("first switch = %+v", first)
+	}
+}
+
+func TestParseTranscript_NoMetadata(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ffff1111-2222-3333-4444-555555555555.jsonl")
+	os.WriteFile(path, []byte(`{"type":"summary","sessionId":"ffff1111-2222-3333-4444-555555555555"}`+"\n"), 0644)
+
+	if result := parseTranscript(path, testLogger()); result == nil || result.Metadata != nil {
+		t.Errorf("expected nil metadata, got %+v", result)
+	}
+}
+
+func TestParseTranscript_Commands(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "abab1111-2222-3333-4444-555555555555.jsonl")
+
+	content := `{"type":"assistant","cwd":"/repo","message":{"role":"assistant","content":[{"type":"tool_use","id":"b1","name":"Bash","input":{"command":"go test ./...","description":"Run tests"}}]},"timestamp":"2026-02-14T10:00:00Z"}
+{"type":"user","cwd":"/repo","message":{"role":"user","content":[{"type":"tool_result","tool_use_id":"b1","is_error":true,"content":"Exit code 1\nFAIL pkg"}]},"toolUseResult":"Error: Exit code 1\nFAIL pkg","timestamp":"2026-02-14T10:00:02.5Z"}
+{"type":"assistant","cwd":"/repo/sub","message":{"role":"assistant","content":[{"type":"tool_use","id":"b2","name":"Bash","input":{"command":"ls"}}]},"timestamp":"2026-02-14T10:00:03Z"}
+{"type":"user","cwd":"/repo/sub","message":{"role":"user","content":[{"type":"tool_result","tool_use_id":"b2","content":[{"type":"text","text":"a.go"}]}]},"toolUseResult":{"stdout":"a.go\n","stderr":"","interrupted":false},"timestamp":"2026-02-14T10:00:03.1Z"}
+{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"b3","name":"Bash","input":{"command":"sleep 100"}}]},"timestamp":"2026-02-14T10:00:04Z"}
+`
+	os.WriteFile(path, []byte(content), 0644)
+
+	result := parseTranscript(path, testLogger())
+	if result == nil || len(result.Commands) != 3 {
+		t.Fatalf("expected 3 commands, got %+v", result)
+	}
+
+	failed := result.Commands[0]
+	if failed.Command != "go test ./..." || failed.Description != "Run tests" || failed.WorkingDir != "/repo" {
+		t.Errorf("command = %+v", failed)
+	}
+	if !failed.Error || failed.ExitCode == nil || *failed.ExitCode != 1 || failed.DurationMs != 2500 {
+		t.Errorf("failed command result = %+v", failed)
+	}
+	if failed.Output != "Exit code 1\nFAIL pkg" {
+		t.Errorf("output = %q", failed.Output)
+	}
+
+	ok := result.Commands[1]
+	if ok.ExitCode == nil || *ok.ExitCode != 0 || ok.Output != "a.go" || ok.WorkingDir != "/repo/sub" {
+		t.Errorf("successful command = %+v", ok)
+	}
+
+	if pending := result.Commands[2]; pending.ExitCode != nil || pending.Output != "" {
+		t.Errorf("command without result = %+v", pending)
+	}
+}
+
+func TestParseTranscript_Usage(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "acac1111-2222-3333-4444-555555555555.jsonl")
+
+	// m1 is split across two lines that repeat its usage, with output
+	// tokens growing on the second.
+	content := `{"type":"assistant","message":{"id":"m1","role":"assistant","model":"claude-sonnet-4-5","content":[{"type":"text","text":"a"}],"usage":{"input_tokens":10,"cache_creation_input_tokens":100,"cache_read_input_tokens":1000,"output_tokens":1}},"timestamp":"2026-02-14T10:00:00Z"}
+{"type":"assistant","message":{"id":"m1","role":"assistant","model":"claude-sonnet-4-5","content":[{"type":"tool_use","id":"t1","name":"Bash","input":{"command":"ls"}}],"usage":{"input_tokens":10,"cache_creation_input_tokens":100,"cache_read_input_tokens":1000,"output_tokens":50}},"timestamp":"2026-02-14T10:00:01Z"}
+{"type":"assistant","message":{"id":"m2","role":"assistant","model":"claude-opus-4-1","content":[{"type":"text","text":"b"}],"usage":{"input_tokens":5,"output_tokens":20}},"timestamp":"2026-02-14T10:00:02Z"}
+{"type":"assistant","message":{"id":"m3","role":"assistant","model":"<synthetic>","content":[{"type":"text","text":"API Error"}],"usage":{"input_tokens":0,"output_tokens":0}},"timestamp":"2026-02-14T10:00:03Z"}
+`
+	os.WriteFile(path, []byte(content), 0644)
+
+	result := parseTranscript(path, testLogger())
+	if result == nil || len(result.Usage) != 2 {
+		t.Fatalf("expected usage for 2 models, got %+v", result)
+	}
+	want := TokenUsage{InputTokens: 10, OutputTokens: 50, CacheCreationInputTokens: 100, CacheReadInputTokens: 1000}
+	if got := result.Usage["claude-sonnet-4-5"]; got != want {
+		t.Errorf("sonnet usage = %+v, want %+v", got, want)
+	}
+	if got := result.Usage["claude-opus-4-1"]; got != (TokenUsage{InputTokens: 5, OutputTokens: 20}) {
+		t.Errorf("opus usage = %+v", got)
+	}
+}
+
+func TestParser_UsageActivityIsIncremental(t *testing.T) {
+	var got []TokenUsage
+	p := newTranscriptParser()
+	p.emit = func(a Activity) {
+		if a.Kind == ActivityUsage {
+			got = append(got, *a.Usage)
+		}
+	}
+	p.feed([]byte(`{"type":"assistant","message":{"id":"m1","role":"assistant","model":"m","content":[],"usage":{"input_tokens":10,"output_tokens":1}}}`))
+	p.feed([]byte(`{"type":"assistant","message":{"id":"m1","role":"assistant","model":"m","content":[],"usage":{"input_tokens":10,"output_tokens":1}}}`))
+	p.feed([]byte(`{"type":"assistant","message":{"id":"m1","role":"assistant","model":"m","content":[],"usage":{"input_tokens":10,"output_tokens":7}}}`))
+
+	if len(got) != 2 {
+		t.Fatalf("usage activities = %+v, want 2", got)
+	}
+	if got[0] != (TokenUsage{InputTokens: 10, OutputTokens: 1}) || got[1] != (TokenUsage{OutputTokens: 6}) {
+		t.Errorf("usage deltas = %+v", got)
+	}
+}
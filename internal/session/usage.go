@@ -0,0 +1,74 @@
+package session
+
+// TokenUsage counts the tokens of one or more API calls.
+type TokenUsage struct {
+	InputTokens              int64 `json:"input_tokens"`
+	OutputTokens             int64 `json:"output_tokens"`
+	CacheCreationInputTokens int64 `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int64 `json:"cache_read_input_tokens"`
+}
+
+// Add accumulates o into u.
+func (u *TokenUsage) Add(o TokenUsage) {
+	u.InputTokens += o.InputTokens
+	u.OutputTokens += o.OutputTokens
+	u.CacheCreationInputTokens += o.CacheCreationInputTokens
+	u.CacheReadInputTokens += o.CacheReadInputTokens
+}
+
+// IsZero reports whether no tokens were counted.
+func (u TokenUsage) IsZero() bool {
+	return u == TokenUsage{}
+}
+
+// Total is the sum of all token kinds.
+func (u TokenUsage) Total() int64 {
+	return u.InputTokens + u.OutputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
+}
+
+// messageUsage is the usage recorded so far for one assistant message.
+type messageUsage struct {
+	model string
+	usage TokenUsage
+}
+
+// feedUsage records the usage reported on an assistant line and returns the
+// increase over what was already counted for the message. Claude Code writes
+// one line per content block and repeats the message's usage on each, with
+// output tokens growing as the response streams, so usage is taken as the
+// per-field maximum over a message's lines rather than summed.
+func (p *transcriptParser) feedUsage(msgID, model string, u TokenUsage) TokenUsage {
+	if model == syntheticModel {
+		return TokenUsage{}
+	}
+	if msgID == "" {
+		p.addUsage(model, u)
+		return u
+	}
+
+	prev := p.messageUsage[msgID]
+	cur := TokenUsage{
+		InputTokens:              max(prev.usage.InputTokens, u.InputTokens),
+		OutputTokens:             max(prev.usage.OutputTokens, u.OutputTokens),
+		CacheCreationInputTokens: max(prev.usage.CacheCreationInputTokens, u.CacheCreationInputTokens),
+		CacheReadInputTokens:     max(prev.usage.CacheReadInputTokens, u.CacheReadInputTokens),
+	}
+	delta := TokenUsage{
+		InputTokens:              cur.InputTokens - prev.usage.InputTokens,
+		OutputTokens:             cur.OutputTokens - prev.usage.OutputTokens,
+		CacheCreationInputTokens: cur.CacheCreationInputTokens - prev.usage.CacheCreationInputTokens,
+		CacheReadInputTokens:     cur.CacheReadInputTokens - prev.usage.CacheReadInputTokens,
+	}
+	p.messageUsage[msgID] = messageUsage{model: model, usage: cur}
+	p.addUsage(model, delta)
+	return delta
+}
+
+func (p *transcriptParser) addUsage(model string, u TokenUsage) {
+	if u.IsZero() {
+		return
+	}
+	total := p.usage[model]
+	total.Add(u)
+	p.usage[model] = total
+}
@@ -0,0 +1,321 @@
+// Package stall detects sessions that are stuck or looping: repeating the
+// same tool call, hitting the same error over and over, writing nothing
+// while the process stays alive, or simply running too long.
+package stall
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/session"
+)
+
+// Reasons a session is considered stalled.
+const (
+	ReasonRepeatedCall  = "repeated_tool_call"
+	ReasonRepeatedError = "repeated_error"
+	ReasonNoProgress    = "no_progress"
+	ReasonWallClock     = "wall_clock"
+)
+
+const (
+	// callWindow is how many recent tool calls are compared for repeats.
+	callWindow = 20
+	// maxEvidence bounds the tool input and error text quoted as evidence.
+	maxEvidence = 500
+)
+
+// Config enables detectors; a zero value disables the detector.
+type Config struct {
+	// RepeatedCalls fires when one identical tool call appears this many
+	// times among the last 20 calls.
+	RepeatedCalls int
+	// RepeatedErrors fires when the same tool error is returned this many
+	// times.
+	RepeatedErrors int
+	// NoProgress fires when the transcript has not been written for this
+	// long while the claude process is still alive.
+	NoProgress time.Duration
+	// MaxDuration fires when a session has been running this long, counted
+	// from its first transcript entry so that a sidecar restart, which reads
+	// transcripts from the start again, does not reset it.
+	MaxDuration time.Duration
+	// CheckInterval is how often the time-based detectors run.
+	CheckInterval time.Duration
+}
+
+// Stall describes why a session is considered stuck, with evidence.
+type Stall struct {
+	Reason       string    `json:"reason"`
+	Detail       string    `json:"detail"`
+	Tool         string    `json:"tool,omitempty"`
+	Input        string    `json:"input,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	Count        int       `json:"count,omitempty"`
+	StartedAt    time.Time `json:"started_at,omitzero"`
+	LastActivity time.Time `json:"last_activity,omitzero"`
+	At           time.Time `json:"at"`
+}
+
+// OnStall is called once per detected stall.
+type OnStall func(info session.SessionInfo, s Stall)
+
+type call struct {
+	tool  string
+	input string
+}
+
+// sessionState is what the detector remembers about one session.
+type sessionState struct {
+	info   session.SessionInfo
+	calls  []call // most recent last, at most callWindow
+	errors map[string]int
+	fired  map[string]bool
+
+	// started is the earliest activity timestamp seen, zero until one is.
+	started time.Time
+}
+
+// Detector watches session activity and state for stalls.
+type Detector struct {
+	cfg     Config
+	onStall OnStall
+	logger  *slog.Logger
+
+	mu       sync.Mutex
+	sessions map[string]*sessionState
+	done     chan struct{}
+	retick   chan struct{}
+}
+
+// New creates a detector.
+func New(cfg Config, onStall OnStall, logger *slog.Logger) *Detector {
+	return &Detector{
+		cfg:      withDefaults(cfg),
+		onStall:  onStall,
+		logger:   logger.With("component", "stall"),
+		sessions: make(map[string]*sessionState),
+		done:     make(chan struct{}),
+		retick:   make(chan struct{}, 1),
+	}
+}
+
+func withDefaults(cfg Config) Config {
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = time.Minute
+	}
+	return cfg
+}
+
+// SetConfig replaces the thresholds while the detector runs. Session state
+// is kept, so stalls already reported do not fire again.
+func (d *Detector) SetConfig(cfg Config) {
+	cfg = withDefaults(cfg)
+	d.mu.Lock()
+	changed := cfg.CheckInterval != d.cfg.CheckInterval
+	d.cfg = cfg
+	d.mu.Unlock()
+
+	if changed {
+		select {
+		case d.retick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (d *Detector) state(info session.SessionInfo) *sessionState {
+	st, ok := d.sessions[info.SessionID]
+	if !ok {
+		st = &sessionState{
+			info:   info,
+			errors: make(map[string]int),
+			fired:  make(map[string]bool),
+		}
+		d.sessions[info.SessionID] = st
+	}
+	return st
+}
+
+// ObserveState records liveness changes. It matches session.OnState.
+func (d *Detector) ObserveState(info session.SessionInfo) {
+	d.mu.Lock()
+	st := d.state(info)
+	st.info = info
+	if info.State == session.StateRunning {
+		// Writing again; a later silence is a new stall.
+		delete(st.fired, ReasonNoProgress)
+	}
+	d.mu.Unlock()
+}
+
+// Observe checks new activity for loops. It matches session.OnActivity.
+func (d *Detector) Observe(info session.SessionInfo, acts []session.Activity) {
+	var found []Stall
+
+	d.mu.Lock()
+	st := d.state(info)
+	for _, a := range acts {
+		if !a.Timestamp.IsZero() && (st.started.IsZero() || a.Timestamp.Before(st.started)) {
+			st.started = a.Timestamp
+		}
+		switch {
+		case a.Kind == session.ActivityToolUse && d.cfg.RepeatedCalls > 0:
+			c := call{tool: a.Tool, input: string(a.Input)}
+			st.calls = append(st.calls, c)
+			if len(st.calls) > callWindow {
+				st.calls = st.calls[len(st.calls)-callWindow:]
+			}
+			n := 0
+			for _, prev := range st.calls {
+				if prev == c {
+					n++
+				}
+			}
+			key := ReasonRepeatedCall + "\x00" + c.tool + "\x00" + c.input
+			if n >= d.cfg.RepeatedCalls && !st.fired[key] {
+				st.fired[key] = true
+				found = append(found, Stall{
+					Reason: ReasonRepeatedCall,
+					Detail: fmt.Sprintf("%s called %d times with the same input in the last %d calls", c.tool, n, len(st.calls)),
+					Tool:   c.tool,
+					Input:  truncate(c.input),
+					Count:  n,
+				})
+			}
+		case a.Kind == session.ActivityToolResult && a.IsError && d.cfg.RepeatedErrors > 0:
+			text := truncate(a.Text)
+			st.errors[a.Tool+"\x00"+text]++
+			n := st.errors[a.Tool+"\x00"+text]
+			key := ReasonRepeatedError + "\x00" + a.Tool + "\x00" + text
+			if n >= d.cfg.RepeatedErrors && !st.fired[key] {
+				st.fired[key] = true
+				found = append(found, Stall{
+					Reason: ReasonRepeatedError,
+					Detail: fmt.Sprintf("%s failed %d times with the same error", a.Tool, n),
+					Tool:   a.Tool,
+					Error:  text,
+					Count:  n,
+				})
+			}
+		}
+	}
+	started := st.startedAt()
+	d.mu.Unlock()
+
+	for i := range found {
+		found[i].StartedAt = started
+	}
+	d.report(info, found)
+}
+
+// Start runs the time-based detectors. Blocks until Stop.
+func (d *Detector) Start() {
+	d.mu.Lock()
+	ticker := time.NewTicker(d.cfg.CheckInterval)
+	d.mu.Unlock()
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			d.check(time.Now())
+		case <-d.retick:
+			d.mu.Lock()
+			ticker.Reset(d.cfg.CheckInterval)
+			d.mu.Unlock()
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// Stop halts the detector.
+func (d *Detector) Stop() {
+	close(d.done)
+}
+
+// check fires the no-progress and wall-clock detectors.
+func (d *Detector) check(now time.Time) {
+	type stalled struct {
+		info  session.SessionInfo
+		stall Stall
+	}
+	var found []stalled
+
+	d.mu.Lock()
+	for _, st := range d.sessions {
+		info := st.info
+		if info.State == session.StateReported {
+			continue
+		}
+		if d.cfg.NoProgress > 0 && info.State == session.StateIdle && !st.fired[ReasonNoProgress] {
+			if silent := now.Sub(info.LastActivity); silent >= d.cfg.NoProgress {
+				st.fired[ReasonNoProgress] = true
+				found = append(found, stalled{info, Stall{
+					Reason:    ReasonNoProgress,
+					Detail:    fmt.Sprintf("no transcript writes for %s while the process is alive", silent.Round(time.Second)),
+					StartedAt: st.startedAt(),
+				}})
+			}
+		}
+		started := st.startedAt()
+		if d.cfg.MaxDuration > 0 && !started.IsZero() && !st.fired[ReasonWallClock] {
+			if running := now.Sub(started); running >= d.cfg.MaxDuration {
+				st.fired[ReasonWallClock] = true
+				found = append(found, stalled{info, Stall{
+					Reason:    ReasonWallClock,
+					Detail:    fmt.Sprintf("running for %s, over the %s cap", running.Round(time.Second), d.cfg.MaxDuration),
+					StartedAt: started,
+				}})
+			}
+		}
+	}
+	d.mu.Unlock()
+
+	for _, f := range found {
+		d.report(f.info, []Stall{f.stall})
+	}
+}
+
+// startedAt is when the session began: its first transcript entry, or when
+// the tracker first saw it if no entry had a timestamp.
+func (st *sessionState) startedAt() time.Time {
+	if !st.started.IsZero() {
+		return st.started
+	}
+	return st.info.StartedAt
+}
+
+func (d *Detector) report(info session.SessionInfo, stalls []Stall) {
+	for _, s := range stalls {
+		s.LastActivity = info.LastActivity
+		s.At = time.Now().UTC()
+		d.logger.Warn("session stalled", "session_id", info.SessionID, "reason", s.Reason, "detail", s.Detail)
+		if d.onStall != nil {
+			d.onStall(info, s)
+		}
+	}
+}
+
+// Forget drops the state of a finished session.
+func (d *Detector) Forget(sessionID string) {
+	d.mu.Lock()
+	delete(d.sessions, sessionID)
+	d.mu.Unlock()
+}
+
+// truncate shortens s to maxEvidence bytes without splitting a rune.
+func truncate(s string) string {
+	if len(s) <= maxEvidence {
+		return s
+	}
+	n := maxEvidence
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "…"
+}
@@ -0,0 +1,150 @@
+package stall
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/session"
+)
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+}
+
+func newTestDetector(cfg Config) (*Detector, *[]Stall) {
+	var stalls []Stall
+	d := New(cfg, func(_ session.SessionInfo, s Stall) { stalls = append(stalls, s) }, testLogger())
+	return d, &stalls
+}
+
+func bash(cmd string) session.Activity {
+	input, _ := json.Marshal(map[string]string{"command": cmd})
+	return session.Activity{Kind: session.ActivityToolUse, Tool: "Bash", Input: input}
+}
+
+func TestRepeatedCalls(t *testing.T) {
+	d, stalls := newTestDetector(Config{RepeatedCalls: 3})
+	info := session.SessionInfo{SessionID: "s1"}
+
+	d.Observe(info, []session.Activity{bash("make"), bash("ls"), bash("make")})
+	if len(*stalls) != 0 {
+		t.Fatalf("fired early: %+v", *stalls)
+	}
+	d.Observe(info, []session.Activity{bash("make"), bash("make")})
+	if len(*stalls) != 1 {
+		t.Fatalf("expected one stall, got %+v", *stalls)
+	}
+	s := (*stalls)[0]
+	if s.Reason != ReasonRepeatedCall || s.Tool != "Bash" || s.Count != 3 || !strings.Contains(s.Input, "make") {
+		t.Errorf("stall = %+v", s)
+	}
+}
+
+func TestRepeatedCalls_OutsideWindow(t *testing.T) {
+	d, stalls := newTestDetector(Config{RepeatedCalls: 2})
+	info := session.SessionInfo{SessionID: "s1"}
+
+	acts := []session.Activity{bash("make")}
+	for i := 0; i < callWindow; i++ {
+		acts = append(acts, bash(strings.Repeat("x", i+1)))
+	}
+	acts = append(acts, bash("make"))
+	d.Observe(info, acts)
+	if len(*stalls) != 0 {
+		t.Errorf("calls %d apart should not count as a loop: %+v", callWindow+1, *stalls)
+	}
+}
+
+func TestSetConfig(t *testing.T) {
+	d, stalls := newTestDetector(Config{RepeatedCalls: 5})
+	info := session.SessionInfo{SessionID: "s1"}
+
+	d.Observe(info, []session.Activity{bash("make"), bash("make")})
+	d.SetConfig(Config{RepeatedCalls: 3})
+	if len(*stalls) != 0 {
+		t.Fatalf("fired early: %+v", *stalls)
+	}
+	// Calls seen before the change still count towards the loop.
+	d.Observe(info, []session.Activity{bash("make")})
+	if len(*stalls) != 1 || (*stalls)[0].Count != 3 {
+		t.Fatalf("expected one stall of 3 calls, got %+v", *stalls)
+	}
+}
+
+func TestRepeatedErrors(t *testing.T) {
+	d, stalls := newTestDetector(Config{RepeatedErrors: 2})
+	info := session.SessionInfo{SessionID: "s1"}
+	fail := session.Activity{Kind: session.ActivityToolResult, Tool: "Edit", IsError: true, Text: "old_string not found"}
+	other := session.Activity{Kind: session.ActivityToolResult, Tool: "Edit", IsError: true, Text: "file not read"}
+
+	d.Observe(info, []session.Activity{fail, other, fail, fail})
+	if len(*stalls) != 1 {
+		t.Fatalf("expected one stall, got %+v", *stalls)
+	}
+	if s := (*stalls)[0]; s.Reason != ReasonRepeatedError || s.Error != "old_string not found" || s.Count != 2 {
+		t.Errorf("stall = %+v", s)
+	}
+}
+
+func TestNoProgressAndWallClock(t *testing.T) {
+	d, stalls := newTestDetector(Config{NoProgress: 10 * time.Minute, MaxDuration: time.Hour})
+	now := time.Now()
+
+	d.ObserveState(session.SessionInfo{SessionID: "quiet", State: session.StateIdle, StartedAt: now.Add(-20 * time.Minute), LastActivity: now.Add(-15 * time.Minute)})
+	d.ObserveState(session.SessionInfo{SessionID: "busy", State: session.StateRunning, StartedAt: now.Add(-2 * time.Hour), LastActivity: now})
+	d.ObserveState(session.SessionInfo{SessionID: "fresh", State: session.StateIdle, StartedAt: now.Add(-5 * time.Minute), LastActivity: now.Add(-time.Minute)})
+
+	d.check(now)
+	d.check(now) // fires once
+	if len(*stalls) != 2 {
+		t.Fatalf("expected two stalls, got %+v", *stalls)
+	}
+	reasons := map[string]bool{}
+	for _, s := range *stalls {
+		reasons[s.Reason] = true
+	}
+	if !reasons[ReasonNoProgress] || !reasons[ReasonWallClock] {
+		t.Errorf("reasons = %v", reasons)
+	}
+
+	// Progress re-arms the no-progress detector.
+	d.Forget("fresh")
+	d.ObserveState(session.SessionInfo{SessionID: "quiet", State: session.StateRunning, StartedAt: now.Add(-20 * time.Minute), LastActivity: now})
+	d.ObserveState(session.SessionInfo{SessionID: "quiet", State: session.StateIdle, StartedAt: now.Add(-20 * time.Minute), LastActivity: now})
+	d.check(now.Add(11 * time.Minute))
+	if len(*stalls) != 3 || (*stalls)[2].Reason != ReasonNoProgress {
+		t.Errorf("expected no-progress to fire again, got %+v", *stalls)
+	}
+}
+
+func TestWallClock_CountsFromFirstEntry(t *testing.T) {
+	d, stalls := newTestDetector(Config{MaxDuration: time.Hour})
+	now := time.Now()
+
+	// The sidecar restarted a minute ago and re-read a transcript begun two
+	// hours earlier.
+	info := session.SessionInfo{SessionID: "s1", State: session.StateRunning, StartedAt: now.Add(-time.Minute), LastActivity: now}
+	d.ObserveState(info)
+	d.Observe(info, []session.Activity{
+		{Kind: session.ActivityPrompt, Timestamp: now.Add(-2 * time.Hour)},
+		{Kind: session.ActivityText, Timestamp: now.Add(-time.Minute)},
+	})
+	d.check(now)
+	if len(*stalls) != 1 || (*stalls)[0].Reason != ReasonWallClock || !(*stalls)[0].StartedAt.Equal(now.Add(-2*time.Hour)) {
+		t.Errorf("stalls = %+v, want a wall-clock stall from the first entry", *stalls)
+	}
+}
+
+func TestForget(t *testing.T) {
+	d, stalls := newTestDetector(Config{MaxDuration: time.Minute})
+	d.ObserveState(session.SessionInfo{SessionID: "s1", State: session.StateRunning, StartedAt: time.Now().Add(-time.Hour)})
+	d.Forget("s1")
+	d.check(time.Now())
+	if len(*stalls) != 0 {
+		t.Errorf("forgotten session fired: %+v", *stalls)
+	}
+}
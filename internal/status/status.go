@@ -0,0 +1,331 @@
+// Package status maintains a live per-session status record in a NATS KV
+// bucket so that other services can look up a session's state without
+// consuming the event stream.
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/redact"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/session"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// DefaultBucket is the KV bucket holding session status records.
+const DefaultBucket = "CC_SESSION_STATUS"
+
+// States reported in addition to the tracker's running and idle states.
+const (
+	StateCompleted session.State = "completed"
+	StateFailed    session.State = "failed"
+)
+
+// Status is the record stored under the session ID key.
+type Status struct {
+	SessionID      string        `json:"session_id"`
+	State          session.State `json:"state"`
+	Host           string        `json:"host"`
+	PID            int           `json:"pid,omitempty"`
+	WorkingDir     string        `json:"working_dir,omitempty"`
+	TranscriptPath string        `json:"transcript_path"`
+	StartedAt      time.Time     `json:"started_at,omitzero"`
+	LastActivity   time.Time     `json:"last_activity"`
+	UpdatedAt      time.Time     `json:"updated_at"`
+
+	// Summary stats, filled in on completion.
+	FilesChanged int   `json:"files_changed"`
+	DurationMs   int64 `json:"duration_ms,omitempty"`
+	ExitCode     *int  `json:"exit_code,omitempty"`
+}
+
+// Store writes status records asynchronously. Updates are coalesced per
+// session; repeated updates in the same state are written at most once per
+// throttle interval, while state changes are written immediately.
+type Store struct {
+	bucket   string
+	ttl      time.Duration
+	host     string
+	throttle time.Duration
+	logger   *slog.Logger
+	redactor *redact.Redactor
+
+	mu       sync.Mutex
+	js       jetstream.JetStream
+	kv       jetstream.KeyValue
+	pending  map[string]Status
+	deleted  map[string]bool
+	started  map[string]time.Time
+	written  map[string]written
+	wake     chan struct{}
+	done     chan struct{}
+	finished chan struct{}
+}
+
+// written remembers the last record written for a session.
+type written struct {
+	state session.State
+	at    time.Time
+}
+
+// New creates a status store. The bucket is created with the given TTL on
+// first use if it does not exist.
+func New(js jetstream.JetStream, bucket string, ttl, throttle time.Duration, host string, logger *slog.Logger) *Store {
+	if bucket == "" {
+		bucket = DefaultBucket
+	}
+	return &Store{
+		js:       js,
+		bucket:   bucket,
+		ttl:      ttl,
+		host:     host,
+		throttle: throttle,
+		logger:   logger.With("component", "status"),
+		pending:  make(map[string]Status),
+		deleted:  make(map[string]bool),
+		started:  make(map[string]time.Time),
+		written:  make(map[string]written),
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+}
+
+// SetJetStream moves writes to a new connection, e.g. after the publisher
+// reconnects. Pending records are kept and written there.
+func (s *Store) SetJetStream(js jetstream.JetStream) {
+	s.mu.Lock()
+	s.js = js
+	s.kv = nil
+	s.mu.Unlock()
+}
+
+// SetRedactor scrubs records before they are written. Must be called before
+// Start.
+func (s *Store) SetRedactor(r *redact.Redactor) {
+	s.redactor = r
+}
+
+// Observe records tracker activity. It matches session.OnState so it can be
+// registered with the tracker directly, and never blocks.
+func (s *Store) Observe(ev session.SessionInfo) {
+	s.enqueue(Status{
+		SessionID:      ev.SessionID,
+		State:          ev.State,
+		PID:            ev.PID,
+		WorkingDir:     ev.WorkingDir,
+		TranscriptPath: ev.TranscriptPath,
+		StartedAt:      ev.StartedAt,
+		LastActivity:   ev.LastActivity,
+	})
+}
+
+// Completed records the final state of a session.
+func (s *Store) Completed(cs *session.CompletedSession) {
+	state := StateCompleted
+	if cs.ExitCode != 0 {
+		state = StateFailed
+	}
+	exitCode := cs.ExitCode
+	s.enqueue(Status{
+		SessionID:      cs.SessionID,
+		State:          state,
+		PID:            cs.PID,
+		WorkingDir:     cs.WorkingDir,
+		TranscriptPath: cs.TranscriptPath,
+		LastActivity:   time.Now().UTC(),
+		FilesChanged:   len(cs.FilesChanged),
+		DurationMs:     cs.DurationMs,
+		ExitCode:       &exitCode,
+	})
+}
+
+// Forget drops a session whose transcript went away: pending updates are
+// discarded and its record is deleted from the bucket. It never blocks.
+func (s *Store) Forget(sessionID string) {
+	s.mu.Lock()
+	delete(s.pending, sessionID)
+	delete(s.started, sessionID)
+	delete(s.written, sessionID)
+	s.deleted[sessionID] = true
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Store) enqueue(st Status) {
+	if st.SessionID == "" {
+		return
+	}
+	s.mu.Lock()
+	if st.StartedAt.IsZero() {
+		st.StartedAt = s.started[st.SessionID]
+	} else {
+		s.started[st.SessionID] = st.StartedAt
+	}
+	if st.State == StateCompleted || st.State == StateFailed {
+		delete(s.started, st.SessionID)
+	}
+	s.pending[st.SessionID] = st
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start runs the write loop. Blocks until Stop.
+func (s *Store) Start() {
+	defer close(s.finished)
+
+	// Throttled updates become due without a new wake-up, so re-check
+	// periodically.
+	interval := s.throttle
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.wake:
+			s.flush(false)
+		case <-ticker.C:
+			s.flush(false)
+		case <-s.done:
+			s.flush(true)
+			return
+		}
+	}
+}
+
+// Stop flushes pending updates and halts the write loop.
+func (s *Store) Stop() {
+	close(s.done)
+	<-s.finished
+}
+
+// flush writes pending records that are due. With force, throttling is
+// ignored so nothing is lost on shutdown.
+func (s *Store) flush(force bool) {
+	now := time.Now()
+
+	s.mu.Lock()
+	deleted := s.deleted
+	s.deleted = make(map[string]bool)
+	var due []Status
+	for id, st := range s.pending {
+		last, ok := s.written[id]
+		if !force && ok && last.state == st.State && now.Sub(last.at) < s.throttle {
+			continue
+		}
+		due = append(due, st)
+		delete(s.pending, id)
+		if st.State == StateCompleted || st.State == StateFailed {
+			delete(s.written, id)
+		} else {
+			s.written[id] = written{state: st.State, at: now}
+		}
+	}
+	s.mu.Unlock()
+
+	// Deletions go first: an update queued after Forget is newer.
+	for id := range deleted {
+		if err := s.delete(id); err != nil {
+			s.logger.Warn("failed to delete session status", "session_id", id, "error", err)
+		}
+	}
+	for _, st := range due {
+		if err := s.put(st); err != nil {
+			s.logger.Warn("failed to write session status", "session_id", st.SessionID, "state", st.State, "error", err)
+		}
+	}
+}
+
+func (s *Store) put(st Status) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	kv, err := s.bucketKV(ctx)
+	if err != nil {
+		return err
+	}
+
+	st.Host = s.host
+	st.UpdatedAt = time.Now().UTC()
+	raw, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	if raw, err = s.redactor.JSON(raw, redact.Report{}); err != nil {
+		return err
+	}
+	_, err = kv.Put(ctx, st.SessionID, raw)
+	return err
+}
+
+func (s *Store) delete(sessionID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	kv, err := s.bucketKV(ctx)
+	if err != nil {
+		return err
+	}
+	return kv.Delete(ctx, sessionID)
+}
+
+// bucketKV returns the bucket handle, creating the bucket if needed.
+func (s *Store) bucketKV(ctx context.Context) (jetstream.KeyValue, error) {
+	s.mu.Lock()
+	js, kv := s.js, s.kv
+	s.mu.Unlock()
+	if kv != nil {
+		return kv, nil
+	}
+
+	kv, err := js.KeyValue(ctx, s.bucket)
+	if errors.Is(err, jetstream.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(ctx, jetstream.KeyValueConfig{
+			Bucket:      s.bucket,
+			Description: "cc-sidecar session status",
+			TTL:         s.ttl,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	if s.js == js {
+		s.kv = kv
+	}
+	s.mu.Unlock()
+	return kv, nil
+}
+
+// Get reads the status of a session from the bucket.
+func Get(ctx context.Context, js jetstream.JetStream, bucket, sessionID string) (*Status, error) {
+	kv, err := js.KeyValue(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := kv.Get(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	var st Status
+	if err := json.Unmarshal(entry.Value(), &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
@@ -0,0 +1,222 @@
+package status
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/natstest"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/session"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+}
+
+func testJetStream(t *testing.T) jetstream.JetStream {
+	t.Helper()
+	s := natstest.Run(t, nil)
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return js
+}
+
+// waitForState polls the bucket until the session reaches the given state.
+func waitForState(t *testing.T, js jetstream.JetStream, sessionID string, state session.State) *Status {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		st, err := Get(context.Background(), js, DefaultBucket, sessionID)
+		if err == nil && st.State == state {
+			return st
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("session %s did not reach state %s (last: %+v, err: %v)", sessionID, state, st, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStore_Lifecycle(t *testing.T) {
+	js := testJetStream(t)
+	store := New(js, "", time.Hour, 50*time.Millisecond, "devbox", testLogger())
+	go store.Start()
+	defer store.Stop()
+
+	started := time.Now().Add(-time.Minute).UTC()
+	store.Observe(session.SessionInfo{
+		SessionID:      "sess-1",
+		TranscriptPath: "/p/sess-1.jsonl",
+		WorkingDir:     "/home/mike/Warren",
+		State:          session.StateRunning,
+		PID:            1234,
+		StartedAt:      started,
+		LastActivity:   time.Now(),
+	})
+
+	st := waitForState(t, js, "sess-1", session.StateRunning)
+	if st.PID != 1234 || st.WorkingDir != "/home/mike/Warren" || st.Host != "devbox" {
+		t.Errorf("unexpected running status: %+v", st)
+	}
+
+	store.Observe(session.SessionInfo{SessionID: "sess-1", TranscriptPath: "/p/sess-1.jsonl", State: session.StateIdle, PID: 1234})
+	waitForState(t, js, "sess-1", session.StateIdle)
+
+	store.Completed(&session.CompletedSession{
+		SessionID:    "sess-1",
+		FilesChanged: []string{"/a.go", "/b.go"},
+		DurationMs:   60000,
+		ExitCode:     0,
+	})
+	st = waitForState(t, js, "sess-1", StateCompleted)
+	if st.FilesChanged != 2 || st.DurationMs != 60000 || st.ExitCode == nil || *st.ExitCode != 0 {
+		t.Errorf("unexpected completed status: %+v", st)
+	}
+	if !st.StartedAt.Equal(started) {
+		t.Errorf("started_at = %v, want %v carried over from running state", st.StartedAt, started)
+	}
+
+	kv, _ := js.KeyValue(context.Background(), DefaultBucket)
+	kvStatus, _ := kv.Status(context.Background())
+	if kvStatus.TTL() != time.Hour {
+		t.Errorf("bucket TTL = %v, want 1h", kvStatus.TTL())
+	}
+}
+
+func TestStore_FailedState(t *testing.T) {
+	js := testJetStream(t)
+	store := New(js, "", 0, time.Second, "devbox", testLogger())
+	go store.Start()
+	defer store.Stop()
+
+	store.Completed(&session.CompletedSession{SessionID: "sess-f", ExitCode: 1})
+	st := waitForState(t, js, "sess-f", StateFailed)
+	if st.ExitCode == nil || *st.ExitCode != 1 {
+		t.Errorf("exit_code = %v, want 1", st.ExitCode)
+	}
+}
+
+func TestStore_SetJetStream(t *testing.T) {
+	first, second := testJetStream(t), testJetStream(t)
+	store := New(first, "", 0, time.Second, "devbox", testLogger())
+	go store.Start()
+	defer store.Stop()
+
+	store.Observe(session.SessionInfo{SessionID: "sess-1", State: session.StateRunning, StartedAt: time.Now()})
+	waitForState(t, first, "sess-1", session.StateRunning)
+
+	// The bucket is created on the new server and the session's start time
+	// carries over.
+	store.SetJetStream(second)
+	store.Completed(&session.CompletedSession{SessionID: "sess-1"})
+	if st := waitForState(t, second, "sess-1", StateCompleted); st.StartedAt.IsZero() {
+		t.Errorf("started_at lost across SetJetStream: %+v", st)
+	}
+}
+
+func TestStore_ThrottlesSameState(t *testing.T) {
+	js := testJetStream(t)
+	store := New(js, "", 0, time.Hour, "devbox", testLogger())
+	go store.Start()
+	defer store.Stop()
+
+	ev := session.SessionInfo{SessionID: "sess-t", State: session.StateRunning}
+	store.Observe(ev)
+	waitForState(t, js, "sess-t", session.StateRunning)
+
+	kv, _ := js.KeyValue(context.Background(), DefaultBucket)
+	entry, _ := kv.Get(context.Background(), "sess-t")
+	rev := entry.Revision()
+
+	// Further activity within the throttle interval is coalesced.
+	for i := 0; i < 10; i++ {
+		store.Observe(ev)
+	}
+	time.Sleep(100 * time.Millisecond)
+	entry, _ = kv.Get(context.Background(), "sess-t")
+	if entry.Revision() != rev {
+		t.Errorf("revision changed from %d to %d within throttle interval", rev, entry.Revision())
+	}
+
+	// A state change bypasses the throttle.
+	ev.State = session.StateIdle
+	store.Observe(ev)
+	waitForState(t, js, "sess-t", session.StateIdle)
+}
+
+func TestStore_StopFlushesPending(t *testing.T) {
+	js := testJetStream(t)
+	store := New(js, "", 0, time.Hour, "devbox", testLogger())
+	go store.Start()
+
+	ev := session.SessionInfo{SessionID: "sess-s", State: session.StateRunning}
+	store.Observe(ev)
+	waitForState(t, js, "sess-s", session.StateRunning)
+
+	ev.LastActivity = time.Now().UTC()
+	store.Observe(ev)
+	store.Stop()
+
+	st, err := Get(context.Background(), js, DefaultBucket, "sess-s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.LastActivity.Equal(ev.LastActivity) {
+		t.Errorf("last_activity = %v, want %v flushed on stop", st.LastActivity, ev.LastActivity)
+	}
+}
+
+func TestStore_Forget(t *testing.T) {
+	js := testJetStream(t)
+	store := New(js, "", time.Hour, time.Hour, "devbox", testLogger())
+	go store.Start()
+	defer store.Stop()
+
+	started := time.Now().Add(-time.Minute).UTC()
+	store.Observe(session.SessionInfo{SessionID: "sess-f", State: session.StateRunning, StartedAt: started})
+	waitForState(t, js, "sess-f", session.StateRunning)
+
+	// A throttled update still pending when the transcript goes away must
+	// not bring the record back.
+	store.Observe(session.SessionInfo{SessionID: "sess-f", State: session.StateRunning})
+	store.Forget("sess-f")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := Get(context.Background(), js, DefaultBucket, "sess-f")
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("record not deleted: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	store.mu.Lock()
+	_, pending := store.pending["sess-f"]
+	_, start := store.started["sess-f"]
+	_, written := store.written["sess-f"]
+	store.mu.Unlock()
+	if pending || start || written {
+		t.Errorf("state kept after Forget: pending %v, started %v, written %v", pending, start, written)
+	}
+
+	// A session seen again afterwards is written as new.
+	store.Observe(session.SessionInfo{SessionID: "sess-f", State: session.StateIdle})
+	if st := waitForState(t, js, "sess-f", session.StateIdle); !st.StartedAt.IsZero() {
+		t.Errorf("started_at = %v, want none after Forget", st.StartedAt)
+	}
+}
@@ -0,0 +1,308 @@
+// Package stream turns transcript activity into a live, per-session feed of
+// normalised messages: user prompts, assistant text, tool calls and tool
+// results. Each session's messages carry a sequence number so consumers can
+// order them and spot gaps.
+package stream
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/redact"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/session"
+)
+
+// Roles of a streamed message.
+const (
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// Defaults for a zero Config.
+const (
+	DefaultMaxText  = 16 * 1024
+	DefaultMaxInput = 16 * 1024
+	DefaultMaxQueue = 10000
+)
+
+// Config bounds what is streamed.
+type Config struct {
+	// MaxText is the most bytes of text, command or tool result kept per
+	// message; longer values are cut and the message marked truncated.
+	MaxText int
+	// MaxInput is the largest tool input kept, in bytes. Larger inputs are
+	// dropped and only their size is sent.
+	MaxInput int
+	// MaxQueue is how many messages may wait for publishing. Messages
+	// beyond it are dropped; their sequence numbers are still used, so the
+	// gap is visible downstream.
+	MaxQueue int
+}
+
+// seqTTL is how long the sequence counter of a quiet session is kept. It
+// matches how long the tracker resumes an evicted transcript, so a session
+// that is written to again carries on numbering where it left off instead
+// of reusing sequence numbers.
+const seqTTL = 24 * time.Hour
+
+// pruneInterval bounds how often idle counters are looked for.
+const pruneInterval = time.Minute
+
+// Message is one normalised transcript item.
+type Message struct {
+	SessionID  string               `json:"session_id"`
+	Seq        uint64               `json:"seq"`
+	Role       string               `json:"role"`
+	Kind       session.ActivityKind `json:"kind"`
+	Timestamp  time.Time            `json:"timestamp,omitzero"`
+	MessageID  string               `json:"message_id,omitempty"`
+	WorkingDir string               `json:"working_dir,omitempty"`
+	Text       string               `json:"text,omitempty"`
+
+	Tool       string          `json:"tool,omitempty"`
+	ToolUseID  string          `json:"tool_use_id,omitempty"`
+	Input      json.RawMessage `json:"input,omitempty"`
+	InputBytes int             `json:"input_bytes,omitempty"`
+	FilePath   string          `json:"file_path,omitempty"`
+	Command    string          `json:"command,omitempty"`
+	IsError    bool            `json:"is_error,omitempty"`
+
+	Truncated  bool          `json:"truncated,omitempty"`
+	Redactions redact.Report `json:"redactions,omitempty"`
+
+	// Offset and Index locate the message in the transcript: the byte
+	// offset of its line and its position among the line's activities.
+	// Unlike Seq, which restarts with the sidecar, they stay the same when
+	// the transcript is read again.
+	Offset int64 `json:"offset"`
+	Index  int   `json:"index,omitempty"`
+}
+
+// Publish sends one message. It is called from the streamer's goroutine.
+type Publish func(m Message) error
+
+// Streamer numbers, bounds and redacts activity, then hands it to a
+// publisher in order.
+type Streamer struct {
+	cfg      Config
+	publish  Publish
+	redactor *redact.Redactor
+	logger   *slog.Logger
+
+	mu      sync.Mutex
+	seq     map[string]*counter
+	pruned  time.Time
+	queue   []Message
+	dropped int
+
+	wake chan struct{}
+	done chan struct{}
+	stop sync.Once
+	exit chan struct{}
+}
+
+// counter is a session's last sequence number.
+type counter struct {
+	n    uint64
+	seen time.Time
+}
+
+// New creates a streamer. Call Start to begin publishing.
+func New(cfg Config, publish Publish, logger *slog.Logger) *Streamer {
+	if cfg.MaxText <= 0 {
+		cfg.MaxText = DefaultMaxText
+	}
+	if cfg.MaxInput <= 0 {
+		cfg.MaxInput = DefaultMaxInput
+	}
+	if cfg.MaxQueue <= 0 {
+		cfg.MaxQueue = DefaultMaxQueue
+	}
+	return &Streamer{
+		cfg:     cfg,
+		publish: publish,
+		logger:  logger.With("component", "stream"),
+		seq:     make(map[string]*counter),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		exit:    make(chan struct{}),
+	}
+}
+
+// SetRedactor scrubs messages before they are truncated, so a secret cut
+// in half by a size limit is still caught. It must be called before
+// Observe.
+func (s *Streamer) SetRedactor(r *redact.Redactor) {
+	s.redactor = r
+}
+
+// Observe queues the messages in acts. It never blocks on publishing and is
+// intended as a Tracker OnActivity callback.
+func (s *Streamer) Observe(info session.SessionInfo, acts []session.Activity) {
+	var msgs []Message
+	for _, a := range acts {
+		if m, ok := s.message(info, a); ok {
+			msgs = append(msgs, m)
+		}
+	}
+	if len(msgs) == 0 {
+		return
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	if now.Sub(s.pruned) >= pruneInterval {
+		s.prune(now)
+	}
+	c := s.seq[info.SessionID]
+	if c == nil {
+		c = &counter{}
+		s.seq[info.SessionID] = c
+	}
+	c.seen = now
+	for _, m := range msgs {
+		c.n++
+		m.Seq = c.n
+		if len(s.queue) >= s.cfg.MaxQueue {
+			s.dropped++
+			continue
+		}
+		s.queue = append(s.queue, m)
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Forget drops a session's sequence counter, so its messages are numbered
+// from the start again. Completed sessions need not be forgotten: their
+// counters are dropped once idle for a day.
+func (s *Streamer) Forget(sessionID string) {
+	s.mu.Lock()
+	delete(s.seq, sessionID)
+	s.mu.Unlock()
+}
+
+// prune drops counters idle for longer than seqTTL. s.mu must be held.
+func (s *Streamer) prune(now time.Time) {
+	s.pruned = now
+	for id, c := range s.seq {
+		if now.Sub(c.seen) > seqTTL {
+			delete(s.seq, id)
+		}
+	}
+}
+
+// Start publishes queued messages. Blocks until Stop.
+func (s *Streamer) Start() {
+	defer close(s.exit)
+	for {
+		select {
+		case <-s.wake:
+			s.drain()
+		case <-s.done:
+			s.drain()
+			return
+		}
+	}
+}
+
+// Stop publishes what is still queued and halts the streamer.
+func (s *Streamer) Stop() {
+	s.stop.Do(func() { close(s.done) })
+	<-s.exit
+}
+
+// drain publishes every queued message in order.
+func (s *Streamer) drain() {
+	s.mu.Lock()
+	queue := s.queue
+	s.queue = nil
+	dropped := s.dropped
+	s.dropped = 0
+	s.mu.Unlock()
+
+	if dropped > 0 {
+		s.logger.Warn("stream queue full, dropped messages", "dropped", dropped)
+	}
+	for _, m := range queue {
+		if err := s.publish(m); err != nil {
+			s.logger.Error("failed to publish stream message", "error", err, "session_id", m.SessionID, "seq", m.Seq)
+		}
+	}
+}
+
+// message normalises one activity. Usage activities are not streamed.
+func (s *Streamer) message(info session.SessionInfo, a session.Activity) (Message, bool) {
+	m := Message{
+		SessionID:  info.SessionID,
+		Offset:     a.Offset,
+		Index:      a.Index,
+		Kind:       a.Kind,
+		Timestamp:  a.Timestamp,
+		MessageID:  a.MessageID,
+		WorkingDir: a.CWD,
+		Tool:       a.Tool,
+		ToolUseID:  a.ToolUseID,
+		FilePath:   a.FilePath,
+		IsError:    a.IsError,
+	}
+	switch a.Kind {
+	case session.ActivityPrompt, session.ActivityToolResult:
+		m.Role = RoleUser
+	case session.ActivityText, session.ActivityToolUse:
+		m.Role = RoleAssistant
+	default:
+		return Message{}, false
+	}
+	if m.WorkingDir == "" {
+		m.WorkingDir = info.WorkingDir
+	}
+
+	rep := redact.Report{}
+	if a.Kind == session.ActivityToolResult && a.Text != "" && s.redactor.Denied(a.FilePath) {
+		// The content of a denied file, read back by the tool.
+		rep[redact.PathDetector]++
+		a.Text = redact.Placeholder(redact.PathDetector)
+	}
+	m.Text = s.clip(s.redactor.String(a.Text, rep), &m.Truncated)
+	m.Command = s.clip(s.redactor.String(a.Command, rep), &m.Truncated)
+	m.FilePath = s.redactor.String(m.FilePath, rep)
+	if len(a.Input) > 0 {
+		input, err := s.redactor.JSON(a.Input, rep)
+		if err != nil {
+			// Not valid JSON; never send it unredacted.
+			input = nil
+		}
+		switch {
+		case input == nil || len(input) > s.cfg.MaxInput:
+			m.InputBytes = len(a.Input)
+			m.Truncated = true
+		default:
+			m.Input = input
+		}
+	}
+	if len(rep) > 0 {
+		m.Redactions = rep
+	}
+	return m, true
+}
+
+// clip cuts text to MaxText bytes on a rune boundary.
+func (s *Streamer) clip(text string, truncated *bool) string {
+	if len(text) <= s.cfg.MaxText {
+		return text
+	}
+	*truncated = true
+	n := s.cfg.MaxText
+	for n > 0 && !utf8.RuneStart(text[n]) {
+		n--
+	}
+	return text[:n] + "…"
+}
@@ -0,0 +1,270 @@
+package top
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/live"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/session"
+)
+
+// reconnectInterval is how long the remote source waits before reopening a
+// dropped event stream.
+const reconnectInterval = 2 * time.Second
+
+// Source supplies the sessions shown by the dashboard.
+type Source interface {
+	// Sessions returns the current sessions, most recently active first.
+	Sessions() []live.Session
+	// Activity returns up to limit of a session's most recent activities.
+	Activity(sessionID string, limit int) ([]session.Activity, error)
+	// Changed receives a value, coalesced, whenever sessions change.
+	Changed() <-chan struct{}
+	// Status describes where the data comes from, for the header.
+	Status() string
+}
+
+// Local reads a monitor in the same process.
+type Local struct {
+	monitor *live.Monitor
+	label   string
+	changed chan struct{}
+}
+
+// NewLocal returns a source for monitor. It stays subscribed until ctx is
+// done.
+func NewLocal(ctx context.Context, monitor *live.Monitor, label string) *Local {
+	l := &Local{monitor: monitor, label: label, changed: make(chan struct{}, 1)}
+	updates, cancel := monitor.Subscribe()
+	go func() {
+		<-ctx.Done()
+		cancel()
+	}()
+	go func() {
+		for range updates {
+			signal(l.changed)
+		}
+	}()
+	return l
+}
+
+// Sessions implements Source.
+func (l *Local) Sessions() []live.Session { return l.monitor.Sessions() }
+
+// Activity implements Source.
+func (l *Local) Activity(sessionID string, limit int) ([]session.Activity, error) {
+	acts, ok := l.monitor.Activity(sessionID, limit)
+	if !ok {
+		return nil, fmt.Errorf("session %s is no longer tracked", sessionID)
+	}
+	return acts, nil
+}
+
+// Changed implements Source.
+func (l *Local) Changed() <-chan struct{} { return l.changed }
+
+// Status implements Source.
+func (l *Local) Status() string { return l.label }
+
+// Remote reads a daemon's admin API, kept current by its event stream.
+type Remote struct {
+	base   string
+	token  string
+	client *http.Client
+
+	mu       sync.Mutex
+	sessions map[string]live.Session
+	status   string
+	changed  chan struct{}
+}
+
+// Dial connects to the admin API at base, e.g. "http://127.0.0.1:7777",
+// and follows its event stream until ctx is done. token, if set, is sent as
+// a bearer token.
+func Dial(ctx context.Context, base, token string) (*Remote, error) {
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+	r := &Remote{
+		base:     strings.TrimSuffix(base, "/"),
+		token:    token,
+		client:   &http.Client{},
+		sessions: make(map[string]live.Session),
+		changed:  make(chan struct{}, 1),
+	}
+
+	// Probe once so an absent daemon is reported straight away.
+	probeCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	var sessions []live.Session
+	if err := r.get(probeCtx, "/v1/sessions", &sessions); err != nil {
+		return nil, err
+	}
+	for _, s := range sessions {
+		r.sessions[s.SessionID] = s
+	}
+	r.status = "daemon " + r.base
+
+	go r.follow(ctx)
+	return r, nil
+}
+
+// Sessions implements Source.
+func (r *Remote) Sessions() []live.Session {
+	r.mu.Lock()
+	out := make([]live.Session, 0, len(r.sessions))
+	for _, s := range r.sessions {
+		out = append(out, s)
+	}
+	r.mu.Unlock()
+	live.Sort(out)
+	return out
+}
+
+// Activity implements Source.
+func (r *Remote) Activity(sessionID string, limit int) ([]session.Activity, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	var acts []session.Activity
+	path := fmt.Sprintf("/v1/sessions/%s/activity?limit=%d", url.PathEscape(sessionID), max(limit, 1))
+	err := r.get(ctx, path, &acts)
+	return acts, err
+}
+
+// Changed implements Source.
+func (r *Remote) Changed() <-chan struct{} { return r.changed }
+
+// Status implements Source.
+func (r *Remote) Status() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status
+}
+
+func (r *Remote) request(ctx context.Context, path string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+	return req, nil
+}
+
+func (r *Remote) get(ctx context.Context, path string, v any) error {
+	req, err := r.request(ctx, path)
+	if err != nil {
+		return err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("admin API: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&e)
+		return fmt.Errorf("admin API: %s: %s", resp.Status, e.Error)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// follow applies the event stream, reconnecting until ctx is done.
+func (r *Remote) follow(ctx context.Context) {
+	for {
+		err := r.stream(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		r.setStatus(fmt.Sprintf("daemon %s: reconnecting (%v)", r.base, err))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(reconnectInterval):
+		}
+	}
+}
+
+// stream reads one event stream connection. The stream starts with every
+// current session, so the view is replaced on each connect.
+func (r *Remote) stream(ctx context.Context) error {
+	req, err := r.request(ctx, "/v1/events")
+	if err != nil {
+		return err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("events: %s", resp.Status)
+	}
+
+	r.mu.Lock()
+	r.sessions = make(map[string]live.Session)
+	r.status = "daemon " + r.base
+	r.mu.Unlock()
+
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	var event, data string
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case line == "":
+			if event == "session" && data != "" {
+				r.apply(data)
+			}
+			event, data = "", ""
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data += strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("event stream closed")
+}
+
+func (r *Remote) apply(data string) {
+	var s live.Session
+	if err := json.Unmarshal([]byte(data), &s); err != nil {
+		return
+	}
+	r.mu.Lock()
+	if s.Removed {
+		delete(r.sessions, s.SessionID)
+	} else {
+		r.sessions[s.SessionID] = s
+	}
+	r.mu.Unlock()
+	signal(r.changed)
+}
+
+func (r *Remote) setStatus(status string) {
+	r.mu.Lock()
+	r.status = status
+	r.mu.Unlock()
+	signal(r.changed)
+}
+
+// signal does a non-blocking send on a coalescing channel.
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,410 @@
+// Package top is a top-style terminal dashboard of the sessions a sidecar is
+// tracking, drawn with plain ANSI escape sequences.
+package top
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/live"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/session"
+	"golang.org/x/term"
+)
+
+const (
+	// redrawInterval bounds how often updates are drawn.
+	redrawInterval = 250 * time.Millisecond
+	// tickInterval redraws an unchanged screen so idle times advance.
+	tickInterval = time.Second
+)
+
+// ANSI escape sequences.
+const (
+	altScreenOn  = "\x1b[?1049h"
+	altScreenOff = "\x1b[?1049l"
+	cursorHide   = "\x1b[?25l"
+	cursorShow   = "\x1b[?25h"
+	cursorHome   = "\x1b[H"
+	clearLine    = "\x1b[K"
+	clearBelow   = "\x1b[J"
+	reverse      = "\x1b[7m"
+	bold         = "\x1b[1m"
+	dim          = "\x1b[2m"
+	reset        = "\x1b[0m"
+)
+
+// key is a decoded key press.
+type key int
+
+const (
+	keyNone key = iota
+	keyUp
+	keyDown
+	keyEnter
+	keyBack
+	keyQuit
+)
+
+// parseKey decodes one read from a raw terminal.
+func parseKey(b []byte) key {
+	switch string(b) {
+	case "q", "Q", "\x03":
+		return keyQuit
+	case "k", "\x1b[A", "\x1bOA":
+		return keyUp
+	case "j", "\x1b[B", "\x1bOB":
+		return keyDown
+	case "\r", "\n", "l", "\x1b[C", "\x1bOC":
+		return keyEnter
+	case "\x1b", "h", "\x7f", "\b", "\x1b[D", "\x1bOD":
+		return keyBack
+	}
+	return keyNone
+}
+
+// model is the dashboard state.
+type model struct {
+	sessions []live.Session
+	selected string // session ID under the cursor
+	detail   string // session ID being drilled into, or ""
+	acts     []session.Activity
+	actsErr  error
+	status   string
+	width    int
+	height   int
+	now      time.Time
+}
+
+// cursor returns the index of the selected session, defaulting to the
+// first.
+func (m *model) cursor() int {
+	for i, s := range m.sessions {
+		if s.SessionID == m.selected {
+			return i
+		}
+	}
+	return 0
+}
+
+// handle applies a key press and reports whether to quit.
+func (m *model) handle(k key) bool {
+	switch k {
+	case keyQuit:
+		return true
+	case keyUp, keyDown:
+		if m.detail != "" || len(m.sessions) == 0 {
+			return false
+		}
+		i := m.cursor()
+		if k == keyUp && i > 0 {
+			i--
+		} else if k == keyDown && i < len(m.sessions)-1 {
+			i++
+		}
+		m.selected = m.sessions[i].SessionID
+	case keyEnter:
+		if m.detail == "" && len(m.sessions) > 0 {
+			m.detail = m.sessions[m.cursor()].SessionID
+			m.selected = m.detail
+		}
+	case keyBack:
+		m.detail = ""
+	}
+	return false
+}
+
+// render draws the current view as lines no wider than the terminal.
+func (m *model) render() []string {
+	var lines []string
+	header := fmt.Sprintf("cc-sidecar top — %d sessions — %s", len(m.sessions), m.status)
+	lines = append(lines, bold+fit(header, m.width)+reset)
+
+	if m.detail != "" {
+		lines = append(lines, m.renderDetail()...)
+	} else {
+		lines = append(lines, m.renderList()...)
+	}
+
+	if len(lines) > m.height-1 {
+		lines = lines[:m.height-1]
+	}
+	for len(lines) < m.height-1 {
+		lines = append(lines, "")
+	}
+	help := "↑/↓ select  enter open  q quit"
+	if m.detail != "" {
+		help = "esc back  q quit"
+	}
+	return append(lines, dim+fit(help, m.width)+reset)
+}
+
+const listFormat = "%-8s  %-24s  %-7s  %6s  %7s  %9s  %8s  %5s  %s"
+
+func (m *model) renderList() []string {
+	lines := []string{
+		"",
+		reverse + fit(fmt.Sprintf(listFormat, "SESSION", "PROJECT", "STATE", "IDLE", "PID", "TOKENS", "COST", "FILES", "LAST TOOL"), m.width) + reset,
+	}
+	if len(m.sessions) == 0 {
+		return append(lines, dim+fit("No active sessions. They appear on their next transcript write.", m.width)+reset)
+	}
+	cur := m.cursor()
+	for i, s := range m.sessions {
+		pid := "-"
+		if s.PID != 0 {
+			pid = fmt.Sprint(s.PID)
+		}
+		tool := s.LastTool
+		if s.LastToolDetail != "" {
+			tool += " " + s.LastToolDetail
+		}
+		row := fit(fmt.Sprintf(listFormat,
+			shortID(s.SessionID),
+			clip(project(s.WorkingDir, s.TranscriptPath), 24),
+			s.State,
+			idle(m.now, s.LastActivity),
+			pid,
+			tokens(s.Tokens.Total()),
+			fmt.Sprintf("$%.2f", s.CostUSD),
+			fmt.Sprint(s.FilesChanged),
+			oneLine(tool),
+		), m.width)
+		if i == cur {
+			row = reverse + row + reset
+		}
+		lines = append(lines, row)
+	}
+	return lines
+}
+
+func (m *model) renderDetail() []string {
+	var s *live.Session
+	for i := range m.sessions {
+		if m.sessions[i].SessionID == m.detail {
+			s = &m.sessions[i]
+		}
+	}
+	if s == nil {
+		return []string{"", fit("Session "+m.detail+" is no longer tracked.", m.width)}
+	}
+
+	lines := []string{
+		"",
+		fit(fmt.Sprintf("Session  %s  (%s, pid %d)", s.SessionID, s.State, s.PID), m.width),
+		fit("Project  "+firstNonEmpty(s.WorkingDir, s.TranscriptPath), m.width),
+		fit(fmt.Sprintf("Started  %s   idle %s   tokens %s   $%.2f   files %d",
+			s.StartedAt.Local().Format("15:04:05"), idle(m.now, s.LastActivity), tokens(s.Tokens.Total()), s.CostUSD, s.FilesChanged), m.width),
+		"",
+	}
+	if m.actsErr != nil {
+		return append(lines, fit("activity unavailable: "+m.actsErr.Error(), m.width))
+	}
+
+	room := m.height - 1 - 1 - len(lines)
+	acts := m.acts
+	if room < 0 {
+		room = 0
+	}
+	if len(acts) > room {
+		acts = acts[len(acts)-room:]
+	}
+	for _, a := range acts {
+		lines = append(lines, fit(activityLine(a), m.width))
+	}
+	return lines
+}
+
+// activityLine formats one parsed transcript entry.
+func activityLine(a session.Activity) string {
+	ts := "        "
+	if !a.Timestamp.IsZero() {
+		ts = a.Timestamp.Local().Format("15:04:05")
+	}
+	var label, text string
+	switch a.Kind {
+	case session.ActivityPrompt:
+		label, text = "user", a.Text
+	case session.ActivityText:
+		label, text = "agent", a.Text
+	case session.ActivityToolUse:
+		label, text = "tool", a.Tool+" "+firstNonEmpty(a.FilePath, a.Command, string(a.Input))
+	case session.ActivityToolResult:
+		label, text = "result", "ok "+a.Text
+		if a.IsError {
+			label, text = "result", "error "+a.Text
+		}
+	default:
+		label = string(a.Kind)
+	}
+	return fmt.Sprintf("%s  %-6s  %s", ts, label, oneLine(text))
+}
+
+func project(workingDir, transcriptPath string) string {
+	if workingDir != "" {
+		return printable(filepath.Base(workingDir))
+	}
+	return printable(filepath.Base(filepath.Dir(transcriptPath)))
+}
+
+func idle(now, last time.Time) string {
+	if last.IsZero() {
+		return "-"
+	}
+	d := now.Sub(last)
+	switch {
+	case d < time.Second:
+		return "0s"
+	case d < time.Hour:
+		return d.Truncate(time.Second).String()
+	default:
+		return d.Truncate(time.Minute).String()
+	}
+}
+
+func tokens(n int64) string {
+	switch {
+	case n >= 1_000_000:
+		return fmt.Sprintf("%.1fM", float64(n)/1e6)
+	case n >= 10_000:
+		return fmt.Sprintf("%.0fk", float64(n)/1e3)
+	default:
+		return fmt.Sprint(n)
+	}
+}
+
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(printable(s)), " ")
+}
+
+// printable replaces control characters, which transcripts and remote
+// sidecars could use to inject terminal escape sequences, with spaces.
+func printable(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, s)
+}
+
+func firstNonEmpty(ss ...string) string {
+	for _, s := range ss {
+		if s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// clip shortens s to n runes, marking the cut.
+func clip(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	r := []rune(s)
+	return string(r[:n-1]) + "…"
+}
+
+// fit makes s safe to print and clips it to the terminal width.
+func fit(s string, width int) string {
+	s = printable(s)
+	if width <= 0 {
+		return s
+	}
+	return clip(s, width)
+}
+
+// Run draws the dashboard on the terminal until the user quits. in must be
+// a terminal; it is put into raw mode for the duration.
+func Run(src Source, in, out *os.File) error {
+	fd := int(in.Fd())
+	old, err := term.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("terminal raw mode: %w", err)
+	}
+	defer term.Restore(fd, old)
+
+	fmt.Fprint(out, altScreenOn+cursorHide)
+	defer fmt.Fprint(out, cursorShow+altScreenOff)
+
+	keys := make(chan key, 16)
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			n, err := in.Read(buf)
+			if err != nil {
+				keys <- keyQuit
+				return
+			}
+			if k := parseKey(buf[:n]); k != keyNone {
+				keys <- k
+			}
+		}
+	}()
+
+	m := &model{}
+	draw := func() {
+		m.width, m.height, err = term.GetSize(int(out.Fd()))
+		if err != nil || m.height < 3 {
+			m.width, m.height = 80, 24
+		}
+		m.now = time.Now()
+		m.status = src.Status()
+		m.sessions = src.Sessions()
+		if m.selected == "" && len(m.sessions) > 0 {
+			m.selected = m.sessions[0].SessionID
+		}
+		if m.detail != "" {
+			m.acts, m.actsErr = src.Activity(m.detail, m.height)
+		}
+
+		var b strings.Builder
+		b.WriteString(cursorHome)
+		for i, line := range m.render() {
+			if i > 0 {
+				b.WriteString("\r\n")
+			}
+			b.WriteString(line)
+			b.WriteString(clearLine)
+		}
+		b.WriteString(clearBelow)
+		fmt.Fprint(out, b.String())
+	}
+
+	redraw := time.NewTicker(redrawInterval)
+	defer redraw.Stop()
+	draw()
+	var (
+		dirty    bool
+		lastDraw = time.Now()
+	)
+	for {
+		select {
+		case k := <-keys:
+			if m.handle(k) {
+				return nil
+			}
+			draw()
+			dirty, lastDraw = false, time.Now()
+		case <-src.Changed():
+			dirty = true
+		case <-redraw.C:
+			if dirty || time.Since(lastDraw) >= tickInterval {
+				draw()
+				dirty, lastDraw = false, time.Now()
+			}
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package top
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"unicode"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/admin"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/live"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/session"
+)
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+}
+
+func TestParseKey(t *testing.T) {
+	tests := map[string]key{
+		"q":      keyQuit,
+		"\x03":   keyQuit,
+		"\x1b[A": keyUp,
+		"k":      keyUp,
+		"\x1b[B": keyDown,
+		"\r":     keyEnter,
+		"\x1b":   keyBack,
+		"\x7f":   keyBack,
+		"x":      keyNone,
+	}
+	for in, want := range tests {
+		if got := parseKey([]byte(in)); got != want {
+			t.Errorf("parseKey(%q) = %v, want %v", in, got, want)
+		}
+	}
+}
+
+func testModel() *model {
+	now := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	return &model{
+		sessions: []live.Session{
+			{
+				SessionInfo:    session.SessionInfo{SessionID: "aaaaaaaa-1111", WorkingDir: "/home/u/api", State: session.StateRunning, PID: 4242, LastActivity: now.Add(-5 * time.Second)},
+				Tokens:         session.TokenUsage{InputTokens: 12_000, OutputTokens: 500},
+				CostUSD:        0.42,
+				LastTool:       "Bash",
+				LastToolDetail: "go test\n./...",
+				FilesChanged:   3,
+			},
+			{SessionInfo: session.SessionInfo{SessionID: "bbbbbbbb-2222", TranscriptPath: "/p/-home-u-web/b.jsonl", State: session.StateIdle, LastActivity: now.Add(-2 * time.Minute)}},
+		},
+		status: "daemon http://127.0.0.1:7777",
+		width:  120,
+		height: 20,
+		now:    now,
+	}
+}
+
+func TestModel_RenderList(t *testing.T) {
+	m := testModel()
+	lines := m.render()
+	if len(lines) != m.height {
+		t.Fatalf("rendered %d lines, want %d", len(lines), m.height)
+	}
+	out := strings.Join(lines, "\n")
+	for _, want := range []string{"2 sessions", "aaaaaaaa", "api", "running", "5s", "4242", "12k", "$0.42", "Bash go test ./...", "bbbbbbbb", "-home-u-web", "2m0s"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("list missing %q:\n%s", want, out)
+		}
+	}
+	// The first session is selected.
+	if !strings.Contains(lines[3], reverse) {
+		t.Errorf("first row not highlighted: %q", lines[3])
+	}
+
+	m.width = 30
+	for _, l := range m.render() {
+		plain := strings.NewReplacer(reverse, "", reset, "", bold, "", dim, "").Replace(l)
+		if n := len([]rune(plain)); n > 30 {
+			t.Errorf("line wider than terminal (%d): %q", n, plain)
+		}
+	}
+}
+
+func TestModel_StripsControlCharacters(t *testing.T) {
+	m := testModel()
+	m.sessions[0].LastToolDetail = "\x1b]0;pwned\x07 \x1b[2J\u009b31m"
+	m.sessions[1].WorkingDir = "/home/u/\x1b[31mweb"
+	m.status = "\x1bc"
+	m.acts = []session.Activity{{Kind: session.ActivityText, Text: "hi\x1b[8m"}}
+	lines := m.render()
+	m.detail = m.sessions[0].SessionID
+	lines = append(lines, m.render()...)
+
+	for _, l := range lines {
+		plain := strings.NewReplacer(reverse, "", reset, "", bold, "", dim, "").Replace(l)
+		for _, r := range plain {
+			if unicode.IsControl(r) {
+				t.Fatalf("control character %U in %q", r, plain)
+			}
+		}
+	}
+}
+
+func TestModel_Navigation(t *testing.T) {
+	m := testModel()
+	m.handle(keyDown)
+	m.handle(keyDown)
+	if m.selected != "bbbbbbbb-2222" {
+		t.Errorf("selected = %q", m.selected)
+	}
+	m.handle(keyEnter)
+	if m.detail != "bbbbbbbb-2222" {
+		t.Errorf("detail = %q", m.detail)
+	}
+	// Up/down do nothing inside a session.
+	m.handle(keyUp)
+	if m.selected != "bbbbbbbb-2222" {
+		t.Errorf("selected changed in detail view: %q", m.selected)
+	}
+	m.handle(keyBack)
+	if m.detail != "" {
+		t.Error("back did not leave detail view")
+	}
+	if !m.handle(keyQuit) {
+		t.Error("quit not reported")
+	}
+}
+
+func TestModel_RenderDetail(t *testing.T) {
+	m := testModel()
+	m.detail = "aaaaaaaa-1111"
+	m.height = 10
+	for i := 0; i < 20; i++ {
+		m.acts = append(m.acts, session.Activity{Kind: session.ActivityText, Text: "step"})
+	}
+	m.acts = append(m.acts,
+		session.Activity{Kind: session.ActivityToolUse, Tool: "Edit", FilePath: "/home/u/api/main.go"},
+		session.Activity{Kind: session.ActivityToolResult, IsError: true, Text: "no match"},
+	)
+
+	lines := m.render()
+	if len(lines) != m.height {
+		t.Fatalf("rendered %d lines, want %d", len(lines), m.height)
+	}
+	out := strings.Join(lines, "\n")
+	for _, want := range []string{"aaaaaaaa-1111", "pid 4242", "tool    Edit /home/u/api/main.go", "result  error no match", "esc back"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("detail missing %q:\n%s", want, out)
+		}
+	}
+
+	m.detail = "gone"
+	if out := strings.Join(m.render(), "\n"); !strings.Contains(out, "no longer tracked") {
+		t.Errorf("missing session:\n%s", out)
+	}
+}
+
+func TestRemote(t *testing.T) {
+	mon := live.New(nil)
+	mon.Observe(session.SessionInfo{SessionID: "s1", State: session.StateRunning}, []session.Activity{{Kind: session.ActivityPrompt, Text: "hi"}})
+	srv := admin.New("127.0.0.1:0", mon, testLogger())
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := Dial(ctx, srv.Addr(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Sessions(); len(got) != 1 || got[0].SessionID != "s1" {
+		t.Fatalf("initial sessions = %+v", got)
+	}
+
+	waitFor := func(cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatal("condition not met")
+			}
+			select {
+			case <-r.Changed():
+			case <-time.After(50 * time.Millisecond):
+			}
+		}
+	}
+
+	mon.ObserveState(session.SessionInfo{SessionID: "s2", State: session.StateRunning, LastActivity: time.Now()})
+	waitFor(func() bool { return len(r.Sessions()) == 2 })
+	mon.Forget("s1")
+	waitFor(func() bool { return len(r.Sessions()) == 1 })
+
+	acts, err := r.Activity("s2", 10)
+	if err != nil || len(acts) != 0 {
+		t.Errorf("activity = %v %v", acts, err)
+	}
+	if _, err := r.Activity("s1", 10); err == nil {
+		t.Error("expected error for forgotten session")
+	}
+	if !strings.HasPrefix(r.Status(), "daemon http://") {
+		t.Errorf("status = %q", r.Status())
+	}
+
+	if _, err := Dial(ctx, "127.0.0.1:1", ""); err == nil {
+		t.Error("expected error dialling a closed port")
+	}
+}
+
+func TestRemote_Token(t *testing.T) {
+	srv := admin.New("127.0.0.1:0", live.New(nil), testLogger())
+	srv.SetToken("secret")
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := Dial(ctx, srv.Addr(), ""); err == nil {
+		t.Error("expected error without the token")
+	}
+	if _, err := Dial(ctx, srv.Addr(), "secret"); err != nil {
+		t.Errorf("Dial with token: %v", err)
+	}
+}
+
+func TestLocal(t *testing.T) {
+	mon := live.New(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := NewLocal(ctx, mon, "standalone")
+
+	mon.ObserveState(session.SessionInfo{SessionID: "s1"})
+	select {
+	case <-l.Changed():
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change signalled")
+	}
+	if len(l.Sessions()) != 1 || l.Status() != "standalone" {
+		t.Errorf("sessions = %+v status = %q", l.Sessions(), l.Status())
+	}
+	if _, err := l.Activity("missing", 5); err == nil {
+		t.Error("expected error for unknown session")
+	}
+}
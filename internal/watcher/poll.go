@@ -0,0 +1,160 @@
+package watcher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Mode selects how roots are watched.
+type Mode string
+
+const (
+	// ModeFSNotify relies on inotify events only.
+	ModeFSNotify Mode = "fsnotify"
+	// ModePoll stats every transcript each poll interval and never uses
+	// inotify. It works on NFS, SSHFS and bind mounts from another kernel.
+	ModePoll Mode = "poll"
+	// ModeAuto uses inotify and polls a root instead when a watch cannot be
+	// added, e.g. because max_user_watches is exhausted, or when a scan
+	// finds a transcript change that produced no event. Inotify roots are
+	// scanned for such changes every verifyInterval only.
+	ModeAuto Mode = "auto"
+)
+
+// DefaultPollInterval is how often transcripts are stat'ed when polling.
+const DefaultPollInterval = 2 * time.Second
+
+// verifyInterval is how often auto mode walks the roots still on inotify
+// to look for changes that produced no event. Polled roots are walked every
+// poll interval.
+const verifyInterval = time.Minute
+
+// eventGrace is how long a scan waits for the fsnotify event of a change
+// before deciding events are not arriving for its root.
+const eventGrace = 5 * time.Second
+
+// mtimeSlack allows for coarse filesystem timestamps when deciding whether
+// a newly seen transcript was written since the last scan.
+const mtimeSlack = time.Second
+
+// ParseMode validates a watch mode; empty means ModeAuto.
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(s); m {
+	case "":
+		return ModeAuto, nil
+	case ModeFSNotify, ModePoll, ModeAuto:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown watch mode %q (want fsnotify, poll or auto)", s)
+}
+
+// fileStat is what a scan compares to spot a written transcript.
+type fileStat struct {
+	size    int64
+	modTime time.Time
+}
+
+// fallback switches a root to polling in auto mode. attrs describe the
+// reason in the log.
+func (w *Watcher) fallback(dir, reason string, attrs ...any) {
+	if dir == "" || w.polled[dir] {
+		return
+	}
+	attrs = append([]any{"root", dir}, attrs...)
+	if w.mode != ModeAuto {
+		w.logger.Warn(reason, attrs...)
+		return
+	}
+	w.logger.Warn(reason+", polling root instead", attrs...)
+	w.polled[dir] = true
+}
+
+// scan stats the transcripts under the polled roots and passes changes to
+// the tracker. In auto mode it also walks the inotify roots, but only every
+// verifyInterval, as they are only checked for a change that had no event,
+// which switches the root to polling. A quiet scan walks every root and
+// only records the current state.
+func (w *Watcher) scan(now time.Time, quiet bool) {
+	if w.mode == ModeFSNotify {
+		return
+	}
+	verify := quiet || now.Sub(w.lastVerify) >= verifyInterval
+
+	seen := make(map[string]bool)
+	skipped := make(map[string]bool)
+	for _, dir := range w.Roots() {
+		if !w.polled[dir] && !verify {
+			skipped[dir] = true
+			continue
+		}
+		if _, err := os.Stat(dir); err != nil {
+			w.unwatch(dir)
+			continue
+		}
+		since := w.lastScan
+		if !w.polled[dir] {
+			since = w.lastVerify
+		}
+		filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !strings.HasSuffix(path, ".jsonl") || !w.Allow(path) {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			seen[path] = true
+
+			st := fileStat{size: info.Size(), modTime: info.ModTime()}
+			prev, known := w.files[path]
+			// Transcripts found under a new root are only picked up when
+			// written since the last scan, as inotify would.
+			changed := known && st != prev || !known && st.modTime.After(since.Add(-mtimeSlack))
+			if quiet || !changed {
+				w.files[path] = st
+				delete(w.events, path)
+				return nil
+			}
+
+			if !w.polled[dir] {
+				if w.events[path] {
+					w.files[path] = st
+					delete(w.events, path)
+					return nil
+				}
+				if now.Sub(st.modTime) < eventGrace {
+					return nil // the event may still be on its way
+				}
+				w.fallback(dir, "transcript changed without a filesystem event", "path", path)
+			}
+			w.files[path] = st
+			delete(w.events, path)
+			w.tracker.Touch(path)
+			return nil
+		})
+	}
+
+	for path := range w.files {
+		root := w.rootOf(path)
+		if seen[path] || skipped[root] {
+			continue
+		}
+		delete(w.files, path)
+		// Removals under inotify roots arrive as events.
+		if root == "" || w.polled[root] {
+			w.tracker.Forget(path)
+		}
+	}
+	for path := range w.events {
+		if !seen[path] && !skipped[w.rootOf(path)] {
+			delete(w.events, path)
+		}
+	}
+	w.lastScan = now
+	if verify {
+		w.lastVerify = now
+	}
+}
@@ -0,0 +1,183 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncRecorder is a goroutine-safe Toucher for watchers that are running.
+type syncRecorder struct {
+	mu      sync.Mutex
+	touches []string
+	forgets []string
+}
+
+func (r *syncRecorder) Touch(path string) {
+	r.mu.Lock()
+	r.touches = append(r.touches, path)
+	r.mu.Unlock()
+}
+
+func (r *syncRecorder) Forget(path string) {
+	r.mu.Lock()
+	r.forgets = append(r.forgets, path)
+	r.mu.Unlock()
+}
+
+func (r *syncRecorder) Rename(oldPath, newPath string) {}
+
+func (r *syncRecorder) touched() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.touches...)
+}
+
+func (r *syncRecorder) forgotten() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.forgets...)
+}
+
+// eventually polls cond until it holds or fails the test after 5s.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for in, want := range map[string]Mode{"": ModeAuto, "auto": ModeAuto, "poll": ModePoll, "fsnotify": ModeFSNotify} {
+		if got, err := ParseMode(in); err != nil || got != want {
+			t.Errorf("ParseMode(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseMode("inotify"); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
+
+func TestPollMode(t *testing.T) {
+	root := t.TempDir()
+	project := filepath.Join(root, "-home-mike-api")
+	os.MkdirAll(project, 0755)
+	old := filepath.Join(project, "old.jsonl")
+	os.WriteFile(old, []byte("{}\n"), 0644)
+	past := time.Now().Add(-time.Hour)
+	os.Chtimes(old, past, past)
+	active := filepath.Join(project, "active.jsonl")
+	os.WriteFile(active, []byte("{}\n"), 0644)
+
+	rec := &syncRecorder{}
+	w, err := New([]Root{{Path: root}}, ModePoll, rec, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.fw != nil {
+		t.Error("poll mode must not use inotify")
+	}
+	w.SetPollInterval(10 * time.Millisecond)
+	go w.Start()
+	defer w.Stop()
+
+	// Existing transcripts are not reported until written.
+	time.Sleep(50 * time.Millisecond)
+	if got := rec.touched(); len(got) != 0 {
+		t.Fatalf("touched %v before any write", got)
+	}
+
+	os.WriteFile(active, []byte("{}\n{}\n"), 0644)
+	created := filepath.Join(project, "new.jsonl")
+	os.WriteFile(created, []byte("{}\n"), 0644)
+
+	eventually(t, "written transcripts to be touched", func() bool {
+		got := rec.touched()
+		return slices.Contains(got, active) && slices.Contains(got, created)
+	})
+	if slices.Contains(rec.touched(), old) {
+		t.Errorf("untouched transcript %s reported", old)
+	}
+
+	// Deletions are noticed by the next scan.
+	os.Remove(created)
+	eventually(t, "deleted transcript to be forgotten", func() bool {
+		return slices.Contains(rec.forgotten(), created)
+	})
+}
+
+func TestAutoMode_FallsBackWhenEventsMissing(t *testing.T) {
+	root := t.TempDir()
+	project := filepath.Join(root, "-home-mike-api")
+	os.MkdirAll(project, 0755)
+	path := filepath.Join(project, "sess.jsonl")
+	os.WriteFile(path, []byte("{}\n"), 0644)
+
+	var touched []string
+	w, err := New([]Root{{Path: root}}, ModeAuto, &touchRecorder{touches: &touched}, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.fw.Close()
+
+	// A write whose event was delivered leaves the root on inotify.
+	os.WriteFile(path, []byte("{}\n{}\n"), 0644)
+	w.events[path] = true
+	w.scan(time.Now().Add(verifyInterval), false)
+	if w.polled[root] || len(touched) != 0 {
+		t.Fatalf("polled=%v touched=%v after a delivered event", w.polled[root], touched)
+	}
+
+	// A recent write without an event is given time to arrive.
+	os.WriteFile(path, []byte("{}\n{}\n{}\n"), 0644)
+	w.lastVerify = time.Time{}
+	w.scan(time.Now(), false)
+	if w.polled[root] {
+		t.Fatal("fell back before the event grace period")
+	}
+
+	// Inotify roots are not walked again until the verify interval is up.
+	w.scan(w.lastVerify.Add(verifyInterval/2), false)
+	if w.polled[root] {
+		t.Fatal("inotify root walked before the verify interval")
+	}
+
+	// Once it is, the root is polled and the write is not lost.
+	w.scan(w.lastVerify.Add(verifyInterval), false)
+	if !w.polled[root] {
+		t.Fatal("root not polled after a change without events")
+	}
+	if len(touched) != 1 || touched[0] != path {
+		t.Errorf("touched = %v, want %s", touched, path)
+	}
+}
+
+func TestAutoMode_FallsBackWhenAddFails(t *testing.T) {
+	base := t.TempDir()
+	os.MkdirAll(filepath.Join(base, "alice", "projects"), 0755)
+
+	var touched []string
+	w, err := New([]Root{{Path: filepath.Join(base, "*", "projects")}}, ModeAuto, &touchRecorder{touches: &touched}, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Stand in for an exhausted max_user_watches.
+	w.fw.Close()
+
+	bob := filepath.Join(base, "bob", "projects")
+	os.MkdirAll(bob, 0755)
+	w.expand()
+	if !w.polled[bob] {
+		t.Errorf("root %s not polled after its watch failed", bob)
+	}
+	if w.polled[filepath.Join(base, "alice", "projects")] {
+		t.Error("healthy root switched to polling")
+	}
+}
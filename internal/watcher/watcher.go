@@ -1,82 +1,422 @@
+// Package watcher follows Claude Code transcript directories for JSONL
+// writes.
+//
+// A root is a directory or a glob such as /home/*/.claude/projects, so one
+// sidecar can follow every account on a shared machine. Globs are expanded
+// again every rescan interval, picking up home directories created after
+// startup.
+//
+// Permissions: the sidecar needs read and execute permission on each
+// matched root and its project directories, and read permission on the
+// transcripts. Roots it cannot read are logged and retried on the next
+// rescan; they never stop other roots from being watched. Directories it
+// cannot read below a root are skipped.
+//
+// Roots on filesystems without inotify (NFS, SSHFS, some container bind
+// mounts) can be polled instead; see Mode.
 package watcher
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
 	"log/slog"
+	"maps"
 	"os"
+	"os/user"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/filter"
 	"github.com/fsnotify/fsnotify"
 )
 
-// Toucher is satisfied by session.Tracker — accepts file paths on write events.
+// DefaultRescanInterval is how often glob roots are re-expanded.
+const DefaultRescanInterval = 30 * time.Second
+
+// userPlaceholder in an owner label is replaced by the account owning the
+// matched root directory.
+const userPlaceholder = "{user}"
+
+// Toucher is satisfied by session.Tracker — accepts file paths on write,
+// removal and rename events. Forget and Rename may be given directories.
 type Toucher interface {
 	Touch(path string)
+	Forget(path string)
+	Rename(oldPath, newPath string)
+}
+
+// renameWait is how long a rename waits for the new name to appear before
+// it is treated as a removal.
+const renameWait = 100 * time.Millisecond
+
+// Root is a directory, or a glob of directories, holding project transcript
+// directories.
+type Root struct {
+	Path string
+	// Owner labels sessions found under the root. "{user}" is replaced by
+	// the name of the account owning the matched directory.
+	Owner string
+	// Filter selects the transcripts followed; nil follows all of them.
+	Filter *filter.Filter
+}
+
+// matched is a directory matched by a root.
+type matched struct {
+	root   int // index in roots
+	owner  string
+	filter *filter.Filter
 }
 
-// Watcher monitors ~/.claude/projects/ for JSONL transcript changes.
+// Watcher monitors transcript roots for JSONL transcript changes.
 type Watcher struct {
-	dir     string
+	mode    Mode
+	rescan  time.Duration
+	poll    time.Duration
 	tracker Toucher
 	logger  *slog.Logger
 	fw      *fsnotify.Watcher
 	done    chan struct{}
+
+	mu      sync.Mutex
+	roots   []Root
+	watched map[string]matched // by matched root dir
+
+	// renamed is the old name of a rename awaiting its new name.
+	renamed string
+	// lastExpand is when the roots were last matched; zero until New has.
+	lastExpand time.Time
+
+	// Polling state, used only by the Start goroutine once running.
+	polled     map[string]bool // matched root dirs being polled
+	files      map[string]fileStat
+	events     map[string]bool // transcripts with an fsnotify event since they were last scanned
+	lastScan   time.Time
+	lastVerify time.Time // last scan of the inotify roots
 }
 
 // New creates a new transcript watcher.
-func New(dir string, tracker Toucher, logger *slog.Logger) (*Watcher, error) {
-	fw, err := fsnotify.NewWatcher()
-	if err != nil {
-		return nil, err
+func New(roots []Root, mode Mode, tracker Toucher, logger *slog.Logger) (*Watcher, error) {
+	if mode == "" {
+		mode = ModeAuto
 	}
-
 	w := &Watcher{
-		dir:     dir,
+		roots:   roots,
+		mode:    mode,
+		rescan:  DefaultRescanInterval,
+		poll:    DefaultPollInterval,
 		tracker: tracker,
 		logger:  logger.With("component", "watcher"),
-		fw:      fw,
 		done:    make(chan struct{}),
+		watched: make(map[string]matched),
+		polled:  make(map[string]bool),
+		files:   make(map[string]fileStat),
+		events:  make(map[string]bool),
 	}
 
-	// Add the root dir and all subdirectories.
-	if err := w.addRecursive(dir); err != nil {
-		fw.Close()
-		return nil, err
+	if mode != ModePoll {
+		fw, err := fsnotify.NewWatcher()
+		switch {
+		case err == nil:
+			w.fw = fw
+		case mode == ModeAuto:
+			w.logger.Warn("inotify unavailable, polling every root", "error", err)
+			w.mode = ModePoll
+		default:
+			return nil, err
+		}
 	}
 
+	// Add the matched roots and all their subdirectories, and remember the
+	// transcripts already there.
+	w.expand()
+	w.scan(time.Now(), true)
 	return w, nil
 }
 
+// SetRescanInterval changes how often glob roots are re-expanded. It must be
+// called before Start.
+func (w *Watcher) SetRescanInterval(d time.Duration) {
+	if d > 0 {
+		w.rescan = d
+	}
+}
+
+// SetPollInterval changes how often polled roots are scanned. It must be
+// called before Start.
+func (w *Watcher) SetPollInterval(d time.Duration) {
+	if d > 0 {
+		w.poll = d
+	}
+}
+
+// SetRoots replaces the owner labels and filters of the roots while the
+// watcher runs. The paths must be those given to New, in the same order.
+// Sessions already tracked keep their owner, and dropping those excluded by
+// a new filter is left to the caller.
+func (w *Watcher) SetRoots(roots []Root) error {
+	w.mu.Lock()
+	old := w.roots
+	w.mu.Unlock()
+	if len(roots) != len(old) {
+		return errors.New("watch roots cannot change without a restart")
+	}
+	for i := range roots {
+		if roots[i].Path != old[i].Path {
+			return fmt.Errorf("watch root %s cannot change to %s without a restart", old[i].Path, roots[i].Path)
+		}
+	}
+
+	// Resolve owners outside the lock; they may look up accounts.
+	dirs := make(map[string]matched)
+	for dir, m := range w.watchedDirs() {
+		root := roots[m.root]
+		dirs[dir] = matched{root: m.root, owner: ownerLabel(root.Owner, dir), filter: root.Filter}
+	}
+
+	w.mu.Lock()
+	w.roots = roots
+	for dir, m := range dirs {
+		if _, ok := w.watched[dir]; ok {
+			w.watched[dir] = m
+		}
+	}
+	w.mu.Unlock()
+	return nil
+}
+
+// watchedDirs returns a copy of the matched root dirs.
+func (w *Watcher) watchedDirs() map[string]matched {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return maps.Clone(w.watched)
+}
+
+// Owner returns the owner label of the root a transcript belongs to.
+func (w *Watcher) Owner(path string) string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.watched[w.rootOfLocked(path)].owner
+}
+
+// Allow reports whether a transcript passes the filter of its root.
+// Excluded transcripts are never passed to the tracker.
+func (w *Watcher) Allow(path string) bool {
+	w.mu.Lock()
+	root := w.rootOfLocked(path)
+	f := w.watched[root].filter
+	w.mu.Unlock()
+	if f == nil {
+		return true
+	}
+
+	slug := ""
+	if rel, err := filepath.Rel(root, filepath.Dir(path)); err == nil && rel != "." {
+		slug, _, _ = strings.Cut(rel, string(filepath.Separator))
+	}
+	return f.Allow(slug, filepath.Base(path))
+}
+
+// rootOf returns the matched root dir containing path, or "".
+func (w *Watcher) rootOf(path string) string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rootOfLocked(path)
+}
+
+// rootOfLocked is rootOf for callers holding w.mu.
+func (w *Watcher) rootOfLocked(path string) string {
+	best := ""
+	for dir := range w.watched {
+		if len(dir) > len(best) && within(path, dir) {
+			best = dir
+		}
+	}
+	return best
+}
+
+// Roots returns the directories currently matched by the roots.
+func (w *Watcher) Roots() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	out := make([]string, 0, len(w.watched))
+	for dir := range w.watched {
+		out = append(out, dir)
+	}
+	slices.Sort(out)
+	return out
+}
+
+// expand matches every root and starts watching directories not yet
+// watched. Transcripts in a root found after startup that were written
+// since the previous expansion are touched, as addNew does for new
+// directories, since their sessions may be running already.
+func (w *Watcher) expand() {
+	since := w.lastExpand
+	w.lastExpand = time.Now()
+
+	w.mu.Lock()
+	roots := w.roots
+	w.mu.Unlock()
+	for i, root := range roots {
+		for _, dir := range match(root.Path) {
+			w.mu.Lock()
+			_, ok := w.watched[dir]
+			w.mu.Unlock()
+			if ok {
+				continue
+			}
+			if _, err := os.ReadDir(dir); err != nil {
+				// Unreadable for now; tried again on the next rescan.
+				w.logger.Warn("could not watch root", "path", dir, "error", err)
+				continue
+			}
+			owner := ownerLabel(root.Owner, dir)
+			w.mu.Lock()
+			// SetRoots may have replaced the filter meanwhile.
+			w.watched[dir] = matched{root: i, owner: owner, filter: w.roots[i].Filter}
+			w.mu.Unlock()
+
+			if w.mode == ModePoll {
+				w.polled[dir] = true
+			} else if err := w.addRecursive(dir); err != nil {
+				w.fallback(dir, "could not add inotify watch", "error", err)
+			}
+			w.logger.Info("watching root", "path", dir, "owner", owner, "polled", w.polled[dir])
+			if !since.IsZero() {
+				w.touchRecent(dir, since.Add(-mtimeSlack))
+			}
+		}
+	}
+}
+
+// touchRecent touches the transcripts under dir written after since.
+func (w *Watcher) touchRecent(dir string, since time.Time) {
+	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".jsonl") {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.ModTime().After(since) {
+			w.touch(path)
+		}
+		return nil
+	})
+}
+
+// match returns the existing directories matched by a root.
+func match(pattern string) []string {
+	if !strings.ContainsAny(pattern, "*?[") {
+		if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+			return []string{filepath.Clean(pattern)}
+		}
+		return nil
+	}
+	matches, _ := filepath.Glob(pattern)
+	var dirs []string
+	for _, m := range matches {
+		if info, err := os.Stat(m); err == nil && info.IsDir() {
+			dirs = append(dirs, m)
+		}
+	}
+	return dirs
+}
+
+// ownerLabel fills in the account owning dir.
+func ownerLabel(label, dir string) string {
+	if !strings.Contains(label, userPlaceholder) {
+		return label
+	}
+	name := ""
+	if info, err := os.Stat(dir); err == nil {
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			uid := strconv.FormatUint(uint64(st.Uid), 10)
+			name = uid
+			if u, err := user.LookupId(uid); err == nil {
+				name = u.Username
+			}
+		}
+	}
+	return strings.ReplaceAll(label, userPlaceholder, name)
+}
+
+// within reports whether path is dir or below it.
+func within(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// addRecursive watches root and its subdirectories. It returns the first
+// error adding a watch; unreadable directories are skipped.
 func (w *Watcher) addRecursive(root string) error {
-	return filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+	var first error
+	filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
 		if err != nil {
 			return nil // skip inaccessible dirs
 		}
 		if d.IsDir() {
 			if err := w.fw.Add(path); err != nil {
 				w.logger.Warn("could not watch directory", "path", path, "error", err)
+				if first == nil && !errors.Is(err, fs.ErrPermission) {
+					first = err
+				}
 			}
 		}
 		return nil
 	})
+	return first
 }
 
 // Start begins watching for file events. Blocks until Stop is called.
 func (w *Watcher) Start() {
-	w.logger.Info("watching for transcript changes", "dir", w.dir)
+	w.logger.Info("watching for transcript changes", "roots", w.Roots())
+
+	rescan := time.NewTicker(w.rescan)
+	defer rescan.Stop()
+
+	// Auto mode scans too, to notice roots whose events never arrive.
+	var scan <-chan time.Time
+	if w.mode != ModeFSNotify {
+		ticker := time.NewTicker(w.poll)
+		defer ticker.Stop()
+		scan = ticker.C
+	}
+
+	// A nil fsnotify watcher leaves these nil, so they never fire.
+	var (
+		events <-chan fsnotify.Event
+		errs   <-chan error
+		flush  <-chan time.Time
+	)
+	if w.fw != nil {
+		events, errs = w.fw.Events, w.fw.Errors
+	}
 
 	for {
 		select {
-		case event, ok := <-w.fw.Events:
+		case event, ok := <-events:
 			if !ok {
 				return
 			}
 			w.handleEvent(event)
-		case err, ok := <-w.fw.Errors:
+			flush = nil
+			if w.renamed != "" {
+				flush = time.After(renameWait)
+			}
+		case <-flush:
+			w.flushRename()
+		case err, ok := <-errs:
 			if !ok {
 				return
 			}
 			w.logger.Error("watcher error", "error", err)
+		case <-rescan.C:
+			w.expand()
+		case now := <-scan:
+			w.scan(now, false)
 		case <-w.done:
 			return
 		}
@@ -86,17 +426,41 @@ func (w *Watcher) Start() {
 // Stop halts the watcher.
 func (w *Watcher) Stop() {
 	close(w.done)
-	w.fw.Close()
+	if w.fw != nil {
+		w.fw.Close()
+	}
 }
 
 func (w *Watcher) handleEvent(event fsnotify.Event) {
-	// Watch for new directories (new project dirs).
+	// A move within the watched tree arrives as Rename on the old name
+	// followed by Create on the new one. Anything else means it left.
+	if from := w.renamed; from != "" {
+		w.renamed = ""
+		switch {
+		case event.Op&fsnotify.Create == 0:
+			w.removed(from)
+		case strings.HasSuffix(event.Name, ".jsonl") && !w.Allow(event.Name):
+			// Renamed to an excluded name.
+			w.tracker.Forget(from)
+		default:
+			w.tracker.Rename(from, event.Name)
+		}
+	}
+
+	switch {
+	case event.Op&fsnotify.Remove != 0:
+		w.removed(event.Name)
+		return
+	case event.Op&fsnotify.Rename != 0:
+		w.renamed = event.Name
+		return
+	}
+
+	// Watch for new directories (new or recreated project dirs).
 	if event.Op&fsnotify.Create != 0 {
 		info, err := os.Stat(event.Name)
 		if err == nil && info.IsDir() {
-			if err := w.fw.Add(event.Name); err != nil {
-				w.logger.Warn("could not watch new directory", "path", event.Name, "error", err)
-			}
+			w.addNew(event.Name)
 			return
 		}
 	}
@@ -107,6 +471,91 @@ func (w *Watcher) handleEvent(event fsnotify.Event) {
 	}
 
 	if event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
-		w.tracker.Touch(event.Name)
+		w.touch(event.Name)
+	}
+}
+
+// touch passes a written transcript to the tracker unless it is excluded.
+func (w *Watcher) touch(path string) {
+	if !w.Allow(path) {
+		return
+	}
+	if w.mode == ModeAuto {
+		w.events[path] = true
+	}
+	w.tracker.Touch(path)
+}
+
+// addNew watches a directory created while running, with everything
+// already inside it: session and subagent directories and transcripts can
+// be created before the watch is in place. Each directory is watched before
+// it is listed, so anything created afterwards produces an event, and
+// transcripts found by the listing are touched. Both may report the same
+// file, which the tracker tolerates.
+//
+// Like scan, only transcripts written since the directory appeared or since
+// the last scan are touched, so a tree moved in with old sessions does not
+// report them as new.
+func (w *Watcher) addNew(dir string) {
+	since := time.Now().Add(-eventGrace)
+	if !w.lastScan.IsZero() && w.lastScan.Before(since) {
+		since = w.lastScan
+	}
+	since = since.Add(-mtimeSlack)
+
+	var addErr error
+	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil // skip inaccessible dirs
+		}
+		switch {
+		case d.IsDir():
+			if err := w.fw.Add(path); err != nil {
+				w.logger.Warn("could not watch new directory", "path", path, "error", err)
+				if addErr == nil && !errors.Is(err, fs.ErrPermission) {
+					addErr = err
+				}
+			}
+		case strings.HasSuffix(path, ".jsonl"):
+			if info, err := d.Info(); err == nil && info.ModTime().After(since) {
+				w.touch(path)
+			}
+		}
+		return nil
+	})
+	if addErr != nil {
+		w.fallback(w.rootOf(dir), "could not add inotify watch", "error", addErr)
+	}
+}
+
+// flushRename treats a rename whose new name never appeared as a removal.
+func (w *Watcher) flushRename() {
+	if from := w.renamed; from != "" {
+		w.renamed = ""
+		w.removed(from)
+	}
+}
+
+// removed drops a deleted file or directory: its inotify watch, if any, and
+// the transcripts under it. A removed root is matched again by a later
+// rescan once it is recreated.
+func (w *Watcher) removed(path string) {
+	if w.fw != nil {
+		_ = w.fw.Remove(path) // usually already gone with the directory
+	}
+	w.unwatch(path)
+	w.tracker.Forget(path)
+}
+
+// unwatch forgets matched roots at or below path.
+func (w *Watcher) unwatch(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for dir := range w.watched {
+		if within(dir, path) {
+			delete(w.watched, dir)
+			delete(w.polled, dir)
+			w.logger.Info("root removed", "path", dir)
+		}
 	}
 }
@@ -1,10 +1,16 @@
 package watcher
 
 import (
+	"fmt"
 	"os"
+	"os/user"
 	"path/filepath"
+	"slices"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/filter"
 	"github.com/fsnotify/fsnotify"
 )
 
@@ -16,7 +22,6 @@ func TestHandleEvent_IgnoresNonJSONL(t *testing.T) {
 	tracker := &touchRecorder{touches: &touched}
 
 	w := &Watcher{
-		dir:     dir,
 		tracker: tracker,
 		logger:  testLogger(),
 		fw:      nil, // not used by handleEvent for non-Create events
@@ -47,7 +52,6 @@ func TestHandleEvent_TouchesJSONL(t *testing.T) {
 	tracker := &touchRecorder{touches: &touched}
 
 	w := &Watcher{
-		dir:     dir,
 		tracker: tracker,
 		logger:  testLogger(),
 		fw:      nil,
@@ -78,7 +82,6 @@ func TestHandleEvent_CreateJSONL(t *testing.T) {
 	// but for JSONL Create events the path is not a directory so it falls
 	// through to the suffix check and Touch.
 	w := &Watcher{
-		dir:     dir,
 		tracker: tracker,
 		logger:  testLogger(),
 		fw:      nil,
@@ -99,14 +102,13 @@ func TestHandleEvent_CreateJSONL(t *testing.T) {
 	}
 }
 
-func TestHandleEvent_IgnoresChmodAndRemove(t *testing.T) {
+func TestHandleEvent_IgnoresChmod(t *testing.T) {
 	dir := t.TempDir()
 
 	var touched []string
 	tracker := &touchRecorder{touches: &touched}
 
 	w := &Watcher{
-		dir:     dir,
 		tracker: tracker,
 		logger:  testLogger(),
 		fw:      nil,
@@ -120,13 +122,9 @@ func TestHandleEvent_IgnoresChmodAndRemove(t *testing.T) {
 		Op:   fsnotify.Chmod,
 	})
 
-	w.handleEvent(fsnotify.Event{
-		Name: jsonlPath,
-		Op:   fsnotify.Remove,
-	})
-
-	if len(touched) != 0 {
-		t.Errorf("expected 0 touches for Chmod/Remove, got %d", len(touched))
+	// Removals are forgotten instead; see TestHandleEvent_RemoveForgets.
+	if len(touched) != 0 || len(tracker.forgets) != 0 {
+		t.Errorf("expected no touches or forgets for Chmod, got %v and %v", touched, tracker.forgets)
 	}
 }
 
@@ -144,7 +142,6 @@ func TestAddRecursive(t *testing.T) {
 	defer fw.Close()
 
 	w := &Watcher{
-		dir:    root,
 		logger: testLogger(),
 		fw:     fw,
 		done:   make(chan struct{}),
@@ -159,3 +156,452 @@ func TestAddRecursive(t *testing.T) {
 	// by checking that we can write to a subdirectory and receive an event.
 	// For simplicity, just verify no error was returned.
 }
+
+func TestNew_GlobRootsAndOwners(t *testing.T) {
+	base := t.TempDir()
+	alice := filepath.Join(base, "home", "alice", ".claude", "projects")
+	bob := filepath.Join(base, "home", "bob", ".claude", "projects")
+	ci := filepath.Join(base, "srv", "ci", "projects")
+	for _, dir := range []string{alice, bob, ci, filepath.Join(base, "home", "carol")} {
+		os.MkdirAll(dir, 0755)
+	}
+
+	var touched []string
+	w, err := New([]Root{
+		{Path: filepath.Join(base, "home", "*", ".claude", "projects"), Owner: "{user}"},
+		{Path: ci, Owner: "ci"},
+	}, ModeAuto, &touchRecorder{touches: &touched}, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.fw.Close()
+
+	want := []string{alice, bob, ci}
+	if got := w.Roots(); !slices.Equal(got, want) {
+		t.Errorf("roots = %v, want %v", got, want)
+	}
+
+	me, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Owner(filepath.Join(alice, "-home-alice-api", "s.jsonl")); got != me.Username {
+		t.Errorf("owner of glob root = %q, want the owning account %q", got, me.Username)
+	}
+	if got := w.Owner(filepath.Join(ci, "-srv-ci-build", "s.jsonl")); got != "ci" {
+		t.Errorf("owner = %q, want ci", got)
+	}
+	if got := w.Owner(filepath.Join(base, "elsewhere", "s.jsonl")); got != "" {
+		t.Errorf("owner outside roots = %q, want empty", got)
+	}
+}
+
+func TestStart_RescanPicksUpNewHomes(t *testing.T) {
+	base := t.TempDir()
+	os.MkdirAll(filepath.Join(base, "alice", ".claude", "projects"), 0755)
+
+	var (
+		mu      sync.Mutex
+		touched []string
+	)
+	w, err := New([]Root{{Path: filepath.Join(base, "*", ".claude", "projects")}}, ModeAuto, toucherFunc(func(path string) {
+		mu.Lock()
+		touched = append(touched, path)
+		mu.Unlock()
+	}), testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetRescanInterval(20 * time.Millisecond)
+	go w.Start()
+	defer w.Stop()
+
+	// A user created after startup.
+	project := filepath.Join(base, "dave", ".claude", "projects", "-home-dave-app")
+	os.MkdirAll(project, 0755)
+
+	transcript := filepath.Join(project, "sess.jsonl")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		// Keep writing until the new root and its project dir are watched.
+		os.WriteFile(transcript, []byte("{}\n"), 0644)
+		mu.Lock()
+		n := len(touched)
+		mu.Unlock()
+		if n > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("new home not picked up; roots = %v", w.Roots())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestExpand_TouchesRecentTranscriptsInNewRoot(t *testing.T) {
+	base := t.TempDir()
+	os.MkdirAll(filepath.Join(base, "alice", "projects"), 0755)
+
+	var touched []string
+	w, err := New([]Root{{Path: filepath.Join(base, "*", "projects")}}, ModeAuto, &touchRecorder{touches: &touched}, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.fw.Close()
+
+	// A home whose session started before the rescan found it.
+	project := filepath.Join(base, "bob", "projects", "-home-bob-app")
+	os.MkdirAll(project, 0755)
+	old := filepath.Join(project, "old.jsonl")
+	os.WriteFile(old, []byte("{}\n"), 0644)
+	past := time.Now().Add(-time.Hour)
+	os.Chtimes(old, past, past)
+	active := filepath.Join(project, "active.jsonl")
+	os.WriteFile(active, []byte("{}\n"), 0644)
+
+	w.expand()
+	if !slices.Equal(touched, []string{active}) {
+		t.Errorf("touched = %v, want only %s", touched, active)
+	}
+}
+
+func TestNew_UnreadableRootIsSkipped(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root bypasses directory permissions")
+	}
+	base := t.TempDir()
+	open := filepath.Join(base, "alice", "projects")
+	locked := filepath.Join(base, "bob", "projects")
+	os.MkdirAll(open, 0755)
+	os.MkdirAll(locked, 0755)
+	os.Chmod(locked, 0)
+	defer os.Chmod(locked, 0755)
+
+	var touched []string
+	w, err := New([]Root{{Path: filepath.Join(base, "*", "projects")}}, ModeAuto, &touchRecorder{touches: &touched}, testLogger())
+	if err != nil {
+		t.Fatalf("an unreadable root must not fail the watcher: %v", err)
+	}
+	defer w.fw.Close()
+
+	if got := w.Roots(); !slices.Equal(got, []string{open}) {
+		t.Errorf("roots = %v, want only the readable %s", got, open)
+	}
+
+	// Once access is granted the next rescan picks it up.
+	os.Chmod(locked, 0755)
+	w.expand()
+	if got := w.Roots(); len(got) != 2 {
+		t.Errorf("roots after granting access = %v, want both", got)
+	}
+}
+
+func TestHandleEvent_RemoveForgets(t *testing.T) {
+	dir := t.TempDir()
+	var touched []string
+	rec := &touchRecorder{touches: &touched}
+	w := &Watcher{tracker: rec, logger: testLogger(), watched: map[string]matched{}}
+
+	path := filepath.Join(dir, "session.jsonl")
+	w.handleEvent(fsnotify.Event{Name: path, Op: fsnotify.Remove})
+	w.handleEvent(fsnotify.Event{Name: dir, Op: fsnotify.Remove})
+
+	if !slices.Equal(rec.forgets, []string{path, dir}) {
+		t.Errorf("forgets = %v, want %s then %s", rec.forgets, path, dir)
+	}
+}
+
+func TestHandleEvent_Rename(t *testing.T) {
+	dir := t.TempDir()
+	var touched []string
+	rec := &touchRecorder{touches: &touched}
+	w := &Watcher{tracker: rec, logger: testLogger(), watched: map[string]matched{}}
+
+	// An atomic-rename writer replaces the transcript with a temp file.
+	tmp := filepath.Join(dir, "session.jsonl.tmp")
+	path := filepath.Join(dir, "session.jsonl")
+	os.WriteFile(path, []byte("{}\n"), 0644)
+	w.handleEvent(fsnotify.Event{Name: tmp, Op: fsnotify.Rename})
+	w.handleEvent(fsnotify.Event{Name: path, Op: fsnotify.Create})
+
+	if len(rec.renames) != 1 || rec.renames[0] != [2]string{tmp, path} {
+		t.Errorf("renames = %v, want %s -> %s", rec.renames, tmp, path)
+	}
+	if !slices.Equal(touched, []string{path}) {
+		t.Errorf("touched = %v, want the new name", touched)
+	}
+
+	// Moved out of the watched tree: no Create follows.
+	gone := filepath.Join(dir, "gone.jsonl")
+	w.handleEvent(fsnotify.Event{Name: gone, Op: fsnotify.Rename})
+	w.flushRename()
+	// Followed by an unrelated event instead of its Create.
+	other := filepath.Join(dir, "other.jsonl")
+	w.handleEvent(fsnotify.Event{Name: other, Op: fsnotify.Rename})
+	w.handleEvent(fsnotify.Event{Name: path, Op: fsnotify.Write})
+
+	if !slices.Equal(rec.forgets, []string{gone, other}) {
+		t.Errorf("forgets = %v, want %s and %s", rec.forgets, gone, other)
+	}
+	if len(rec.renames) != 1 {
+		t.Errorf("renames = %v, want no more", rec.renames)
+	}
+}
+
+func TestStart_RecreatedDirectoriesAreWatched(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "projects")
+	project := filepath.Join(root, "-home-mike-api")
+	os.MkdirAll(project, 0755)
+
+	rec := &syncRecorder{}
+	w, err := New([]Root{{Path: root}}, ModeFSNotify, rec, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetRescanInterval(20 * time.Millisecond)
+	go w.Start()
+	defer w.Stop()
+
+	// A project dir deleted and recreated.
+	os.RemoveAll(project)
+	eventually(t, "removed project dir to be forgotten", func() bool {
+		return slices.Contains(rec.forgotten(), project)
+	})
+	os.MkdirAll(project, 0755)
+	transcript := filepath.Join(project, "sess.jsonl")
+	eventually(t, "transcript in recreated project dir", func() bool {
+		os.WriteFile(transcript, []byte("{}\n"), 0644)
+		return slices.Contains(rec.touched(), transcript)
+	})
+
+	// The root itself deleted and recreated.
+	os.RemoveAll(root)
+	eventually(t, "removed root to be unwatched", func() bool {
+		return len(w.Roots()) == 0
+	})
+	os.MkdirAll(project, 0755)
+	eventually(t, "recreated root to be watched", func() bool {
+		return len(w.Roots()) == 1
+	})
+	again := filepath.Join(project, "again.jsonl")
+	eventually(t, "transcript in recreated root", func() bool {
+		os.WriteFile(again, []byte("{}\n"), 0644)
+		return slices.Contains(rec.touched(), again)
+	})
+}
+
+func TestStart_NewDirectoryTreeIsScanned(t *testing.T) {
+	root := t.TempDir()
+	rec := &syncRecorder{}
+	w, err := New([]Root{{Path: root}}, ModeFSNotify, rec, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go w.Start()
+	defer w.Stop()
+
+	// Build the project, session and subagent dirs with their transcripts
+	// elsewhere and move them in at once, so every file predates any watch.
+	staging := filepath.Join(t.TempDir(), "-home-mike-api")
+	subagents := filepath.Join(staging, "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee", "subagents")
+	os.MkdirAll(subagents, 0755)
+	os.WriteFile(filepath.Join(staging, "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee.jsonl"), []byte("{}\n"), 0644)
+	os.WriteFile(filepath.Join(subagents, "agent-1.jsonl"), []byte("{}\n"), 0644)
+	project := filepath.Join(root, "-home-mike-api")
+	if err := os.Rename(staging, project); err != nil {
+		t.Fatal(err)
+	}
+
+	session := filepath.Join(project, "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee.jsonl")
+	agent := filepath.Join(project, "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee", "subagents", "agent-1.jsonl")
+	eventually(t, "existing transcripts to be touched", func() bool {
+		got := rec.touched()
+		return slices.Contains(got, session) && slices.Contains(got, agent)
+	})
+
+	// The nested subagent dir is watched too.
+	later := filepath.Join(project, "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee", "subagents", "agent-2.jsonl")
+	os.WriteFile(later, []byte("{}\n"), 0644)
+	eventually(t, "transcript in nested subagent dir", func() bool {
+		return slices.Contains(rec.touched(), later)
+	})
+}
+
+func TestStart_OldTranscriptsInNewDirectoryAreNotTouched(t *testing.T) {
+	root := t.TempDir()
+	rec := &syncRecorder{}
+	w, err := New([]Root{{Path: root}}, ModeFSNotify, rec, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go w.Start()
+	defer w.Stop()
+
+	// A project dir holding an hour-old session is moved in, next to one
+	// that was just written.
+	staging := filepath.Join(t.TempDir(), "-home-mike-old")
+	os.MkdirAll(staging, 0755)
+	old := filepath.Join(staging, "old.jsonl")
+	os.WriteFile(old, []byte("{}\n"), 0644)
+	hourAgo := time.Now().Add(-time.Hour)
+	os.Chtimes(old, hourAgo, hourAgo)
+	os.WriteFile(filepath.Join(staging, "new.jsonl"), []byte("{}\n"), 0644)
+	project := filepath.Join(root, "-home-mike-old")
+	if err := os.Rename(staging, project); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, "the recent transcript to be touched", func() bool {
+		return slices.Contains(rec.touched(), filepath.Join(project, "new.jsonl"))
+	})
+	if slices.Contains(rec.touched(), filepath.Join(project, "old.jsonl")) {
+		t.Error("hour-old transcript in a new directory was touched")
+	}
+}
+
+func TestStart_QuicklyCreatedTreeIsNotMissed(t *testing.T) {
+	root := t.TempDir()
+	rec := &syncRecorder{}
+	w, err := New([]Root{{Path: root}}, ModeFSNotify, rec, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go w.Start()
+	defer w.Stop()
+
+	// Created in quick succession, racing the watch on each new dir.
+	var want []string
+	for i := range 20 {
+		dir := filepath.Join(root, fmt.Sprintf("-home-mike-p%d", i), "sess", "subagents")
+		os.MkdirAll(dir, 0755)
+		path := filepath.Join(dir, "agent.jsonl")
+		os.WriteFile(path, []byte("{}\n"), 0644)
+		want = append(want, path)
+	}
+	eventually(t, "every transcript to be touched", func() bool {
+		got := rec.touched()
+		for _, p := range want {
+			if !slices.Contains(got, p) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func TestFilters_ExcludedTranscriptsAreNeverTouched(t *testing.T) {
+	for _, mode := range []Mode{ModeFSNotify, ModePoll} {
+		t.Run(string(mode), func(t *testing.T) {
+			base := t.TempDir()
+			work, personal := filepath.Join(base, "work"), filepath.Join(base, "personal")
+			for _, dir := range []string{
+				filepath.Join(work, "-srv-api"), filepath.Join(work, "-srv-scratch-x"),
+				filepath.Join(personal, "-home-mike-api"),
+			} {
+				os.MkdirAll(dir, 0755)
+			}
+
+			workFilter, err := filter.New(nil, []string{"/srv/scratch*/**", "agent-*.jsonl"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// A root overriding the rules with an include list.
+			personalFilter, err := filter.New([]string{"/home/mike/blog/**"}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rec := &syncRecorder{}
+			w, err := New([]Root{
+				{Path: work, Filter: workFilter},
+				{Path: personal, Filter: personalFilter},
+			}, mode, rec, testLogger())
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.SetPollInterval(10 * time.Millisecond)
+			go w.Start()
+			defer w.Stop()
+
+			allowed := filepath.Join(work, "-srv-api", "s1.jsonl")
+			excluded := []string{
+				filepath.Join(work, "-srv-scratch-x", "s2.jsonl"),
+				filepath.Join(work, "-srv-api", "agent-1.jsonl"),
+				filepath.Join(personal, "-home-mike-api", "s3.jsonl"),
+			}
+			for _, p := range excluded {
+				os.WriteFile(p, []byte("{}\n"), 0644)
+			}
+			eventually(t, "allowed transcript to be touched", func() bool {
+				os.WriteFile(allowed, []byte("{}\n"), 0644)
+				return slices.Contains(rec.touched(), allowed)
+			})
+			// Anything excluded would have been seen by now.
+			time.Sleep(50 * time.Millisecond)
+			for _, p := range excluded {
+				if slices.Contains(rec.touched(), p) {
+					t.Errorf("excluded transcript %s was touched", p)
+				}
+			}
+
+			// A new blog project under the include list is followed.
+			blog := filepath.Join(personal, "-home-mike-blog-site", "s4.jsonl")
+			os.MkdirAll(filepath.Dir(blog), 0755)
+			eventually(t, "included transcript to be touched", func() bool {
+				os.WriteFile(blog, []byte("{}\n"), 0644)
+				return slices.Contains(rec.touched(), blog)
+			})
+		})
+	}
+}
+
+func TestAllow_NestedSubagentUsesProjectSlug(t *testing.T) {
+	root := t.TempDir()
+	f, _ := filter.New(nil, []string{"/home/mike/scratch/**"})
+	var touched []string
+	w, err := New([]Root{{Path: root, Filter: f}}, ModeFSNotify, &touchRecorder{touches: &touched}, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	if w.Allow(filepath.Join(root, "-home-mike-scratch-x", "sess", "subagents", "agent-1.jsonl")) {
+		t.Error("subagent transcript of an excluded project allowed")
+	}
+	if !w.Allow(filepath.Join(root, "-home-mike-api", "sess", "subagents", "agent-1.jsonl")) {
+		t.Error("subagent transcript of an allowed project excluded")
+	}
+}
+
+func TestSetRoots_ReplacesFilters(t *testing.T) {
+	root := t.TempDir()
+	project := filepath.Join(root, "-srv-api")
+	os.MkdirAll(project, 0755)
+
+	rec := &syncRecorder{}
+	w, err := New([]Root{{Path: root, Owner: "a"}}, ModeFSNotify, rec, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go w.Start()
+	defer w.Stop()
+
+	if err := w.SetRoots([]Root{{Path: t.TempDir()}}); err == nil {
+		t.Error("SetRoots accepted a different path")
+	}
+	f, _ := filter.New(nil, []string{"/srv/api/**"})
+	if err := w.SetRoots([]Root{{Path: root, Owner: "b", Filter: f}}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(project, "s1.jsonl")
+	if w.Allow(path) || w.Owner(path) != "b" {
+		t.Errorf("Allow = %v, Owner = %q after SetRoots", w.Allow(path), w.Owner(path))
+	}
+	os.WriteFile(path, []byte("{}\n"), 0644)
+	time.Sleep(100 * time.Millisecond)
+	if slices.Contains(rec.touched(), path) {
+		t.Error("transcript excluded by SetRoots was touched")
+	}
+}
@@ -5,15 +5,33 @@ import (
 	"os"
 )
 
-// touchRecorder is a test double for Toucher that records Touch calls.
+// touchRecorder is a test double for Toucher that records Touch calls, and
+// Forget and Rename calls.
 type touchRecorder struct {
 	touches *[]string
+	forgets []string
+	renames [][2]string
 }
 
 func (r *touchRecorder) Touch(path string) {
 	*r.touches = append(*r.touches, path)
 }
 
+func (r *touchRecorder) Forget(path string) {
+	r.forgets = append(r.forgets, path)
+}
+
+func (r *touchRecorder) Rename(oldPath, newPath string) {
+	r.renames = append(r.renames, [2]string{oldPath, newPath})
+}
+
 func testLogger() *slog.Logger {
 	return slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
 }
+
+// toucherFunc adapts a function to Toucher.
+type toucherFunc func(path string)
+
+func (f toucherFunc) Touch(path string)              { f(path) }
+func (f toucherFunc) Forget(path string)             {}
+func (f toucherFunc) Rename(oldPath, newPath string) {}
@@ -1,35 +1,50 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/admin"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/attribution"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/budget"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/control"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/history"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/live"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/policy"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/pricing"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/provision"
 	"github.com/MikeSquared-Agency/cc-sidecar/internal/publisher"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/redact"
 	"github.com/MikeSquared-Agency/cc-sidecar/internal/registry"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/report"
 	"github.com/MikeSquared-Agency/cc-sidecar/internal/session"
-	"github.com/MikeSquared-Agency/cc-sidecar/internal/watcher"
-
-	"gopkg.in/yaml.v3"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/stall"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/status"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/stream"
+	"github.com/nats-io/nats.go/jetstream"
 )
 
-// Config holds the sidecar configuration.
-type Config struct {
-	NATS struct {
-		URL   string `yaml:"url"`
-		Token string `yaml:"token"`
-	} `yaml:"nats"`
-	WatchDir      string        `yaml:"watch_dir"`
-	IdleThreshold time.Duration `yaml:"idle_threshold"`
-	PollInterval  time.Duration `yaml:"poll_interval"`
-}
-
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "history":
+			os.Exit(runHistory(os.Args[2:]))
+		case "report":
+			os.Exit(runReport(os.Args[2:]))
+		case "top":
+			os.Exit(runTop(os.Args[2:]))
+		}
+	}
+
 	configPath := flag.String("config", "", "path to config file")
 	flag.Parse()
 
@@ -38,90 +53,510 @@ func main() {
 
 	cfg := loadConfig(*configPath, logger)
 
-	// Expand watch dir.
-	watchDir := expandHome(cfg.WatchDir)
-
 	// Connect to NATS and create publisher.
-	pub, err := publisher.New(cfg.NATS.URL, cfg.NATS.Token, logger)
+	pub, err := publisher.New(cfg.NATS.publisherOptions(), logger)
 	if err != nil {
 		logger.Error("failed to connect to NATS", "error", err)
 		os.Exit(1)
 	}
 	defer pub.Close()
-	logger.Info("connected to NATS", "url", cfg.NATS.URL)
+	logger.Info("connected to NATS", "url", pub.ConnectedURL())
+
+	if cfg.Provision.Enabled {
+		if err := provisionJetStream(pub.JetStream(), cfg, logger); err != nil {
+			logger.Error("failed to provision JetStream resources", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// Everything leaving the machine goes through the redactor. It is
+	// updated in place on reload, so it exists even when disabled.
+	redactor := new(redact.Redactor)
+	compiled, err := cfg.Redaction.redactor()
+	if err != nil {
+		logger.Error("invalid redaction config", "error", err)
+		os.Exit(1)
+	}
+	redactor.Update(compiled)
+	pub.SetRedactor(redactor)
+	switch cfg.Events.Commands {
+	case publisher.CommandsOff, publisher.CommandsInline, publisher.CommandsEvent:
+	default:
+		logger.Error("invalid events config", "commands", cfg.Events.Commands)
+		os.Exit(1)
+	}
+	pub.SetEventOptions(publisher.EventOptions{Summary: cfg.Events.Summary, Commands: cfg.Events.Commands})
+	prices, err := pricing.New(cfg.Pricing)
+	if err != nil {
+		logger.Error("invalid pricing config", "error", err)
+		os.Exit(1)
+	}
+	pub.SetPricing(prices)
+
+	if cfg.Archive.Enabled {
+		uploader, err := newUploader(pub.JetStream(), cfg, redactor, logger)
+		if err != nil {
+			logger.Error("invalid archive config", "error", err)
+			os.Exit(1)
+		}
+		pub.SetArchiver(uploader)
+	}
+
+	// Optionally keep a local history of completed sessions.
+	var historyStore *history.Store
+	if cfg.History.Enabled {
+		historyStore, err = history.Open(expandHome(cfg.History.Path))
+		if err != nil {
+			// History is a local convenience; events still go out without it.
+			logger.Warn("failed to open session history, running without it", "error", err)
+			historyStore = nil
+		}
+	}
+	if historyStore != nil {
+		defer historyStore.Close()
+		pub.SetOnPublished(func(s *session.CompletedSession, data publisher.SessionData, pubErr error) {
+			rec := history.NewRecord(s, time.Now())
+			rec.TaskID = data.TaskID
+			rec.OwnerUUID = data.OwnerUUID
+			rec.AttributedBy = data.AttributedBy
+			rec.CostUSD = data.CostUSD
+			rec.Published = pubErr == nil
+			if pubErr != nil {
+				rec.PublishError = pubErr.Error()
+			}
+			if err := historyStore.Record(context.Background(), rec); err != nil {
+				logger.Warn("failed to record session history", "error", err, "session_id", s.SessionID)
+			}
+		})
+		pub.SetOnAttributed(func(sessionID string, m registry.TaskMapping) {
+			if err := historyStore.Attribute(context.Background(), sessionID, m.TaskID, m.OwnerUUID, "registry"); err != nil {
+				logger.Warn("failed to record late attribution", "error", err, "session_id", sessionID)
+			}
+		})
+	}
+
+	// Optionally generate periodic usage reports from the history.
+	var reportScheduler *report.Scheduler
+	switch {
+	case cfg.Reports.Enabled && !cfg.History.Enabled:
+		logger.Error("invalid reports config", "error", "reports require history to be enabled")
+		os.Exit(1)
+	case cfg.Reports.Enabled && historyStore == nil:
+		logger.Warn("session history is unavailable, running without reports")
+	case cfg.Reports.Enabled:
+		at, err := cfg.Reports.at()
+		if err == nil {
+			reportScheduler, err = report.NewScheduler(cfg.Reports.Periods, at, func(period string, start, end time.Time) (*report.Report, error) {
+				return report.FromHistory(context.Background(), historyStore, period, start, end, cfg.Reports.Top)
+			}, func(r *report.Report) error {
+				return deliverReport(r, cfg.Reports, pub, logger)
+			}, logger)
+		}
+		if err != nil {
+			logger.Error("invalid reports config", "error", err)
+			os.Exit(1)
+		}
+		go reportScheduler.Start()
+	}
 
 	// Create registry client for task_id lookups.
+	pub.SetAttributionWindow(cfg.Registry.AttributionWindow)
 	reg := registry.New(pub.JetStream(), logger)
+	reg.SetOnUpdate(pub.Attribute)
+	go reg.Start()
 
-	// Create session tracker.
-	tracker := session.NewTracker(cfg.IdleThreshold, cfg.PollInterval, logger, func(s *session.CompletedSession) {
+	attr, envResolver, err := buildAttribution(cfg, reg, logger)
+	if err != nil {
+		logger.Error("invalid attribution config", "error", err)
+		os.Exit(1)
+	}
+
+	host, _ := os.Hostname()
+
+	// Optionally write live session status back to KV.
+	var statusStore *status.Store
+	if cfg.Status.Enabled {
+		statusStore = status.New(pub.JetStream(), cfg.Status.Bucket, cfg.Status.TTL, cfg.Status.Throttle, host, logger)
+		statusStore.SetRedactor(redactor)
+		go statusStore.Start()
+	}
+
+	// Optionally evaluate policy rules as transcripts grow.
+	var policyEngine *policy.Engine
+	if cfg.Policy.Enabled {
+		policyEngine, err = policy.New(cfg.Policy.rules(), func(info session.SessionInfo, v policy.Violation) {
+			// Attribution may wait on the registry; keep the tracker moving.
+			go func() {
+				if err := pub.PublishViolation(info, v, attr); err != nil {
+					logger.Error("failed to publish policy violation", "error", err, "session_id", info.SessionID, "rule", v.Rule)
+				}
+			}()
+		}, logger)
+		if err != nil {
+			logger.Error("invalid policy config", "error", err)
+			os.Exit(1)
+		}
+		pub.SetPolicy(policyEngine)
+	}
+
+	// Optionally detect stuck and looping sessions. The tracker is created
+	// below; force-completion looks it up when a stall fires.
+	var (
+		stallDetector *stall.Detector
+		tracker       *session.Tracker
+	)
+	if cfg.Stall.Enabled {
+		stallDetector = stall.New(cfg.Stall.config(), func(info session.SessionInfo, st stall.Stall) {
+			go func() {
+				if err := pub.PublishStalled(info, st, attr); err != nil {
+					logger.Error("failed to publish session stalled", "error", err, "session_id", info.SessionID, "reason", st.Reason)
+				}
+				if cfg.Stall.ForceComplete && (st.Reason == stall.ReasonNoProgress || st.Reason == stall.ReasonWallClock) {
+					if _, err := tracker.ForceComplete(info.SessionID); err != nil {
+						logger.Warn("failed to force-complete stalled session", "error", err, "session_id", info.SessionID)
+					}
+				}
+			}()
+		}, logger)
+		go stallDetector.Start()
+	}
+
+	// Optionally charge spend to task and owner budgets.
+	var budgetEnforcer *budget.Enforcer
+	if cfg.Budget.Enabled {
+		budgetCfg, err := cfg.Budget.config()
+		if err != nil {
+			logger.Error("invalid budget config", "error", err)
+			os.Exit(1)
+		}
+		resolve := func(info session.SessionInfo) (budget.Attribution, bool) {
+			mapping, _ := attr.Resolve(info.Partial())
+			if mapping == nil {
+				return budget.Attribution{}, false
+			}
+			return budget.Attribution{TaskID: mapping.TaskID, OwnerUUID: mapping.OwnerUUID}, true
+		}
+		budgetEnforcer, err = budget.New(budgetCfg, prices, resolve, func(info session.SessionInfo, ba budget.Attribution, a budget.Alert) {
+			if err := pub.PublishBudget(info, ba, a); err != nil {
+				logger.Error("failed to publish budget alert", "error", err, "session_id", info.SessionID, "scope", a.Scope, "id", a.ID)
+			}
+		}, logger)
+		if err != nil {
+			logger.Error("invalid budget config", "error", err)
+			os.Exit(1)
+		}
+		if cfg.Budget.Bucket != "" {
+			budgetEnforcer.SetBucket(pub.JetStream(), cfg.Budget.Bucket)
+		}
+		go budgetEnforcer.Start()
+	}
+
+	// Optionally publish every transcript message as it is written.
+	var streamer *stream.Streamer
+	if cfg.Stream.Enabled {
+		streamer = stream.New(stream.Config{
+			MaxText:  cfg.Stream.MaxText,
+			MaxInput: cfg.Stream.MaxInput,
+			MaxQueue: cfg.Stream.MaxQueue,
+		}, pub.PublishStream, logger)
+		streamer.SetRedactor(redactor)
+		go streamer.Start()
+	}
+
+	// Optionally keep a live view of sessions for the admin API.
+	var monitor *live.Monitor
+	if cfg.Admin.Enabled {
+		monitor = live.New(prices)
+	}
+
+	// publishSession publishes the completed or failed event for a session.
+	publishSession := func(s *session.CompletedSession) error {
 		if s.ExitCode != 0 {
-			if err := pub.PublishFailed(s, reg); err != nil {
-				logger.Error("failed to publish session failed", "error", err, "session_id", s.SessionID)
+			return pub.PublishFailed(s, attr)
+		}
+		return pub.PublishCompleted(s, attr)
+	}
+
+	// forget drops per-session state once a session completes or its
+	// transcript is deleted.
+	forget := func(sessionID string) {
+		if policyEngine != nil {
+			policyEngine.Forget(sessionID)
+		}
+		if stallDetector != nil {
+			stallDetector.Forget(sessionID)
+		}
+		if budgetEnforcer != nil {
+			budgetEnforcer.Forget(sessionID)
+		}
+		if monitor != nil {
+			monitor.Forget(sessionID)
+		}
+	}
+
+	// Create session tracker. Each completion runs on its own goroutine, so
+	// a session waiting for a late registry mapping does not hold up the
+	// others, and force-complete requests are answered before publishing.
+	var completing sync.WaitGroup
+	tracker = session.NewTracker(cfg.IdleThreshold, cfg.PollInterval, logger, func(s *session.CompletedSession) {
+		completing.Add(1)
+		go func() {
+			defer completing.Done()
+			if statusStore != nil {
+				statusStore.Completed(s)
 			}
-		} else {
-			if err := pub.PublishCompleted(s, reg); err != nil {
-				logger.Error("failed to publish session completed", "error", err, "session_id", s.SessionID)
+			if err := publishSession(s); err != nil {
+				logger.Error("failed to publish session event", "error", err, "session_id", s.SessionID, "exit_code", s.ExitCode)
 			}
+			forget(s.SessionID)
+		}()
+	})
+	// Stream sequence numbers and captured environments outlive completion,
+	// since a session can resume after it, and the status record of a
+	// completed session is kept for lookups; all go only when the transcript
+	// does.
+	tracker.SetOnForget(func(info session.SessionInfo) {
+		forget(info.SessionID)
+		if envResolver != nil {
+			envResolver.Forget(info.TranscriptPath)
+		}
+		if streamer != nil {
+			streamer.Forget(info.SessionID)
+		}
+		if statusStore != nil {
+			statusStore.Forget(info.SessionID)
 		}
 	})
 
+	if envResolver != nil {
+		tracker.SetOnProcess(envResolver.Observe)
+	}
+	var (
+		onState    []session.OnState
+		onActivity []session.OnActivity
+	)
+	if statusStore != nil {
+		onState = append(onState, statusStore.Observe)
+	}
+	if policyEngine != nil {
+		onActivity = append(onActivity, policyEngine.Observe)
+	}
+	if stallDetector != nil {
+		onState = append(onState, stallDetector.ObserveState)
+		onActivity = append(onActivity, stallDetector.Observe)
+	}
+	if budgetEnforcer != nil {
+		onActivity = append(onActivity, budgetEnforcer.Observe)
+	}
+	if streamer != nil {
+		onActivity = append(onActivity, streamer.Observe)
+	}
+	if monitor != nil {
+		onState = append(onState, monitor.ObserveState)
+		onActivity = append(onActivity, monitor.Observe)
+	}
+	if len(onState) > 0 {
+		tracker.SetOnState(func(ev session.SessionInfo) {
+			for _, fn := range onState {
+				fn(ev)
+			}
+		})
+	}
+	if len(onActivity) > 0 {
+		tracker.SetOnActivity(func(info session.SessionInfo, acts []session.Activity) {
+			for _, fn := range onActivity {
+				fn(info, acts)
+			}
+		})
+	}
+
 	// Create watcher.
-	w, err := watcher.New(watchDir, tracker, logger)
+	w, err := cfg.newWatcher(tracker, logger)
 	if err != nil {
 		logger.Error("failed to create watcher", "error", err)
 		os.Exit(1)
 	}
+	tracker.SetOwner(w.Owner)
+
+	// Optionally answer control requests from remote operators.
+	var ctrl *control.Server
+	if cfg.Control.Enabled {
+		ctrl = control.New(pub.Conn(), cfg.controlHost(), cfg.rootPaths(), tracker, publishSession, logger)
+		ctrl.SetRedactor(redactor)
+		ctrl.SetAllow(w.Allow)
+		if err := ctrl.Start(); err != nil {
+			logger.Error("failed to start control plane", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// Optionally serve the live view to local tools such as "top".
+	var adminServer *admin.Server
+	if monitor != nil {
+		adminServer = admin.New(cfg.Admin.Listen, monitor, logger)
+		adminServer.SetRedactor(redactor)
+		adminServer.SetToken(cfg.Admin.Token)
+		if err := adminServer.Start(); err != nil {
+			logger.Error("failed to start admin API", "error", err)
+			os.Exit(1)
+		}
+	}
 
 	go w.Start()
 	go tracker.Start()
 
-	logger.Info("cc-sidecar started", "watch_dir", watchDir, "idle_threshold", cfg.IdleThreshold)
+	logger.Info("cc-sidecar started", "watch_roots", cfg.rootPaths(), "idle_threshold", cfg.IdleThreshold)
+
+	// Reload the config on SIGHUP and, if enabled, when the file changes.
+	rl := &reloader{
+		path:     *configPath,
+		logger:   logger.With("component", "reload"),
+		pub:      pub,
+		redactor: redactor,
+		tracker:  tracker,
+		watcher:  w,
+		registry: reg,
+		stall:    stallDetector,
+		status:   statusStore,
+		budget:   budgetEnforcer,
+		control:  ctrl,
+		cfg:      cfg,
+	}
+	stopReload := make(chan struct{})
+	defer close(stopReload)
+	if cfg.Reload.Watch && *configPath != "" {
+		if err := rl.watchFile(stopReload); err != nil {
+			logger.Warn("could not watch config file, reload with SIGHUP instead", "error", err)
+		}
+	}
 
 	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-	<-sigCh
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigCh {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		rl.reload("SIGHUP")
+	}
 
 	logger.Info("shutting down")
+	if adminServer != nil {
+		adminServer.Stop()
+	}
+	if ctrl != nil {
+		ctrl.Stop()
+	}
 	w.Stop()
 	tracker.Stop()
-}
-
-func loadConfig(path string, logger *slog.Logger) Config {
-	cfg := Config{
-		WatchDir:      "~/.claude/projects/",
-		IdleThreshold: 10 * time.Second,
-		PollInterval:  15 * time.Second,
+	completing.Wait()
+	if streamer != nil {
+		streamer.Stop()
+	}
+	if stallDetector != nil {
+		stallDetector.Stop()
+	}
+	if budgetEnforcer != nil {
+		budgetEnforcer.Stop()
+	}
+	if reportScheduler != nil {
+		reportScheduler.Stop()
 	}
-	cfg.NATS.URL = "nats://localhost:4222"
+	if statusStore != nil {
+		statusStore.Stop()
+	}
+	reg.Stop()
+	stats := reg.Stats()
+	logger.Info("registry cache stats", "entries", stats.Entries, "hits", stats.Hits, "misses", stats.Misses, "fallbacks", stats.Fallbacks)
+}
 
-	// Load config file if provided.
-	if path != "" {
-		data, err := os.ReadFile(path)
-		if err != nil {
-			logger.Warn("could not read config file, using defaults", "path", path, "error", err)
-		} else if err := yaml.Unmarshal(data, &cfg); err != nil {
-			logger.Warn("could not parse config file, using defaults", "path", path, "error", err)
+// buildAttribution assembles the configured resolver chain. The env resolver,
+// if enabled, is returned separately so it can observe claude processes.
+func buildAttribution(cfg Config, reg *registry.Registry, logger *slog.Logger) (*attribution.Chain, *attribution.EnvResolver, error) {
+	var (
+		resolvers   []attribution.Resolver
+		envResolver *attribution.EnvResolver
+	)
+	for _, name := range cfg.Attribution.Resolvers {
+		switch name {
+		case "registry":
+			resolvers = append(resolvers, attribution.NewRegistryResolver(reg, cfg.Registry.WaitForMapping))
+		case "prompt":
+			r, err := attribution.NewPromptResolver(cfg.Attribution.PromptPattern)
+			if err != nil {
+				return nil, nil, err
+			}
+			resolvers = append(resolvers, r)
+		case "env":
+			envResolver = attribution.NewEnvResolver(cfg.Attribution.Env.TaskVar, cfg.Attribution.Env.OwnerVar)
+			resolvers = append(resolvers, envResolver)
+		case "path":
+			rules := make([]attribution.PathRule, 0, len(cfg.Attribution.PathRules))
+			for _, r := range cfg.Attribution.PathRules {
+				rules = append(rules, attribution.PathRule{Source: r.Source, Pattern: r.Pattern})
+			}
+			r, err := attribution.NewPathResolver(rules)
+			if err != nil {
+				return nil, nil, err
+			}
+			resolvers = append(resolvers, r)
+		default:
+			return nil, nil, fmt.Errorf("unknown resolver %q", name)
 		}
 	}
+	return attribution.NewChain(logger, resolvers...), envResolver, nil
+}
 
-	// Env overrides (highest precedence).
-	if v := os.Getenv("CC_SIDECAR_NATS_URL"); v != "" {
-		cfg.NATS.URL = v
+// provisionJetStream creates or updates the event stream and registry bucket
+// and logs any configuration drift it finds.
+func provisionJetStream(js jetstream.JetStream, full Config, logger *slog.Logger) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cfg := full.Provision
+	p := provision.New(js, cfg.DryRun, logger)
+	var res provision.Result
+
+	if err := p.Stream(ctx, provision.StreamConfig{
+		Name:            cfg.Stream.Name,
+		Subjects:        cfg.Stream.Subjects,
+		Retention:       cfg.Stream.Retention,
+		Storage:         cfg.Stream.Storage,
+		MaxAge:          cfg.Stream.MaxAge,
+		Replicas:        cfg.Stream.Replicas,
+		DuplicateWindow: cfg.Stream.DuplicateWindow,
+	}, &res); err != nil {
+		return err
 	}
-	if v := os.Getenv("CC_SIDECAR_NATS_TOKEN"); v != "" {
-		cfg.NATS.Token = v
+	if err := p.Bucket(ctx, provision.BucketConfig{
+		Bucket:   registry.BucketName,
+		TTL:      cfg.Registry.TTL,
+		History:  cfg.Registry.History,
+		Replicas: cfg.Registry.Replicas,
+	}, &res); err != nil {
+		return err
 	}
-	if v := os.Getenv("CC_SIDECAR_WATCH_DIR"); v != "" {
-		cfg.WatchDir = v
+	if full.Status.Enabled {
+		if err := p.Bucket(ctx, provision.BucketConfig{
+			Bucket:   full.Status.Bucket,
+			TTL:      full.Status.TTL,
+			History:  1,
+			Replicas: cfg.Registry.Replicas,
+		}, &res); err != nil {
+			return err
+		}
 	}
-	if v := os.Getenv("CC_SIDECAR_IDLE_THRESHOLD"); v != "" {
-		if d, err := time.ParseDuration(v); err == nil {
-			cfg.IdleThreshold = d
+	if full.Budget.Enabled && full.Budget.Bucket != "" {
+		if err := p.Bucket(ctx, provision.BucketConfig{
+			Bucket:   full.Budget.Bucket,
+			History:  1,
+			Replicas: cfg.Registry.Replicas,
+		}, &res); err != nil {
+			return err
 		}
 	}
 
-	return cfg
+	logger.Info("provisioning complete", "dry_run", cfg.DryRun, "created", res.Created, "updated", res.Updated, "drift", len(res.Drift))
+	return nil
 }
 
 func expandHome(path string) string {
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/archive"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/budget"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/control"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/publisher"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/redact"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/registry"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/session"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/stall"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/status"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/watcher"
+	"github.com/fsnotify/fsnotify"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// reloadDebounce coalesces the burst of events an editor or a config map
+// update produces into one reload.
+const reloadDebounce = 250 * time.Millisecond
+
+// reloader applies a changed config to the running sidecar without losing
+// tracked sessions. Thresholds, filters, redaction, event sections, the
+// control host and provisioned subjects change in place, and NATS changes
+// move every component to a new connection. Anything else is logged and
+// takes effect on the next restart.
+type reloader struct {
+	path   string
+	logger *slog.Logger
+
+	pub      *publisher.Publisher
+	redactor *redact.Redactor
+	tracker  *session.Tracker
+	watcher  *watcher.Watcher
+	registry *registry.Registry
+	// Optional components; nil when disabled.
+	stall   *stall.Detector
+	status  *status.Store
+	budget  *budget.Enforcer
+	control *control.Server
+
+	mu  sync.Mutex
+	cfg Config // as applied
+}
+
+// reload reads the config file and applies it. An invalid config is
+// rejected and the current one kept.
+func (r *reloader) reload(reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := readConfig(r.path)
+	if err == nil {
+		err = next.validate()
+	}
+	if err == nil {
+		err = r.apply(next)
+	}
+	if err != nil {
+		r.logger.Error("config reload rejected, keeping the current config", "reason", reason, "path", r.path, "error", err)
+	}
+}
+
+// apply switches to next, which must be valid. Only a failed NATS
+// reconnect can fail it, and that happens before anything else changes.
+func (r *reloader) apply(next Config) error {
+	cur := r.cfg
+	applied := reloadable(cur, next)
+	if restart := changedSettings(applied, next); len(restart) > 0 {
+		r.logger.Warn("config changes need a restart to take effect", "settings", restart)
+	}
+	changed := changedSettings(cur, applied)
+	if len(changed) == 0 {
+		r.logger.Info("config reloaded, nothing to apply")
+		return nil
+	}
+
+	roots, err := applied.roots()
+	if err != nil {
+		return err
+	}
+	compiled, err := applied.Redaction.redactor()
+	if err != nil {
+		return err
+	}
+
+	natsChanged := !reflect.DeepEqual(cur.NATS, applied.NATS)
+	if natsChanged {
+		if err := r.pub.Reconnect(applied.NATS.publisherOptions()); err != nil {
+			return fmt.Errorf("reconnect to NATS: %w", err)
+		}
+		r.moveConnection(applied)
+	}
+	r.cfg = applied
+
+	// Redact with the new rules before anything else can publish.
+	r.redactor.Update(compiled)
+	r.pub.SetEventOptions(publisher.EventOptions{Summary: applied.Events.Summary, Commands: applied.Events.Commands})
+	r.tracker.SetThresholds(applied.IdleThreshold, applied.PollInterval)
+	if r.stall != nil {
+		r.stall.SetConfig(applied.Stall.config())
+	}
+	if err := r.watcher.SetRoots(roots); err != nil {
+		// reloadable keeps the root paths, so this is not expected.
+		r.logger.Error("failed to apply watch roots", "error", err)
+	}
+	r.forgetExcluded()
+
+	if r.control != nil && (natsChanged || cur.Control.Host != applied.Control.Host) {
+		if err := r.control.Resubscribe(r.pub.Conn(), applied.controlHost()); err != nil {
+			r.logger.Error("failed to move control plane", "error", err)
+		}
+	}
+	if applied.Provision.Enabled && (natsChanged || !reflect.DeepEqual(cur.Provision, applied.Provision)) {
+		if err := provisionJetStream(r.pub.JetStream(), applied, r.logger); err != nil {
+			r.logger.Error("failed to provision JetStream resources", "error", err)
+		}
+	}
+
+	r.logger.Info("config reloaded", "changed", changed)
+	return nil
+}
+
+// moveConnection points every component holding the old connection at the
+// publisher's new one. Their state, such as cached mappings and spend, is
+// kept.
+func (r *reloader) moveConnection(cfg Config) {
+	js := r.pub.JetStream()
+	r.registry.SetJetStream(js)
+	if r.status != nil {
+		r.status.SetJetStream(js)
+	}
+	if r.budget != nil {
+		r.budget.SetJetStream(js)
+	}
+	if cfg.Archive.Enabled {
+		uploader, err := newUploader(js, cfg, r.redactor, r.logger)
+		if err != nil {
+			r.logger.Error("failed to move transcript archive", "error", err)
+		} else {
+			r.pub.SetArchiver(uploader)
+		}
+	}
+}
+
+// forgetExcluded drops tracked sessions whose transcripts the filters now
+// exclude, so nothing more is published for them.
+func (r *reloader) forgetExcluded() {
+	for _, info := range r.tracker.Sessions() {
+		if !r.watcher.Allow(info.TranscriptPath) {
+			r.logger.Info("session excluded by filters, no longer tracked", "session_id", info.SessionID, "path", info.TranscriptPath)
+			r.tracker.Forget(info.TranscriptPath)
+		}
+	}
+}
+
+// watchFile reloads whenever the config file changes, until done is
+// closed. The directory is watched, since editors and Kubernetes config maps
+// replace the file rather than write to it.
+func (r *reloader) watchFile(done <-chan struct{}) error {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("watch config: %w", err)
+	}
+	path := filepath.Clean(r.path)
+	if err := fw.Add(filepath.Dir(path)); err != nil {
+		fw.Close()
+		return fmt.Errorf("watch config: %w", err)
+	}
+
+	go func() {
+		defer fw.Close()
+		debounce := time.NewTimer(reloadDebounce)
+		debounce.Stop()
+		for {
+			select {
+			case ev, ok := <-fw.Events:
+				if !ok {
+					return
+				}
+				// Config maps swap a "..data" symlink next to the file.
+				if filepath.Clean(ev.Name) == path || strings.HasPrefix(filepath.Base(ev.Name), "..") {
+					debounce.Reset(reloadDebounce)
+				}
+			case err, ok := <-fw.Errors:
+				if !ok {
+					return
+				}
+				r.logger.Warn("config watch error", "error", err)
+			case <-debounce.C:
+				r.reload("file changed")
+			case <-done:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// reloadable returns cur with the settings a reload applies taken from
+// next. Watch roots follow next only while their paths stay the same.
+func reloadable(cur, next Config) Config {
+	out := cur
+	out.NATS = next.NATS
+	out.IdleThreshold = next.IdleThreshold
+	out.PollInterval = next.PollInterval
+	if slices.Equal(cur.rootPaths(), next.rootPaths()) {
+		out.WatchDir = next.WatchDir
+		out.WatchRoots = next.WatchRoots
+		out.Filters = next.Filters
+	}
+	out.Stall = next.Stall
+	out.Stall.Enabled = cur.Stall.Enabled
+	out.Stall.ForceComplete = cur.Stall.ForceComplete
+	out.Redaction = next.Redaction
+	out.Events = next.Events
+	out.Control.Host = next.Control.Host
+	out.Provision = next.Provision
+	return out
+}
+
+// changedSettings lists the top-level settings that differ between a and b
+// by their config file key.
+func changedSettings(a, b Config) []string {
+	var keys []string
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	for i := range va.NumField() {
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			key, _, _ := strings.Cut(va.Type().Field(i).Tag.Get("yaml"), ",")
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// newUploader creates the transcript archive uploader for a connection.
+func newUploader(js jetstream.JetStream, cfg Config, redactor *redact.Redactor, logger *slog.Logger) (*archive.Uploader, error) {
+	uploader, err := archive.New(js, archive.Config{
+		Bucket:      cfg.Archive.Bucket,
+		Compression: cfg.Archive.Compression,
+		ChunkSize:   cfg.Archive.ChunkSize,
+		PartSize:    cfg.Archive.PartSize,
+		MaxAge:      cfg.Archive.MaxAge,
+		Replicas:    cfg.Archive.Replicas,
+	}, logger)
+	if err != nil {
+		return nil, err
+	}
+	uploader.SetRedactor(redactor)
+	return uploader, nil
+}
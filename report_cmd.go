@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/history"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/publisher"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/report"
+)
+
+// runReport implements "cc-sidecar report", generating a usage report from
+// the local session history on demand.
+func runReport(args []string) int {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to config file")
+	dbPath := fs.String("db", "", "history database (default from config)")
+	period := fs.String("period", report.PeriodDaily, "daily or weekly")
+	date := fs.String("date", "", "a day within the period, 2006-01-02 (default: the last complete period)")
+	format := fs.String("format", "", "markdown or json (default from config)")
+	dir := fs.String("dir", "", "write the report to this directory instead of stdout")
+	publish := fs.Bool("publish", false, "also publish the report on swarm.cc.report.{period}")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	cfg := loadConfig(*configPath, logger)
+	if *dbPath == "" {
+		*dbPath = cfg.History.Path
+	}
+	if *format == "" {
+		*format = cfg.Reports.Format
+	}
+
+	var (
+		start, end time.Time
+		err        error
+	)
+	if *date == "" {
+		start, end, err = report.Window(*period, time.Now())
+	} else {
+		var day time.Time
+		day, err = time.ParseInLocation("2006-01-02", *date, time.Local)
+		if err == nil {
+			start, end, err = report.WindowFor(*period, day)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "report: %v\n", err)
+		return 2
+	}
+
+	store, err := history.Open(expandHome(*dbPath))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "report: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	r, err := report.FromHistory(context.Background(), store, *period, start, end, cfg.Reports.Top)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "report: %v\n", err)
+		return 1
+	}
+	r.Host, _ = os.Hostname()
+
+	out := cfg.Reports
+	out.Format = *format
+	out.Dir = *dir
+	out.Publish = *publish
+
+	var pub *publisher.Publisher
+	if out.Publish {
+		if pub, err = publisher.New(cfg.NATS.publisherOptions(), logger); err != nil {
+			fmt.Fprintf(os.Stderr, "report: %v\n", err)
+			return 1
+		}
+		defer pub.Close()
+		redactor, err := cfg.Redaction.redactor()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "report: %v\n", err)
+			return 1
+		}
+		pub.SetRedactor(redactor)
+	}
+
+	if out.Dir == "" {
+		data, err := r.Render(out.Format)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "report: %v\n", err)
+			return 1
+		}
+		os.Stdout.Write(data)
+	}
+	if err := deliverReport(r, out, pub, logger); err != nil {
+		fmt.Fprintf(os.Stderr, "report: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// deliverReport writes the report to the configured directory and publishes
+// it if enabled.
+func deliverReport(r *report.Report, cfg ReportsConfig, pub *publisher.Publisher, logger *slog.Logger) error {
+	if r.Host == "" {
+		r.Host, _ = os.Hostname()
+	}
+	if cfg.Dir != "" {
+		path, err := r.WriteFile(expandHome(cfg.Dir), cfg.Format)
+		if err != nil {
+			return err
+		}
+		logger.Info("report written", "path", path)
+	}
+	if cfg.Publish && pub != nil {
+		var markdown string
+		if cfg.Format == report.FormatMarkdown {
+			data, err := r.Render(report.FormatMarkdown)
+			if err != nil {
+				return err
+			}
+			markdown = string(data)
+		}
+		if err := pub.PublishReport(r, markdown); err != nil {
+			return fmt.Errorf("publish report: %w", err)
+		}
+	}
+	return nil
+}
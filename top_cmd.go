@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/live"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/pricing"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/session"
+	"github.com/MikeSquared-Agency/cc-sidecar/internal/top"
+	"golang.org/x/term"
+)
+
+// runTop implements "cc-sidecar top", a live dashboard of tracked sessions.
+// It reads the daemon's admin API, or watches the transcripts itself when no
+// daemon is reachable or -standalone is given.
+func runTop(args []string) int {
+	fs := flag.NewFlagSet("top", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to config file")
+	addr := fs.String("addr", "", "daemon admin API address (default from config)")
+	standalone := fs.Bool("standalone", false, "watch transcripts directly instead of connecting to the daemon")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		fmt.Fprintln(os.Stderr, "top: stdin and stdout must be a terminal")
+		return 2
+	}
+
+	// The dashboard owns the screen; only the config loader may warn.
+	cfg := loadConfig(*configPath, slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+	if *addr == "" {
+		*addr = cfg.Admin.Listen
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var src top.Source
+	if !*standalone {
+		remote, err := top.Dial(ctx, *addr, cfg.Admin.Token)
+		if err == nil {
+			src = remote
+		} else {
+			fmt.Fprintf(os.Stderr, "top: %v; watching transcripts directly\n", err)
+		}
+	}
+	if src == nil {
+		local, stop, err := watchStandalone(ctx, cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "top: %v\n", err)
+			return 1
+		}
+		defer stop()
+		src = local
+	}
+
+	if err := top.Run(src, os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "top: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// watchStandalone tracks the watch dir in-process, without NATS, and
+// returns a dashboard source for it.
+func watchStandalone(ctx context.Context, cfg Config) (*top.Local, func(), error) {
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	prices, err := pricing.New(cfg.Pricing)
+	if err != nil {
+		return nil, nil, err
+	}
+	monitor := live.New(prices)
+
+	tracker := session.NewTracker(cfg.IdleThreshold, cfg.PollInterval, logger, func(s *session.CompletedSession) {
+		monitor.Forget(s.SessionID)
+	})
+	tracker.SetOnForget(func(info session.SessionInfo) {
+		monitor.Forget(info.SessionID)
+	})
+	tracker.SetOnState(monitor.ObserveState)
+	tracker.SetOnActivity(monitor.Observe)
+
+	w, err := cfg.newWatcher(tracker, logger)
+	if err != nil {
+		return nil, nil, fmt.Errorf("watch transcripts: %w", err)
+	}
+	tracker.SetOwner(w.Owner)
+	go w.Start()
+	go tracker.Start()
+
+	return top.NewLocal(ctx, monitor, "standalone "+strings.Join(cfg.rootPaths(), ", ")), func() {
+		w.Stop()
+		tracker.Stop()
+	}, nil
+}